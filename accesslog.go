@@ -0,0 +1,257 @@
+package velocity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// AccessLogFormat is the line format AccessLog writes to an io.Writer.
+type AccessLogFormat string
+
+const (
+	// AccessLogJSON writes one JSON object per line, with the configured
+	// fields as keys. It is the default.
+	AccessLogJSON AccessLogFormat = "json"
+
+	// AccessLogCommon writes lines in the spirit of the Common Log
+	// Format:
+	//
+	//	<peer> - - [02/Jan/2006:15:04:05 -0700] "read /users/42" ok 512 1.2ms
+	//
+	// with the peer's node ID in place of the client host, the WEB/1
+	// method and path as the request line, and the duration appended. The
+	// fields are fixed; AccessLogConfig.Fields does not apply.
+	AccessLogCommon AccessLogFormat = "common"
+)
+
+// Access log fields, for AccessLogConfig.Fields.
+const (
+	AccessFieldTime        = "time"        // when the request finished, RFC 3339
+	AccessFieldMethod      = "method"      // the WEB/1 method
+	AccessFieldPath        = "path"        // the request path
+	AccessFieldRoute       = "route"       // the matched route pattern
+	AccessFieldStatus      = "status"      // the response status
+	AccessFieldBytesIn     = "bytes_in"    // the request body size
+	AccessFieldBytesOut    = "bytes_out"   // the response body size, streamed bytes included
+	AccessFieldDuration    = "duration"    // the handling time
+	AccessFieldPeer        = "peer"        // the peer's node ID
+	AccessFieldRequestID   = "request_id"  // the request ID, in hex
+	AccessFieldCompression = "compression" // the connection's compression algorithm
+	AccessFieldError       = "error"       // the error the handler returned, if any
+)
+
+// defaultAccessFields are the fields logged when AccessLogConfig.Fields is
+// empty.
+var defaultAccessFields = []string{
+	AccessFieldTime, AccessFieldMethod, AccessFieldPath, AccessFieldRoute,
+	AccessFieldStatus, AccessFieldBytesOut, AccessFieldDuration, AccessFieldPeer,
+}
+
+var accessFields = map[string]bool{
+	AccessFieldTime: true, AccessFieldMethod: true, AccessFieldPath: true,
+	AccessFieldRoute: true, AccessFieldStatus: true, AccessFieldBytesIn: true,
+	AccessFieldBytesOut: true, AccessFieldDuration: true, AccessFieldPeer: true,
+	AccessFieldRequestID: true, AccessFieldCompression: true, AccessFieldError: true,
+}
+
+// AccessLogConfig configures AccessLog.
+type AccessLogConfig struct {
+	// Format is the line format written to Output. Empty means
+	// AccessLogJSON. It does not apply to a Logger sink.
+	Format AccessLogFormat
+
+	// Output, if set, receives one line per logged request, for shipping
+	// to a log pipeline. Writes are serialized.
+	Output io.Writer
+
+	// Logger, used when Output is nil, receives each request as an info
+	// entry "request" with the fields as key-value pairs; wrap a
+	// *slog.Logger with SlogLogger. If both are nil, the request's logger
+	// (see Context.Logger) is used.
+	Logger Logger
+
+	// SampleRate is the fraction of successful requests logged, between 0
+	// and 1; zero means 1, every request. Requests answered with an error
+	// status are always logged.
+	SampleRate float64
+
+	// Fields lists the fields logged, in order, from the AccessField
+	// constants. Empty means time, method, path, route, status, bytes_out,
+	// duration, and peer.
+	Fields []string
+
+	// Skipper, if set, selects requests that are not logged at all, such
+	// as health checks. Unlike sampling, it applies to failed requests
+	// too.
+	Skipper Skipper
+}
+
+// AccessLog returns middleware that logs every completed request, like
+// RequestLogger, in a configurable format to a configurable sink:
+//
+//	srv.Use(velocity.AccessLog(velocity.AccessLogConfig{
+//	    Output:     accessFile,
+//	    SampleRate: 0.1,
+//	    Fields:     []string{velocity.AccessFieldTime, velocity.AccessFieldStatus, velocity.AccessFieldBytesOut},
+//	}))
+//
+// The entry is written after the downstream handler returns, with the status
+// the request was, or will be, answered with. Failures to write to Output are
+// reported through the request's logger. AccessLog panics if cfg.Format or a
+// field is unknown, or cfg.SampleRate is outside [0, 1].
+func AccessLog(cfg AccessLogConfig) MiddlewareFunc {
+	switch cfg.Format {
+	case "":
+		cfg.Format = AccessLogJSON
+	case AccessLogJSON, AccessLogCommon:
+	default:
+		panic(fmt.Sprintf("velocity: unknown access log format %q", cfg.Format))
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		panic(fmt.Sprintf("velocity: access log sample rate must be between 0 and 1, got %v", cfg.SampleRate))
+	}
+	if cfg.SampleRate == 0 {
+		cfg.SampleRate = 1
+	}
+	if len(cfg.Fields) == 0 {
+		cfg.Fields = defaultAccessFields
+	}
+	for _, f := range cfg.Fields {
+		if !accessFields[f] {
+			panic(fmt.Sprintf("velocity: unknown access log field %q", f))
+		}
+	}
+	var mu sync.Mutex // serializes writes to cfg.Output
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if cfg.Skipper != nil && cfg.Skipper(c) {
+				return next(c)
+			}
+			start := time.Now()
+			err := next(c)
+			status := metricsStatus(c, err)
+			if cfg.SampleRate < 1 && nwep.StatusIsSuccess(status) && rand.Float64() >= cfg.SampleRate {
+				return err
+			}
+			e := accessEntry{c: c, err: err, status: status, end: time.Now()}
+			e.dur = e.end.Sub(start)
+			if cfg.Output == nil {
+				log := cfg.Logger
+				if log == nil {
+					log = c.Logger()
+				}
+				log.Info("request", e.args(cfg.Fields)...)
+				return err
+			}
+			var line []byte
+			if cfg.Format == AccessLogCommon {
+				line = e.common()
+			} else {
+				line = e.json(cfg.Fields)
+			}
+			mu.Lock()
+			_, werr := cfg.Output.Write(line)
+			mu.Unlock()
+			if werr != nil {
+				c.Logger().Warn("access log write failed", "error", werr.Error())
+			}
+			return err
+		}
+	}
+}
+
+// accessEntry is one request being logged.
+type accessEntry struct {
+	c      *Context
+	err    error
+	status string
+	end    time.Time
+	dur    time.Duration
+}
+
+// field returns the value of field f, and false if it has none for this
+// request.
+func (e *accessEntry) field(f string) (any, bool) {
+	c := e.c
+	switch f {
+	case AccessFieldTime:
+		return e.end.Format(time.RFC3339Nano), true
+	case AccessFieldMethod:
+		return c.Method(), true
+	case AccessFieldPath:
+		return c.Path(), true
+	case AccessFieldRoute:
+		return c.RoutePattern(), true
+	case AccessFieldStatus:
+		return e.status, true
+	case AccessFieldBytesIn:
+		return len(c.Request.Body), true
+	case AccessFieldBytesOut:
+		return c.written, true
+	case AccessFieldDuration:
+		return e.dur.String(), true
+	case AccessFieldPeer:
+		return FormatNodeID(c.PeerNodeID()), true
+	case AccessFieldRequestID:
+		return c.requestIDHex(), true
+	case AccessFieldCompression:
+		if cs, ok := c.ConnSettings(); ok && cs.Compression != "" {
+			return cs.Compression, true
+		}
+	case AccessFieldError:
+		if e.err != nil {
+			return e.err.Error(), true
+		}
+	}
+	return nil, false
+}
+
+// args returns the fields as Logger key-value pairs.
+func (e *accessEntry) args(fields []string) []any {
+	args := make([]any, 0, 2*len(fields))
+	for _, f := range fields {
+		if v, ok := e.field(f); ok {
+			args = append(args, f, v)
+		}
+	}
+	return args
+}
+
+// json returns the fields as a JSON line, keys in the order of fields.
+func (e *accessEntry) json(fields []string) []byte {
+	b := []byte{'{'}
+	for _, f := range fields {
+		v, ok := e.field(f)
+		if !ok {
+			continue
+		}
+		if len(b) > 1 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendQuote(b, f)
+		b = append(b, ':')
+		enc, _ := json.Marshal(v)
+		b = append(b, enc...)
+	}
+	return append(b, '}', '\n')
+}
+
+// common returns the entry as an AccessLogCommon line.
+func (e *accessEntry) common() []byte {
+	peer := "-"
+	if id := e.c.PeerNodeID(); !id.IsZero() {
+		peer = FormatNodeID(id)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - - [%s] %q %s %d %s\n", peer, e.end.Format("02/Jan/2006:15:04:05 -0700"),
+		e.c.Method()+" "+e.c.Path(), e.status, e.c.written, e.dur)
+	return []byte(b.String())
+}
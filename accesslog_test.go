@@ -0,0 +1,74 @@
+package velocity
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogJSON(t *testing.T) {
+	var out bytes.Buffer
+	mw := AccessLog(AccessLogConfig{
+		Output: &out,
+		Fields: []string{AccessFieldStatus, AccessFieldMethod, AccessFieldBytesIn, AccessFieldBytesOut, AccessFieldError},
+	})
+	h := mw(func(c *Context) error { return c.OK([]byte("hello")) })
+	c, _ := NewTestContext(MethodWrite, "/items", []byte("abc"))
+	if err := h(c); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"status":"ok","method":"write","bytes_in":3,"bytes_out":5}` + "\n"
+	if out.String() != want {
+		t.Fatalf("line = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	h = mw(func(c *Context) error { return ErrNotFoundf("no item %d", 7) })
+	c, _ = NewTestContext(MethodRead, "/items/7", nil)
+	h(c)
+	var entry map[string]any
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("%v: %q", err, out.String())
+	}
+	if entry["status"] != StatusNotFound || entry["error"] == nil {
+		t.Fatalf("entry = %v", entry)
+	}
+}
+
+func TestAccessLogCommonAndSampling(t *testing.T) {
+	var out bytes.Buffer
+	mw := AccessLog(AccessLogConfig{Format: AccessLogCommon, Output: &out, SampleRate: 1e-9})
+	ok := mw(func(c *Context) error { return c.OK([]byte("x")) })
+	fail := mw(func(c *Context) error { return c.Error(StatusBadRequest, "bad") })
+	for range 10 {
+		c, _ := NewTestContext(MethodRead, "/a", nil)
+		ok(c)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("sampled-out requests logged: %q", out.String())
+	}
+	c, _ := NewTestContext(MethodRead, "/a", nil)
+	fail(c)
+	line := out.String()
+	if !strings.HasPrefix(line, "- - - [") || !strings.Contains(line, `] "read /a" bad_request 3 `) {
+		t.Fatalf("line = %q", line)
+	}
+}
+
+func TestAccessLogPanics(t *testing.T) {
+	for _, cfg := range []AccessLogConfig{
+		{Format: "xml"},
+		{SampleRate: 2},
+		{Fields: []string{"nope"}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("AccessLog(%+v) did not panic", cfg)
+				}
+			}()
+			AccessLog(cfg)
+		}()
+	}
+}
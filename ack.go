@@ -0,0 +1,214 @@
+package velocity
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// HeaderNotifyID is the notification header that carries the ID of a
+// notification sent with NotifyWithAck. The ID is the same on every retry, so
+// that clients can discard duplicates.
+const HeaderNotifyID = "x-notify-id"
+
+// AckPathPrefix is the path prefix of acknowledgement requests. A client
+// acknowledges a notification sent with NotifyWithAck by sending a request,
+// with any method, to AckPath(id).
+const AckPathPrefix = "/ack/"
+
+// AckPath returns the path a client requests to acknowledge the notification
+// whose HeaderNotifyID header is id.
+func AckPath(id string) string { return AckPathPrefix + id }
+
+// NotifyID returns the value of the HeaderNotifyID header from the headers of
+// a received notification. The second return value is false if the
+// notification does not expect an acknowledgement.
+func NotifyID(headers []nwep.Header) (string, bool) {
+	for _, h := range headers {
+		if h.Name == HeaderNotifyID {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// RetryPolicy controls how NotifyWithAck retries a notification that was not
+// acknowledged in time.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of sends, including the first. It must
+	// be at least 1.
+	MaxAttempts int
+
+	// Backoff is the pause after the first unacknowledged attempt. It doubles
+	// after each further attempt, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used by NotifyWithAck unless
+// WithNotifyRetry sets another.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	Backoff:     500 * time.Millisecond,
+	MaxBackoff:  30 * time.Second,
+}
+
+// WithNotifyRetry sets the retry policy used by NotifyWithAck. This function
+// returns an error if p.MaxAttempts is less than 1 or either duration is
+// negative.
+func WithNotifyRetry(p RetryPolicy) Option {
+	return func(s *Server) error {
+		if p.MaxAttempts < 1 {
+			return fmt.Errorf("velocity: retry MaxAttempts must be at least 1, got %d", p.MaxAttempts)
+		}
+		if p.Backoff < 0 || p.MaxBackoff < 0 {
+			return fmt.Errorf("velocity: retry backoff must not be negative, got %s and %s", p.Backoff, p.MaxBackoff)
+		}
+		s.notifyRetry = &p
+		return nil
+	}
+}
+
+// NotifyWithAck sends a notification to peer and waits until the peer
+// acknowledges it, giving at-least-once delivery for events that must not be
+// lost. The notification carries a HeaderNotifyID header with a fresh ID, and
+// the client acknowledges it by sending a request to AckPath(id); the server
+// answers such requests itself, before routing, and only from peer.
+//
+// If no acknowledgement arrives within ackTimeout, or the send fails, the
+// notification is sent again with the same ID after a backoff, as set by
+// WithNotifyRetry or DefaultRetryPolicy. Clients may therefore see a
+// notification more than once and should use the ID to discard duplicates.
+//
+// If peer is offline and WithNotifyQueue is set, the notification is queued
+// once and not retried; NotifyWithAck waits ackTimeout for the peer to
+// reconnect and acknowledge it, and otherwise returns ErrNotifyNotAcked while
+// the notification stays queued.
+//
+// NotifyWithAck blocks until the notification is acknowledged, returning nil,
+// or until the attempts are used up, returning an error wrapping
+// ErrNotifyNotAcked. It returns an error if ackTimeout is not positive, and
+// ErrServerNotRunning if the server has not been started. If the
+// notification transform drops the notification, nothing is sent and
+// NotifyWithAck returns nil.
+func (s *Server) NotifyWithAck(peer nwep.NodeID, event, path string, body []byte, ackTimeout time.Duration) error {
+	if ackTimeout <= 0 {
+		return fmt.Errorf("velocity: ack timeout must be positive, got %s", ackTimeout)
+	}
+	if s.nwep == nil {
+		return ErrServerNotRunning
+	}
+	size := len(body)
+	body, ok := s.transformNotify(event, path, body)
+	if !ok {
+		s.observeNotify(NotifyEvent{Peer: peer, Event: event, Path: path, Size: size, Outcome: NotifyDropped})
+		return nil
+	}
+	policy := DefaultRetryPolicy
+	if s.notifyRetry != nil {
+		policy = *s.notifyRetry
+	}
+
+	id, acked := s.acks.add(peer)
+	defer s.acks.remove(id)
+	opts := &nwep.NotifyOptions{Headers: []nwep.Header{{Name: HeaderNotifyID, Value: id}}}
+
+	backoff := policy.Backoff
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		var outcome string
+		outcome, lastErr = s.deliver(peer, event, path, body, opts, false)
+		if outcome == NotifyQueued {
+			select {
+			case <-acked:
+				return nil
+			case <-time.After(ackTimeout):
+				return fmt.Errorf("%w: queued for an offline peer", ErrNotifyNotAcked)
+			}
+		}
+		if lastErr == nil {
+			select {
+			case <-acked:
+				return nil
+			case <-time.After(ackTimeout):
+			}
+		}
+		if attempt >= policy.MaxAttempts {
+			break
+		}
+		select {
+		case <-acked:
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, policy.MaxBackoff)
+	}
+	if lastErr != nil {
+		return fmt.Errorf("%w after %d attempts: %w", ErrNotifyNotAcked, policy.MaxAttempts, lastErr)
+	}
+	return fmt.Errorf("%w after %d attempts", ErrNotifyNotAcked, policy.MaxAttempts)
+}
+
+// ackSet tracks notifications waiting for an acknowledgement.
+type ackSet struct {
+	mu      sync.Mutex
+	pending map[string]pendingAck
+}
+
+type pendingAck struct {
+	peer  nwep.NodeID
+	acked chan struct{}
+}
+
+// add registers a new notification for peer and returns its ID and a channel
+// that is closed when the peer acknowledges it.
+func (as *ackSet) add(peer nwep.NodeID) (string, <-chan struct{}) {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	id := hex.EncodeToString(b[:])
+	ch := make(chan struct{})
+	as.mu.Lock()
+	if as.pending == nil {
+		as.pending = make(map[string]pendingAck)
+	}
+	as.pending[id] = pendingAck{peer: peer, acked: ch}
+	as.mu.Unlock()
+	return id, ch
+}
+
+func (as *ackSet) remove(id string) {
+	as.mu.Lock()
+	delete(as.pending, id)
+	as.mu.Unlock()
+}
+
+// ack marks the notification id as acknowledged by peer. It reports whether
+// id was pending for that peer. A repeated acknowledgement reports false.
+func (as *ackSet) ack(peer nwep.NodeID, id string) bool {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	p, ok := as.pending[id]
+	if !ok || p.peer != peer {
+		return false
+	}
+	delete(as.pending, id)
+	close(p.acked)
+	return true
+}
+
+// handle answers c if it is an acknowledgement of a pending notification and
+// reports whether it did. Other requests, including ones under AckPathPrefix
+// that match no pending ID, are left for the router.
+func (as *ackSet) handle(c *Context) bool {
+	id, ok := strings.CutPrefix(c.Request.Path, AckPathPrefix)
+	if !ok || !as.ack(c.PeerNodeID(), id) {
+		return false
+	}
+	_ = c.NoContent()
+	return true
+}
@@ -0,0 +1,77 @@
+package velocity
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestAckSet(t *testing.T) {
+	var as ackSet
+	peer := nwep.NodeID{3}
+	id, acked := as.add(peer)
+
+	if as.ack(nwep.NodeID{4}, id) {
+		t.Fatal("ack accepted from the wrong peer")
+	}
+	if !as.ack(peer, id) {
+		t.Fatal("ack rejected from the target peer")
+	}
+	select {
+	case <-acked:
+	default:
+		t.Fatal("acked channel not closed")
+	}
+	if as.ack(peer, id) {
+		t.Fatal("repeated ack accepted")
+	}
+	as.remove(id)
+}
+
+func TestNotifyWithAckQueuesOnce(t *testing.T) {
+	var mu sync.Mutex
+	var outcomes []string
+	queue := &MemoryQueue{}
+	s, err := New(":0",
+		WithNotifyQueue(QueueConfig{MaxPerPeer: 10, Storage: queue}),
+		WithNotifyRetry(RetryPolicy{MaxAttempts: 3}),
+		WithNotifyObserver(func(e NotifyEvent) {
+			mu.Lock()
+			outcomes = append(outcomes, e.Outcome)
+			mu.Unlock()
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	peer := nwep.NodeID{5}
+	if err := s.NotifyWithAck(peer, "tick", "/", nil, 0); err == nil {
+		t.Fatal("zero ack timeout accepted")
+	}
+	err = s.NotifyWithAck(peer, "tick", "/", []byte("x"), time.Millisecond)
+	if !errors.Is(err, ErrNotifyNotAcked) {
+		t.Fatalf("NotifyWithAck to an offline peer = %v, want ErrNotifyNotAcked", err)
+	}
+	if list, _ := queue.Dequeue(peer); len(list) != 1 {
+		t.Fatalf("%d notifications queued, want 1", len(list))
+	}
+
+	s.SetNotifyTransform(func(event, path string, body []byte) []byte { return nil })
+	if err := s.NotifyWithAck(peer, "tick", "/", []byte("x"), time.Millisecond); err != nil {
+		t.Fatalf("NotifyWithAck of a dropped notification = %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{NotifyQueued, NotifyDropped}; !slices.Equal(outcomes, want) {
+		t.Fatalf("observed outcomes %q, want %q", outcomes, want)
+	}
+}
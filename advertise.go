@@ -0,0 +1,102 @@
+package velocity
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// WithAdvertisedAddr sets the IP address and port that Server.URL embeds in
+// place of the address the server is bound to. Use it when the bind address
+// is useless to peers: a server behind NAT, or one bound to 0.0.0.0 or [::],
+// whose URLs would otherwise carry the wildcard address. ip may be an IPv4 or
+// IPv6 address, so a dual-stack server bound to [::] can advertise either
+// family. A port of 0 keeps the bound port. Only the URL changes; the server
+// still listens where New and WithAdditionalAddr say.
+//
+// With several listeners the advertised address replaces the primary one,
+// in URL and the first entry of URLs.
+//
+// This option returns an error if ip is not an IP address or port is not in
+// the range 0 to 65535.
+func WithAdvertisedAddr(ip string, port int) Option {
+	return func(s *Server) error {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return fmt.Errorf("velocity: advertised address: %w", err)
+		}
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("velocity: advertised port must be between 0 and 65535, got %d", port)
+		}
+		s.advertisedIP = addr.Unmap()
+		s.advertisedPort = port
+		return nil
+	}
+}
+
+// advertise rewrites url, as produced by nwep, to carry the address set by
+// WithAdvertisedAddr. It returns url unchanged if no address was set or url
+// cannot be parsed.
+func (s *Server) advertise(url string) string {
+	if !s.advertisedIP.IsValid() {
+		return url
+	}
+	out, err := rewriteURLAddr(url, s.advertisedIP, s.advertisedPort)
+	if err != nil {
+		s.logger.Warn("cannot rewrite URL with advertised address", "url", url, "error", err.Error())
+		return url
+	}
+	return out
+}
+
+// rewriteURLAddr replaces the IP address in the host of a WEB/1 URL,
+// web://[Base58(IP||NodeID)]:port/path, with ip and, if port is not 0, the
+// port with port. The node ID and path are kept. An IPv4 address is encoded
+// in 4 bytes if url's address was, and in IPv4-mapped form otherwise.
+func rewriteURLAddr(url string, ip netip.Addr, port int) (string, error) {
+	rest, ok := strings.CutPrefix(url, "web://")
+	if !ok {
+		return "", fmt.Errorf("%q is not a web:// URL", url)
+	}
+	authority, path, hasPath := strings.Cut(rest, "/")
+	var host, tail string
+	bracketed := strings.HasPrefix(authority, "[")
+	if i := strings.LastIndex(authority, "]"); bracketed && i > 0 {
+		host, tail = authority[1:i], authority[i+1:]
+	} else if i := strings.LastIndexByte(authority, ':'); i >= 0 {
+		host, tail = authority[:i], authority[i:]
+	} else {
+		host = authority
+	}
+	raw, err := decodeBase58(host)
+	if err != nil {
+		return "", err
+	}
+	ipLen := len(raw) - len(nwep.NodeID{})
+	if ipLen != 4 && ipLen != 16 {
+		return "", fmt.Errorf("host decodes to %d bytes, want an IP address and a node ID", len(raw))
+	}
+	var addr []byte
+	if ip.Is4() && ipLen == 4 {
+		b4 := ip.As4()
+		addr = b4[:]
+	} else {
+		b16 := ip.As16()
+		addr = b16[:]
+	}
+	host = encodeBase58(append(addr, raw[ipLen:]...))
+	if port != 0 {
+		tail = ":" + strconv.Itoa(port)
+	}
+	if bracketed {
+		host = "[" + host + "]"
+	}
+	url = "web://" + host + tail
+	if hasPath {
+		url += "/" + path
+	}
+	return url, nil
+}
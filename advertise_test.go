@@ -0,0 +1,44 @@
+package velocity
+
+import (
+	"net/netip"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestRewriteURLAddr(t *testing.T) {
+	id := nwep.NodeID{9, 8, 7}
+	orig := testPoolURL(id) + "a/b"
+	tests := []struct {
+		ip   string
+		port int
+		want string
+	}{
+		{"203.0.113.7", 0, "web://" + encodeBase58(append([]byte{203, 0, 113, 7}, id[:]...)) + ":6937/a/b"},
+		{"203.0.113.7", 7000, "web://" + encodeBase58(append([]byte{203, 0, 113, 7}, id[:]...)) + ":7000/a/b"},
+		{"2001:db8::1", 0, "web://" + encodeBase58(append(netip.MustParseAddr("2001:db8::1").AsSlice(), id[:]...)) + ":6937/a/b"},
+	}
+	for _, tt := range tests {
+		got, err := rewriteURLAddr(orig, netip.MustParseAddr(tt.ip), tt.port)
+		if err != nil || got != tt.want {
+			t.Errorf("rewrite to %s:%d = %q, %v; want %q", tt.ip, tt.port, got, err, tt.want)
+		}
+		if peer, err := NodeIDFromURL(got); err != nil || peer != id {
+			t.Errorf("rewritten URL lost the node ID: %x, %v", peer, err)
+		}
+	}
+
+	s := &Server{}
+	for _, bad := range []Option{WithAdvertisedAddr("host.example", 0), WithAdvertisedAddr("10.0.0.1", 70000)} {
+		if err := bad(s); err == nil {
+			t.Error("WithAdvertisedAddr accepted an invalid address")
+		}
+	}
+	if err := (&Config{AdvertisedAddr: "[2001:db8::1]:7000"}).Apply(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.advertisedIP != netip.MustParseAddr("2001:db8::1") || s.advertisedPort != 7000 {
+		t.Errorf("Config.AdvertisedAddr applied as %s port %d", s.advertisedIP, s.advertisedPort)
+	}
+}
@@ -0,0 +1,203 @@
+package velocity
+
+import (
+	"encoding/hex"
+	"errors"
+	"slices"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// setTrustStore installs ts, built with anchors, as the server's trust store,
+// freeing any previous one.
+func (s *Server) setTrustStore(ts *nwep.TrustStore, anchors []nwep.BLSPubkey) {
+	s.trustMu.Lock()
+	defer s.trustMu.Unlock()
+	if s.trustStore != nil {
+		s.trustStore.Free()
+	}
+	s.trustStore = ts
+	s.anchors = slices.Clone(anchors)
+}
+
+// TrustStore returns the trust store built by WithTrust or by a Config with
+// TrustAnchors, or nil if there is none. The Server owns it and frees it on
+// Shutdown. Change its anchors through AddAnchor and RemoveAnchor, which keep
+// Anchors accurate and serialize updates.
+func (s *Server) TrustStore() *nwep.TrustStore {
+	s.trustMu.Lock()
+	defer s.trustMu.Unlock()
+	return s.trustStore
+}
+
+// Anchors returns the anchors in the server's trust store: those it was
+// built with plus those added with AddAnchor, minus those removed with
+// RemoveAnchor, in the order they were added. It returns nil if the server
+// has no trust store.
+func (s *Server) Anchors() []nwep.BLSPubkey {
+	s.trustMu.Lock()
+	defer s.trustMu.Unlock()
+	return slices.Clone(s.anchors)
+}
+
+// AddAnchor adds pk to the server's trust store as a trusted checkpoint
+// signer, taking effect for identity lookups from then on. Adding an anchor
+// already present is a no-op. This function returns ErrNoTrustStore if the
+// server has no trust store, or the error from nwep if adding fails.
+func (s *Server) AddAnchor(pk nwep.BLSPubkey) error {
+	s.trustMu.Lock()
+	defer s.trustMu.Unlock()
+	if s.trustStore == nil {
+		return ErrNoTrustStore
+	}
+	if slices.Contains(s.anchors, pk) {
+		return nil
+	}
+	if err := s.trustStore.AddAnchor(pk, false); err != nil {
+		return err
+	}
+	s.anchors = append(s.anchors, pk)
+	return nil
+}
+
+// RemoveAnchor removes pk from the server's trust store. Removing an anchor
+// that is not present is a no-op. This function returns ErrNoTrustStore if
+// the server has no trust store.
+func (s *Server) RemoveAnchor(pk nwep.BLSPubkey) error {
+	s.trustMu.Lock()
+	defer s.trustMu.Unlock()
+	if s.trustStore == nil {
+		return ErrNoTrustStore
+	}
+	i := slices.Index(s.anchors, pk)
+	if i < 0 {
+		return nil
+	}
+	if err := s.trustStore.RemoveAnchor(pk); err != nil {
+		return err
+	}
+	s.anchors = slices.Delete(s.anchors, i, i+1)
+	return nil
+}
+
+// DefaultAnchorAdminPrefix is the path prefix AnchorAdmin mounts its
+// endpoints under unless AnchorAdminOptions.Prefix says otherwise.
+const DefaultAnchorAdminPrefix = "/admin/anchors"
+
+// AnchorAdminOptions configures AnchorAdmin.
+type AnchorAdminOptions struct {
+	// Prefix is the path the endpoints are mounted under. Empty means
+	// DefaultAnchorAdminPrefix.
+	Prefix string
+
+	// AllowPeers lists the peers that may use the endpoints; every other
+	// peer gets status "forbidden". It must not be empty.
+	AllowPeers []nwep.NodeID
+}
+
+// anchorList is the JSON body of the anchor admin endpoints. Anchors are
+// hex-encoded BLS public keys.
+type anchorList struct {
+	Anchors []string `json:"anchors"`
+}
+
+// AnchorAdmin mounts endpoints for managing the server's trust anchors at
+// runtime and returns their group:
+//
+//	velocity.AnchorAdmin(srv, velocity.AnchorAdminOptions{AllowPeers: []nwep.NodeID{operator}})
+//
+// Under opts.Prefix it serves, with anchors as hex-encoded BLS public keys in
+// a JSON body of the form {"anchors": ["..."]}:
+//
+//	read   /      list the anchors
+//	write  /      add the anchors in the body
+//	update /      replace the anchor set with the one in the body, adding
+//	              new anchors before removing old ones
+//	delete /:key  remove one anchor
+//
+// Writes respond with the resulting anchor list. Bad keys get status
+// "bad_request" and a server without a trust store "unavailable". Changing
+// trust anchors is sensitive, so access is restricted with AllowPeers;
+// AnchorAdmin panics if opts.AllowPeers is empty.
+func AnchorAdmin(srv *Server, opts AnchorAdminOptions) *Group {
+	if len(opts.AllowPeers) == 0 {
+		panic("velocity: AnchorAdmin requires at least one allowed peer")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultAnchorAdminPrefix
+	}
+	list := func(c *Context) error {
+		return c.JSON(anchorList{Anchors: formatAnchors(srv.Anchors())})
+	}
+	g := srv.Group(opts.Prefix, AllowPeers(opts.AllowPeers...))
+	g.Read("/", list)
+	g.Write("/", func(c *Context) error {
+		anchors, err := bindAnchors(c)
+		if err != nil {
+			return err
+		}
+		for _, pk := range anchors {
+			if err := srv.AddAnchor(pk); err != nil {
+				return anchorAdminError(err)
+			}
+		}
+		return list(c)
+	})
+	g.Update("/", func(c *Context) error {
+		anchors, err := bindAnchors(c)
+		if err != nil {
+			return err
+		}
+		for _, pk := range anchors {
+			if err := srv.AddAnchor(pk); err != nil {
+				return anchorAdminError(err)
+			}
+		}
+		for _, pk := range srv.Anchors() {
+			if !slices.Contains(anchors, pk) {
+				if err := srv.RemoveAnchor(pk); err != nil {
+					return anchorAdminError(err)
+				}
+			}
+		}
+		return list(c)
+	})
+	g.Delete("/:key", func(c *Context) error {
+		pks, err := parseAnchors([]string{c.Param("key")})
+		if err != nil {
+			return ErrBadRequest(err.Error())
+		}
+		if err := srv.RemoveAnchor(pks[0]); err != nil {
+			return anchorAdminError(err)
+		}
+		return list(c)
+	})
+	return g
+}
+
+func bindAnchors(c *Context) ([]nwep.BLSPubkey, error) {
+	var body anchorList
+	if err := c.Bind(&body); err != nil {
+		return nil, ErrBadRequest("body must be {\"anchors\": [...]}")
+	}
+	anchors, err := parseAnchors(body.Anchors)
+	if err != nil {
+		return nil, ErrBadRequest(err.Error())
+	}
+	return anchors, nil
+}
+
+func anchorAdminError(err error) error {
+	if errors.Is(err, ErrNoTrustStore) {
+		return NewError(StatusUnavailable, "server has no trust store")
+	}
+	return err
+}
+
+func formatAnchors(anchors []nwep.BLSPubkey) []string {
+	out := make([]string, len(anchors))
+	for i, pk := range anchors {
+		out[i] = hex.EncodeToString(pk[:])
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+package velocity
+
+import (
+	"errors"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestAnchors(t *testing.T) {
+	s := &Server{}
+	if err := s.AddAnchor(nwep.BLSPubkey{1}); !errors.Is(err, ErrNoTrustStore) {
+		t.Fatalf("AddAnchor without store = %v, want ErrNoTrustStore", err)
+	}
+	if err := s.RemoveAnchor(nwep.BLSPubkey{1}); !errors.Is(err, ErrNoTrustStore) {
+		t.Fatalf("RemoveAnchor without store = %v, want ErrNoTrustStore", err)
+	}
+
+	s.trustStore = &nwep.TrustStore{}
+	s.anchors = []nwep.BLSPubkey{{1}}
+	if err := s.AddAnchor(nwep.BLSPubkey{2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddAnchor(nwep.BLSPubkey{1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Anchors(); len(got) != 2 || got[0] != (nwep.BLSPubkey{1}) || got[1] != (nwep.BLSPubkey{2}) {
+		t.Fatalf("Anchors() = %v", formatAnchors(got))
+	}
+	if err := s.RemoveAnchor(nwep.BLSPubkey{3}); err != nil {
+		t.Fatalf("RemoveAnchor of absent anchor = %v, want nil", err)
+	}
+}
+
+func TestAnchorAdminNoPeers(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("AnchorAdmin with no allowed peers did not panic")
+		}
+	}()
+	AnchorAdmin(&Server{}, AnchorAdminOptions{})
+}
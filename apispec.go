@@ -0,0 +1,350 @@
+package velocity
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// APISpecPath is the path WithAPISpec serves the API description at.
+const APISpecPath = "/.well-known/api"
+
+// routeSchema is the metadata attached to a route by WithSchema.
+type routeSchema struct {
+	request, response reflect.Type
+	description       string
+}
+
+// WithSchema returns route middleware that attaches a description of the
+// route to it, for Server.APISpec: req and resp are values of the request and
+// response body types, and description says what the route does:
+//
+//	srv.Router().Write("/users", createUser,
+//	    velocity.WithSchema(CreateUser{}, User{}, "Create a user"))
+//
+// Either type may be nil if the route has no body in that direction. The
+// middleware itself does nothing when requests are handled. Attached to a
+// group, it applies to every route of the group; a route's own WithSchema
+// takes precedence.
+func WithSchema(req, resp any, description string) MiddlewareFunc {
+	s := &routeSchema{description: description}
+	if req != nil {
+		s.request = reflect.TypeOf(req)
+	}
+	if resp != nil {
+		s.response = reflect.TypeOf(resp)
+	}
+	return s.middleware
+}
+
+func (s *routeSchema) middleware(next HandlerFunc) HandlerFunc {
+	if next == nil {
+		// Probed by schemaFrom.
+		return func(*Context) error { return schemaFound{s} }
+	}
+	return next
+}
+
+// schemaMiddlewarePC identifies middleware made by WithSchema, which are all
+// method values of routeSchema.middleware.
+var schemaMiddlewarePC = reflect.ValueOf((&routeSchema{}).middleware).Pointer()
+
+// schemaFound is how a probed WithSchema middleware reports its schema.
+type schemaFound struct{ s *routeSchema }
+
+func (schemaFound) Error() string { return "velocity: route schema" }
+
+// schemaFrom returns the schema attached by the last WithSchema middleware in
+// mw, or nil if there is none.
+func schemaFrom(mw []MiddlewareFunc) *routeSchema {
+	var s *routeSchema
+	for _, m := range mw {
+		if reflect.ValueOf(m).Pointer() != schemaMiddlewarePC {
+			continue
+		}
+		if found, ok := m(nil)(nil).(schemaFound); ok {
+			s = found.s
+		}
+	}
+	return s
+}
+
+// WithAPISpec serves the API description produced by Server.APISpec at
+// APISpecPath, with the given title and version in its info section, so that
+// client teams can generate stubs against it. This function returns an error
+// if title is empty.
+func WithAPISpec(title, version string) Option {
+	return func(s *Server) error {
+		if title == "" {
+			return fmt.Errorf("velocity: API spec title must not be empty")
+		}
+		s.apiTitle, s.apiVersion = title, version
+		s.router.Read(APISpecPath, func(c *Context) error {
+			spec, err := c.server.APISpec()
+			if err != nil {
+				return err
+			}
+			c.SetHeader("content-type", MIMEJSON)
+			return c.OK(spec)
+		})
+		return nil
+	}
+}
+
+// APISpec returns a machine-readable description of the server's routes,
+// including those of mounted routers (see Router.Mount), as JSON, in the shape
+// of an OpenAPI 3.1 document adapted to WEB/1: operations are keyed by WEB/1
+// method ("read", "write", ...), or "any" for routes registered with Handle,
+// and responses by WEB/1 status. Path parameters appear in OpenAPI form
+// ("/users/{id}"), and prefix routes are marked with "x-prefix".
+//
+// Request and response bodies are described for routes with WithSchema, as
+// JSON Schemas derived from the Go types: fields are named by their json
+// tags, named struct types become shared components, and the rules of
+// Validate tags become schema constraints. The title and version come from
+// WithAPISpec.
+func (s *Server) APISpec() ([]byte, error) {
+	g := &schemaGen{defs: make(map[string]any), names: make(map[reflect.Type]string)}
+	paths := make(map[string]map[string]*apiOperation)
+	add := func(method, pattern string, r *route, prefix bool) {
+		path, params := openAPIPath(pattern)
+		if method == "" {
+			method = "any"
+		}
+		op := &apiOperation{Parameters: params, Prefix: prefix, Responses: map[string]*apiBody{}}
+		if sc := r.schema; sc != nil {
+			op.Description = sc.description
+			if sc.request != nil {
+				op.RequestBody = &apiBody{Content: g.content(sc.request)}
+			}
+			if sc.response != nil {
+				op.Responses[StatusOK] = &apiBody{Description: "ok", Content: g.content(sc.response)}
+			}
+		}
+		if paths[path] == nil {
+			paths[path] = make(map[string]*apiOperation)
+		}
+		paths[path][method] = op
+	}
+	var addRouter func(rt *Router, prefix string)
+	addRouter = func(rt *Router, prefix string) {
+		for key, r := range rt.exact {
+			method, _, ok := strings.Cut(key, " ")
+			if !ok {
+				method = ""
+			}
+			add(method, prefix+r.pattern, r, false)
+		}
+		for _, pr := range rt.params {
+			add(pr.method, prefix+pr.route.pattern, pr.route, false)
+		}
+		for _, pr := range rt.prefixes {
+			add("", prefix+pr.prefix, pr.route, true)
+		}
+		for _, m := range rt.mounts {
+			addRouter(m.sub, prefix+m.prefix)
+		}
+	}
+	addRouter(s.router, "")
+
+	title := s.apiTitle
+	if title == "" {
+		title = "velocity"
+	}
+	spec := map[string]any{
+		"openapi":    "3.1.0",
+		"x-protocol": "WEB/1",
+		"info":       map[string]string{"title": title, "version": s.apiVersion},
+		"paths":      paths,
+	}
+	if len(g.defs) > 0 {
+		spec["components"] = map[string]any{"schemas": g.defs}
+	}
+	return json.Marshal(spec)
+}
+
+type apiOperation struct {
+	Description string              `json:"description,omitempty"`
+	Parameters  []apiParameter      `json:"parameters,omitempty"`
+	RequestBody *apiBody            `json:"requestBody,omitempty"`
+	Responses   map[string]*apiBody `json:"responses"`
+	Prefix      bool                `json:"x-prefix,omitempty"`
+}
+
+type apiParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   map[string]any `json:"schema"`
+}
+
+type apiBody struct {
+	Description string                    `json:"description,omitempty"`
+	Content     map[string]map[string]any `json:"content,omitempty"`
+}
+
+// openAPIPath converts a route pattern to an OpenAPI path, with "{name}" for
+// each ":name", "*", or "**" segment, and returns its path parameters.
+func openAPIPath(pattern string) (string, []apiParameter) {
+	if !isParamPattern(pattern) {
+		return pattern, nil
+	}
+	segs := splitPath(pattern)
+	var params []apiParameter
+	for i, seg := range segs {
+		if !isVariableSegment(seg) {
+			continue
+		}
+		name := wildcardParam
+		if strings.HasPrefix(seg, ":") {
+			name = seg[1:]
+		}
+		segs[i] = "{" + name + "}"
+		params = append(params, apiParameter{Name: name, In: "path", Required: true, Schema: map[string]any{"type": "string"}})
+	}
+	return "/" + strings.Join(segs, "/"), params
+}
+
+// schemaGen derives JSON Schemas from Go types, collecting named struct types
+// in defs.
+type schemaGen struct {
+	defs  map[string]any
+	names map[reflect.Type]string
+}
+
+func (g *schemaGen) content(t reflect.Type) map[string]map[string]any {
+	return map[string]map[string]any{MIMEJSON: {"schema": g.schema(t)}}
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.PointerTo(t).Implements(textMarshalerType):
+		return map[string]any{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + g.define(t)}
+	}
+	return map[string]any{}
+}
+
+// define adds the named struct type t to g.defs, if it is not there yet, and
+// returns its component name.
+func (g *schemaGen) define(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := g.defs[name]; taken {
+		name = strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + name
+	}
+	g.names[t] = name
+	g.defs[name] = nil // reserve the name while recursing
+	g.defs[name] = g.object(t)
+	return name
+}
+
+// object returns the schema of struct type t, with a property per field as
+// encoding/json would marshal it.
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	var required []string
+	for _, f := range binStructFields(t) {
+		sf := t.FieldByIndex(f.index)
+		ps := g.schema(sf.Type)
+		if tag := sf.Tag.Get("validate"); tag != "" && tag != "-" {
+			if applyValidateRules(ps, sf.Type, tag) {
+				required = append(required, f.name)
+			}
+		}
+		props[f.name] = ps
+	}
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// applyValidateRules adds the JSON Schema equivalents of the Validate rules in
+// tag to ps, the schema of a field of type t, and reports whether the field
+// is required. Rules that cannot be expressed are left out.
+func applyValidateRules(ps map[string]any, t reflect.Type, tag string) (required bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if _, isRef := ps["$ref"]; isRef {
+		return strings.Contains(","+tag+",", ",required,")
+	}
+	var minKey, maxKey string
+	switch t.Kind() {
+	case reflect.String:
+		minKey, maxKey = "minLength", "maxLength"
+	case reflect.Slice, reflect.Array:
+		minKey, maxKey = "minItems", "maxItems"
+	case reflect.Map:
+		minKey, maxKey = "minProperties", "maxProperties"
+	default:
+		minKey, maxKey = "minimum", "maximum"
+	}
+	for rule := range strings.SplitSeq(tag, ",") {
+		rule, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		n, err := strconv.ParseFloat(param, 64)
+		switch rule {
+		case "required":
+			required = true
+		case "min":
+			if err == nil {
+				ps[minKey] = n
+			}
+		case "max":
+			if err == nil {
+				ps[maxKey] = n
+			}
+		case "len":
+			if err == nil && minKey != "minimum" {
+				ps[minKey], ps[maxKey] = n, n
+			}
+		case "oneof":
+			var enum []any
+			for v := range strings.FieldsSeq(param) {
+				if f, err := strconv.ParseFloat(v, 64); err == nil && t.Kind() != reflect.String {
+					enum = append(enum, f)
+				} else {
+					enum = append(enum, v)
+				}
+			}
+			ps["enum"] = enum
+		}
+	}
+	return required
+}
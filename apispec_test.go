@@ -0,0 +1,77 @@
+package velocity
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type specUser struct {
+	ID    string    `json:"id"`
+	Name  string    `json:"name" validate:"required,max=64"`
+	Role  string    `json:"role,omitempty" validate:"omitempty,oneof=admin member"`
+	Boss  *specUser `json:"boss,omitempty"`
+	Notes []byte    `json:"-"`
+}
+
+func TestAPISpec(t *testing.T) {
+	s := &Server{router: NewRouter()}
+	s.router.Write("/users", nopHandler, WithSchema(specUser{}, specUser{}, "Create a user"))
+	s.router.Read("/users/:id", nopHandler, WithSchema(nil, &specUser{}, "Get a user"))
+	s.router.HandlePrefix("/static/", nopHandler)
+
+	if name := MiddlewareName(WithSchema(nil, nil, "")); name != "velocity.WithSchema" {
+		t.Errorf("MiddlewareName = %q", name)
+	}
+
+	data, err := s.APISpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Description string
+			Parameters  []struct{ Name string }
+			RequestBody *struct{}
+			Responses   map[string]any
+			Prefix      bool `json:"x-prefix"`
+		}
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]any
+				Required   []string
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	create := spec.Paths["/users"]["write"]
+	if create.Description != "Create a user" || create.RequestBody == nil || create.Responses["ok"] == nil {
+		t.Errorf("write /users = %+v", create)
+	}
+	get := spec.Paths["/users/{id}"]["read"]
+	if len(get.Parameters) != 1 || get.Parameters[0].Name != "id" || get.RequestBody != nil {
+		t.Errorf("read /users/{id} = %+v", get)
+	}
+	if !spec.Paths["/static/"]["any"].Prefix {
+		t.Error("prefix route not marked")
+	}
+
+	user := spec.Components.Schemas["specUser"]
+	if len(user.Required) != 1 || user.Required[0] != "name" {
+		t.Errorf("required = %v", user.Required)
+	}
+	if user.Properties["name"]["maxLength"] != 64.0 {
+		t.Errorf("name schema = %v", user.Properties["name"])
+	}
+	if user.Properties["boss"]["$ref"] != "#/components/schemas/specUser" {
+		t.Errorf("boss schema = %v", user.Properties["boss"])
+	}
+	if _, ok := user.Properties["Notes"]; ok {
+		t.Error(`field tagged json:"-" described`)
+	}
+	if enum, _ := user.Properties["role"]["enum"].([]any); len(enum) != 2 {
+		t.Errorf("role schema = %v", user.Properties["role"])
+	}
+}
@@ -0,0 +1,334 @@
+package velocity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// Audit outcomes. Any string is a valid outcome; these are the common ones.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// LogEntryAudit is the Merkle log entry type WithAudit appends for each audit
+// record when AuditOptions.Log is set. An encoded entry stores its type in a
+// single byte; LogEntryAudit fits it and lies well above the entry types nwep
+// defines.
+const LogEntryAudit = 0x40
+
+// AuditRecord is one entry of the audit trail. Records are chained: each
+// carries the hash of the record before it, so removing, reordering, or
+// editing a record breaks the chain, which VerifyAuditChain detects.
+type AuditRecord struct {
+	// Seq numbers the records of a trail, from 1. A server run starts a
+	// new trail unless AuditOptions.Resume continues an earlier one.
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+
+	// Peer is the requesting peer's node ID (see FormatNodeID), or "" for
+	// an unauthenticated peer or a record written with Server.Audit.
+	Peer string `json:"peer,omitempty"`
+
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+	Outcome  string `json:"outcome"`
+
+	// Method, Path, RequestID, and Tenant describe the request the record
+	// was written for, if any.
+	Method    string `json:"method,omitempty"`
+	Path      string `json:"path,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+
+	Attrs map[string]string `json:"attrs,omitempty"`
+
+	// Prev is the Hash of the previous record, or "" for the first.
+	Prev string `json:"prev"`
+
+	// Hash is the hex SHA-256 of the record's JSON encoding with Hash and
+	// LogIndex empty.
+	Hash string `json:"hash"`
+
+	// LogIndex is the index of the Merkle log entry the record's hash was
+	// appended as, if AuditOptions.Log is set. It is not covered by Hash.
+	LogIndex *uint64 `json:"log_index,omitempty"`
+}
+
+// hash returns the hash r's Hash field must hold.
+func (r AuditRecord) hash() ([32]byte, error) {
+	r.Hash, r.LogIndex = "", nil
+	b, err := json.Marshal(r)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(b), nil
+}
+
+// AuditSink receives audit records, in order. A sink must not modify or
+// retain the record.
+type AuditSink interface {
+	WriteAudit(rec *AuditRecord) error
+}
+
+// AuditSinkFunc adapts a function to an AuditSink.
+type AuditSinkFunc func(rec *AuditRecord) error
+
+// WriteAudit implements AuditSink.
+func (f AuditSinkFunc) WriteAudit(rec *AuditRecord) error { return f(rec) }
+
+// AuditWriter returns an AuditSink that writes each record to w as a line of
+// JSON, ready to be read back and checked with VerifyAuditChain.
+func AuditWriter(w io.Writer) AuditSink {
+	return AuditSinkFunc(func(rec *AuditRecord) error {
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(b, '\n'))
+		return err
+	})
+}
+
+// AuditOptions configures WithAudit.
+type AuditOptions struct {
+	// Sinks receive every record. Empty means the server's Logger, as
+	// info entries "audit".
+	Sinks []AuditSink
+
+	// Log, if set, receives an entry for every record, of type
+	// LogEntryAudit, holding the record's hash in its Pubkey field and
+	// signed with the server's keypair, so that the trail is anchored in
+	// a Merkle log that others can audit (see LogService and
+	// WithCheckpointSigner).
+	Log *LogService
+
+	// Resume, if set, is the last record of the trail written before, read
+	// back from a sink (see LastAuditRecord). The server's first record
+	// follows it, so that the trail continues across restarts. Without it,
+	// every run starts a trail at Seq 1.
+	Resume *AuditRecord
+}
+
+// auditTrail is the state of the server's audit trail.
+type auditTrail struct {
+	opts AuditOptions
+
+	mu   sync.Mutex // serializes records, so that the chain is in order
+	seq  uint64
+	prev string
+}
+
+// WithAudit enables the audit trail written by Context.Audit and
+// Server.Audit. Without it, audit records are written to the server's Logger
+// and are not chained.
+func WithAudit(opts AuditOptions) Option {
+	return func(s *Server) error {
+		for _, sink := range opts.Sinks {
+			if sink == nil {
+				return errors.New("velocity: audit sink must not be nil")
+			}
+		}
+		a := &auditTrail{opts: opts}
+		if r := opts.Resume; r != nil {
+			if err := VerifyAuditChain([]AuditRecord{*r}); err != nil {
+				return fmt.Errorf("velocity: audit resume record: %w", err)
+			}
+			a.seq, a.prev = r.Seq, r.Hash
+		}
+		s.audit = a
+		return nil
+	}
+}
+
+// Audit records a security-relevant operation in the server's audit trail
+// (see WithAudit), with the requesting peer, the time, and the request's
+// method, path, request ID, and tenant:
+//
+//	if err := c.Audit("delete", "/users/"+id, velocity.AuditSuccess, "reason", reason); err != nil {
+//	    return err
+//	}
+//
+// attrs are alternating keys and values, like a Logger's arguments; values
+// are formatted with fmt.Sprint. Audit returns an error, joining those of
+// the sinks and the Merkle log, if the record could not be written
+// everywhere; a request whose operation must not go unaudited should fail
+// then.
+func (c *Context) Audit(action, resource, outcome string, attrs ...any) error {
+	rec := &AuditRecord{
+		Action:   action,
+		Resource: resource,
+		Outcome:  outcome,
+		Method:   c.Method(),
+		Path:     c.Path(),
+		Tenant:   c.tenant,
+		Attrs:    auditAttrs(attrs),
+	}
+	if peer := c.PeerNodeID(); !peer.IsZero() {
+		rec.Peer = FormatNodeID(peer)
+	}
+	if id := c.RequestID(); id != ([16]byte{}) {
+		rec.RequestID = hex.EncodeToString(id[:])
+	}
+	if c.server == nil {
+		return nil
+	}
+	return c.server.writeAudit(rec)
+}
+
+// Audit records an operation the server performed on its own behalf, outside
+// a request, such as a key rotation. See Context.Audit.
+func (s *Server) Audit(action, resource, outcome string, attrs ...any) error {
+	return s.writeAudit(&AuditRecord{
+		Action:   action,
+		Resource: resource,
+		Outcome:  outcome,
+		Attrs:    auditAttrs(attrs),
+	})
+}
+
+// auditAttrs converts key-value pairs to a record's Attrs. A key without a
+// value gets "".
+func auditAttrs(attrs []any) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(attrs)/2)
+	for i := 0; i < len(attrs); i += 2 {
+		var v string
+		if i+1 < len(attrs) {
+			v = fmt.Sprint(attrs[i+1])
+		}
+		m[fmt.Sprint(attrs[i])] = v
+	}
+	return m
+}
+
+// writeAudit timestamps, chains, and writes rec. The chain advances only if
+// rec was written everywhere; otherwise the next record takes rec's place,
+// with the same Seq and Prev.
+func (s *Server) writeAudit(rec *AuditRecord) error {
+	a := s.audit
+	if a == nil {
+		args := []any{"action", rec.Action, "resource", rec.Resource, "outcome", rec.Outcome, "peer", rec.Peer}
+		for k, v := range rec.Attrs {
+			args = append(args, k, v)
+		}
+		s.logger.Info("audit", args...)
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rec.Seq = a.seq + 1
+	rec.Time = time.Now().UTC()
+	rec.Prev = a.prev
+	sum, err := rec.hash()
+	if err != nil {
+		return fmt.Errorf("velocity: audit record: %w", err)
+	}
+	rec.Hash = hex.EncodeToString(sum[:])
+
+	var errs []error
+	if a.opts.Log != nil {
+		index, err := s.anchorAudit(a.opts.Log, rec, sum)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			rec.LogIndex = &index
+		}
+	}
+	if len(a.opts.Sinks) == 0 {
+		s.logger.Info("audit", "seq", rec.Seq, "action", rec.Action, "resource", rec.Resource,
+			"outcome", rec.Outcome, "peer", rec.Peer, "hash", rec.Hash)
+	}
+	for _, sink := range a.opts.Sinks {
+		if err := sink.WriteAudit(rec); err != nil {
+			errs = append(errs, fmt.Errorf("velocity: audit sink: %w", err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		s.logger.Error("audit record not written", "seq", rec.Seq, "action", rec.Action, "error", err.Error())
+		return err
+	}
+	a.seq, a.prev = rec.Seq, rec.Hash
+	return nil
+}
+
+// anchorAudit appends the entry for a record with hash sum to l.
+func (s *Server) anchorAudit(l *LogService, rec *AuditRecord, sum [32]byte) (uint64, error) {
+	kp := s.Keypair()
+	id, err := kp.NodeID()
+	if err != nil {
+		return 0, fmt.Errorf("velocity: audit log entry: %w", err)
+	}
+	entry := &nwep.MerkleEntry{
+		Type:      LogEntryAudit,
+		Timestamp: uint64(rec.Time.UnixNano()),
+		NodeID:    id,
+		Pubkey:    sum,
+	}
+	encoded, err := nwep.MerkleEntryEncode(entry)
+	if err != nil {
+		return 0, fmt.Errorf("velocity: audit log entry: %w", err)
+	}
+	if entry.Signature, err = nwep.Sign(kp, encoded); err != nil {
+		return 0, fmt.Errorf("velocity: audit log entry: %w", err)
+	}
+	return l.Append(entry)
+}
+
+// VerifyAuditChain checks that recs are consecutive records of one audit
+// trail, as written by WithAudit: that each record's Hash matches its
+// contents and each record's Prev is the Hash of the one before it. A record
+// followed by one with the same Seq and Prev is one that a sink took but
+// another sink or the Merkle log did not; the record after it replaced it in
+// the chain, and it is skipped. VerifyAuditChain returns nil if the records
+// are a chain, and otherwise an error wrapping ErrAuditTampered that names
+// the first record that is not. The first record may be from the middle of a
+// trail; check that it is Seq 1 to rule out records removed from the start.
+func VerifyAuditChain(recs []AuditRecord) error {
+	var last *AuditRecord
+	for i := range recs {
+		r := &recs[i]
+		sum, err := r.hash()
+		if err != nil {
+			return fmt.Errorf("velocity: audit record %d: %w", r.Seq, err)
+		}
+		if hex.EncodeToString(sum[:]) != r.Hash {
+			return fmt.Errorf("%w: record %d does not match its hash", ErrAuditTampered, r.Seq)
+		}
+		replaced := last != nil && r.Seq == last.Seq && r.Prev == last.Prev && r.Hash != last.Hash
+		if last != nil && !replaced && (r.Prev != last.Hash || r.Seq != last.Seq+1) {
+			return fmt.Errorf("%w: record %d does not follow record %d", ErrAuditTampered, r.Seq, last.Seq)
+		}
+		last = r
+	}
+	return nil
+}
+
+// LastAuditRecord returns the last record in r, which holds records as
+// written by AuditWriter, or nil if r holds none. Pass it as
+// AuditOptions.Resume to continue the trail of an AuditWriter file.
+func LastAuditRecord(r io.Reader) (*AuditRecord, error) {
+	var last *AuditRecord
+	dec := json.NewDecoder(r)
+	for {
+		var rec AuditRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return last, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("velocity: reading audit records: %w", err)
+		}
+		last = &rec
+	}
+}
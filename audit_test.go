@@ -0,0 +1,111 @@
+package velocity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestAuditChain(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Server{logger: DefaultLogger(), router: NewRouter(), peers: newPeerTracker()}
+	if err := WithAudit(AuditOptions{Sinks: []AuditSink{AuditWriter(&buf)}})(s); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := NewTestContext(MethodDelete, "/users/7", nil)
+	c.server = s
+	if err := c.Audit("delete", "/users/7", AuditSuccess, "reason", "request", "dangling"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Audit("rotate-key", "keypair", AuditFailure); err != nil {
+		t.Fatal(err)
+	}
+
+	var recs []AuditRecord
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var r AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, r)
+	}
+	if len(recs) != 2 || recs[0].Seq != 1 || recs[0].Method != MethodDelete ||
+		recs[0].Attrs["reason"] != "request" || recs[0].Attrs["dangling"] != "" {
+		t.Fatalf("records = %+v", recs)
+	}
+	if err := VerifyAuditChain(recs); err != nil {
+		t.Fatal(err)
+	}
+
+	recs[0].Outcome = AuditDenied
+	if err := VerifyAuditChain(recs); !errors.Is(err, ErrAuditTampered) {
+		t.Fatalf("edited record: %v", err)
+	}
+	if err := VerifyAuditChain(recs[1:]); err != nil {
+		t.Fatalf("suffix of a chain: %v", err)
+	}
+	if err := VerifyAuditChain([]AuditRecord{recs[1], recs[1]}); !errors.Is(err, ErrAuditTampered) {
+		t.Fatalf("duplicated record: %v", err)
+	}
+}
+
+func TestAuditFailedWriteAndResume(t *testing.T) {
+	var buf bytes.Buffer
+	fail := true
+	flaky := AuditSinkFunc(func(rec *AuditRecord) error {
+		if fail {
+			fail = false
+			return errors.New("disk full")
+		}
+		return nil
+	})
+	s := &Server{logger: DefaultLogger(), router: NewRouter(), peers: newPeerTracker()}
+	if err := WithAudit(AuditOptions{Sinks: []AuditSink{AuditWriter(&buf), flaky}})(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Audit("a", "r", AuditSuccess); err == nil {
+		t.Fatal("failed sink not reported")
+	}
+	for _, action := range []string{"b", "c"} {
+		if err := s.Audit(action, "r", AuditSuccess); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last, err := LastAuditRecord(bytes.NewReader(buf.Bytes()))
+	if err != nil || last == nil || last.Seq != 2 || last.Action != "c" {
+		t.Fatalf("LastAuditRecord = %+v, %v", last, err)
+	}
+
+	restarted := &Server{logger: DefaultLogger(), router: NewRouter(), peers: newPeerTracker()}
+	if err := WithAudit(AuditOptions{Sinks: []AuditSink{AuditWriter(&buf)}, Resume: last})(restarted); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Audit("d", "r", AuditSuccess); err != nil {
+		t.Fatal(err)
+	}
+
+	var recs []AuditRecord
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r AuditRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, r)
+	}
+	if len(recs) != 4 || recs[0].Seq != 1 || recs[1].Seq != 1 || recs[3].Seq != 3 {
+		t.Fatalf("records = %+v", recs)
+	}
+	if err := VerifyAuditChain(recs); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := *last
+	edited.Outcome = AuditDenied
+	if err := WithAudit(AuditOptions{Resume: &edited})(restarted); !errors.Is(err, ErrAuditTampered) {
+		t.Fatalf("WithAudit with an edited resume record = %v", err)
+	}
+}
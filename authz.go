@@ -0,0 +1,305 @@
+package velocity
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+const contextKeyRoles = "velocity.roles"
+
+// PolicyStore maps peers to the roles that RequireRole checks. Roles here are
+// application authorization roles, such as "admin"; they are unrelated to the
+// WEB/1 handshake role in ConnInfo.Role.
+//
+// RolePolicy and FilePolicy map node IDs to roles. A custom store can also
+// use the peer's verified identity, or look roles up in a database.
+type PolicyStore interface {
+	// Roles returns the roles of peer. identity is the peer's verified
+	// identity if TrustVerify ran before RequireRole and found one, and nil
+	// otherwise.
+	Roles(ctx context.Context, peer nwep.NodeID, identity *nwep.VerifiedIdentity) ([]string, error)
+}
+
+// PolicyStoreFunc adapts a function to the PolicyStore interface.
+type PolicyStoreFunc func(ctx context.Context, peer nwep.NodeID, identity *nwep.VerifiedIdentity) ([]string, error)
+
+// Roles calls f(ctx, peer, identity).
+func (f PolicyStoreFunc) Roles(ctx context.Context, peer nwep.NodeID, identity *nwep.VerifiedIdentity) ([]string, error) {
+	return f(ctx, peer, identity)
+}
+
+// WithPolicyStore sets the store RequireRole resolves peers' roles with. It
+// can be replaced while the server runs with Server.SetPolicyStore. This
+// option returns an error if store is nil.
+func WithPolicyStore(store PolicyStore) Option {
+	return func(s *Server) error {
+		if store == nil {
+			return errors.New("velocity: nil policy store")
+		}
+		s.SetPolicyStore(store)
+		return nil
+	}
+}
+
+// SetPolicyStore replaces the server's policy store. Requests that have
+// already resolved their roles keep them; later requests use store. A nil
+// store leaves every peer without roles.
+func (s *Server) SetPolicyStore(store PolicyStore) {
+	s.policyMu.Lock()
+	s.policy = store
+	s.policyMu.Unlock()
+}
+
+// PolicyStore returns the server's policy store, or nil if there is none.
+func (s *Server) PolicyStore() PolicyStore {
+	s.policyMu.RLock()
+	defer s.policyMu.RUnlock()
+	return s.policy
+}
+
+// PeerRoles returns the roles of the requesting peer, resolved with the
+// server's policy store on first use and remembered for the rest of the
+// request. It returns no roles for an unauthenticated peer or when the server
+// has no policy store, and the store's error if the lookup fails.
+func PeerRoles(c *Context) ([]string, error) {
+	if v, ok := c.Get(contextKeyRoles); ok {
+		roles, _ := v.([]string)
+		return roles, nil
+	}
+	peer := c.PeerNodeID()
+	if peer.IsZero() || c.server == nil {
+		return nil, nil
+	}
+	store := c.server.PolicyStore()
+	if store == nil {
+		return nil, nil
+	}
+	roles, err := store.Roles(c.Ctx(), peer, VerifiedIdentity(c))
+	if err != nil {
+		return nil, fmt.Errorf("velocity: resolve peer roles: %w", err)
+	}
+	c.Set(contextKeyRoles, roles)
+	return roles, nil
+}
+
+// RequireRole returns middleware that admits peers holding at least one of
+// roles, as resolved by the server's policy store (see WithPolicyStore).
+// Unauthenticated peers are rejected with status "unauthorized" and the
+// message "peer identity required", and peers without a listed role with
+// status "forbidden" and the message "role required". A policy store error
+// fails the request with it. Apply it to a group or route:
+//
+//	admin := srv.Group("/admin", velocity.RequireRole("admin"))
+//	admin.Delete("/users/:id", deleteUser, velocity.RequireRole("owner"))
+//
+// RequireRole must run after TrustVerify, whose verified identity it passes to
+// the policy store; ValidateMiddleware checks this. RequireRole panics if
+// roles is empty.
+func RequireRole(roles ...string) MiddlewareFunc {
+	if len(roles) == 0 {
+		panic("velocity: RequireRole requires at least one role")
+	}
+	roles = slices.Clone(roles)
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if c.PeerNodeID().IsZero() {
+				return c.Unauthorized("peer identity required")
+			}
+			have, err := PeerRoles(c)
+			if err != nil {
+				return err
+			}
+			for _, r := range have {
+				if slices.Contains(roles, r) {
+					return next(c)
+				}
+			}
+			return c.Forbidden("role required")
+		}
+	}
+}
+
+// RolePolicy is a PolicyStore that maps node IDs to roles in memory. The
+// roles of the wildcard entry, set with SetDefaultRoles, are held by every
+// authenticated peer as well as its own. A RolePolicy is safe for concurrent
+// use and may be changed while the server runs.
+type RolePolicy struct {
+	mu       sync.RWMutex
+	peers    map[nwep.NodeID][]string
+	defaults []string
+}
+
+// NewRolePolicy returns a RolePolicy with the given mapping, which it copies.
+func NewRolePolicy(peers map[nwep.NodeID][]string) *RolePolicy {
+	p := &RolePolicy{peers: make(map[nwep.NodeID][]string, len(peers))}
+	for id, roles := range peers {
+		p.peers[id] = slices.Clone(roles)
+	}
+	return p
+}
+
+// SetRoles replaces the roles of peer. No roles removes the peer.
+func (p *RolePolicy) SetRoles(peer nwep.NodeID, roles ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		p.peers = make(map[nwep.NodeID][]string)
+	}
+	if len(roles) == 0 {
+		delete(p.peers, peer)
+		return
+	}
+	p.peers[peer] = slices.Clone(roles)
+}
+
+// SetDefaultRoles replaces the roles every authenticated peer holds.
+func (p *RolePolicy) SetDefaultRoles(roles ...string) {
+	p.mu.Lock()
+	p.defaults = slices.Clone(roles)
+	p.mu.Unlock()
+}
+
+// Replace atomically replaces the whole mapping, default roles included.
+func (p *RolePolicy) Replace(peers map[nwep.NodeID][]string, defaults []string) {
+	np := NewRolePolicy(peers)
+	p.mu.Lock()
+	p.peers, p.defaults = np.peers, slices.Clone(defaults)
+	p.mu.Unlock()
+}
+
+// Roles returns the default roles followed by peer's own.
+func (p *RolePolicy) Roles(_ context.Context, peer nwep.NodeID, _ *nwep.VerifiedIdentity) ([]string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return slices.Concat(p.defaults, p.peers[peer]), nil
+}
+
+// policyFileCheckInterval bounds how often a FilePolicy checks its file for
+// changes.
+const policyFileCheckInterval = time.Second
+
+// FilePolicy is a RolePolicy loaded from a file and reloaded whenever the
+// file changes, so roles can be edited without restarting the server. Each
+// line of the file names a peer, in the form produced by FormatNodeID, or *
+// for every authenticated peer, followed by its roles, separated by spaces or
+// commas:
+//
+//	# operators
+//	4f1c...9a2e admin, deploy
+//	* reader
+//
+// Blank lines and lines starting with # are ignored. The file is checked for
+// changes at most once a second, when roles are looked up. A change that
+// fails to parse is reported by Err and leaves the previous mapping in force.
+type FilePolicy struct {
+	RolePolicy
+	path string
+
+	mu      sync.Mutex
+	checked time.Time
+	modTime time.Time
+	err     error
+}
+
+// LoadPolicyFile returns a FilePolicy for the file at path. This function
+// returns an error if the file cannot be read or parsed.
+func LoadPolicyFile(path string) (*FilePolicy, error) {
+	f := &FilePolicy{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload reads the file now, whether or not it has changed. On error the
+// previous mapping stays in force.
+func (f *FilePolicy) Reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reload()
+}
+
+// reload reads the file. The caller must hold f.mu.
+func (f *FilePolicy) reload() error {
+	f.checked = time.Now()
+	file, err := os.Open(f.path)
+	if err != nil {
+		f.err = fmt.Errorf("velocity: policy file: %w", err)
+		return f.err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		f.err = fmt.Errorf("velocity: policy file: %w", err)
+		return f.err
+	}
+	peers, defaults, err := parsePolicy(file, f.path)
+	if err != nil {
+		f.err = err
+		return err
+	}
+	f.RolePolicy.Replace(peers, defaults)
+	f.modTime, f.err = info.ModTime(), nil
+	return nil
+}
+
+// Err returns the error from the last reload, or nil if it succeeded.
+func (f *FilePolicy) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// Roles reloads the file if it has changed and returns peer's roles.
+func (f *FilePolicy) Roles(ctx context.Context, peer nwep.NodeID, identity *nwep.VerifiedIdentity) ([]string, error) {
+	f.mu.Lock()
+	if time.Since(f.checked) >= policyFileCheckInterval {
+		f.checked = time.Now()
+		if info, err := os.Stat(f.path); err == nil && !info.ModTime().Equal(f.modTime) {
+			_ = f.reload()
+		}
+	}
+	f.mu.Unlock()
+	return f.RolePolicy.Roles(ctx, peer, identity)
+}
+
+// parsePolicy parses the FilePolicy format. name is used in error messages.
+func parsePolicy(r io.Reader, name string) (map[nwep.NodeID][]string, []string, error) {
+	peers := make(map[nwep.NodeID][]string)
+	var defaults []string
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if len(fields) == 0 {
+			continue
+		}
+		who, roles := fields[0], fields[1:]
+		if who == "*" {
+			defaults = append(defaults, roles...)
+			continue
+		}
+		id, err := NodeIDFromString(who)
+		if err != nil {
+			return nil, nil, fmt.Errorf("velocity: policy file %s:%d: %w", name, n, err)
+		}
+		peers[id] = append(peers[id], roles...)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, fmt.Errorf("velocity: policy file: %w", err)
+	}
+	return peers, defaults, nil
+}
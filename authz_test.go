@@ -0,0 +1,68 @@
+package velocity
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestRolePolicy(t *testing.T) {
+	a, b := nwep.NodeID{1}, nwep.NodeID{2}
+	p := NewRolePolicy(map[nwep.NodeID][]string{a: {"admin"}})
+	p.SetDefaultRoles("reader")
+	ctx := context.Background()
+	if got, _ := p.Roles(ctx, a, nil); !slices.Equal(got, []string{"reader", "admin"}) {
+		t.Fatalf("roles of a = %v", got)
+	}
+	if got, _ := p.Roles(ctx, b, nil); !slices.Equal(got, []string{"reader"}) {
+		t.Fatalf("roles of b = %v", got)
+	}
+	p.SetRoles(a)
+	if got, _ := p.Roles(ctx, a, nil); !slices.Equal(got, []string{"reader"}) {
+		t.Fatalf("roles of a after removal = %v", got)
+	}
+}
+
+func TestFilePolicy(t *testing.T) {
+	a := nwep.NodeID{1}
+	path := filepath.Join(t.TempDir(), "roles")
+	write := func(data string, mod time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	write("# operators\n"+FormatNodeID(a)+" admin, deploy\n* reader\n", start)
+	f, err := LoadPolicyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if got, _ := f.Roles(ctx, a, nil); !slices.Equal(got, []string{"reader", "admin", "deploy"}) {
+		t.Fatalf("roles = %v", got)
+	}
+
+	write(FormatNodeID(a)+" reader\n", start.Add(time.Minute))
+	f.checked = time.Time{}
+	if got, _ := f.Roles(ctx, a, nil); !slices.Equal(got, []string{"reader"}) {
+		t.Fatalf("roles after reload = %v", got)
+	}
+
+	write("bogus admin\n", start.Add(2*time.Minute))
+	f.checked = time.Time{}
+	if got, _ := f.Roles(ctx, a, nil); !slices.Equal(got, []string{"reader"}) {
+		t.Fatalf("roles after bad reload = %v", got)
+	}
+	if f.Err() == nil {
+		t.Fatal("bad reload not reported by Err")
+	}
+}
@@ -0,0 +1,119 @@
+package velocity
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+// BindParams populates the fields of the struct pointed to by v from the path
+// parameters captured by the matched route. Each field to bind is tagged with
+// the parameter name:
+//
+//	type userPath struct {
+//		Org string `param:"org"`
+//		ID  int64  `param:"id"`
+//	}
+//
+//	var p userPath
+//	if err := c.BindParams(&p); err != nil {
+//		return c.BadRequest(err.Error())
+//	}
+//
+// Supported field types are string, bool, the signed and unsigned integer
+// types, float32, float64, and any type implementing encoding.TextUnmarshaler.
+// Fields whose parameter was not captured are left unchanged; untagged and
+// unexported fields are ignored.
+//
+// BindParams returns an error if v is not a non-nil pointer to a struct, if a
+// tagged field has an unsupported type, or if a value cannot be converted to
+// the field's type. The error names the offending parameter.
+func (c *Context) BindParams(v any) error {
+	return bindTagged(v, "param", func(name string) (string, bool) {
+		for _, p := range c.params {
+			if p.name == name {
+				return p.value, true
+			}
+		}
+		return "", false
+	})
+}
+
+// bindTagged sets each field of the struct pointed to by v that carries tag
+// from the value lookup returns for the tag's name.
+func bindTagged(v any, tag string, lookup func(name string) (string, bool)) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("velocity: bind %s: expected non-nil pointer to struct, got %T", tag, v)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		f := rt.Field(i)
+		name, ok := f.Tag.Lookup(tag)
+		if !ok || name == "" || name == "-" || !f.IsExported() {
+			continue
+		}
+		s, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(rv.Field(i), s); err != nil {
+			return fmt.Errorf("velocity: bind %s %q: %w", tag, name, err)
+		}
+	}
+	return nil
+}
+
+// setField converts s to the type of fv and stores it.
+func setField(fv reflect.Value, s string) error {
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return numError(err)
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		fv.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
+
+// numError unwraps a *strconv.NumError so the bind error reads
+// `velocity: bind param "id": parsing "abc": invalid syntax` rather than
+// repeating the strconv function name.
+func numError(err error) error {
+	var ne *strconv.NumError
+	if errors.As(err, &ne) {
+		return fmt.Errorf("parsing %q: %w", ne.Num, ne.Err)
+	}
+	return err
+}
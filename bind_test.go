@@ -0,0 +1,40 @@
+package velocity
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBindParams(t *testing.T) {
+	c := &Context{params: []pathParam{{"org", "acme"}, {"id", "42"}, {"live", "true"}}}
+
+	var p struct {
+		Org    string `param:"org"`
+		ID     uint32 `param:"id"`
+		Live   bool   `param:"live"`
+		Absent int    `param:"absent"`
+		Plain  string
+	}
+	p.Absent = 7
+	if err := c.BindParams(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Org != "acme" || p.ID != 42 || !p.Live || p.Absent != 7 {
+		t.Fatalf("bound %+v", p)
+	}
+}
+
+func TestBindParamsErrors(t *testing.T) {
+	c := &Context{params: []pathParam{{"id", "abc"}}}
+
+	var p struct {
+		ID int `param:"id"`
+	}
+	err := c.BindParams(&p)
+	if err == nil || !strings.Contains(err.Error(), `param "id"`) {
+		t.Fatalf("err = %v, want error naming the parameter", err)
+	}
+	if err := c.BindParams(p); err == nil {
+		t.Fatal("expected error for non-pointer")
+	}
+}
@@ -0,0 +1,462 @@
+package velocity
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Media types of the binary encodings sent by Context.CBOR and
+// Context.MsgPack.
+const (
+	MIMECBOR    = "application/cbor"
+	MIMEMsgPack = "application/msgpack"
+)
+
+// CBOR encodes v as CBOR (RFC 8949) and sends it with a
+// "content-type: application/cbor" header. The response status is the one
+// previously set with SetStatus, or "ok" if none was set.
+//
+// If a codec is registered for MIMECBOR (see RegisterCodec), it does the
+// encoding. Otherwise a built-in encoder is used, which follows the
+// encoding/json conventions for Go values: struct fields are keyed by their
+// json tag names and honour "omitempty" and "-", []byte becomes a byte string,
+// types implementing encoding.TextMarshaler become text, and time.Time becomes
+// a tagged RFC 3339 date. Decoding CBOR request bodies with Bind needs a
+// registered codec. This function returns a non-nil error if v cannot be
+// encoded, for instance because it contains a channel or function, or if the
+// response write fails.
+func (c *Context) CBOR(v any) error {
+	return c.renderBinary(MIMECBOR, formatCBOR, v)
+}
+
+// MsgPack is like CBOR but encodes v as MessagePack and sends it with a
+// "content-type: application/msgpack" header. The built-in encoder sends
+// time.Time as a MessagePack timestamp; a codec registered for MIMEMsgPack
+// takes precedence over it.
+func (c *Context) MsgPack(v any) error {
+	return c.renderBinary(MIMEMsgPack, formatMsgPack, v)
+}
+
+// MarshalCBOR encodes v as CBOR, as Context.CBOR does, for bodies sent
+// other than as a response, such as notifications.
+func MarshalCBOR(v any) ([]byte, error) {
+	return marshalBinary(MIMECBOR, formatCBOR, v)
+}
+
+// MarshalMsgPack encodes v as MessagePack, as Context.MsgPack does.
+func MarshalMsgPack(v any) ([]byte, error) {
+	return marshalBinary(MIMEMsgPack, formatMsgPack, v)
+}
+
+// marshalBinary encodes v with the codec registered for mt, or else with the
+// built-in encoder for f.
+func marshalBinary(mt string, f binFormat, v any) ([]byte, error) {
+	if codec, ok := LookupCodec(mt); ok {
+		return codec.Marshal(v)
+	}
+	e := binEncoder{format: f}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func (c *Context) renderBinary(mt string, f binFormat, v any) error {
+	data, err := marshalBinary(mt, f, v)
+	if err != nil {
+		return err
+	}
+	status := c.status
+	if status == "" {
+		status = StatusOK
+	}
+	c.SetHeader("content-type", mt)
+	return c.Respond(status, data)
+}
+
+type binFormat int
+
+const (
+	formatCBOR binFormat = iota
+	formatMsgPack
+)
+
+var (
+	timeType            = reflect.TypeFor[time.Time]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	binStructFieldCache sync.Map // reflect.Type -> []binField
+)
+
+// binEncoder appends values to buf in CBOR or MessagePack.
+type binEncoder struct {
+	format binFormat
+	buf    []byte
+}
+
+func (e *binEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.null()
+		return nil
+	}
+	t := v.Type()
+	if (t.Kind() == reflect.Pointer || t.Kind() == reflect.Interface) && v.IsNil() {
+		e.null()
+		return nil
+	}
+	if t == timeType {
+		e.time(v.Interface().(time.Time))
+		return nil
+	}
+	if t.Kind() != reflect.Interface && t.Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		e.str(string(text))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		e.bool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uint(v.Uint())
+	case reflect.Float32:
+		e.float32(float32(v.Float()))
+	case reflect.Float64:
+		e.float64(v.Float())
+	case reflect.String:
+		e.str(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.null()
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			e.bytes(v.Bytes())
+			return nil
+		}
+		return e.array(v)
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.bytes(b)
+			return nil
+		}
+		return e.array(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.null()
+			return nil
+		}
+		keys := v.MapKeys()
+		if t.Key().Kind() == reflect.String {
+			slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+		}
+		e.head(majorMap, uint64(len(keys)))
+		for _, k := range keys {
+			if err := e.encode(k); err != nil {
+				return err
+			}
+			if err := e.encode(v.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := binStructFields(t)
+		present := make([]reflect.Value, len(fields))
+		n := 0
+		for i, f := range fields {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+				continue
+			}
+			present[i] = fv
+			n++
+		}
+		e.head(majorMap, uint64(n))
+		for i, f := range fields {
+			if !present[i].IsValid() {
+				continue
+			}
+			e.str(f.name)
+			if err := e.encode(present[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("velocity: cannot encode %s", t)
+	}
+	return nil
+}
+
+func (e *binEncoder) array(v reflect.Value) error {
+	e.head(majorArray, uint64(v.Len()))
+	for i := range v.Len() {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CBOR major types. MessagePack has no such notion, but the same values
+// select its array, map, string, and binary headers in head.
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+)
+
+// head appends a header for a value of the given major type and length or
+// value n.
+func (e *binEncoder) head(major byte, n uint64) {
+	if e.format == formatCBOR {
+		switch {
+		case n < 24:
+			e.buf = append(e.buf, major<<5|byte(n))
+		case n <= math.MaxUint8:
+			e.buf = append(e.buf, major<<5|24, byte(n))
+		case n <= math.MaxUint16:
+			e.buf = binary.BigEndian.AppendUint16(append(e.buf, major<<5|25), uint16(n))
+		case n <= math.MaxUint32:
+			e.buf = binary.BigEndian.AppendUint32(append(e.buf, major<<5|26), uint32(n))
+		default:
+			e.buf = binary.BigEndian.AppendUint64(append(e.buf, major<<5|27), n)
+		}
+		return
+	}
+	// MessagePack: fixed forms first, then 8-, 16-, and 32-bit lengths.
+	var fix, fixMax byte
+	var codes [3]byte // 8-bit, 16-bit, 32-bit forms; 0 if there is none
+	switch major {
+	case majorText:
+		fix, fixMax, codes = 0xa0, 31, [3]byte{0xd9, 0xda, 0xdb}
+	case majorBytes:
+		fixMax, codes = 0, [3]byte{0xc4, 0xc5, 0xc6}
+	case majorArray:
+		fix, fixMax, codes = 0x90, 15, [3]byte{0, 0xdc, 0xdd}
+	case majorMap:
+		fix, fixMax, codes = 0x80, 15, [3]byte{0, 0xde, 0xdf}
+	}
+	switch {
+	case fix != 0 && n <= uint64(fixMax):
+		e.buf = append(e.buf, fix|byte(n))
+	case codes[0] != 0 && n <= math.MaxUint8:
+		e.buf = append(e.buf, codes[0], byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, codes[1]), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, codes[2]), uint32(n))
+	}
+}
+
+func (e *binEncoder) null() {
+	if e.format == formatCBOR {
+		e.buf = append(e.buf, 0xf6)
+	} else {
+		e.buf = append(e.buf, 0xc0)
+	}
+}
+
+func (e *binEncoder) bool(b bool) {
+	switch {
+	case e.format == formatCBOR && b:
+		e.buf = append(e.buf, 0xf5)
+	case e.format == formatCBOR:
+		e.buf = append(e.buf, 0xf4)
+	case b:
+		e.buf = append(e.buf, 0xc3)
+	default:
+		e.buf = append(e.buf, 0xc2)
+	}
+}
+
+func (e *binEncoder) uint(n uint64) {
+	if e.format == formatCBOR {
+		e.head(majorUint, n)
+		return
+	}
+	switch {
+	case n <= 0x7f:
+		e.buf = append(e.buf, byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xce), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcf), n)
+	}
+}
+
+func (e *binEncoder) int(n int64) {
+	if n >= 0 {
+		e.uint(uint64(n))
+		return
+	}
+	if e.format == formatCBOR {
+		e.head(majorNegInt, uint64(-(n + 1)))
+		return
+	}
+	switch {
+	case n >= -32:
+		e.buf = append(e.buf, byte(int8(n)))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(int8(n)))
+	case n >= math.MinInt16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xd1), uint16(int16(n)))
+	case n >= math.MinInt32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xd2), uint32(int32(n)))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xd3), uint64(n))
+	}
+}
+
+func (e *binEncoder) float32(f float32) {
+	code := byte(0xca)
+	if e.format == formatCBOR {
+		code = 0xfa
+	}
+	e.buf = binary.BigEndian.AppendUint32(append(e.buf, code), math.Float32bits(f))
+}
+
+func (e *binEncoder) float64(f float64) {
+	code := byte(0xcb)
+	if e.format == formatCBOR {
+		code = 0xfb
+	}
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, code), math.Float64bits(f))
+}
+
+func (e *binEncoder) str(s string) {
+	e.head(majorText, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *binEncoder) bytes(b []byte) {
+	e.head(majorBytes, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// time appends t as a CBOR standard date/time string (tag 0), or as a
+// MessagePack timestamp extension in its 96-bit form.
+func (e *binEncoder) time(t time.Time) {
+	if e.format == formatCBOR {
+		e.head(majorTag, 0)
+		e.str(t.Format(time.RFC3339Nano))
+		return
+	}
+	e.buf = append(e.buf, 0xc7, 12, 0xff)
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(t.Nanosecond()))
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(t.Unix()))
+}
+
+// binField is a struct field as encoded by binEncoder.
+type binField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// binStructFields returns the encoded fields of t, named and filtered by their
+// json tags. Fields of embedded structs without a tag name are promoted.
+func binStructFields(t reflect.Type) []binField {
+	if f, ok := binStructFieldCache.Load(t); ok {
+		return f.([]binField)
+	}
+	var fields []binField
+	for _, sf := range reflect.VisibleFields(t) {
+		if !sf.IsExported() || sf.Tag.Get("json") == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if sf.Anonymous && name == "" && isStructType(sf.Type) {
+			continue // promoted through VisibleFields
+		}
+		if hasEmbeddedParent(t, sf.Index) {
+			// A field of a named or tagged embedded struct is encoded
+			// inside it, not promoted.
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, binField{name: name, index: sf.Index, omitEmpty: strings.Contains(","+opts+",", ",omitempty,")})
+	}
+	binStructFieldCache.Store(t, fields)
+	return fields
+}
+
+// hasEmbeddedParent reports whether the field at index is reached through an
+// embedded field that is not promoted, because it has a json tag name or is
+// not a struct.
+func hasEmbeddedParent(t reflect.Type, index []int) bool {
+	for i := range len(index) - 1 {
+		f := t.Field(index[i])
+		tag := f.Tag.Get("json")
+		if name, _, _ := strings.Cut(tag, ","); name != "" || tag == "-" || !isStructType(f.Type) {
+			return true
+		}
+		t = f.Type
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+	}
+	return false
+}
+
+// isStructType reports whether t is a struct or a pointer to one.
+func isStructType(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex but reports false instead
+// of panicking when it would step through a nil pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyValue reports whether v is empty in the sense of the json
+// "omitempty" option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
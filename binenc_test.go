@@ -0,0 +1,76 @@
+package velocity
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBinaryEncoding(t *testing.T) {
+	type inner struct {
+		B bool `json:"b"`
+	}
+	type point struct {
+		inner
+		X    int     `json:"x"`
+		Name string  `json:"name,omitempty"`
+		Skip string  `json:"-"`
+		Data []byte  `json:"d"`
+		F    float64 `json:"f"`
+	}
+	v := point{inner: inner{B: true}, X: -300, Data: []byte{1}, F: 1.5}
+
+	for _, tt := range []struct {
+		format binFormat
+		v      any
+		want   []byte
+	}{
+		{formatCBOR, nil, []byte{0xf6}},
+		{formatCBOR, 500, []byte{0x19, 0x01, 0xf4}},
+		{formatCBOR, -1, []byte{0x20}},
+		{formatCBOR, "a", []byte{0x61, 'a'}},
+		{formatCBOR, []int{1, 2}, []byte{0x82, 0x01, 0x02}},
+		{formatCBOR, map[string]bool{"b": false, "a": true}, []byte{0xa2, 0x61, 'a', 0xf5, 0x61, 'b', 0xf4}},
+		{formatCBOR, v, []byte{0xa4,
+			0x61, 'b', 0xf5,
+			0x61, 'x', 0x39, 0x01, 0x2b,
+			0x61, 'd', 0x41, 0x01,
+			0x61, 'f', 0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{formatCBOR, time.Unix(0, 0).UTC(), append([]byte{0xc0, 0x74}, "1970-01-01T00:00:00Z"...)},
+
+		{formatMsgPack, nil, []byte{0xc0}},
+		{formatMsgPack, 500, []byte{0xcd, 0x01, 0xf4}},
+		{formatMsgPack, -1, []byte{0xff}},
+		{formatMsgPack, -300, []byte{0xd1, 0xfe, 0xd4}},
+		{formatMsgPack, "a", []byte{0xa1, 'a'}},
+		{formatMsgPack, []byte{7}, []byte{0xc4, 0x01, 0x07}},
+		{formatMsgPack, []int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{formatMsgPack, map[string]bool{"a": true}, []byte{0x81, 0xa1, 'a', 0xc3}},
+		{formatMsgPack, time.Unix(1, 2), []byte{0xc7, 12, 0xff, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 1}},
+	} {
+		e := binEncoder{format: tt.format}
+		if err := e.encode(reflect.ValueOf(tt.v)); err != nil {
+			t.Fatalf("encode %v: %v", tt.v, err)
+		}
+		if !bytes.Equal(e.buf, tt.want) {
+			t.Errorf("format %d, encode %v = % x, want % x", tt.format, tt.v, e.buf, tt.want)
+		}
+	}
+
+	e := binEncoder{}
+	if err := e.encode(reflect.ValueOf(map[string]any{"ch": make(chan int)})); err == nil {
+		t.Fatal("encoding a channel succeeded")
+	}
+}
+
+func TestContextCBOR(t *testing.T) {
+	c, rec := NewTestContext(MethodRead, "/", nil)
+	c.SetStatus(StatusCreated)
+	if err := c.CBOR(1); err != nil {
+		t.Fatal(err)
+	}
+	if ct, _ := rec.Header("content-type"); ct != MIMECBOR || rec.Status != StatusCreated || !bytes.Equal(rec.Body, []byte{0x01}) {
+		t.Fatalf("CBOR: %q %q % x", ct, rec.Status, rec.Body)
+	}
+}
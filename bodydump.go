@@ -0,0 +1,116 @@
+package velocity
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DefaultBodyDumpMax is how many bytes of each body BodyDump captures unless
+// BodyDumpOptions says otherwise.
+const DefaultBodyDumpMax = 64 << 10
+
+// BodyDumpOptions configures BodyDumpWith.
+type BodyDumpOptions struct {
+	// MaxBytes caps how much of each body is captured; longer bodies are
+	// truncated. The response is still sent in full. Zero means
+	// DefaultBodyDumpMax.
+	MaxBytes int
+
+	// Redact, if set, is called with the captured bodies before they are
+	// passed on, and returns them with secrets such as tokens or passwords
+	// removed. It may modify the slices in place.
+	Redact func(c *Context, reqBody, respBody []byte) ([]byte, []byte)
+
+	// Skipper, if set, selects requests that are passed through without
+	// capturing anything; fn is not called for them.
+	Skipper Skipper
+}
+
+// BodyDump returns middleware that calls fn with the request body and the
+// response body of every request it handles, for debugging. It is meant for
+// selected routes rather than the whole server:
+//
+//	dump := velocity.BodyDump(func(c *velocity.Context, req, resp []byte) {
+//	    log.Printf("%s %s\n> %s\n< %s", c.Method(), c.Path(), req, resp)
+//	})
+//	srv.Router().Write("/orders", createOrder, dump)
+//
+// It is BodyDumpWith with the default options.
+func BodyDump(fn func(c *Context, reqBody, respBody []byte)) MiddlewareFunc {
+	return BodyDumpWith(fn, BodyDumpOptions{})
+}
+
+// BodyDumpWith is BodyDump with options for the capture size, redaction, and
+// skipping requests.
+//
+// fn is called after the downstream handler returns. The response body is
+// what was sent through the Context, with Respond, Write, StreamWrite, or the
+// helpers built on them; writes made directly on Context.Response bypass the
+// capture. An error the handler returns without responding is answered by
+// the server's error handler after BodyDump returns, so its response body is
+// nil. Under WithStreamingUploads, the request body is captured only if the
+// handler read it into memory with Body or Bind. Neither slice may be
+// retained after fn returns. BodyDumpWith panics if fn is nil or
+// opts.MaxBytes is negative.
+func BodyDumpWith(fn func(c *Context, reqBody, respBody []byte), opts BodyDumpOptions) MiddlewareFunc {
+	if fn == nil {
+		panic("velocity: BodyDump requires a function")
+	}
+	if opts.MaxBytes < 0 {
+		panic(fmt.Sprintf("velocity: BodyDump MaxBytes must not be negative, got %d", opts.MaxBytes))
+	}
+	if opts.MaxBytes == 0 {
+		opts.MaxBytes = DefaultBodyDumpMax
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if opts.Skipper != nil && opts.Skipper(c) {
+				return next(c)
+			}
+			w := c.w
+			capture := &bodyCapture{ResponseWriter: w, max: opts.MaxBytes}
+			c.w = capture
+			err := next(c)
+			c.w = w
+			req := c.Request.Body
+			req = bytes.Clone(req[:min(len(req), opts.MaxBytes)])
+			resp := capture.buf
+			if opts.Redact != nil {
+				req, resp = opts.Redact(c, req, resp)
+			}
+			fn(c, req, resp)
+			return err
+		}
+	}
+}
+
+// bodyCapture is a ResponseWriter that passes everything on to the wrapped
+// ResponseWriter and keeps the first max bytes of the response body, like
+// Idempotency's teeWriter without the rest of the response.
+type bodyCapture struct {
+	ResponseWriter
+	max int
+	buf []byte
+}
+
+func (b *bodyCapture) keep(p []byte) {
+	if n := b.max - len(b.buf); n > 0 {
+		b.buf = append(b.buf, p[:min(n, len(p))]...)
+	}
+}
+
+func (b *bodyCapture) Respond(status string, body []byte) error {
+	b.keep(body)
+	return b.ResponseWriter.Respond(status, body)
+}
+
+func (b *bodyCapture) Write(body []byte) error {
+	b.keep(body)
+	return b.ResponseWriter.Write(body)
+}
+
+func (b *bodyCapture) StreamWrite(data []byte) (int, error) {
+	n, err := b.ResponseWriter.StreamWrite(data)
+	b.keep(data[:n])
+	return n, err
+}
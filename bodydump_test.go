@@ -0,0 +1,57 @@
+package velocity
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBodyDump(t *testing.T) {
+	var gotReq, gotResp []byte
+	mw := BodyDumpWith(func(c *Context, req, resp []byte) {
+		gotReq, gotResp = bytes.Clone(req), bytes.Clone(resp)
+	}, BodyDumpOptions{
+		MaxBytes: 8,
+		Redact: func(c *Context, req, resp []byte) ([]byte, []byte) {
+			return bytes.ReplaceAll(req, []byte("pw"), []byte("**")), resp
+		},
+	})
+	h := mw(func(c *Context) error {
+		if _, err := c.StreamWrite([]byte("hello ")); err != nil {
+			return err
+		}
+		_, err := c.StreamWrite([]byte("world"))
+		return err
+	})
+	c, rec := NewTestContext(MethodWrite, "/login", []byte("pw=secret-value"))
+	if err := h(c); err != nil {
+		t.Fatal(err)
+	}
+	if string(gotReq) != "**=secre" {
+		t.Errorf("request body = %q", gotReq)
+	}
+	if string(gotResp) != "hello wo" {
+		t.Errorf("response body = %q", gotResp)
+	}
+	if string(rec.Body) != "hello world" {
+		t.Errorf("sent body = %q, want it in full", rec.Body)
+	}
+	if _, ok := c.w.(*bodyCapture); ok {
+		t.Error("capture writer left in place")
+	}
+}
+
+func TestBodyDumpPanics(t *testing.T) {
+	for name, f := range map[string]func(){
+		"nil fn":   func() { BodyDump(nil) },
+		"negative": func() { BodyDumpWith(func(*Context, []byte, []byte) {}, BodyDumpOptions{MaxBytes: -1}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: did not panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
@@ -0,0 +1,214 @@
+package velocity
+
+import (
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// ResponseBuffer holds a response that has been sent through the Context but
+// not yet to the peer, so that middleware can inspect and modify it. See
+// BufferResponse.
+//
+// The status and headers are changed through the Context, with SetStatus and
+// SetHeader, which keep working after the handler has responded; the body is
+// changed with SetBody.
+type ResponseBuffer struct {
+	w         ResponseWriter // where the response is flushed to
+	status    string
+	headers   []nwep.Header
+	body      []byte
+	responded bool // Respond or Write was called
+	streaming bool // StreamWrite was called; the buffer is passed through
+	flushed   bool
+}
+
+// Status returns the buffered status, or "" if none has been set.
+func (b *ResponseBuffer) Status() string { return b.status }
+
+// Header returns the value of the buffered header name and whether it is
+// set.
+func (b *ResponseBuffer) Header(name string) (string, bool) {
+	for _, h := range b.headers {
+		if h.Name == name {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// DelHeader removes the buffered header name, which a write-through response
+// cannot do once the header is set.
+func (b *ResponseBuffer) DelHeader(name string) {
+	for i := range b.headers {
+		if b.headers[i].Name == name {
+			b.headers = append(b.headers[:i], b.headers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Body returns the buffered body. It is nil until the handler responds.
+func (b *ResponseBuffer) Body() []byte { return b.body }
+
+// SetBody replaces the buffered body.
+func (b *ResponseBuffer) SetBody(body []byte) { b.body = body }
+
+// Responded reports whether the handler has sent a complete response with
+// Respond, Write, or a helper built on them. A handler that returned an error
+// without responding leaves it false; its error response is sent by the
+// server's error handler after BufferResponse returns, unbuffered.
+func (b *ResponseBuffer) Responded() bool { return b.responded }
+
+// Streaming reports whether the handler switched to a streamed response with
+// StreamWrite. A streamed response is not held back: the status and headers
+// buffered so far are sent with the first write, and the buffer no longer
+// applies.
+func (b *ResponseBuffer) Streaming() bool { return b.streaming }
+
+// SetStatus implements ResponseWriter.
+func (b *ResponseBuffer) SetStatus(status string) { b.status = status }
+
+// SetHeader implements ResponseWriter.
+func (b *ResponseBuffer) SetHeader(name, value string) {
+	for i := range b.headers {
+		if b.headers[i].Name == name {
+			b.headers[i].Value = value
+			return
+		}
+	}
+	b.headers = append(b.headers, nwep.Header{Name: name, Value: value})
+}
+
+// Respond implements ResponseWriter.
+func (b *ResponseBuffer) Respond(status string, body []byte) error {
+	b.status = status
+	return b.Write(body)
+}
+
+// Write implements ResponseWriter.
+func (b *ResponseBuffer) Write(body []byte) error {
+	if b.streaming || b.flushed {
+		return b.w.Write(body)
+	}
+	b.body = append(b.body, body...)
+	b.responded = true
+	return nil
+}
+
+// StreamWrite implements ResponseWriter.
+func (b *ResponseBuffer) StreamWrite(data []byte) (int, error) {
+	if !b.streaming {
+		b.streaming = true
+		b.sendHead()
+	}
+	return b.w.StreamWrite(data)
+}
+
+// StreamClose implements ResponseWriter.
+func (b *ResponseBuffer) StreamClose(errCode int) {
+	_ = b.flush()
+	b.w.StreamClose(errCode)
+}
+
+// StreamID implements ResponseWriter.
+func (b *ResponseBuffer) StreamID() int64 { return b.w.StreamID() }
+
+// IsServerInitiated implements ResponseWriter.
+func (b *ResponseBuffer) IsServerInitiated() bool { return b.w.IsServerInitiated() }
+
+// CloseWrite implements ResponseWriter. It sends the buffered response
+// first.
+func (b *ResponseBuffer) CloseWrite() error {
+	if err := b.flush(); err != nil {
+		return err
+	}
+	return b.w.CloseWrite()
+}
+
+// CloseRead implements ResponseWriter.
+func (b *ResponseBuffer) CloseRead() error { return b.w.CloseRead() }
+
+// sendHead passes the buffered status and headers on.
+func (b *ResponseBuffer) sendHead() {
+	for _, h := range b.headers {
+		b.w.SetHeader(h.Name, h.Value)
+	}
+	if b.status != "" {
+		b.w.SetStatus(b.status)
+	}
+}
+
+// flush sends the buffered response, once. A buffer the handler never
+// responded through passes on only its status and headers, for the error
+// response that follows.
+func (b *ResponseBuffer) flush() error {
+	if b.flushed || b.streaming {
+		return nil
+	}
+	b.flushed = true
+	b.sendHead()
+	if !b.responded {
+		return nil
+	}
+	status := b.status
+	if status == "" {
+		status = StatusOK
+	}
+	return b.w.Respond(status, b.body)
+}
+
+// ResponseBuffer returns the response buffer installed by BufferResponse, or
+// nil if the response is written through to the peer.
+func (c *Context) ResponseBuffer() *ResponseBuffer { return c.buf }
+
+// BufferResponse returns middleware that holds back the response of the
+// handlers after it until they return, so that the middleware between them
+// can inspect and rewrite the status, headers, and body before anything is
+// sent:
+//
+//	srv.Use(velocity.BufferResponse(), etag)
+//
+//	func etag(next velocity.HandlerFunc) velocity.HandlerFunc {
+//	    return func(c *velocity.Context) error {
+//	        err := next(c)
+//	        if b := c.ResponseBuffer(); err == nil && b.Responded() {
+//	            sum := sha256.Sum256(b.Body())
+//	            c.SetHeader("etag", hex.EncodeToString(sum[:8]))
+//	        }
+//	        return err
+//	    }
+//	}
+//
+// This is what compression, ETag, and caching middleware need, and what a
+// write-through response cannot offer. The cost is that the whole body is held
+// in memory, so streamed responses (see Context.StreamWrite) are passed
+// through as soon as they start. A BufferResponse inside another one is a
+// no-op. If sending the buffered response fails, the middleware returns the
+// error, unless the handler returned one of its own.
+func BufferResponse() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if c.buf != nil {
+				return next(c)
+			}
+			w := c.w
+			b := &ResponseBuffer{w: w}
+			c.w, c.buf = b, b
+			err := next(c)
+			c.w, c.buf = w, nil
+			if b.streaming {
+				return err
+			}
+			// The middleware may have rewritten the response, so the
+			// Context's view of it is updated to match what is sent.
+			c.respHeaders = append(c.respHeaders[:0], b.headers...)
+			if b.responded {
+				c.status = b.status
+				c.written = len(b.body)
+			}
+			if ferr := b.flush(); ferr != nil && err == nil {
+				err = ferr
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package velocity
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBufferResponse(t *testing.T) {
+	rewrite := func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			err := next(c)
+			b := c.ResponseBuffer()
+			if b == nil || !b.Responded() {
+				t.Fatal("response not buffered")
+			}
+			if _, ok := b.Header("x-secret"); !ok {
+				t.Error("handler header not buffered")
+			}
+			b.DelHeader("x-secret")
+			c.SetHeader("etag", "abc")
+			c.SetStatus(StatusCreated)
+			b.SetBody(bytes.ToUpper(b.Body()))
+			return err
+		}
+	}
+	h := BufferResponse()(BufferResponse()(rewrite(func(c *Context) error {
+		c.SetHeader("x-secret", "1")
+		return c.OK([]byte("hello"))
+	})))
+	c, rec := NewTestContext(MethodRead, "/", nil)
+	if err := h(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != StatusCreated || string(rec.Body) != "HELLO" {
+		t.Fatalf("sent %s %q", rec.Status, rec.Body)
+	}
+	if _, ok := rec.Header("x-secret"); ok {
+		t.Error("deleted header sent")
+	}
+	if v, _ := rec.Header("etag"); v != "abc" {
+		t.Errorf("etag = %q", v)
+	}
+	if c.written != 5 || c.status != StatusCreated || c.ResponseBuffer() != nil {
+		t.Errorf("context not updated: written %d, status %q", c.written, c.status)
+	}
+	if _, ok := c.responseHeader("x-secret"); ok {
+		t.Error("deleted header still mirrored")
+	}
+}
+
+func TestBufferResponseStreaming(t *testing.T) {
+	h := BufferResponse()(func(c *Context) error {
+		c.SetHeader("content-type", "text/plain")
+		if _, err := c.StreamWrite([]byte("a")); err != nil {
+			return err
+		}
+		if len(c.ResponseBuffer().Body()) != 0 || !c.ResponseBuffer().Streaming() {
+			t.Error("streamed write buffered")
+		}
+		return nil
+	})
+	c, rec := NewTestContext(MethodRead, "/", nil)
+	if err := h(c); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := rec.Header("content-type"); v != "text/plain" || string(rec.Body) != "a" {
+		t.Fatalf("sent %q with content-type %q", rec.Body, v)
+	}
+}
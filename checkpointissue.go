@@ -0,0 +1,258 @@
+package velocity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// checkpointIssuer holds the state of a server that issues its own anchor
+// checkpoints: see WithCheckpointSigner and WithCheckpointInterval.
+type checkpointIssuer struct {
+	log      *LogService
+	kp       *nwep.BLSKeypair
+	interval time.Duration
+	path     string // state file, empty if not persisted
+
+	mu     sync.Mutex
+	state  checkpointState
+	latest *nwep.Checkpoint
+}
+
+// checkpointState is what survives a restart, as JSON in the state file.
+// Checkpoint is the latest checkpoint, as encoded by nwep.CheckpointEncode.
+type checkpointState struct {
+	Epoch      uint64 `json:"epoch"`
+	LogSize    uint64 `json:"log_size"`
+	Checkpoint []byte `json:"checkpoint,omitempty"`
+}
+
+// WithCheckpointSigner gives the server the log its anchor checkpoints cover
+// and the BLS keypair that signs them, for Server.IssueCheckpoint and
+// WithCheckpointInterval. Each checkpoint covers the root and size of l's
+// Merkle log, read together so that an append cannot fall between them.
+// Checkpoints are published through the server's nwep.AnchorServer, so
+// WithAnchorServer must be used too. The server does not take ownership of
+// either argument.
+//
+// This option returns an error if l or kp is nil.
+func WithCheckpointSigner(l *LogService, kp *nwep.BLSKeypair) Option {
+	return func(s *Server) error {
+		if l == nil || kp == nil {
+			return errors.New("velocity: checkpoint signer needs a log and a BLS keypair")
+		}
+		s.issuer.log = l
+		s.issuer.kp = kp
+		return nil
+	}
+}
+
+// WithCheckpointState persists the latest issued checkpoint in the file at
+// path, so that a restarted server carries on from the next epoch instead of
+// starting over at 1, and serves the checkpoint at /checkpoint/latest until
+// it issues a newer one. The file is read when the server starts, which adds
+// the checkpoint to the server's nwep.AnchorServer, and is rewritten
+// atomically after each checkpoint.
+//
+// This option returns an error if path is empty.
+func WithCheckpointState(path string) Option {
+	return func(s *Server) error {
+		if path == "" {
+			return errors.New("velocity: empty checkpoint state path")
+		}
+		s.issuer.path = path
+		return nil
+	}
+}
+
+// WithCheckpointInterval makes the server issue anchor checkpoints on its
+// own: once at start and then every d, whenever the log given to
+// WithCheckpointSigner has grown since the last checkpoint, it creates a
+// checkpoint of the log's current root and size, signs it, and adds it to
+// the server's nwep.AnchorServer, which serves it at /checkpoint/latest.
+// Failures are logged and retried at the next tick.
+//
+//	srv, err := velocity.New(":6937",
+//	    velocity.WithAnchorServer(anchorSrv),
+//	    velocity.WithCheckpointSigner(logSvc.MerkleLog(), blsKeys),
+//	    velocity.WithCheckpointState("checkpoint.json"),
+//	    velocity.WithCheckpointInterval(time.Minute),
+//	)
+//
+// Start fails unless WithCheckpointSigner and WithAnchorServer are used too.
+// This option returns an error if d is not positive.
+func WithCheckpointInterval(d time.Duration) Option {
+	return func(s *Server) error {
+		if d <= 0 {
+			return fmt.Errorf("velocity: checkpoint interval must be positive, got %s", d)
+		}
+		first := s.issuer.interval == 0
+		s.issuer.interval = d
+		if !first {
+			return nil
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		s.onStart = append(s.onStart, func(s *Server) {
+			wg.Go(func() {
+				t := time.NewTicker(s.issuer.interval)
+				defer t.Stop()
+				for {
+					if _, err := s.issueCheckpoint(false); err != nil {
+						s.logger.Warn("checkpoint issuance failed", "error", err.Error())
+					}
+					select {
+					case <-t.C:
+					case <-ctx.Done():
+						return
+					}
+				}
+			})
+		})
+		s.onShutdown = append(s.onShutdown, func(*Server) {
+			cancel()
+			wg.Wait()
+		})
+		return nil
+	}
+}
+
+// startCheckpointIssuer checks the checkpoint options, loads the state file,
+// and republishes the checkpoint saved in it, for Start.
+func (s *Server) startCheckpointIssuer() error {
+	is := &s.issuer
+	if is.interval > 0 && (is.log == nil || s.anchorServer == nil) {
+		return errors.New("velocity: WithCheckpointInterval needs WithCheckpointSigner and WithAnchorServer")
+	}
+	if is.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(is.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("velocity: read checkpoint state: %w", err)
+	}
+	var st checkpointState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("velocity: read checkpoint state %s: %w", is.path, err)
+	}
+	var cp *nwep.Checkpoint
+	if len(st.Checkpoint) > 0 {
+		if cp, err = nwep.CheckpointDecode(st.Checkpoint); err != nil {
+			return fmt.Errorf("velocity: read checkpoint state %s: %w", is.path, err)
+		}
+		if s.anchorServer != nil {
+			if err := s.anchorServer.AddCheckpoint(cp); err != nil {
+				return fmt.Errorf("velocity: republish checkpoint %d: %w", st.Epoch, err)
+			}
+		}
+	}
+	is.mu.Lock()
+	is.state, is.latest = st, cp
+	is.mu.Unlock()
+	return nil
+}
+
+// IssueCheckpoint creates a checkpoint of the current root and size of the
+// log given to WithCheckpointSigner, signs it, adds it to the server's
+// nwep.AnchorServer, and returns it. Its epoch is one more than the previous
+// checkpoint's. It does this whether or not the log has grown, unlike the
+// schedule WithCheckpointInterval sets.
+//
+// This function returns ErrNoCheckpointSigner if the server has no signer or
+// no anchor server, or an error if the checkpoint cannot be created, signed,
+// published, or persisted.
+func (s *Server) IssueCheckpoint() (*nwep.Checkpoint, error) {
+	return s.issueCheckpoint(true)
+}
+
+// issueCheckpoint issues a checkpoint if force is set, no checkpoint has been
+// issued, or the log has grown since the last one, and returns it, or nil if
+// there was nothing to issue.
+func (s *Server) issueCheckpoint(force bool) (*nwep.Checkpoint, error) {
+	is := &s.issuer
+	if is.log == nil || s.anchorServer == nil {
+		return nil, ErrNoCheckpointSigner
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	size, root, err := is.log.head()
+	if err != nil {
+		return nil, fmt.Errorf("velocity: checkpoint root: %w", err)
+	}
+	if !force && is.state.Epoch > 0 && size == is.state.LogSize {
+		return nil, nil
+	}
+	next := checkpointState{Epoch: is.state.Epoch + 1, LogSize: size}
+	cp, err := nwep.CheckpointNew(next.Epoch, uint64(time.Now().UnixNano()), root, size)
+	if err != nil {
+		return nil, fmt.Errorf("velocity: create checkpoint: %w", err)
+	}
+	if err := nwep.CheckpointSign(cp, is.kp); err != nil {
+		return nil, fmt.Errorf("velocity: sign checkpoint: %w", err)
+	}
+	if next.Checkpoint, err = nwep.CheckpointEncode(cp); err != nil {
+		return nil, fmt.Errorf("velocity: encode checkpoint: %w", err)
+	}
+	// Persist before publishing, so that a crash in between cannot lead to
+	// the same epoch being issued twice with different contents.
+	if err := is.save(next); err != nil {
+		return nil, err
+	}
+	is.state = next
+	if err := s.anchorServer.AddCheckpoint(cp); err != nil {
+		return nil, fmt.Errorf("velocity: publish checkpoint: %w", err)
+	}
+	is.latest = cp
+	s.logger.Info("checkpoint issued", "epoch", next.Epoch, "log_size", size)
+	return cp, nil
+}
+
+// save writes st to the state file, if there is one, through a temporary file
+// renamed over it. The caller must hold is.mu.
+func (is *checkpointIssuer) save(st checkpointState) error {
+	if is.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(is.path), filepath.Base(is.path)+".*")
+	if err != nil {
+		return fmt.Errorf("velocity: write checkpoint state: %w", err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), is.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("velocity: write checkpoint state: %w", err)
+	}
+	return nil
+}
+
+// LatestCheckpoint returns the newest checkpoint the server has issued, or
+// the one saved in the state file (see WithCheckpointState) if it has issued
+// none since it started, or nil if there is neither.
+func (s *Server) LatestCheckpoint() *nwep.Checkpoint {
+	s.issuer.mu.Lock()
+	defer s.issuer.mu.Unlock()
+	return s.issuer.latest
+}
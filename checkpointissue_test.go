@@ -0,0 +1,91 @@
+package velocity
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestCheckpointIssuerOptions(t *testing.T) {
+	if _, err := New(":0", WithCheckpointInterval(0)); err == nil {
+		t.Fatal("zero interval accepted")
+	}
+	if _, err := New(":0", WithCheckpointSigner(nil, nil)); err == nil {
+		t.Fatal("nil signer accepted")
+	}
+	if _, err := New(":0", WithCheckpointState("")); err == nil {
+		t.Fatal("empty state path accepted")
+	}
+
+	s, err := New(":0", WithCheckpointInterval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.startCheckpointIssuer(); err == nil {
+		t.Fatal("interval without signer accepted")
+	}
+	if _, err := s.IssueCheckpoint(); !errors.Is(err, ErrNoCheckpointSigner) {
+		t.Fatalf("IssueCheckpoint = %v", err)
+	}
+	if s.LatestCheckpoint() != nil {
+		t.Fatal("checkpoint issued without signer")
+	}
+}
+
+func TestCheckpointStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	s, err := New(":0", WithCheckpointState(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.startCheckpointIssuer(); err != nil {
+		t.Fatalf("missing state file: %v", err)
+	}
+	if err := s.issuer.save(checkpointState{Epoch: 7, LogSize: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := New(":0", WithCheckpointState(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.startCheckpointIssuer(); err != nil {
+		t.Fatal(err)
+	}
+	if st := s2.issuer.state; st.Epoch != 7 || st.LogSize != 42 {
+		t.Fatalf("state = %+v", st)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Fatalf("temporary files left: %v", matches)
+	}
+	if s2.LatestCheckpoint() != nil {
+		t.Fatal("checkpoint loaded from a state file without one")
+	}
+
+	var root nwep.MerkleHash
+	root[0] = 9
+	cp, err := nwep.CheckpointNew(8, 1, root, 43)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := nwep.CheckpointEncode(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.issuer.save(checkpointState{Epoch: 8, LogSize: 43, Checkpoint: encoded}); err != nil {
+		t.Fatal(err)
+	}
+	s3, err := New(":0", WithCheckpointState(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s3.startCheckpointIssuer(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s3.LatestCheckpoint(); got == nil || got.Epoch != 8 || got.LogSize != 43 || got.MerkleRoot != root {
+		t.Fatalf("LatestCheckpoint after a restart = %+v", got)
+	}
+}
@@ -0,0 +1,256 @@
+package velocity
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// DefaultCheckpointSyncInterval is how often a CheckpointSyncer fetches
+// checkpoints unless CheckpointSyncOptions.Interval says otherwise.
+const DefaultCheckpointSyncInterval = 10 * time.Minute
+
+// CheckpointSyncOptions configures a CheckpointSyncer.
+type CheckpointSyncOptions struct {
+	// AnchorURLs are the web:// URLs of the anchor servers to fetch
+	// checkpoints from. There must be at least one.
+	AnchorURLs []string
+
+	// Interval is the time between syncs. Zero means
+	// DefaultCheckpointSyncInterval.
+	Interval time.Duration
+
+	// Jitter, if positive, adds a random delay of up to Jitter to each
+	// interval, so that many servers syncing from the same anchors do not
+	// fetch in lockstep.
+	Jitter time.Duration
+
+	// Client configures the connections to the anchor servers.
+	Client ClientOptions
+
+	// OnCheckpoint, if set, is called after a checkpoint from url is
+	// accepted into the trust store.
+	OnCheckpoint func(url string, cp *nwep.Checkpoint)
+
+	// OnError, if set, is called when fetching from url fails or its
+	// checkpoint is rejected. Failures are also logged at warn level.
+	OnError func(url string, err error)
+}
+
+// CheckpointSyncer keeps a server's trust store fresh by periodically reading
+// /checkpoint/latest from a set of anchor servers and adding each checkpoint
+// newer than the last one accepted:
+//
+//	syncer, err := velocity.NewCheckpointSyncer(srv, velocity.CheckpointSyncOptions{
+//	    AnchorURLs: []string{anchorURL},
+//	    Interval:   5 * time.Minute,
+//	    Jitter:     time.Minute,
+//	})
+//	...
+//	defer syncer.Close()
+//
+// The trust store verifies each checkpoint's aggregate signature against its
+// anchors and quorum threshold (see TrustConfig.Settings) and rejects those
+// that fall short, so an anchor server need not itself be trusted. A
+// CheckpointSyncer is safe for concurrent use.
+type CheckpointSyncer struct {
+	srv  *Server
+	opts CheckpointSyncOptions
+	pool *ClientPool
+
+	mu      sync.Mutex
+	peers   map[nwep.NodeID]string // anchor node ID to URL
+	epoch   uint64                 // epoch of the newest accepted checkpoint
+	latest  *nwep.Checkpoint
+	lastErr error
+	synced  time.Time
+
+	ctx       context.Context // canceled by Close
+	stop      context.CancelFunc
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewCheckpointSyncer returns a syncer that feeds srv's trust store, set up
+// with WithTrust or Config.TrustAnchors. It syncs once right away, in the
+// background, and then every opts.Interval until Close.
+//
+// This function returns ErrNoTrustStore if srv has no trust store, or an
+// error if opts is invalid or an anchor URL has no node ID.
+func NewCheckpointSyncer(srv *Server, opts CheckpointSyncOptions) (*CheckpointSyncer, error) {
+	if srv.TrustStore() == nil {
+		return nil, ErrNoTrustStore
+	}
+	if len(opts.AnchorURLs) == 0 {
+		return nil, errors.New("velocity: checkpoint sync needs at least one anchor URL")
+	}
+	if opts.Interval < 0 {
+		return nil, fmt.Errorf("velocity: checkpoint sync interval must not be negative, got %s", opts.Interval)
+	}
+	if opts.Interval == 0 {
+		opts.Interval = DefaultCheckpointSyncInterval
+	}
+	if opts.Jitter < 0 {
+		return nil, fmt.Errorf("velocity: checkpoint sync jitter must not be negative, got %s", opts.Jitter)
+	}
+	opts.AnchorURLs = slices.Clone(opts.AnchorURLs)
+
+	pool, err := NewClientPool(ClientPoolOptions{Client: opts.Client})
+	if err != nil {
+		return nil, err
+	}
+	cs := &CheckpointSyncer{
+		srv:   srv,
+		opts:  opts,
+		pool:  pool,
+		peers: make(map[nwep.NodeID]string),
+	}
+	cs.ctx, cs.stop = context.WithCancel(context.Background())
+	for _, url := range opts.AnchorURLs {
+		peer, err := pool.Add(url)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		cs.peers[peer] = url
+	}
+	cs.wg.Go(cs.loop)
+	return cs, nil
+}
+
+// loop syncs now and then after every interval until Close.
+func (cs *CheckpointSyncer) loop() {
+	for {
+		ctx, cancel := context.WithTimeout(cs.ctx, cs.opts.Interval)
+		_ = cs.Sync(ctx)
+		cancel()
+
+		wait := cs.opts.Interval
+		if cs.opts.Jitter > 0 {
+			wait += rand.N(cs.opts.Jitter)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-cs.ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// Sync fetches the latest checkpoint from every anchor server concurrently
+// and adds those newer than the last accepted checkpoint to the trust store,
+// oldest first. It is run periodically by the syncer, and may also be called
+// to sync at once, for example after a trust failure. Failures are reported
+// to OnError; this function returns their errors joined, or nil if every
+// anchor server was synced.
+func (cs *CheckpointSyncer) Sync(ctx context.Context) error {
+	// Every anchor is asked, not only ClientPool's healthy ones, so that one
+	// that was down is retried each sync.
+	peers := cs.pool.Peers()
+	results := make([]PoolResult, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Go(func() {
+			resp, err := cs.pool.Do(ctx, peer, MethodRead, "/checkpoint/latest", nil)
+			results[i] = PoolResult{Peer: peer, Response: resp, Err: err}
+		})
+	}
+	wg.Wait()
+
+	type fetched struct {
+		url string
+		cp  *nwep.Checkpoint
+	}
+	var cps []fetched
+	var errs []error
+	fail := func(url string, err error) {
+		err = fmt.Errorf("velocity: checkpoint sync from %s: %w", url, err)
+		errs = append(errs, err)
+		cs.srv.logger.Warn("checkpoint sync failed", "anchor", url, "error", err.Error())
+		if cs.opts.OnError != nil {
+			cs.opts.OnError(url, err)
+		}
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, r := range results {
+		url := cs.peers[r.Peer]
+		err := r.Err
+		if err == nil {
+			err = ResponseError(r.Response)
+		}
+		if err != nil {
+			fail(url, err)
+			continue
+		}
+		cp, err := nwep.CheckpointDecode(r.Response.Body)
+		if err != nil {
+			fail(url, err)
+			continue
+		}
+		cps = append(cps, fetched{url, cp})
+	}
+	slices.SortStableFunc(cps, func(a, b fetched) int { return cmp.Compare(a.cp.Epoch, b.cp.Epoch) })
+	for _, f := range cps {
+		if f.cp.Epoch <= cs.epoch {
+			continue
+		}
+		if err := cs.srv.addCheckpoint(f.cp); err != nil {
+			fail(f.url, err)
+			continue
+		}
+		cs.epoch, cs.latest = f.cp.Epoch, f.cp
+		if cs.opts.OnCheckpoint != nil {
+			cs.opts.OnCheckpoint(f.url, f.cp)
+		}
+	}
+	cs.synced = time.Now()
+	cs.lastErr = errors.Join(errs...)
+	return cs.lastErr
+}
+
+// Status returns the epoch of the newest checkpoint accepted, zero if none,
+// when the last sync finished, and that sync's error.
+func (cs *CheckpointSyncer) Status() (epoch uint64, synced time.Time, err error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.epoch, cs.synced, cs.lastErr
+}
+
+// Latest returns the newest checkpoint accepted into the trust store, or nil
+// if none has been.
+func (cs *CheckpointSyncer) Latest() *nwep.Checkpoint {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.latest
+}
+
+// Close stops syncing, cancelling a periodic sync in progress, and closes the
+// connections to the anchor servers.
+func (cs *CheckpointSyncer) Close() {
+	cs.closeOnce.Do(func() {
+		cs.stop()
+		cs.wg.Wait()
+		cs.pool.Close()
+	})
+}
+
+// addCheckpoint adds cp to the server's trust store, which verifies it.
+func (s *Server) addCheckpoint(cp *nwep.Checkpoint) error {
+	s.trustMu.Lock()
+	defer s.trustMu.Unlock()
+	if s.trustStore == nil {
+		return ErrNoTrustStore
+	}
+	return s.trustStore.AddCheckpoint(cp)
+}
@@ -0,0 +1,12 @@
+package velocity
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewCheckpointSyncerErrors(t *testing.T) {
+	if _, err := NewCheckpointSyncer(&Server{}, CheckpointSyncOptions{AnchorURLs: []string{"web://x"}}); !errors.Is(err, ErrNoTrustStore) {
+		t.Fatalf("without trust store: err = %v, want ErrNoTrustStore", err)
+	}
+}
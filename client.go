@@ -0,0 +1,376 @@
+package velocity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// ClientOptions configures a Client.
+type ClientOptions struct {
+	// Keypair authenticates the client to servers. If nil, a random
+	// Ed25519 keypair is generated, and the client's node ID changes
+	// every time a Client is created.
+	Keypair *nwep.Keypair
+
+	// Settings are the nwep transport settings, or nil for the defaults.
+	Settings *nwep.Settings
+
+	// Timeout bounds each request whose context has no deadline. Zero
+	// means no timeout.
+	Timeout time.Duration
+
+	// Reconnect controls reconnection after the connection is lost:
+	// MaxAttempts dial attempts are made, pausing Backoff after the first
+	// failure and doubling up to MaxBackoff. The zero value means
+	// DefaultRetryPolicy.
+	Reconnect RetryPolicy
+
+	// Logger receives reconnection events. If nil, DefaultLogger is used.
+	Logger Logger
+}
+
+// Client is a WEB/1 client for talking to velocity servers. It wraps
+// nwep.Client with context-aware request helpers, JSON encoding, automatic
+// reconnection, and dispatch of notifications by event and path pattern:
+//
+//	client, err := velocity.NewClient(srv.URL("/"), velocity.ClientOptions{Keypair: kp})
+//	...
+//	var user User
+//	err = client.ReadJSON(ctx, "/users/42", &user)
+//
+// When a request fails because the connection was lost, the error is
+// returned and the client reconnects in the background, so that later
+// requests and notifications resume; requests sent meanwhile wait for the
+// reconnection, or for their context. Requests are never retried, since a
+// write may have been applied before the connection failed.
+//
+// A Client is safe for concurrent use. Requests are sent one at a time over
+// its single connection.
+type Client struct {
+	url    string
+	opts   ClientOptions
+	logger Logger
+
+	sem chan struct{} // held while a request is being sent
+
+	mu      sync.Mutex
+	nc      *nwep.Client
+	ready   chan struct{} // closed when the running reconnection ends; nil if none
+	dialErr error         // why the last reconnection failed
+	closed  bool
+	done    chan struct{} // closed by Close
+
+	mux NotifyMux
+}
+
+// NewClient connects to the server at url, a web:// URL such as one returned
+// by Server.URL. This function returns a non-nil error if opts is invalid,
+// nwep initialization or keypair generation fails, or the first connection
+// attempt fails; it does not retry.
+func NewClient(url string, opts ClientOptions) (*Client, error) {
+	if opts.Reconnect == (RetryPolicy{}) {
+		opts.Reconnect = DefaultRetryPolicy
+	}
+	if opts.Reconnect.MaxAttempts < 1 {
+		return nil, fmt.Errorf("velocity: reconnect MaxAttempts must be at least 1, got %d", opts.Reconnect.MaxAttempts)
+	}
+	if opts.Reconnect.Backoff < 0 || opts.Reconnect.MaxBackoff < 0 || opts.Timeout < 0 {
+		return nil, fmt.Errorf("velocity: client durations must not be negative")
+	}
+	if err := initNWEP(); err != nil {
+		return nil, fmt.Errorf("velocity: nwep init: %w", err)
+	}
+	if opts.Keypair == nil {
+		kp, err := nwep.GenerateKeypair()
+		if err != nil {
+			return nil, fmt.Errorf("velocity: generate keypair: %w", err)
+		}
+		opts.Keypair = kp
+	}
+	c := &Client{
+		url:    url,
+		opts:   opts,
+		logger: opts.Logger,
+		sem:    make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	if c.logger == nil {
+		c.logger = DefaultLogger()
+	}
+	nc, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.nc = nc
+	return c, nil
+}
+
+// dial opens a new connection to the server.
+func (c *Client) dial() (*nwep.Client, error) {
+	nopts := []nwep.ClientOption{nwep.WithOnNotify(c.mux.Dispatch)}
+	if c.opts.Settings != nil {
+		nopts = append(nopts, nwep.WithClientSettings(*c.opts.Settings))
+	}
+	nc, err := nwep.NewClient(c.opts.Keypair, nopts...)
+	if err != nil {
+		return nil, fmt.Errorf("velocity: client: %w", err)
+	}
+	if err := nc.Connect(c.url); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("velocity: connect %s: %w", c.url, err)
+	}
+	return nc, nil
+}
+
+// Close closes the connection and stops any reconnection. Requests sent
+// afterwards fail with ErrClientClosed. Close is safe to call more than once.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+	if c.nc != nil {
+		c.nc.Close()
+		c.nc = nil
+	}
+}
+
+// conn returns the current connection, waiting for a reconnection if one is
+// needed.
+func (c *Client) conn(ctx context.Context) (*nwep.Client, error) {
+	waited := false
+	for {
+		c.mu.Lock()
+		switch {
+		case c.closed:
+			c.mu.Unlock()
+			return nil, ErrClientClosed
+		case c.nc != nil:
+			nc := c.nc
+			c.mu.Unlock()
+			return nc, nil
+		case waited && c.ready == nil:
+			err := c.dialErr
+			c.mu.Unlock()
+			return nil, err
+		}
+		if c.ready == nil {
+			c.startReconnect()
+		}
+		ready := c.ready
+		c.mu.Unlock()
+
+		select {
+		case <-ready:
+			waited = true
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+}
+
+// lost records that nc failed with err and starts reconnecting, unless that
+// has happened already.
+func (c *Client) lost(nc *nwep.Client, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nc != nc || c.closed {
+		return
+	}
+	c.logger.Warn("client connection lost", "url", c.url, "error", err.Error())
+	nc.Close()
+	c.nc = nil
+	c.startReconnect()
+}
+
+// startReconnect starts a reconnection. The caller must hold c.mu.
+func (c *Client) startReconnect() {
+	if c.ready != nil {
+		return
+	}
+	ready := make(chan struct{})
+	c.ready = ready
+	go func() {
+		nc, err := c.reconnect()
+		c.mu.Lock()
+		if c.closed && nc != nil {
+			nc.Close()
+		} else if nc != nil {
+			c.nc = nc
+			c.logger.Info("client reconnected", "url", c.url)
+		}
+		c.dialErr = err
+		c.ready = nil
+		close(ready)
+		c.mu.Unlock()
+	}()
+}
+
+// reconnect dials with backoff until it succeeds, the attempts are used up,
+// or the client is closed.
+func (c *Client) reconnect() (*nwep.Client, error) {
+	p := c.opts.Reconnect
+	backoff := p.Backoff
+	var err error
+	for attempt := range p.MaxAttempts {
+		if attempt > 0 {
+			t := time.NewTimer(backoff)
+			select {
+			case <-t.C:
+			case <-c.done:
+				t.Stop()
+				return nil, ErrClientClosed
+			}
+			backoff = min(2*backoff, max(p.MaxBackoff, p.Backoff))
+		}
+		var nc *nwep.Client
+		if nc, err = c.dial(); err == nil {
+			return nc, nil
+		}
+	}
+	c.logger.Warn("client reconnect failed", "url", c.url, "attempts", p.MaxAttempts, "error", err.Error())
+	return nil, err
+}
+
+// Do sends a request with the given method, path, body, and headers and
+// returns the response, whatever its status. It waits at most until ctx is
+// done, or for ClientOptions.Timeout if ctx has no deadline.
+//
+// This function returns a non-nil error only if no response was received;
+// use ResponseError to turn an error status into an error.
+func (c *Client) Do(ctx context.Context, method, path string, body []byte, headers ...nwep.Header) (*nwep.Response, error) {
+	if c.opts.Timeout > 0 {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+			defer cancel()
+		}
+	}
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+	nc, err := c.conn(ctx)
+	if err != nil {
+		<-c.sem
+		return nil, err
+	}
+
+	type result struct {
+		resp *nwep.Response
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		// The send cannot be interrupted, so it keeps the semaphore
+		// until it finishes even if the caller has given up.
+		defer func() { <-c.sem }()
+		resp, err := clientSend(nc, method, path, body, headers)
+		if err != nil {
+			c.lost(nc, err)
+		}
+		ch <- result{resp, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// clientSend sends the request with nc.
+func clientSend(nc *nwep.Client, method, path string, body []byte, headers []nwep.Header) (*nwep.Response, error) {
+	if len(headers) > 0 {
+		return nc.DoWithHeaders(method, path, body, headers)
+	}
+	return nc.Do(method, path, body)
+}
+
+// Read sends a read request for path. See Do.
+func (c *Client) Read(ctx context.Context, path string, headers ...nwep.Header) (*nwep.Response, error) {
+	return c.Do(ctx, MethodRead, path, nil, headers...)
+}
+
+// Write sends a write request for path with body. See Do.
+func (c *Client) Write(ctx context.Context, path string, body []byte, headers ...nwep.Header) (*nwep.Response, error) {
+	return c.Do(ctx, MethodWrite, path, body, headers...)
+}
+
+// Update sends an update request for path with body. See Do.
+func (c *Client) Update(ctx context.Context, path string, body []byte, headers ...nwep.Header) (*nwep.Response, error) {
+	return c.Do(ctx, MethodUpdate, path, body, headers...)
+}
+
+// Delete sends a delete request for path. See Do.
+func (c *Client) Delete(ctx context.Context, path string, headers ...nwep.Header) (*nwep.Response, error) {
+	return c.Do(ctx, MethodDelete, path, nil, headers...)
+}
+
+// ReadJSON sends a read request for path and decodes the JSON response body
+// into out. This function returns a non-nil error if the request fails, the
+// response has an error status (see ResponseError), or decoding fails.
+func (c *Client) ReadJSON(ctx context.Context, path string, out any, headers ...nwep.Header) error {
+	resp, err := c.Read(ctx, path, headers...)
+	return decodeResponse(resp, err, out)
+}
+
+// WriteJSON sends a write request for path with in encoded as JSON, and
+// decodes the JSON response body into out unless out is nil. Errors are
+// reported as by ReadJSON.
+func (c *Client) WriteJSON(ctx context.Context, path string, in, out any, headers ...nwep.Header) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Write(ctx, path, body, headers...)
+	return decodeResponse(resp, err, out)
+}
+
+func decodeResponse(resp *nwep.Response, err error, out any) error {
+	if err != nil {
+		return err
+	}
+	if err := ResponseError(resp); err != nil {
+		return err
+	}
+	if out == nil || len(resp.Body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Body, out)
+}
+
+// ResponseError returns nil if resp has a success status, and otherwise an
+// *Error with the response's status. The message and details are taken from
+// the body if it is an ErrorPayload (see WithJSONErrors), and the message is
+// the whole body otherwise.
+func ResponseError(resp *nwep.Response) error {
+	if nwep.StatusIsSuccess(resp.Status) {
+		return nil
+	}
+	if p, err := ParseErrorPayload(resp.Body); err == nil {
+		return &Error{Status: resp.Status, Message: p.Message, Details: p.Details}
+	}
+	return &Error{Status: resp.Status, Message: string(resp.Body)}
+}
+
+// OnNotify registers fn for notifications with the given event ("" for any
+// event) whose path matches pattern, as NotifyMux.On does:
+//
+//	client.OnNotify("update", "/orders/:id", func(n *velocity.Notification) {
+//	    refresh(n.Param("id"))
+//	})
+//
+// Handlers run on the nwep callback and should return quickly.
+func (c *Client) OnNotify(event, pattern string, fn func(*Notification)) {
+	c.mux.On(event, pattern, fn)
+}
@@ -0,0 +1,30 @@
+package velocity
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestResponseError(t *testing.T) {
+	err := ResponseError(&nwep.Response{Status: StatusNotFound, Body: []byte(`{"status":"not_found","message":"no such order"}`)})
+	var e *Error
+	if !errors.As(err, &e) || e.Status != StatusNotFound || e.Message != "no such order" {
+		t.Fatalf("ResponseError = %v", err)
+	}
+	err = ResponseError(&nwep.Response{Status: StatusForbidden, Body: []byte("peer not allowed")})
+	if !errors.As(err, &e) || e.Message != "peer not allowed" {
+		t.Fatalf("ResponseError = %v", err)
+	}
+}
+
+func TestClientClosed(t *testing.T) {
+	c := &Client{sem: make(chan struct{}, 1), done: make(chan struct{}), logger: DefaultLogger()}
+	c.Close()
+	c.Close()
+	if _, err := c.Read(context.Background(), "/"); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("Read after Close = %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+package velocity
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// DefaultHealthCheckInterval is how often a ClientPool checks its peers'
+// health unless ClientPoolOptions.HealthCheckInterval says otherwise.
+const DefaultHealthCheckInterval = 30 * time.Second
+
+// ClientPoolOptions configures a ClientPool.
+type ClientPoolOptions struct {
+	// Client configures each connection the pool opens.
+	Client ClientOptions
+
+	// HealthCheckPath, if set, is read from every connected peer each
+	// HealthCheckInterval; a peer whose check fails, with an error or an
+	// error status, is marked unhealthy until a check succeeds. Without
+	// it, a peer is marked unhealthy only when dialing it fails.
+	HealthCheckPath string
+
+	// HealthCheckInterval is the time between health checks. Zero means
+	// DefaultHealthCheckInterval.
+	HealthCheckInterval time.Duration
+}
+
+// ClientPool maintains Clients to many WEB/1 servers, addressed by node ID,
+// for applications that fan requests out to many peers:
+//
+//	pool, _ := velocity.NewClientPool(velocity.ClientPoolOptions{HealthCheckPath: "/health"})
+//	defer pool.Close()
+//	peer, _ := pool.Add("web://...")
+//	resp, err := pool.Do(ctx, peer, velocity.MethodRead, "/stats", nil)
+//
+// Connections are dialed lazily, on a peer's first request, and then kept
+// open; each one reconnects on its own as described for Client. A ClientPool
+// is safe for concurrent use.
+type ClientPool struct {
+	opts ClientPoolOptions
+
+	mu      sync.Mutex
+	entries map[nwep.NodeID]*poolEntry
+	closed  bool
+	done    chan struct{}
+}
+
+type poolEntry struct {
+	url string
+
+	mu      sync.Mutex // held while dialing
+	client  *Client
+	removed bool
+	healthy bool
+	checked time.Time
+	lastErr error
+}
+
+// PeerHealth is the health of a peer in a ClientPool. See ClientPool.Health.
+type PeerHealth struct {
+	URL string
+
+	// Connected reports whether the pool has dialed the peer.
+	Connected bool
+
+	// Healthy reports whether the last dial or health check succeeded. It
+	// is true for a peer that has not been dialed yet.
+	Healthy bool
+
+	// Checked is when Healthy was last updated, and Err is the error that
+	// made the peer unhealthy.
+	Checked time.Time
+	Err     error
+}
+
+// NewClientPool returns an empty pool. It starts a health-check goroutine,
+// stopped by Close, if opts.HealthCheckPath is set. This function returns an
+// error if opts.HealthCheckInterval is negative or opts.Client is invalid
+// (see NewClient).
+func NewClientPool(opts ClientPoolOptions) (*ClientPool, error) {
+	if opts.HealthCheckInterval < 0 {
+		return nil, fmt.Errorf("velocity: health check interval must not be negative, got %s", opts.HealthCheckInterval)
+	}
+	if opts.HealthCheckInterval == 0 {
+		opts.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if r := opts.Client.Reconnect; r != (RetryPolicy{}) && r.MaxAttempts < 1 {
+		return nil, fmt.Errorf("velocity: reconnect MaxAttempts must be at least 1, got %d", r.MaxAttempts)
+	}
+	p := &ClientPool{
+		opts:    opts,
+		entries: make(map[nwep.NodeID]*poolEntry),
+		done:    make(chan struct{}),
+	}
+	if opts.HealthCheckPath != "" {
+		go p.checkLoop()
+	}
+	return p, nil
+}
+
+// Add adds the server at url, a web:// URL such as one returned by
+// Server.URL, and returns its node ID, which addresses it in the pool. The
+// server is not dialed until its first request. Adding a node ID that is
+// already in the pool replaces its URL, and closes its connection if the URL
+// changed. This function returns an error if the node ID cannot be read
+// from url (see NodeIDFromURL) or the pool is closed.
+func (p *ClientPool) Add(url string) (nwep.NodeID, error) {
+	peer, err := NodeIDFromURL(url)
+	if err != nil {
+		return nwep.NodeID{}, err
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nwep.NodeID{}, ErrClientClosed
+	}
+	old := p.entries[peer]
+	if old != nil && old.url == url {
+		p.mu.Unlock()
+		return peer, nil
+	}
+	p.entries[peer] = &poolEntry{url: url, healthy: true}
+	p.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+	return peer, nil
+}
+
+// Remove removes peer from the pool, closing its connection. It is a no-op
+// if peer is not in the pool.
+func (p *ClientPool) Remove(peer nwep.NodeID) {
+	p.mu.Lock()
+	e := p.entries[peer]
+	delete(p.entries, peer)
+	p.mu.Unlock()
+	if e != nil {
+		e.close()
+	}
+}
+
+// Peers returns the node IDs in the pool, in order.
+func (p *ClientPool) Peers() []nwep.NodeID {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return sortedNodeIDs(slices.Collect(maps.Keys(p.entries)))
+}
+
+// HealthyPeers returns the node IDs of the peers that are healthy, in order.
+func (p *ClientPool) HealthyPeers() []nwep.NodeID {
+	var out []nwep.NodeID
+	for _, peer := range p.Peers() {
+		if h, ok := p.Health(peer); ok && h.Healthy {
+			out = append(out, peer)
+		}
+	}
+	return out
+}
+
+// Health returns the health of peer. The second return value is false if
+// peer is not in the pool.
+func (p *ClientPool) Health(peer nwep.NodeID) (PeerHealth, bool) {
+	e := p.entry(peer)
+	if e == nil {
+		return PeerHealth{}, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return PeerHealth{URL: e.url, Connected: e.client != nil, Healthy: e.healthy, Checked: e.checked, Err: e.lastErr}, true
+}
+
+// Client returns the Client for peer, dialing it if this is its first use.
+// This function returns ErrUnknownPeer if peer is not in the pool, and the
+// dial error if dialing fails; the peer is then marked unhealthy and dialed
+// again on its next use.
+func (p *ClientPool) Client(ctx context.Context, peer nwep.NodeID) (*Client, error) {
+	p.mu.Lock()
+	e, closed := p.entries[peer], p.closed
+	p.mu.Unlock()
+	switch {
+	case closed:
+		return nil, ErrClientClosed
+	case e == nil:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPeer, FormatNodeID(peer))
+	}
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.removed {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPeer, FormatNodeID(peer))
+	}
+	if e.client != nil {
+		return e.client, nil
+	}
+	c, err := NewClient(e.url, p.opts.Client)
+	e.setHealth(err)
+	if err != nil {
+		return nil, err
+	}
+	e.client = c
+	return c, nil
+}
+
+// Do sends a request to peer, dialing it if needed, as Client.Do does.
+func (p *ClientPool) Do(ctx context.Context, peer nwep.NodeID, method, path string, body []byte, headers ...nwep.Header) (*nwep.Response, error) {
+	c, err := p.Client(ctx, peer)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, method, path, body, headers...)
+}
+
+// PoolResult is the outcome of a request to one peer in ClientPool.DoAll.
+type PoolResult struct {
+	Peer     nwep.NodeID
+	Response *nwep.Response
+	Err      error
+}
+
+// DoAll sends the same request to every healthy peer concurrently and returns
+// the results, in peer order, once all have finished or ctx is done.
+func (p *ClientPool) DoAll(ctx context.Context, method, path string, body []byte, headers ...nwep.Header) []PoolResult {
+	peers := p.HealthyPeers()
+	results := make([]PoolResult, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Go(func() {
+			resp, err := p.Do(ctx, peer, method, path, body, headers...)
+			results[i] = PoolResult{Peer: peer, Response: resp, Err: err}
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+// Close closes every connection and stops health checking. Later calls to
+// Add, Client, and Do fail with ErrClientClosed.
+func (p *ClientPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	entries := p.entries
+	p.entries = make(map[nwep.NodeID]*poolEntry)
+	p.mu.Unlock()
+	for _, e := range entries {
+		e.close()
+	}
+}
+
+func (p *ClientPool) entry(peer nwep.NodeID) *poolEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.entries[peer]
+}
+
+// checkLoop runs health checks until the pool is closed.
+func (p *ClientPool) checkLoop() {
+	t := time.NewTicker(p.opts.HealthCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.checkAll()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// checkAll checks the health of every connected peer concurrently.
+func (p *ClientPool) checkAll() {
+	p.mu.Lock()
+	entries := slices.Collect(maps.Values(p.entries))
+	p.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.HealthCheckInterval)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, e := range entries {
+		e.mu.Lock()
+		c := e.client
+		e.mu.Unlock()
+		if c == nil {
+			continue
+		}
+		wg.Go(func() {
+			resp, err := c.Read(ctx, p.opts.HealthCheckPath)
+			if err == nil {
+				err = ResponseError(resp)
+			}
+			e.mu.Lock()
+			e.setHealth(err)
+			e.mu.Unlock()
+		})
+	}
+	wg.Wait()
+}
+
+// setHealth records the outcome of a dial or health check. The caller must
+// hold e.mu.
+func (e *poolEntry) setHealth(err error) {
+	e.healthy, e.lastErr, e.checked = err == nil, err, time.Now()
+}
+
+func (e *poolEntry) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.removed = true
+	if e.client != nil {
+		e.client.Close()
+		e.client = nil
+	}
+}
+
+// sortedNodeIDs sorts ids in place and returns it.
+func sortedNodeIDs(ids []nwep.NodeID) []nwep.NodeID {
+	slices.SortFunc(ids, func(a, b nwep.NodeID) int { return bytes.Compare(a[:], b[:]) })
+	return ids
+}
@@ -0,0 +1,40 @@
+package velocity
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func testPoolURL(id nwep.NodeID) string {
+	return "web://" + encodeBase58(append([]byte{127, 0, 0, 1}, id[:]...)) + ":6937/"
+}
+
+func TestClientPool(t *testing.T) {
+	p, err := NewClientPool(ClientPoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := nwep.NodeID{2}, nwep.NodeID{1}
+	for _, id := range []nwep.NodeID{a, b} {
+		if got, err := p.Add(testPoolURL(id)); err != nil || got != id {
+			t.Fatalf("Add = %x, %v", got, err)
+		}
+	}
+	if peers := p.Peers(); len(peers) != 2 || peers[0] != b {
+		t.Fatalf("Peers = %x", peers)
+	}
+	if h, ok := p.Health(a); !ok || !h.Healthy || h.Connected {
+		t.Fatalf("Health before dial = %+v", h)
+	}
+	p.Remove(a)
+	if _, err := p.Do(context.Background(), a, MethodRead, "/", nil); !errors.Is(err, ErrUnknownPeer) {
+		t.Fatalf("Do to removed peer = %v", err)
+	}
+	p.Close()
+	if _, err := p.Add(testPoolURL(a)); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("Add after Close = %v", err)
+	}
+}
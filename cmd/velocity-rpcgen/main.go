@@ -0,0 +1,212 @@
+// Command velocity-rpcgen generates a typed client stub for an RPC service
+// interface, for use with package github.com/usenwep/velocity/rpc. Given
+//
+//	type Calculator interface {
+//		Add(ctx context.Context, req AddRequest) (AddResponse, error)
+//	}
+//
+// in the current package, running
+//
+//	velocity-rpcgen -type Calculator -service calc
+//
+// writes calculator_rpc.go, declaring CalculatorClient, which implements
+// Calculator by calling the "calc" service, and NewCalculatorClient. It is
+// typically run with go:generate.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	typeName := flag.String("type", "", "name of the service interface (required)")
+	service := flag.String("service", "", "RPC service name (default: the lower-cased type name)")
+	output := flag.String("output", "", "output file (default: <type>_rpc.go, lower-cased)")
+	dir := flag.String("dir", ".", "directory of the package declaring the interface")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("velocity-rpcgen: ")
+
+	if *typeName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *service == "" {
+		*service = strings.ToLower(*typeName)
+	}
+	if *output == "" {
+		*output = strings.ToLower(*typeName) + "_rpc.go"
+	}
+
+	src, err := generate(*dir, *typeName, *service)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(*dir, *output), src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generate returns the formatted source of the stub for the interface
+// typeName declared in the package in dir. Only the files of the package
+// that build constraints select for the current GOOS and GOARCH are read,
+// and test files are not; it is an error for dir to hold more than one
+// package.
+func generate(dir, typeName, service string) ([]byte, error) {
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	for _, name := range slices.Concat(pkg.GoFiles, pkg.CgoFiles) {
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if iface := findInterface(file, typeName); iface != nil {
+			return render(fset, file, iface, typeName, service)
+		}
+	}
+	return nil, fmt.Errorf("interface %s not found in %s", typeName, dir)
+}
+
+func findInterface(file *ast.File, name string) *ast.InterfaceType {
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if it, ok := ts.Type.(*ast.InterfaceType); ok && ts.Name.Name == name {
+				return it
+			}
+		}
+	}
+	return nil
+}
+
+// method is an interface method in the form the stub needs.
+type method struct {
+	name      string
+	req, resp string // resp is "" for methods that return only an error
+}
+
+func render(fset *token.FileSet, file *ast.File, iface *ast.InterfaceType, typeName, service string) ([]byte, error) {
+	var methods []method
+	used := map[string]bool{"context": true}
+	for _, f := range iface.Methods.List {
+		ft, ok := f.Type.(*ast.FuncType)
+		if !ok || len(f.Names) != 1 {
+			return nil, fmt.Errorf("%s: embedded interfaces are not supported", typeName)
+		}
+		name := f.Names[0].Name
+		params := expand(ft.Params)
+		results := expand(ft.Results)
+		if len(params) != 2 || exprString(fset, params[0]) != "context.Context" {
+			return nil, fmt.Errorf("%s.%s: want parameters (context.Context, T)", typeName, name)
+		}
+		if n := len(results); n < 1 || n > 2 || exprString(fset, results[n-1]) != "error" {
+			return nil, fmt.Errorf("%s.%s: want results (R, error) or error", typeName, name)
+		}
+		m := method{name: name, req: exprString(fset, params[1])}
+		collectSelectors(params[1], used)
+		if len(results) == 2 {
+			m.resp = exprString(fset, results[0])
+			collectSelectors(results[0], used)
+		}
+		methods = append(methods, m)
+	}
+
+	var imports []string
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := filepath.Base(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if used[name] && path != "context" {
+			imports = append(imports, strings.TrimSpace(specName(spec)+" "+spec.Path.Value))
+		}
+	}
+	slices.Sort(imports)
+
+	stub := typeName + "Client"
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by velocity-rpcgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", file.Name.Name)
+	b.WriteString("import (\n\t\"context\"\n")
+	for _, imp := range imports {
+		fmt.Fprintf(&b, "\t%s\n", imp)
+	}
+	b.WriteString("\n\t\"github.com/usenwep/velocity\"\n\t\"github.com/usenwep/velocity/rpc\"\n)\n\n")
+	fmt.Fprintf(&b, "// %s calls the %q RPC service. It implements %s.\n", stub, service, typeName)
+	fmt.Fprintf(&b, "type %s struct {\n\trpc *rpc.Client\n}\n\n", stub)
+	fmt.Fprintf(&b, "var _ %s = (*%s)(nil)\n\n", typeName, stub)
+	fmt.Fprintf(&b, "// New%s returns a %s that sends calls with c.\n", stub, stub)
+	fmt.Fprintf(&b, "func New%s(c *velocity.Client) *%s {\n\treturn &%s{rpc: rpc.NewClient(c, %q)}\n}\n", stub, stub, stub, service)
+	for _, m := range methods {
+		if m.resp == "" {
+			fmt.Fprintf(&b, "\nfunc (s *%s) %s(ctx context.Context, req %s) error {\n", stub, m.name, m.req)
+			fmt.Fprintf(&b, "\treturn s.rpc.Call(ctx, %q, req, nil)\n}\n", m.name)
+			continue
+		}
+		fmt.Fprintf(&b, "\nfunc (s *%s) %s(ctx context.Context, req %s) (%s, error) {\n", stub, m.name, m.req, m.resp)
+		fmt.Fprintf(&b, "\tvar resp %s\n\terr := s.rpc.Call(ctx, %q, req, &resp)\n\treturn resp, err\n}\n", m.resp, m.name)
+	}
+	return format.Source(b.Bytes())
+}
+
+// expand returns the type of each parameter or result in fl, repeating
+// types shared by several names.
+func expand(fl *ast.FieldList) []ast.Expr {
+	if fl == nil {
+		return nil
+	}
+	var out []ast.Expr
+	for _, f := range fl.List {
+		for range max(len(f.Names), 1) {
+			out = append(out, f.Type)
+		}
+	}
+	return out
+}
+
+// collectSelectors records the package names referred to in e.
+func collectSelectors(e ast.Expr, used map[string]bool) {
+	ast.Inspect(e, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+}
+
+func specName(spec *ast.ImportSpec) string {
+	if spec.Name == nil {
+		return ""
+	}
+	return spec.Name.Name
+}
+
+func exprString(fset *token.FileSet, e ast.Expr) string {
+	var b bytes.Buffer
+	_ = printer.Fprint(&b, fset, e)
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	src := `package svc
+
+import (
+	"context"
+	"time"
+
+	"example.com/types"
+)
+
+type Calculator interface {
+	Add(ctx context.Context, req types.AddRequest) (time.Duration, error)
+	Reset(context.Context, struct{}) error
+}
+
+type Bad interface {
+	Add(req int) error
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "svc.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := generate(dir, "Calculator", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"example.com/types"`,
+		`"time"`,
+		`func NewCalculatorClient(c *velocity.Client) *CalculatorClient`,
+		`func (s *CalculatorClient) Add(ctx context.Context, req types.AddRequest) (time.Duration, error)`,
+		`s.rpc.Call(ctx, "Reset", req, nil)`,
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output lacks %s:\n%s", want, out)
+		}
+	}
+
+	if _, err := generate(dir, "Bad", "bad"); err == nil {
+		t.Error("generate accepted a method without a context parameter")
+	}
+	if _, err := generate(dir, "Missing", "m"); err == nil {
+		t.Error("generate found a missing interface")
+	}
+
+	// A file excluded by build constraints is not read, but a second
+	// package in the directory is an error.
+	ignored := "//go:build ignore\n\npackage main\n\ntype Tool interface{}\n"
+	if err := os.WriteFile(filepath.Join(dir, "tool.go"), []byte(ignored), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generate(dir, "Tool", "tool"); err == nil {
+		t.Error("generate read a file excluded by build constraints")
+	}
+	if _, err := generate(dir, "Calculator", "calc"); err != nil {
+		t.Errorf("generate with an ignored file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "other.go"), []byte("package other\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generate(dir, "Calculator", "calc"); err == nil {
+		t.Error("generate accepted a directory with two packages")
+	}
+}
@@ -0,0 +1,157 @@
+package velocity
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MIMEJSON is the media type of the JSON codec, which is always registered.
+const MIMEJSON = "application/json"
+
+// Codec encodes and decodes request and response bodies in one media type.
+// Register codecs with RegisterCodec; Context.Bind and Context.Render choose
+// among them by the request's content-type and accept headers.
+// Implementations must be safe for concurrent use.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// jsonCodec is the Codec for MIMEJSON, backed by encoding/json.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+var codecs = struct {
+	mu sync.RWMutex
+	m  map[string]Codec
+}{m: map[string]Codec{MIMEJSON: jsonCodec{}}}
+
+// RegisterCodec makes codec available for the media type contentType, such
+// as "application/cbor", to Context.Bind and Context.Render in every server.
+// Registering a media type again replaces its codec, which can be used to
+// swap in a different JSON implementation. It is typically called from an
+// init function:
+//
+//	func init() {
+//		velocity.RegisterCodec("application/cbor", cborCodec{})
+//	}
+//
+// Media types are matched case-insensitively and without parameters.
+// RegisterCodec panics if contentType is empty or codec is nil.
+func RegisterCodec(contentType string, codec Codec) {
+	mt := mediaType(contentType)
+	if mt == "" || codec == nil {
+		panic("velocity: RegisterCodec: empty content type or nil codec")
+	}
+	codecs.mu.Lock()
+	codecs.m[mt] = codec
+	codecs.mu.Unlock()
+}
+
+// LookupCodec returns the codec registered for contentType, if any.
+// Parameters such as "; charset=utf-8" are ignored.
+func LookupCodec(contentType string) (Codec, bool) {
+	codecs.mu.RLock()
+	defer codecs.mu.RUnlock()
+	c, ok := codecs.m[mediaType(contentType)]
+	return c, ok
+}
+
+// mediaType returns the lower-cased media type of a content-type or accept
+// entry, without parameters.
+func mediaType(v string) string {
+	mt, _, _ := strings.Cut(v, ";")
+	return strings.ToLower(strings.TrimSpace(mt))
+}
+
+// requestCodec returns the codec for a request body with the given
+// content-type, falling back to JSON if the header is missing or names no
+// registered codec.
+func requestCodec(contentType string) (string, Codec) {
+	if c, ok := LookupCodec(contentType); ok {
+		return mediaType(contentType), c
+	}
+	return MIMEJSON, jsonCodec{}
+}
+
+// negotiate picks the codec for a response from the request's accept and
+// content-type headers. The registered type the peer prefers, by q-value and
+// then by order, wins. A wildcard, or a missing accept header, means the
+// request's own content type if a codec is registered for it, and JSON
+// otherwise. JSON is also the answer if nothing acceptable is registered.
+func negotiate(accept, contentType string) (string, Codec) {
+	type candidate struct {
+		mt string
+		q  float64
+	}
+	var cands []candidate
+	for entry := range strings.SplitSeq(accept, ",") {
+		mt := mediaType(entry)
+		if mt == "" {
+			continue
+		}
+		q := 1.0
+		_, params, _ := strings.Cut(entry, ";")
+		for p := range strings.SplitSeq(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q > 0 {
+			cands = append(cands, candidate{mt, q})
+		}
+	}
+	slices.SortStableFunc(cands, func(a, b candidate) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	if len(cands) == 0 {
+		return requestCodec(contentType)
+	}
+	for _, cand := range cands {
+		if cand.mt == "*/*" || (cand.mt == "application/*" && strings.HasPrefix(mediaType(contentType), "application/")) {
+			return requestCodec(contentType)
+		}
+		if c, ok := LookupCodec(cand.mt); ok {
+			return cand.mt, c
+		}
+	}
+	return MIMEJSON, jsonCodec{}
+}
+
+// Render encodes v with the codec the peer asked for in its accept header and
+// sends it with the given status and a matching content-type header. Without
+// an accept header, or with a wildcard, the response uses the codec of the
+// request's own content type, so a peer that sends CBOR gets CBOR back. JSON
+// is used when nothing acceptable is registered:
+//
+//	srv.Router().Read("/readings", func(c *velocity.Context) error {
+//	    return c.Render(velocity.StatusOK, latestReadings())
+//	})
+//
+// See RegisterCodec. This function returns a non-nil error if encoding fails
+// or the response write fails.
+func (c *Context) Render(status string, v any) error {
+	accept, _ := c.Header("accept")
+	contentType, _ := c.Header("content-type")
+	mt, codec := negotiate(accept, contentType)
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.SetHeader("content-type", mt)
+	return c.Respond(status, data)
+}
@@ -0,0 +1,37 @@
+package velocity
+
+import "testing"
+
+type testCodec struct{}
+
+func (testCodec) Marshal(v any) ([]byte, error)      { return []byte("test"), nil }
+func (testCodec) Unmarshal(data []byte, v any) error { return nil }
+
+func TestNegotiate(t *testing.T) {
+	RegisterCodec("application/x-test", testCodec{})
+
+	for _, tt := range []struct {
+		accept, contentType, want string
+	}{
+		{"", "", MIMEJSON},
+		{"", "application/x-test", "application/x-test"},
+		{"*/*", "application/x-test; charset=utf-8", "application/x-test"},
+		{"application/json", "application/x-test", MIMEJSON},
+		{"application/json;q=0.5, application/x-test", "", "application/x-test"},
+		{"application/x-test;q=0, application/json", "", MIMEJSON},
+		{"text/csv", "", MIMEJSON},
+		{"Application/X-Test", "", "application/x-test"},
+	} {
+		if got, _ := negotiate(tt.accept, tt.contentType); got != tt.want {
+			t.Errorf("negotiate(%q, %q) = %q, want %q", tt.accept, tt.contentType, got, tt.want)
+		}
+	}
+
+	c, rec := NewTestContext(MethodRead, "/", nil)
+	if err := c.Render(StatusCreated, map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if ct, _ := rec.Header("content-type"); ct != MIMEJSON || rec.Status != StatusCreated || string(rec.Body) != `{"n":1}` {
+		t.Fatalf("Render: %q %q %q", ct, rec.Status, rec.Body)
+	}
+}
@@ -1,54 +1,116 @@
 package velocity
 
-import nwep "github.com/usenwep/nwep-go"
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// CompressionAlgorithms lists the compression algorithm names accepted by
+// Config.Compression and WithSettings. "none" disables compression. The list
+// holds the algorithms nwep negotiates; if nwep adds one, a program can append
+// its name before configuring the server.
+var CompressionAlgorithms = []string{"none", "zstd", "gzip"}
 
 // Config holds server configuration values that can be loaded from a file,
 // environment, or any other source and applied to a Server via WithConfig.
 // Zero-valued fields are ignored - only fields with non-zero values are
 // applied, allowing partial configuration.
 //
+// LoadConfig reads a Config from a JSON, YAML, or TOML file. The config tag
+// of each field gives its key there, with dots separating sections, and
+// LoadEnv reads the same keys from VELOCITY_* environment variables.
+//
 // Config is a convenience for declarative setup. For programmatic
 // configuration, the individual With* options (WithKeypair, WithSettings,
 // etc.) provide finer-grained control.
 type Config struct {
 	// Addr is the UDP listen address in "host:port" format. If empty,
 	// the address passed to New is used unchanged.
-	Addr string
+	Addr string `config:"addr"`
+
+	// AdditionalAddrs lists further listen addresses, each added as by
+	// WithAdditionalAddr.
+	AdditionalAddrs []string `config:"additional_addrs"`
+
+	// AdvertisedAddr is the address embedded in the server's URLs, as set
+	// by WithAdvertisedAddr: an IP address, optionally with a port, such
+	// as "203.0.113.7:6937" or "[2001:db8::1]:6937". If empty, URLs carry
+	// the bound address.
+	AdvertisedAddr string `config:"advertised_addr"`
 
 	// KeyFile is the path to a hex-encoded Ed25519 seed file. If the
 	// file does not exist, a new keypair is generated and saved. See
 	// LoadOrGenerateKeypair for details. If both KeyFile and KeyEnv
 	// are set, KeyFile takes precedence.
-	KeyFile string
+	KeyFile string `config:"key_file"`
 
 	// KeyEnv is the name of an environment variable containing a
 	// hex-encoded Ed25519 seed. It is only used if KeyFile is empty or
 	// if no keypair was loaded from KeyFile.
-	KeyEnv string
+	KeyEnv string `config:"key_env"`
 
 	// Role sets the server's advertised role in the WEB/1 handshake.
 	// Common values are "regular", "log_server", and "anchor".
-	Role string
+	Role string `config:"role"`
 
 	// MaxStreams sets the maximum number of concurrent streams per
 	// connection. If zero, the nwep default (100) is used.
-	MaxStreams uint32
+	MaxStreams uint32 `config:"limits.max_streams"`
 
 	// MaxMessageSize sets the maximum size of a single protocol
 	// message in bytes. If zero, the nwep default (24 MiB) is used.
-	MaxMessageSize uint32
+	MaxMessageSize uint32 `config:"limits.max_message_size"`
 
 	// TimeoutMs sets the connection idle timeout in milliseconds.
 	// If zero, the nwep default (30000) is used.
-	TimeoutMs uint32
+	TimeoutMs uint32 `config:"limits.timeout_ms"`
 
-	// Compression sets the compression algorithm for the connection.
-	// If empty, no compression is used.
-	Compression string
+	// Compression sets the compression algorithms offered for each
+	// connection, as a comma-separated list in order of preference (e.g.
+	// "zstd,gzip"). A single name is also accepted. Each connection uses
+	// the first listed algorithm that the peer also supports; if the peer
+	// supports none of them, the connection is not compressed. Names must
+	// appear in CompressionAlgorithms. If empty, no compression is used.
+	Compression string `config:"compression"`
 
 	// LogLevel sets the minimum severity for the nwep C library's
-	// internal logger. If zero, the level is not changed.
-	LogLevel nwep.LogLevel
+	// internal logger. If zero, the level is not changed. In files and
+	// the environment it is written as trace, debug, info, warn, or
+	// error, and a level read there is applied even if it is zero.
+	LogLevel nwep.LogLevel `config:"log_level"`
+
+	// MaxBodySize rejects request bodies longer than this many bytes, as
+	// WithMaxBodySize does. If zero, bodies are not limited.
+	MaxBodySize int `config:"limits.max_body_size"`
+
+	// RequestTimeout is the default handler timeout, as set by
+	// WithTimeout. If zero, handlers have no timeout.
+	RequestTimeout time.Duration `config:"limits.request_timeout"`
+
+	// TrustAnchors lists hex-encoded BLS public keys to trust as
+	// checkpoint signers. If any are given, the server builds a trust
+	// store holding them, as WithTrust does, and verifies peers with
+	// TrustVerify middleware.
+	TrustAnchors []string `config:"trust.anchors"`
+
+	// Recover, RequestLogger, and JSONErrors enable the Recover and
+	// RequestLogger middleware and the WithJSONErrors option. The
+	// middleware is added with Server.Use, Recover first, as
+	// ValidateMiddleware requires.
+	Recover       bool `config:"middleware.recover"`
+	RequestLogger bool `config:"middleware.request_logger"`
+	JSONErrors    bool `config:"middleware.json_errors"`
+
+	// logLevelSet records that LogLevel was read from a file or the
+	// environment, so that a zero level there is still applied.
+	logLevelSet bool
 }
 
 // DefaultConfig returns a Config with sensible defaults: port 4433, info-level
@@ -61,15 +123,124 @@ func DefaultConfig() *Config {
 	}
 }
 
+// Validate reports whether cfg's values are usable. It checks that every
+// algorithm listed in Compression appears in CompressionAlgorithms, that
+// MaxBodySize and RequestTimeout are not negative, and that every entry of
+// TrustAnchors is a hex-encoded BLS public key. Apply calls Validate before
+// changing the Server.
+func (cfg *Config) Validate() error {
+	if _, err := parseCompression(cfg.Compression); err != nil {
+		return fmt.Errorf("velocity: config: %w", err)
+	}
+	if cfg.MaxBodySize < 0 {
+		return fmt.Errorf("velocity: config: max body size must not be negative, got %d", cfg.MaxBodySize)
+	}
+	if cfg.RequestTimeout < 0 {
+		return fmt.Errorf("velocity: config: request timeout must not be negative, got %s", cfg.RequestTimeout)
+	}
+	if _, err := parseAnchors(cfg.TrustAnchors); err != nil {
+		return fmt.Errorf("velocity: config: %w", err)
+	}
+	if cfg.AdvertisedAddr != "" {
+		if _, _, err := parseAdvertisedAddr(cfg.AdvertisedAddr); err != nil {
+			return fmt.Errorf("velocity: config: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseAdvertisedAddr splits an advertised address, an IP address with an
+// optional port, into its parts. The port is 0 if absent.
+func parseAdvertisedAddr(s string) (ip string, port int, err error) {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().String(), int(ap.Port()), nil
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid advertised address %q", s)
+	}
+	return addr.String(), 0, nil
+}
+
+// parseAnchors decodes hex-encoded BLS public keys.
+func parseAnchors(keys []string) ([]nwep.BLSPubkey, error) {
+	anchors := make([]nwep.BLSPubkey, 0, len(keys))
+	for _, key := range keys {
+		var pk nwep.BLSPubkey
+		b, err := hex.DecodeString(strings.TrimSpace(key))
+		if err != nil || len(b) != len(pk) {
+			return nil, fmt.Errorf("trust anchor %q is not a %d-byte hex BLS public key", key, len(pk))
+		}
+		copy(pk[:], b)
+		anchors = append(anchors, pk)
+	}
+	return anchors, nil
+}
+
+// parseCompression splits a comma-separated compression preference list,
+// trimming spaces, lowercasing names, and dropping duplicates while keeping
+// the first occurrence. It returns an error naming the first algorithm not in
+// CompressionAlgorithms.
+func parseCompression(list string) ([]string, error) {
+	var algs []string
+	for name := range strings.SplitSeq(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || slices.Contains(algs, name) {
+			continue
+		}
+		if !slices.Contains(CompressionAlgorithms, name) {
+			return nil, fmt.Errorf("unsupported compression algorithm %q", name)
+		}
+		algs = append(algs, name)
+	}
+	return algs, nil
+}
+
+// normalizeCompression validates a compression preference list and returns
+// it in the canonical form passed to nwep: comma-separated, lowercase, with no
+// spaces or duplicates.
+func normalizeCompression(list string) (string, error) {
+	algs, err := parseCompression(list)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(algs, ","), nil
+}
+
 // Apply applies the non-zero fields of cfg to the Server. It is called
-// internally by WithConfig and should not be called directly.
+// internally by WithConfig and should not be called directly. Since it adds
+// middleware and a trust store, a server takes only one Config: applying a
+// second one returns an error.
 //
 // KeyFile is loaded first. If KeyFile is empty or produces no keypair, KeyEnv
 // is tried. LogLevel is applied via SetLogLevel. All transport-related fields
 // are collected into an nwep.Settings and stored on the server.
 //
-// This function returns a non-nil error if key loading fails.
+// This function returns a non-nil error if cfg fails Validate, if key
+// loading fails, or if the trust store for TrustAnchors cannot be built.
 func (cfg *Config) Apply(s *Server) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if s.configApplied {
+		return errors.New("velocity: config already applied to this server")
+	}
+	s.configApplied = true
+	if cfg.Addr != "" {
+		s.addr = cfg.Addr
+	}
+	if cfg.AdvertisedAddr != "" {
+		// Validate has already checked the address.
+		ip, port, _ := parseAdvertisedAddr(cfg.AdvertisedAddr)
+		if err := WithAdvertisedAddr(ip, port)(s); err != nil {
+			return err
+		}
+	}
+	for _, addr := range cfg.AdditionalAddrs {
+		if err := WithAdditionalAddr(addr)(s); err != nil {
+			return err
+		}
+	}
 	if cfg.KeyFile != "" {
 		kp, err := LoadOrGenerateKeypair(cfg.KeyFile)
 		if err != nil {
@@ -84,7 +255,7 @@ func (cfg *Config) Apply(s *Server) error {
 		}
 		s.keypair = kp
 	}
-	if cfg.LogLevel != 0 {
+	if cfg.LogLevel != 0 || cfg.logLevelSet {
 		SetLogLevel(cfg.LogLevel)
 	}
 	settings := nwep.Settings{}
@@ -98,11 +269,38 @@ func (cfg *Config) Apply(s *Server) error {
 		settings.TimeoutMs = cfg.TimeoutMs
 	}
 	if cfg.Compression != "" {
-		settings.Compression = cfg.Compression
+		// Validate has already checked the list.
+		settings.Compression, _ = normalizeCompression(cfg.Compression)
 	}
 	if cfg.Role != "" {
 		settings.Role = cfg.Role
 	}
 	s.settings = &settings
+
+	if cfg.MaxBodySize > 0 {
+		s.maxBody = cfg.MaxBodySize
+	}
+	if cfg.RequestTimeout > 0 {
+		s.timeout = cfg.RequestTimeout
+	}
+	if cfg.JSONErrors {
+		s.jsonErrors = true
+	}
+	if cfg.Recover {
+		s.Use(Recover())
+	}
+	if cfg.RequestLogger {
+		s.Use(RequestLogger())
+	}
+	if len(cfg.TrustAnchors) > 0 {
+		// Validate has already checked the keys.
+		anchors, _ := parseAnchors(cfg.TrustAnchors)
+		ts, err := (&TrustConfig{Anchors: anchors}).Build()
+		if err != nil {
+			return fmt.Errorf("velocity: build trust store: %w", err)
+		}
+		s.setTrustStore(ts, anchors)
+		s.Use(TrustVerify(ts))
+	}
 	return nil
 }
@@ -0,0 +1,155 @@
+package velocity
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestNormalizeCompression(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"", "", true},
+		{"zstd", "zstd", true},
+		{" ZSTD , gzip,zstd,, none", "zstd,gzip,none", true},
+		{"zstd,brotli", "", false},
+	}
+	for _, tt := range tests {
+		got, err := normalizeCompression(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: err = %v, want ok = %v", tt.in, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	if err := (&Config{Compression: "lzma"}).Validate(); err == nil {
+		t.Error("Validate accepted an unsupported algorithm")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	anchor := strings.Repeat("ab", len(nwep.BLSPubkey{}))
+	files := map[string]string{
+		"server.yaml": `# server config
+addr: ":6937"
+key_file: "server.key"
+log_level: debug
+limits:
+  max_streams: 200
+  request_timeout: 5s # per handler
+trust:
+  anchors:
+    - ` + anchor + `
+middleware:
+  recover: true
+`,
+		"server.toml": `addr = ":6937"
+key_file = 'server.key'
+log_level = "debug"
+
+[limits]
+max_streams = 200
+request_timeout = "5s"
+
+[trust]
+anchors = [
+  "` + anchor + `",
+]
+
+[middleware]
+recover = true
+`,
+		"server.json": `{"addr": ":6937", "key_file": "server.key", "log_level": "debug",
+"limits": {"max_streams": 200, "request_timeout": "5s"},
+"trust": {"anchors": ["` + anchor + `"]}, "middleware": {"recover": true}}`,
+	}
+	want := DefaultConfig()
+	want.Addr = ":6937"
+	want.KeyFile = "server.key"
+	want.LogLevel = nwep.LogDebug
+	want.logLevelSet = true
+	want.MaxStreams = 200
+	want.RequestTimeout = 5 * time.Second
+	want.TrustAnchors = []string{anchor}
+	want.Recover = true
+
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("%s: got %+v, want %+v", name, cfg, want)
+		}
+	}
+
+	t.Setenv("VELOCITY_LIMITS_MAX_STREAMS", "50")
+	t.Setenv("VELOCITY_MIDDLEWARE_JSON_ERRORS", "true")
+	t.Setenv("VELOCITY_LOG_LEVEL", "trace")
+	cfg, err := LoadConfig(filepath.Join(dir, "server.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxStreams != 50 || !cfg.JSONErrors || cfg.LogLevel != nwep.LogTrace || !cfg.logLevelSet {
+		t.Errorf("env overrides not applied: %+v", cfg)
+	}
+
+	for name, data := range map[string]string{
+		"typo.yaml":   "limits:\n  max_stream: 1\n",
+		"bad.toml":    "[limits]\nmax_streams = -1\n",
+		"anchor.json": `{"trust": {"anchors": ["00"]}}`,
+		"server.ini":  "addr=:1\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("%s: LoadConfig succeeded", name)
+		}
+	}
+}
+
+func TestParseFlowList(t *testing.T) {
+	got, err := parseFlowList(`["a,b", 'c, d', "e\",f", g]`, tomlScalar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a,b", "c, d", `e",f`, "g"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseFlowList = %q, want %q", got, want)
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	s := &Server{logger: DefaultLogger(), router: NewRouter(), peers: newPeerTracker()}
+	s.Handle("/", func(c *Context) error { return c.NoContent() })
+	cfg := &Config{Recover: true, RequestLogger: true}
+	if err := cfg.Apply(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ValidateMiddleware(); err != nil {
+		t.Fatalf("middleware added by Apply: %v", err)
+	}
+	if err := cfg.Apply(s); err == nil {
+		t.Fatal("second Apply accepted")
+	}
+	if len(s.mw) != 2 {
+		t.Fatalf("%d global middleware after a rejected Apply, want 2", len(s.mw))
+	}
+}
@@ -0,0 +1,463 @@
+package velocity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// ConfigEnvPrefix prefixes the environment variables read by Config.LoadEnv.
+const ConfigEnvPrefix = "VELOCITY_"
+
+// LoadConfig reads the configuration file at path into a Config, starting from
+// DefaultConfig, then applies VELOCITY_* environment overrides (see
+// Config.LoadEnv) and validates the result. The format is chosen by the file
+// extension: .json, .yaml or .yml, or .toml. Keys are the config tags of the
+// Config fields, with each dot-separated section as a nested table:
+//
+//	addr: ":6937"
+//	key_file: server.key
+//	log_level: debug
+//	limits:
+//	  max_streams: 200
+//	  request_timeout: 5s
+//	trust:
+//	  anchors: [a1b2...]
+//	middleware:
+//	  recover: true
+//
+// Durations are strings in time.ParseDuration syntax, log levels are names
+// ("trace", "debug", "info", "warn", "error") or numbers, and lists may also
+// be given as comma-separated strings. The YAML and TOML readers accept the
+// subset of those languages that such files need - nested mappings or
+// tables, scalars, and lists of scalars - and no more.
+//
+// This function returns an error if the file cannot be read or parsed, names
+// a key that is not a Config field, or fails Validate.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("velocity: config: %w", err)
+	}
+	var values map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		values, err = parseJSONConfig(data)
+	case ".yaml", ".yml":
+		values, err = parseYAMLConfig(data)
+	case ".toml":
+		values, err = parseTOMLConfig(data)
+	default:
+		return nil, fmt.Errorf("velocity: config: %s: unsupported file extension %q", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("velocity: config: %s: %w", path, err)
+	}
+	cfg := DefaultConfig()
+	fields := configFields(cfg)
+	for key, v := range values {
+		field, ok := fields[key]
+		if !ok {
+			return nil, fmt.Errorf("velocity: config: %s: unknown key %q", path, key)
+		}
+		if err := setConfigField(field, v); err != nil {
+			return nil, fmt.Errorf("velocity: config: %s: %s: %w", path, key, err)
+		}
+		cfg.logLevelSet = cfg.logLevelSet || field.Type() == logLevelType
+	}
+	if err := cfg.LoadEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadEnv overrides fields of cfg from environment variables. The variable
+// for a field is ConfigEnvPrefix followed by its config key in upper case,
+// with dots replaced by underscores: VELOCITY_ADDR, VELOCITY_LIMITS_MAX_STREAMS,
+// VELOCITY_MIDDLEWARE_RECOVER, and so on. Lists are comma-separated. Unset and
+// empty variables are ignored. This function returns an error naming the
+// first variable whose value does not parse.
+func (cfg *Config) LoadEnv() error {
+	for key, field := range configFields(cfg) {
+		name := ConfigEnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		if err := setConfigField(field, v); err != nil {
+			return fmt.Errorf("velocity: config: %s: %w", name, err)
+		}
+		cfg.logLevelSet = cfg.logLevelSet || field.Type() == logLevelType
+	}
+	return nil
+}
+
+// configFields returns the settable fields of cfg keyed by config tag.
+func configFields(cfg *Config) map[string]reflect.Value {
+	v := reflect.ValueOf(cfg).Elem()
+	fields := make(map[string]reflect.Value, v.NumField())
+	for i := range v.NumField() {
+		if key := v.Type().Field(i).Tag.Get("config"); key != "" {
+			fields[key] = v.Field(i)
+		}
+	}
+	return fields
+}
+
+var (
+	durationType = reflect.TypeFor[time.Duration]()
+	logLevelType = reflect.TypeFor[nwep.LogLevel]()
+)
+
+var logLevelNames = map[string]nwep.LogLevel{
+	"trace": nwep.LogTrace,
+	"debug": nwep.LogDebug,
+	"info":  nwep.LogInfo,
+	"warn":  nwep.LogWarn,
+	"error": nwep.LogError,
+}
+
+// setConfigField stores v, a string or a []string as produced by the config
+// parsers, in field.
+func setConfigField(field reflect.Value, v any) error {
+	if field.Kind() == reflect.Slice {
+		list, ok := v.([]string)
+		if !ok {
+			for item := range strings.SplitSeq(v.(string), ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+		return nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("want a single value, got a list")
+	}
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	case field.Type() == logLevelType:
+		lvl, ok := logLevelNames[strings.ToLower(s)]
+		if !ok {
+			return fmt.Errorf("invalid log level %q", s)
+		}
+		field.SetInt(int64(lvl))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		field.SetUint(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// The config parsers below return a flat map from dotted key to value, where
+// each value is a string or, for lists, a []string.
+
+func parseJSONConfig(data []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	out := make(map[string]any)
+	var walk func(prefix string, m map[string]any) error
+	walk = func(prefix string, m map[string]any) error {
+		for k, v := range m {
+			key := prefix + k
+			switch v := v.(type) {
+			case map[string]any:
+				if err := walk(key+".", v); err != nil {
+					return err
+				}
+			case []any:
+				list := make([]string, 0, len(v))
+				for _, item := range v {
+					s, ok := jsonScalar(item)
+					if !ok {
+						return fmt.Errorf("%s: lists may only hold scalars", key)
+					}
+					list = append(list, s)
+				}
+				out[key] = list
+			default:
+				s, ok := jsonScalar(v)
+				if !ok {
+					return fmt.Errorf("%s: null value", key)
+				}
+				out[key] = s
+			}
+		}
+		return nil
+	}
+	return out, walk("", doc)
+}
+
+func jsonScalar(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+func parseYAMLConfig(data []byte) (map[string]any, error) {
+	type level struct {
+		indent int
+		prefix string
+	}
+	out := make(map[string]any)
+	stack := []level{{indent: -1}}
+	var listKey string // key of the mapping whose block list is being read
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := stripComment(sc.Text())
+		content := strings.TrimLeft(line, " ")
+		if strings.TrimSpace(content) == "" || content == "---" {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", n)
+		}
+		indent := len(line) - len(content)
+		content = strings.TrimSpace(content)
+
+		if item, ok := strings.CutPrefix(content, "-"); ok && (item == "" || item[0] == ' ') {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item outside a list", n)
+			}
+			v, err := yamlScalar(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			list, _ := out[listKey].([]string)
+			out[listKey] = append(list, v)
+			continue
+		}
+
+		for indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		listKey = ""
+		k, v, ok := strings.Cut(content, ":")
+		if !ok || (v != "" && v[0] != ' ') {
+			return nil, fmt.Errorf("line %d: want \"key: value\"", n)
+		}
+		key := stack[len(stack)-1].prefix + unquoteKey(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+			// A nested mapping or a block list follows.
+			stack = append(stack, level{indent: indent, prefix: key + "."})
+			listKey = key
+		case strings.HasPrefix(v, "["):
+			list, err := parseFlowList(v, yamlScalar)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			out[key] = list
+		default:
+			s, err := yamlScalar(v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			out[key] = s
+		}
+	}
+	return out, sc.Err()
+}
+
+func yamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, "{"), strings.HasPrefix(s, "["), strings.HasPrefix(s, "&"), strings.HasPrefix(s, "*"),
+		strings.HasPrefix(s, "|"), strings.HasPrefix(s, ">"):
+		return "", fmt.Errorf("unsupported value %s", s)
+	}
+	return s, nil
+}
+
+func parseTOMLConfig(data []byte) (map[string]any, error) {
+	out := make(map[string]any)
+	prefix := ""
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		n := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") || !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unsupported table header %s", n, line)
+			}
+			prefix = strings.TrimSpace(line[1:len(line)-1]) + "."
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want \"key = value\"", n)
+		}
+		key := prefix + unquoteKey(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, "[") {
+			// Arrays may span lines.
+			for !strings.HasSuffix(v, "]") && i+1 < len(lines) {
+				i++
+				v += " " + strings.TrimSpace(stripComment(lines[i]))
+			}
+			list, err := parseFlowList(v, tomlScalar)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			out[key] = list
+			continue
+		}
+		s, err := tomlScalar(v)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		out[key] = s
+	}
+	return out, nil
+}
+
+func tomlScalar(s string) (string, error) {
+	switch {
+	case s == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case strings.HasPrefix(s, "{"):
+		return "", fmt.Errorf("inline tables are not supported")
+	}
+	// Numbers may use underscores as digit separators.
+	return strings.ReplaceAll(s, "_", ""), nil
+}
+
+// parseFlowList parses a one-line list of scalars such as ["a", "b"].
+func parseFlowList(s string, scalar func(string) (string, error)) ([]string, error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("unterminated list %s", s)
+	}
+	list := []string{}
+	for _, item := range splitFlowItems(s[1 : len(s)-1]) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		v, err := scalar(item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// splitFlowItems splits the body of a flow list at commas, ignoring commas
+// inside quotes.
+func splitFlowItems(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
+
+// stripComment removes a # comment from line, ignoring # inside quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquoteKey(k string) string {
+	if s, err := strconv.Unquote(k); err == nil {
+		return s
+	}
+	return strings.Trim(k, "'")
+}
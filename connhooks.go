@@ -0,0 +1,77 @@
+package velocity
+
+import (
+	"slices"
+	"sync"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// connHooks holds the server's connect and disconnect callbacks. Each is
+// stored behind a pointer so that it can be found again to be removed.
+type connHooks struct {
+	mu         sync.Mutex
+	connect    []*func(*nwep.Conn)
+	disconnect []*func(*nwep.Conn, int)
+}
+
+// OnConnect registers fn to be called when a peer connection is established,
+// after the mutual authentication handshake completes, like WithOnConnect.
+// Unlike the option, it may be called while the server runs, which lets
+// packages that attach to a server, such as metrics or session stores, add
+// their own hooks without displacing anyone else's. Callbacks run in
+// registration order on the nwep callback, so they should return quickly.
+// OnConnect returns a function that unregisters fn. It panics if fn is nil.
+func (s *Server) OnConnect(fn func(*nwep.Conn)) (remove func()) {
+	if fn == nil {
+		panic("velocity: OnConnect requires a function")
+	}
+	p := &fn
+	s.hooks.mu.Lock()
+	s.hooks.connect = append(s.hooks.connect, p)
+	s.hooks.mu.Unlock()
+	return func() {
+		s.hooks.mu.Lock()
+		s.hooks.connect = slices.DeleteFunc(s.hooks.connect, func(q *func(*nwep.Conn)) bool { return q == p })
+		s.hooks.mu.Unlock()
+	}
+}
+
+// OnDisconnect registers fn to be called when a peer connection closes, like
+// WithOnDisconnect, and returns a function that unregisters it. See
+// OnConnect.
+func (s *Server) OnDisconnect(fn func(*nwep.Conn, int)) (remove func()) {
+	if fn == nil {
+		panic("velocity: OnDisconnect requires a function")
+	}
+	p := &fn
+	s.hooks.mu.Lock()
+	s.hooks.disconnect = append(s.hooks.disconnect, p)
+	s.hooks.mu.Unlock()
+	return func() {
+		s.hooks.mu.Lock()
+		s.hooks.disconnect = slices.DeleteFunc(s.hooks.disconnect, func(q *func(*nwep.Conn, int)) bool { return q == p })
+		s.hooks.mu.Unlock()
+	}
+}
+
+// runConnect calls the connect callbacks. The list is copied first so that a
+// callback may register or remove hooks.
+func (h *connHooks) runConnect(conn *nwep.Conn) {
+	h.mu.Lock()
+	fns := slices.Clone(h.connect)
+	h.mu.Unlock()
+	for _, fn := range fns {
+		(*fn)(conn)
+	}
+}
+
+// runDisconnect calls the disconnect callbacks, as runConnect does.
+func (h *connHooks) runDisconnect(conn *nwep.Conn, code int) {
+	h.mu.Lock()
+	fns := slices.Clone(h.disconnect)
+	h.mu.Unlock()
+	for _, fn := range fns {
+		(*fn)(conn, code)
+	}
+}
@@ -0,0 +1,32 @@
+package velocity
+
+import (
+	"slices"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestConnHooks(t *testing.T) {
+	s := &Server{}
+	var got []string
+	for _, opt := range []Option{
+		WithOnConnect(func(*nwep.Conn) { got = append(got, "option") }),
+		WithOnDisconnect(func(_ *nwep.Conn, code int) { got = append(got, "gone") }),
+		WithOnConnect(nil),
+	} {
+		if err := opt(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remove := s.OnConnect(func(*nwep.Conn) { got = append(got, "runtime") })
+	conn := &nwep.Conn{}
+	s.hooks.runConnect(conn)
+	remove()
+	s.hooks.runConnect(conn)
+	s.hooks.runDisconnect(conn, 0)
+	want := []string{"option", "runtime", "option", "gone"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package velocity
+
+import (
+	"bytes"
+	"slices"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// ConnInfo describes a peer's connection, for diagnostics such as a
+// /debug/conns endpoint. See Server.ConnInfo.
+//
+// The fields under "transport" come from the underlying nwep connection; the
+// others are tracked by velocity.
+type ConnInfo struct {
+	// Peer is the node ID of the connected peer.
+	Peer nwep.NodeID
+
+	// ConnectedAt is when the connection completed its handshake.
+	ConnectedAt time.Time
+
+	// InFlight is the number of the peer's requests being handled.
+	InFlight int
+
+	// PushStreams is the number of server-initiated streams open to the
+	// peer (see Server.OpenStream).
+	PushStreams int
+
+	// Tags are the connection's tags (see Server.TagConn), or nil.
+	Tags map[string]string
+
+	// Transport: the remote network address, as "host:port".
+	RemoteAddr string
+
+	// Transport: how long the WEB/1 handshake took.
+	HandshakeDuration time.Duration
+
+	// Transport: bytes received from and sent to the peer.
+	BytesIn, BytesOut uint64
+
+	// Transport: the number of streams opened on the connection, in both
+	// directions, since it was established.
+	Streams uint64
+
+	// Transport: the settings negotiated in the handshake. Role is the
+	// peer's advertised role.
+	Settings nwep.Settings
+	Role     string
+}
+
+// ConnInfo returns information about peer's connection. The second return
+// value is false if the peer is not connected.
+func (s *Server) ConnInfo(peer nwep.NodeID) (ConnInfo, bool) {
+	conn, connectedAt, inflight, ok := s.peers.snapshot(peer)
+	if !ok {
+		return ConnInfo{}, false
+	}
+	info := ConnInfo{
+		Peer:        peer,
+		ConnectedAt: connectedAt,
+		InFlight:    inflight,
+		PushStreams: len(s.streams.list(&peer)),
+		Tags:        s.peers.tagsOf(peer),
+	}
+	fillConnInfo(&info, conn)
+	return info, true
+}
+
+// ConnInfos returns information about every connected peer's connection,
+// ordered by node ID. It returns nil if the server has not been started.
+func (s *Server) ConnInfos() []ConnInfo {
+	var out []ConnInfo
+	for _, peer := range s.ConnectedPeers() {
+		if info, ok := s.ConnInfo(peer); ok {
+			out = append(out, info)
+		}
+	}
+	slices.SortFunc(out, func(a, b ConnInfo) int { return bytes.Compare(a.Peer[:], b.Peer[:]) })
+	return out
+}
+
+// fillConnInfo sets the transport fields of info from conn.
+func fillConnInfo(info *ConnInfo, conn *nwep.Conn) {
+	if addr := conn.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+	info.HandshakeDuration = conn.HandshakeDuration()
+	info.BytesIn, info.BytesOut = conn.BytesReceived(), conn.BytesSent()
+	info.Streams = conn.StreamCount()
+	info.Settings = conn.Settings()
+	info.Role = info.Settings.Role
+}
+
+// snapshot returns peer's connection, connect time, and in-flight request
+// count. ok is false if the peer is not connected.
+func (t *peerTracker) snapshot(peer nwep.NodeID) (conn *nwep.Conn, connectedAt time.Time, inflight int, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ps, found := t.peers[peer]
+	if !found || ps.conn == nil {
+		return nil, time.Time{}, 0, false
+	}
+	return ps.conn, ps.connectedAt, ps.inflight, true
+}
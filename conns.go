@@ -0,0 +1,73 @@
+package velocity
+
+import (
+	"sync"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// connStore holds per-connection state, keyed by connection and then by an
+// owner-chosen key. A connection's state is discarded when it disconnects.
+type connStore struct {
+	mu    sync.Mutex
+	conns map[*nwep.Conn]map[any]any
+}
+
+// loadOrStore returns the value stored under key for conn, if any. Otherwise
+// it stores val and returns it. loaded reports whether a value was present.
+func (cs *connStore) loadOrStore(conn *nwep.Conn, key, val any) (actual any, loaded bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.conns == nil {
+		cs.conns = make(map[*nwep.Conn]map[any]any)
+	}
+	m := cs.conns[conn]
+	if m == nil {
+		m = make(map[any]any)
+		cs.conns[conn] = m
+	}
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	m[key] = val
+	return val, false
+}
+
+// forget discards all state for conn.
+func (cs *connStore) forget(conn *nwep.Conn) {
+	cs.mu.Lock()
+	delete(cs.conns, conn)
+	cs.mu.Unlock()
+}
+
+// onceKey identifies one OncePerConnection instance in the connStore.
+type onceKey struct{ _ byte }
+
+// OncePerConnection returns middleware that runs mw only for the first
+// request on each connection; later requests on the same connection skip it
+// and go straight to the next handler. It suits connection-scoped setup that
+// needs the request Context, such as establishing a session or logging that a
+// peer became active, which an OnConnect callback cannot do.
+//
+// "First" means first to arrive: if several requests on a new connection
+// arrive together, one runs mw and the others do not wait for it. If mw
+// short-circuits the first request, later requests still skip it. Requests
+// that cannot be associated with a connection always run mw. The record of
+// which connections have been seen is discarded when a connection closes, so
+// a peer that reconnects runs mw again.
+func OncePerConnection(mw MiddlewareFunc) MiddlewareFunc {
+	key := &onceKey{}
+	return func(next HandlerFunc) HandlerFunc {
+		first := mw(next)
+		return func(c *Context) error {
+			conn := c.Conn()
+			if conn == nil {
+				return first(c)
+			}
+			if _, seen := c.server.conns.loadOrStore(conn, key, struct{}{}); seen {
+				return next(c)
+			}
+			return first(c)
+		}
+	}
+}
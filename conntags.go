@@ -0,0 +1,91 @@
+package velocity
+
+import (
+	"maps"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// TagConn tags peer's connection with key=value, replacing any value key had,
+// so that the peer can be found again by its logical group, such as a tenant
+// or a device class, with PeersWithTag. Tags live as long as the connection
+// and are dropped when the peer disconnects; tag the peer again when it
+// reconnects, typically from the request that authenticates it:
+//
+//	srv.TagConn(c.PeerNodeID(), "tenant", account.Tenant)
+//	...
+//	srv.NotifyPeers(srv.PeersWithTag("tenant", "acme"), "update", "/plans", body)
+//
+// This function returns ErrPeerNotConnected if peer has no connection, which
+// includes unauthenticated peers.
+func (s *Server) TagConn(peer nwep.NodeID, key, value string) error {
+	if !s.peers.tag(peer, key, value) {
+		return ErrPeerNotConnected
+	}
+	return nil
+}
+
+// UntagConn removes the tag key from peer's connection, if it has one.
+func (s *Server) UntagConn(peer nwep.NodeID, key string) {
+	s.peers.untag(peer, key)
+}
+
+// ConnTags returns a copy of the tags on peer's connection, or nil if it has
+// none.
+func (s *Server) ConnTags(peer nwep.NodeID) map[string]string {
+	return s.peers.tagsOf(peer)
+}
+
+// PeersWithTag returns the connected peers tagged key=value, sorted by node
+// ID.
+func (s *Server) PeersWithTag(key, value string) []nwep.NodeID {
+	return sortedNodeIDs(s.peers.withTag(key, value))
+}
+
+// tag sets key=value on peer's connection. It reports false if the peer is
+// not connected.
+func (t *peerTracker) tag(peer nwep.NodeID, key, value string) bool {
+	if peer.IsZero() {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ps, ok := t.peers[peer]
+	if !ok || ps.conn == nil {
+		return false
+	}
+	if ps.tags == nil {
+		ps.tags = make(map[string]string)
+	}
+	ps.tags[key] = value
+	return true
+}
+
+func (t *peerTracker) untag(peer nwep.NodeID, key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ps, ok := t.peers[peer]; ok {
+		delete(ps.tags, key)
+	}
+}
+
+func (t *peerTracker) tagsOf(peer nwep.NodeID) map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ps, ok := t.peers[peer]; ok && len(ps.tags) > 0 {
+		return maps.Clone(ps.tags)
+	}
+	return nil
+}
+
+func (t *peerTracker) withTag(key, value string) []nwep.NodeID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var ids []nwep.NodeID
+	for id, ps := range t.peers {
+		if v, ok := ps.tags[key]; ok && v == value && ps.conn != nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
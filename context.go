@@ -1,8 +1,15 @@
 package velocity
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
 	"sync"
+	"time"
 
 	nwep "github.com/usenwep/nwep-go"
 )
@@ -31,28 +38,101 @@ type Context struct {
 	// can read them directly from this field.
 	Request *nwep.Request
 
-	server *Server
-	store  map[string]any
+	// w is where responses are sent: Response for requests served by the
+	// server, or another ResponseWriter for a Context from NewContext.
+	w ResponseWriter
+
+	// buf is the response buffer installed by BufferResponse, if any.
+	buf *ResponseBuffer
+
+	server    *Server
+	store     map[string]any
+	committed bool
+	route     string
+	params    []pathParam
+	query     url.Values
+	status    string
+	written   int    // response body bytes sent, for AccessLog
+	tenant    string // see Tenant
+
+	// tenantVerified is set when TenantFromPeer resolved tenant from the
+	// peer's identity, so that Metrics may label series with it.
+	tenantVerified bool
+
+	// bodyOpened is set once a streamed request body has been handed out,
+	// and bodyErr holds the error from reading it into memory.
+	bodyOpened bool
+	bodyErr    error
+
+	// ctx is the request's context.Context. base is its parent without any
+	// request timeout applied, so that Timeout can replace the server
+	// default rather than only shorten it. cancel releases ctx.
+	ctx    context.Context
+	base   context.Context
+	cancel context.CancelFunc
+
+	// respHeaders mirrors the headers set through SetHeader so that they
+	// can be inspected. The backing array is reused across requests.
+	respHeaders []nwep.Header
 }
 
 var ctxPool = sync.Pool{
 	New: func() any { return &Context{} },
 }
 
-func acquireContext(w *nwep.ResponseWriter, r *nwep.Request, s *Server) *Context {
+// WarmPool pre-allocates n Contexts into the Context pool so that the first
+// requests after startup do not pay for allocating them. It is intended for
+// latency-sensitive services and is typically called once, before Run or
+// Start.
+//
+// The pool is shared by every Server in the process, so warming it on one
+// server benefits all of them. Warming is best-effort: the garbage collector
+// may reclaim idle pool entries at any time, so a pool warmed long before
+// traffic arrives can be cold again by then.
+func (s *Server) WarmPool(n int) {
+	for range n {
+		ctxPool.Put(&Context{})
+	}
+}
+
+func acquireContext(w ResponseWriter, r *nwep.Request, s *Server) *Context {
 	c := ctxPool.Get().(*Context)
-	c.Response = w
+	c.Response, _ = w.(*nwep.ResponseWriter)
 	c.Request = r
+	c.w = w
 	c.server = s
 	c.store = nil
+	c.committed = false
+	c.route = ""
+	c.status = ""
+	c.written = 0
+	c.buf = nil
+	c.tenant, c.tenantVerified = "", false
+	c.respHeaders = c.respHeaders[:0]
+	c.params = c.params[:0]
+	c.query = nil
+	c.bodyOpened, c.bodyErr = false, nil
+	c.ctx, c.base, c.cancel = nil, nil, nil
 	return c
 }
 
 func releaseContext(c *Context) {
 	c.Response = nil
 	c.Request = nil
+	c.w = nil
 	c.server = nil
 	c.store = nil
+	c.committed = false
+	c.route = ""
+	c.status = ""
+	c.written = 0
+	c.buf = nil
+	c.tenant, c.tenantVerified = "", false
+	c.respHeaders = c.respHeaders[:0]
+	c.params = c.params[:0]
+	c.query = nil
+	c.bodyOpened, c.bodyErr = false, nil
+	c.ctx, c.base, c.cancel = nil, nil, nil
 	ctxPool.Put(c)
 }
 
@@ -64,24 +144,161 @@ func releaseContext(c *Context) {
 // "delete"). See the Method* constants for the full set of defined values.
 func (c *Context) Method() string { return c.Request.Method }
 
-// Path returns the request path as sent by the client. The path always begins
-// with a "/" and is not URL-decoded.
+// Path returns the request path as sent by the client, including any query
+// string. The path always begins with a "/" and is not URL-decoded.
 func (c *Context) Path() string { return c.Request.Path }
 
+// RoutePattern returns the pattern of the route that matched this request:
+// the registered path for exact and method-specific routes, or the registered
+// prefix for prefix routes. Unlike Path, the pattern has bounded cardinality,
+// which makes it the right label for metrics and access logs. RoutePattern
+// returns "" when the request was served by the not-found handler.
+func (c *Context) RoutePattern() string { return c.route }
+
+// Ctx returns the context.Context for this request. It carries the request
+// deadline set by WithTimeout or Timeout, if any, and any values added with
+// WithContext. It is cancelled when the deadline passes, when the peer's
+// connection closes, when Server.CancelAll is called, or when the handler
+// returns. Handlers doing slow work should pass it on to database calls and
+// other blocking operations so that they stop once the request is over.
+func (c *Context) Ctx() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Context returns the request's context.Context. It is the same as Ctx, under
+// the name used by net/http, for code written against that convention.
+func (c *Context) Context() context.Context { return c.Ctx() }
+
+// WithContext replaces the request's context.Context, typically to attach
+// values such as a trace span for downstream calls:
+//
+//	c.WithContext(context.WithValue(c.Ctx(), spanKey{}, span))
+//
+// ctx should be derived from c.Ctx() so that it keeps the request's deadline
+// and cancellation. Unlike http.Request.WithContext, the Context is updated in
+// place, because Contexts are pooled. A later Timeout keeps ctx's values but
+// replaces its deadline.
+func (c *Context) WithContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// setTimeout replaces the request's deadline with one d from now, discarding
+// any deadline set earlier but keeping the values of the current context. A d
+// of zero or less removes the deadline. The new context is still cancelled
+// along with the request's root context.
+func (c *Context) setTimeout(d time.Duration) {
+	base := c.base
+	if base == nil {
+		base = context.Background()
+	}
+	parent := c.ctx
+	if parent == nil {
+		parent = base
+	}
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	// Detach from the old deadline, then re-attach to the root context so
+	// that request-wide cancellation, and its cause, still propagate.
+	ctx, cancelCause := context.WithCancelCause(context.WithoutCancel(parent))
+	stop := context.AfterFunc(base, func() { cancelCause(context.Cause(base)) })
+	cancelTimeout := context.CancelFunc(func() {})
+	if d > 0 {
+		ctx, cancelTimeout = context.WithTimeout(ctx, d)
+	}
+	c.ctx = ctx
+	c.cancel = func() {
+		stop()
+		cancelTimeout()
+		cancelCause(context.Canceled)
+	}
+}
+
+// timedOut reports whether the request's deadline passed.
+func (c *Context) timedOut() bool {
+	return c.ctx != nil && c.ctx.Err() == context.DeadlineExceeded
+}
+
 // Body returns the raw request body as a byte slice. The returned slice is
 // valid only for the lifetime of the handler - it must not be retained after
-// the handler returns. If the request has no body, Body returns nil.
-func (c *Context) Body() []byte { return c.Request.Body }
+// the handler returns. If the request has no body, Body returns nil. Under
+// WithStreamingUploads, the first call reads the whole body into memory; see
+// BodyReader.
+func (c *Context) Body() []byte {
+	c.readBody()
+	return c.Request.Body
+}
 
-// Bind deserializes the JSON request body into v using encoding/json. v must
-// be a pointer to the target type. This function returns ErrEmptyBody if the
-// request body is empty or nil, or a json.UnmarshalError if the body is not
-// valid JSON for the target type.
+// Bind deserializes the request body into v. v must be a pointer to the
+// target type. The decoder is the codec registered for the request's
+// content-type header (see RegisterCodec); if the header is missing or names
+// no registered codec, the body is decoded as JSON with encoding/json. This
+// function returns ErrEmptyBody if the request body is empty or nil, or the
+// codec's error, such as a json.UnmarshalError, if the body cannot be decoded
+// into v.
 func (c *Context) Bind(v any) error {
-	if len(c.Request.Body) == 0 {
+	body := c.Body()
+	if c.bodyErr != nil {
+		return c.bodyErr
+	}
+	if len(body) == 0 {
 		return ErrEmptyBody
 	}
-	return json.Unmarshal(c.Request.Body, v)
+	contentType, _ := c.Header("content-type")
+	_, codec := requestCodec(contentType)
+	return codec.Unmarshal(body, v)
+}
+
+// NDJSONError reports the failure of Context.NDJSON on a specific line of the
+// request body. Line is 1-based. Err is either a *json.SyntaxError for a line
+// that is not valid JSON, or the error returned by the callback.
+type NDJSONError struct {
+	Line int
+	Err  error
+}
+
+func (e *NDJSONError) Error() string {
+	return fmt.Sprintf("velocity: ndjson line %d: %v", e.Line, e.Err)
+}
+
+func (e *NDJSONError) Unwrap() error { return e.Err }
+
+// NDJSON iterates over a newline-delimited JSON request body, calling fn once
+// per line with the raw JSON value. Blank lines are skipped and a trailing
+// "\r" is trimmed. Iteration stops at the first line that is not valid JSON
+// or for which fn returns an error, and NDJSON returns an *NDJSONError
+// carrying the line number. The raw message aliases the request body and must
+// not be retained after the handler returns.
+//
+// This function returns ErrEmptyBody if the request body is empty.
+func (c *Context) NDJSON(fn func(raw json.RawMessage) error) error {
+	body := c.Body()
+	if c.bodyErr != nil {
+		return c.bodyErr
+	}
+	if len(body) == 0 {
+		return ErrEmptyBody
+	}
+	for line := 1; len(body) > 0; line++ {
+		var raw []byte
+		raw, body, _ = bytes.Cut(body, []byte{'\n'})
+		raw = bytes.TrimSuffix(raw, []byte{'\r'})
+		if len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+		if !json.Valid(raw) {
+			var v any
+			return &NDJSONError{Line: line, Err: json.Unmarshal(raw, &v)}
+		}
+		if err := fn(raw); err != nil {
+			return &NDJSONError{Line: line, Err: err}
+		}
+	}
+	return nil
 }
 
 // Header returns the value of the request header with the given name. The
@@ -97,6 +314,25 @@ func (c *Context) Headers() []nwep.Header {
 	return c.Request.Headers()
 }
 
+// RangeHeaders calls fn for each request header in order, stopping early if fn
+// returns false. Unlike Headers, it does not allocate a slice, which makes it
+// the better choice for middleware that scans headers on every request.
+func (c *Context) RangeHeaders(fn func(name, value string) bool) {
+	c.Request.RangeHeaders(fn)
+}
+
+// RangeResponseHeaders calls fn for each response header set so far with
+// SetHeader (including headers set by helpers such as JSON), in the order
+// they were first set, stopping early if fn returns false. A header set more
+// than once is reported with its latest value.
+func (c *Context) RangeResponseHeaders(fn func(name, value string) bool) {
+	for _, h := range c.respHeaders {
+		if !fn(h.Name, h.Value) {
+			return
+		}
+	}
+}
+
 // RequestID returns the 16-byte request identifier assigned by the client.
 // Every request carries a unique RequestID that can be used for correlation
 // in logs and responses.
@@ -138,6 +374,35 @@ func (c *Context) PeerIdentity() ([32]byte, nwep.NodeID) {
 	return c.Request.Conn.PeerIdentity()
 }
 
+// ConnectedAt returns the time the peer's current connection completed the
+// mutual authentication handshake, as observed by the server's connect
+// callback. It returns the zero time if the peer is not authenticated or the
+// connection is not tracked.
+func (c *Context) ConnectedAt() time.Time {
+	return c.server.peers.connectedAt(c.PeerNodeID())
+}
+
+// HandshakeDuration returns how long the peer's connection took to complete
+// the WEB/1 handshake, from the first packet to mutual authentication. The
+// second return value is false if the request carries no connection, as in
+// a Context created with NewTestContext.
+func (c *Context) HandshakeDuration() (time.Duration, bool) {
+	if c.Request.Conn == nil {
+		return 0, false
+	}
+	return c.Request.Conn.HandshakeDuration(), true
+}
+
+// ConnSettings returns the transport settings negotiated for the peer's
+// connection, such as the compression algorithm in use. The second return
+// value is false if the request carries no connection.
+func (c *Context) ConnSettings() (nwep.Settings, bool) {
+	if c.Request.Conn == nil {
+		return nwep.Settings{}, false
+	}
+	return c.Request.Conn.Settings(), true
+}
+
 // ---------------------------------------------------------------------------
 // Response helpers
 // ---------------------------------------------------------------------------
@@ -150,71 +415,103 @@ func (c *Context) PeerIdentity() ([32]byte, nwep.NodeID) {
 // fails. Only one response may be sent per request - calling Respond (or any
 // other response method) more than once is undefined.
 func (c *Context) Respond(status string, body []byte) error {
-	return c.Response.Respond(status, body)
+	c.committed = true
+	c.status = status
+	c.written += len(body)
+	return c.w.Respond(status, body)
 }
 
 // OK sends a response with status "ok" and the given body. body may be nil.
 func (c *Context) OK(body []byte) error {
-	return c.Response.Respond(nwep.StatusOK, body)
+	return c.Respond(nwep.StatusOK, body)
 }
 
 // Created sends a response with status "created" and the given body. body may
 // be nil.
 func (c *Context) Created(body []byte) error {
-	return c.Response.Respond(nwep.StatusCreated, body)
+	return c.Respond(nwep.StatusCreated, body)
 }
 
 // NoContent sends a response with status "no_content" and no body.
 func (c *Context) NoContent() error {
-	return c.Response.Respond(nwep.StatusNoContent, nil)
+	return c.Respond(nwep.StatusNoContent, nil)
 }
 
-// JSON marshals v to JSON using encoding/json and sends a response with status
-// "ok" and a "content-type: application/json" header. This function returns a
-// non-nil error if JSON marshaling fails or the response write fails.
+// JSON marshals v to JSON using encoding/json and sends it with a
+// "content-type: application/json" header. The response status is the one
+// previously set with SetStatus, or "ok" if none was set. This function
+// returns a non-nil error if JSON marshaling fails or the response write
+// fails.
 func (c *Context) JSON(v any) error {
+	status := c.status
+	if status == "" {
+		status = nwep.StatusOK
+	}
+	return c.JSONStatus(status, v)
+}
+
+// JSONStatus is like JSON but sends the response with the given status,
+// regardless of any status set with SetStatus. It is the natural way to return
+// structured error bodies:
+//
+//	return c.JSONStatus(velocity.StatusBadRequest, map[string]string{"error": "name is required"})
+func (c *Context) JSONStatus(status string, v any) error {
 	data, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
-	c.Response.SetHeader("content-type", "application/json")
-	return c.Response.Respond(nwep.StatusOK, data)
+	c.SetHeader("content-type", "application/json")
+	return c.Respond(status, data)
 }
 
 // Error sends an error response with an arbitrary status and a plain-text
 // message body. The status should be one of the error Status* constants
-// (e.g. StatusBadRequest, StatusInternalError).
+// (e.g. StatusBadRequest, StatusInternalError). If the server is configured
+// with WithJSONErrors, the body is an ErrorPayload instead.
 func (c *Context) Error(status string, msg string) error {
-	return c.Response.Respond(status, []byte(msg))
+	if c.server != nil && c.server.jsonErrors {
+		return c.ErrorJSON(status, msg, nil)
+	}
+	return c.Respond(status, []byte(msg))
 }
 
 // NotFound sends a response with status "not_found" and the given message.
 func (c *Context) NotFound(msg string) error {
-	return c.Response.Respond(nwep.StatusNotFound, []byte(msg))
+	return c.Error(nwep.StatusNotFound, msg)
 }
 
 // BadRequest sends a response with status "bad_request" and the given message.
 func (c *Context) BadRequest(msg string) error {
-	return c.Response.Respond(nwep.StatusBadRequest, []byte(msg))
+	return c.Error(nwep.StatusBadRequest, msg)
 }
 
 // Unauthorized sends a response with status "unauthorized" and the given
 // message.
 func (c *Context) Unauthorized(msg string) error {
-	return c.Response.Respond(nwep.StatusUnauthorized, []byte(msg))
+	return c.Error(nwep.StatusUnauthorized, msg)
 }
 
 // Forbidden sends a response with status "forbidden" and the given message.
 func (c *Context) Forbidden(msg string) error {
-	return c.Response.Respond(nwep.StatusForbidden, []byte(msg))
+	return c.Error(nwep.StatusForbidden, msg)
 }
 
 // InternalError sends a response with status "internal_error" and the given
 // message. Prefer this over Error(StatusInternalError, msg) for clarity.
 func (c *Context) InternalError(msg string) error {
-	return c.Response.Respond(nwep.StatusInternalError, []byte(msg))
+	return c.Error(nwep.StatusInternalError, msg)
 }
 
+// Decline returns ErrDecline, for a handler to pass the request on without
+// responding:
+//
+//	if !canServe(c) {
+//	    return c.Decline()
+//	}
+//
+// See Router.HandleChain.
+func (c *Context) Decline() error { return ErrDecline }
+
 // ---------------------------------------------------------------------------
 // Streaming
 // ---------------------------------------------------------------------------
@@ -224,27 +521,115 @@ func (c *Context) InternalError(msg string) error {
 // called multiple times to send a response incrementally. The caller must call
 // StreamClose when finished.
 func (c *Context) StreamWrite(data []byte) (int, error) {
-	return c.Response.StreamWrite(data)
+	c.committed = true
+	n, err := c.w.StreamWrite(data)
+	c.written += n
+	return n, err
 }
 
 // StreamClose closes the stream with the given error code. Use 0 for a
 // graceful close. After StreamClose, no further writes are permitted on this
 // stream.
 func (c *Context) StreamClose(errCode int) {
-	c.Response.StreamClose(errCode)
+	c.w.StreamClose(errCode)
+}
+
+// streamAbortCode is the error code a stream is closed with when a streamed
+// response fails part way through.
+const streamAbortCode = 1
+
+// streamBufferSize is the size of the buffer Stream writes through.
+const streamBufferSize = 32 << 10
+
+// Stream sends a response produced incrementally by fn, which writes the body
+// to w. Writes are buffered and sent with StreamWrite in chunks of up to
+// 32 KiB, and the stream is closed when fn returns, so handlers that generate
+// large responses need not manage StreamWrite and StreamClose themselves:
+//
+//	return c.Stream(func(w io.Writer) error {
+//	    for row := range rows {
+//	        if _, err := fmt.Fprintln(w, row); err != nil {
+//	            return err
+//	        }
+//	    }
+//	    return nil
+//	})
+//
+// The status is the one set with SetStatus, or "ok" if none was set; set any
+// headers before calling Stream. Writes to w fail once the request's context
+// is done, so fn stops early if the request times out or the peer goes away.
+//
+// If fn returns an error before anything was sent, Stream returns it without
+// responding, leaving the response to the error handler. If some of the body
+// was already sent, the stream is closed with a non-zero error code and the
+// error is returned. Context itself cannot be an io.Writer, because its Write
+// method sends a whole body; pass w to code that expects one.
+func (c *Context) Stream(fn func(w io.Writer) error) error {
+	if c.status == "" {
+		c.SetStatus(nwep.StatusOK)
+	}
+	bw := bufio.NewWriterSize(contextStreamWriter{c}, streamBufferSize)
+	err := fn(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		if c.committed {
+			c.StreamClose(streamAbortCode)
+		}
+		return err
+	}
+	if !c.committed {
+		// fn wrote nothing: send an empty response instead of a stream.
+		return c.Respond(c.status, nil)
+	}
+	c.StreamClose(0)
+	return nil
+}
+
+// contextStreamWriter is an io.Writer that sends its input with StreamWrite.
+type contextStreamWriter struct{ c *Context }
+
+func (w contextStreamWriter) Write(p []byte) (int, error) {
+	if ctx := w.c.Ctx(); ctx.Err() != nil {
+		return 0, context.Cause(ctx)
+	}
+	return w.c.StreamWrite(p)
+}
+
+// CloseSend finishes the send half of the current stream while leaving the
+// receive half open, so the handler can keep reading from a peer that has not
+// yet finished its side. Any bytes already written with StreamWrite are
+// flushed before the half-close takes effect. After CloseSend, no further
+// StreamWrite, Write, or Respond calls are permitted on this stream;
+// StreamClose may still be called to tear down the receive half.
+//
+// Respond and Write already finish the send half when they complete, so
+// CloseSend is only needed by handlers that stream with StreamWrite.
+func (c *Context) CloseSend() error {
+	return c.w.CloseWrite()
+}
+
+// CloseRecv is the inverse of CloseSend: it stops reading from the peer while
+// leaving the send half open, so the handler can keep pushing data after it
+// has consumed the request. This enables request-then-subscribe patterns on a
+// single stream. The peer is told to stop sending; data it has already sent
+// may be discarded.
+func (c *Context) CloseRecv() error {
+	return c.w.CloseRead()
 }
 
 // StreamID returns the numeric identifier for the current stream. Each stream
 // within a connection has a unique ID.
 func (c *Context) StreamID() int64 {
-	return c.Response.StreamID()
+	return c.w.StreamID()
 }
 
 // IsServerInitiated reports whether this stream was initiated by the server
 // (as opposed to being opened by the client request). Server-initiated streams
 // are used for push-style notifications.
 func (c *Context) IsServerInitiated() bool {
-	return c.Response.IsServerInitiated()
+	return c.w.IsServerInitiated()
 }
 
 // ---------------------------------------------------------------------------
@@ -255,14 +640,26 @@ func (c *Context) IsServerInitiated() bool {
 // Respond - headers set after the response body is sent are silently dropped.
 // Header names are case-sensitive in WEB/1.
 func (c *Context) SetHeader(name, value string) {
-	c.Response.SetHeader(name, value)
+	c.recordHeader(name, value)
+	c.w.SetHeader(name, value)
+}
+
+func (c *Context) recordHeader(name, value string) {
+	for i := range c.respHeaders {
+		if c.respHeaders[i].Name == name {
+			c.respHeaders[i].Value = value
+			return
+		}
+	}
+	c.respHeaders = append(c.respHeaders, nwep.Header{Name: name, Value: value})
 }
 
 // SetStatus sets the response status. This must be called before Write. If
 // Respond is used instead, SetStatus is unnecessary because Respond sets the
-// status internally.
+// status internally. JSON also honors a status set here.
 func (c *Context) SetStatus(status string) {
-	c.Response.SetStatus(status)
+	c.status = status
+	c.w.SetStatus(status)
 }
 
 // Write sends the response body. The caller must call SetStatus (and
@@ -270,9 +667,16 @@ func (c *Context) SetStatus(status string) {
 // or JSON convenience methods are simpler. This function returns a non-nil
 // error if the write fails.
 func (c *Context) Write(body []byte) error {
-	return c.Response.Write(body)
+	c.committed = true
+	c.written += len(body)
+	return c.w.Write(body)
 }
 
+// Committed reports whether a response has been started for this request,
+// through Respond, Write, StreamWrite, or any of the helpers built on them.
+// Middleware and error handlers use it to avoid sending a second response.
+func (c *Context) Committed() bool { return c.committed }
+
 // ---------------------------------------------------------------------------
 // Key-value store
 // ---------------------------------------------------------------------------
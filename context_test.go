@@ -0,0 +1,285 @@
+package velocity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestContextNDJSON(t *testing.T) {
+	c := &Context{Request: &nwep.Request{Body: []byte("{\"n\":1}\r\n\n{\"n\":2}\n")}}
+
+	var got []int
+	err := c.NDJSON(func(raw json.RawMessage) error {
+		var v struct{ N int }
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		got = append(got, v.N)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("got %v, want [1 2]", got)
+	}
+}
+
+func TestContextNDJSONErrorLine(t *testing.T) {
+	c := &Context{Request: &nwep.Request{Body: []byte("{}\n{}\n{bad\n{}")}}
+
+	calls := 0
+	err := c.NDJSON(func(raw json.RawMessage) error {
+		calls++
+		return nil
+	})
+	var nerr *NDJSONError
+	if !errors.As(err, &nerr) {
+		t.Fatalf("err = %v, want *NDJSONError", err)
+	}
+	if nerr.Line != 3 {
+		t.Fatalf("line = %d, want 3", nerr.Line)
+	}
+	if calls != 2 {
+		t.Fatalf("calls = %d, want 2", calls)
+	}
+
+	c.Request.Body = nil
+	if err := c.NDJSON(nil); !errors.Is(err, ErrEmptyBody) {
+		t.Fatalf("err = %v, want ErrEmptyBody", err)
+	}
+}
+
+func TestTimeoutOverridesServerDefault(t *testing.T) {
+	c := &Context{}
+	c.setTimeout(time.Millisecond)
+	defer func() { c.cancel() }()
+
+	h := Timeout(time.Hour)(func(c *Context) error {
+		time.Sleep(5 * time.Millisecond)
+		if err := c.Ctx().Err(); err != nil {
+			t.Errorf("ctx err = %v, want nil under the longer route timeout", err)
+		}
+		return nil
+	})
+	if err := h(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.timedOut() {
+		t.Fatal("timedOut = true, want false")
+	}
+
+	c.setTimeout(time.Millisecond)
+	<-c.Ctx().Done()
+	if !c.timedOut() {
+		t.Fatal("timedOut = false after deadline")
+	}
+}
+
+func TestParseErrorPayload(t *testing.T) {
+	data, err := json.Marshal(ErrorPayload{Status: StatusNotFound, Message: "no such user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"status":"not_found","message":"no such user"}` {
+		t.Fatalf("encoded %s", data)
+	}
+	p, err := ParseErrorPayload(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Error() != "not_found: no such user" {
+		t.Fatalf("Error() = %q", p.Error())
+	}
+	if _, err := ParseErrorPayload([]byte(`{"message":"x"}`)); err == nil {
+		t.Fatal("expected error for payload without status")
+	}
+}
+
+func TestRequestSetCancelAll(t *testing.T) {
+	var rs requestSet
+	c := &Context{}
+	rs.begin(c)
+	c.setTimeout(time.Hour)
+
+	if n := rs.cancelAll(ErrRequestCancelled); n != 1 {
+		t.Fatalf("cancelled %d requests, want 1", n)
+	}
+	if !c.cancelled() || c.timedOut() {
+		t.Fatalf("cancelled = %v, timedOut = %v, want true, false", c.cancelled(), c.timedOut())
+	}
+
+	c.cancel()
+	rs.end(c)
+	if n := rs.cancelAll(ErrRequestCancelled); n != 0 {
+		t.Fatalf("cancelled %d requests after end, want 0", n)
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	err := fmt.Errorf("load user: %w", ErrNotFoundf("user %d", 42))
+	e, ok := asError(err)
+	if !ok {
+		t.Fatal("wrapped *Error not found")
+	}
+	if e.Status != StatusNotFound || e.Message != "user 42" {
+		t.Fatalf("got %+v", e)
+	}
+
+	d := e.WithDetails(map[string]int{"id": 42})
+	if e.Details != nil || d.Payload().Details == nil {
+		t.Fatal("WithDetails should copy, not modify")
+	}
+	if _, ok := asError(errors.New("plain")); ok {
+		t.Fatal("plain error reported as *Error")
+	}
+}
+
+type ctxKey struct{}
+
+func TestWithContextSurvivesTimeout(t *testing.T) {
+	var rs requestSet
+	c := &Context{}
+	rs.begin(c)
+	c.setTimeout(time.Hour)
+	c.WithContext(context.WithValue(c.Ctx(), ctxKey{}, "span"))
+
+	c.setTimeout(time.Minute)
+	if v, _ := c.Context().Value(ctxKey{}).(string); v != "span" {
+		t.Fatalf("value after Timeout = %q, want span", v)
+	}
+	if dl, ok := c.Ctx().Deadline(); !ok || time.Until(dl) > time.Minute {
+		t.Fatalf("deadline = %v, %v, want within a minute", dl, ok)
+	}
+
+	rs.cancelConn(nil, ErrPeerDisconnected)
+	select {
+	case <-c.Ctx().Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled on disconnect")
+	}
+	if cause := context.Cause(c.Ctx()); cause != ErrPeerDisconnected {
+		t.Fatalf("cause = %v, want ErrPeerDisconnected", cause)
+	}
+	c.cancel()
+	rs.end(c)
+}
+
+func TestContextQueryParams(t *testing.T) {
+	c := &Context{Request: &nwep.Request{Path: "/items?page=2&tag=a&tag=b%20c&empty="}}
+	if got := c.QueryParam("page"); got != "2" {
+		t.Fatalf("page = %q, want 2", got)
+	}
+	if got := c.QueryParams()["tag"]; len(got) != 2 || got[1] != "b c" {
+		t.Fatalf("tag = %q", got)
+	}
+	if _, ok := c.QueryParams()["empty"]; !ok {
+		t.Fatal("empty parameter missing")
+	}
+	if got := c.QueryParam("missing"); got != "" {
+		t.Fatalf("missing = %q", got)
+	}
+
+	c = &Context{Request: &nwep.Request{Path: "/items"}}
+	if q := c.QueryParams(); q == nil || len(q) != 0 {
+		t.Fatalf("QueryParams() = %v, want empty map", q)
+	}
+}
+
+func TestNewTestContext(t *testing.T) {
+	c, rec := NewTestContext(MethodWrite, "/items", []byte("x"))
+	c.Server().jsonErrors = true
+	if err := c.NotFound("no such item"); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != StatusNotFound || !c.Committed() {
+		t.Fatalf("status = %q, committed = %v", rec.Status, c.Committed())
+	}
+	p, err := ParseErrorPayload(rec.Body)
+	if err != nil || p.Message != "no such item" {
+		t.Fatalf("payload = %+v, %v", p, err)
+	}
+}
+
+func TestServerDrain(t *testing.T) {
+	s := &Server{logger: DefaultLogger(), router: NewRouter(), peers: newPeerTracker()}
+	var inFlight int
+	s.Handle("/work", func(c *Context) error {
+		inFlight = s.InFlight()
+		return c.NoContent()
+	})
+	serve := func() *ResponseRecorder {
+		rec := NewRecorder()
+		s.ServeWEB(rec, &nwep.Request{Method: MethodRead, Path: "/work"})
+		return rec
+	}
+
+	if rec := serve(); rec.Status != StatusNoContent || inFlight != 1 {
+		t.Fatalf("status = %q, in flight = %d", rec.Status, inFlight)
+	}
+	s.Drain()
+	if rec := serve(); rec.Status != StatusUnavailable || string(rec.Body) != "server draining" {
+		t.Fatalf("draining: %q %q", rec.Status, rec.Body)
+	}
+	s.Resume()
+	if rec := serve(); rec.Status != StatusNoContent || s.InFlight() != 0 {
+		t.Fatalf("resumed: status = %q, in flight = %d", rec.Status, s.InFlight())
+	}
+}
+
+func TestContextStream(t *testing.T) {
+	c, rec := NewTestContext(MethodRead, "/export", nil)
+	err := c.Stream(func(w io.Writer) error {
+		for i := range 3 {
+			fmt.Fprintf(w, "row %d\n", i)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != StatusOK || string(rec.Body) != "row 0\nrow 1\nrow 2\n" || !rec.Closed || rec.CloseCode != 0 {
+		t.Fatalf("stream: status %q, body %q, closed %v (%d)", rec.Status, rec.Body, rec.Closed, rec.CloseCode)
+	}
+
+	// An error before anything is sent leaves the response to the caller.
+	boom := errors.New("boom")
+	c, rec = NewTestContext(MethodRead, "/export", nil)
+	if err := c.Stream(func(w io.Writer) error { w.Write([]byte("partial")); return boom }); err != boom {
+		t.Fatalf("err = %v", err)
+	}
+	if c.Committed() || rec.Closed {
+		t.Fatal("failed stream was sent")
+	}
+
+	// An error after data was sent aborts the stream.
+	c, rec = NewTestContext(MethodRead, "/export", nil)
+	err = c.Stream(func(w io.Writer) error {
+		w.Write(bytes.Repeat([]byte("x"), streamBufferSize+1))
+		return boom
+	})
+	if err != boom || !rec.Closed || rec.CloseCode != streamAbortCode {
+		t.Fatalf("err = %v, closed %v (%d)", err, rec.Closed, rec.CloseCode)
+	}
+}
+
+func TestContextHalfClose(t *testing.T) {
+	c, rec := NewTestContext(MethodRead, "/subscribe", nil)
+	if err := c.CloseRecv(); err != nil || !rec.RecvClosed || rec.SendClosed {
+		t.Fatalf("CloseRecv = %v; recorder %+v", err, rec)
+	}
+	if _, err := c.StreamWrite([]byte("event")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CloseSend(); err != nil || !rec.SendClosed || string(rec.Body) != "event" {
+		t.Fatalf("CloseSend = %v; recorder %+v", err, rec)
+	}
+}
@@ -0,0 +1,207 @@
+package velocity
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"strconv"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// DefaultDebugPrefix is the path prefix Debug mounts its endpoints under
+// unless DebugOptions.Prefix says otherwise.
+const DefaultDebugPrefix = "/debug/velocity"
+
+// maxCPUProfile bounds the seconds parameter of the CPU profile endpoint.
+const maxCPUProfile = 5 * time.Minute
+
+// DebugOptions configures Debug.
+type DebugOptions struct {
+	// Prefix is the path the endpoints are mounted under. Empty means
+	// DefaultDebugPrefix.
+	Prefix string
+
+	// AllowPeers lists the peers that may use the endpoints; every other
+	// peer gets status "forbidden". It must not be empty.
+	AllowPeers []nwep.NodeID
+}
+
+// Debug mounts read endpoints for inspecting a running server, in the spirit
+// of net/http/pprof and expvar, and returns their group so that more can be
+// added:
+//
+//	velocity.Debug(srv, velocity.DebugOptions{AllowPeers: []nwep.NodeID{operator}})
+//
+// Under opts.Prefix it serves, as JSON unless noted:
+//
+//	/             the list of endpoints
+//	/routes       the registered routes (see Router.Routes)
+//	/peers        the connected peers (see Server.ConnInfos)
+//	/stats        per-route latency and status counts (see Server.RouteStats),
+//	              with PoolStats and NotifyStats
+//	/build        the Go version, platform, goroutine count, and build info
+//	/vars         the variables published with package expvar
+//	/pprof/NAME   the runtime/pprof profile NAME, such as "goroutine" or
+//	              "heap", in pprof format, or as text with ?debug=1 or 2
+//	/pprof/profile  a CPU profile over ?seconds=N (default 30)
+//
+// Per-route statistics are only recorded when the Metrics middleware is
+// installed. The endpoints reveal a great deal about the server, so access
+// is restricted with AllowPeers; Debug panics if opts.AllowPeers is empty.
+func Debug(srv *Server, opts DebugOptions) *Group {
+	if len(opts.AllowPeers) == 0 {
+		panic("velocity: Debug requires at least one allowed peer")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultDebugPrefix
+	}
+	g := srv.Group(opts.Prefix, AllowPeers(opts.AllowPeers...))
+	g.Read("/", func(c *Context) error {
+		return c.JSON(debugEndpoints(opts.Prefix))
+	})
+	g.Read("/routes", func(c *Context) error {
+		return c.JSON(srv.router.Routes())
+	})
+	g.Read("/peers", func(c *Context) error {
+		return c.JSON(debugPeers(srv.ConnInfos()))
+	})
+	g.Read("/stats", func(c *Context) error {
+		return c.JSON(map[string]any{
+			"routes": srv.RouteStats(),
+			"pool":   srv.PoolStats(),
+			"notify": srv.NotifyStats(),
+		})
+	})
+	g.Read("/build", func(c *Context) error {
+		return c.JSON(debugBuild())
+	})
+	g.Read("/vars", func(c *Context) error {
+		vars := make(map[string]json.RawMessage)
+		expvar.Do(func(kv expvar.KeyValue) {
+			vars[kv.Key] = json.RawMessage(kv.Value.String())
+		})
+		return c.JSON(vars)
+	})
+	g.Read("/pprof/profile", debugCPUProfile)
+	g.Read("/pprof/:name", debugProfile)
+	return g
+}
+
+func debugEndpoints(prefix string) map[string]string {
+	return map[string]string{
+		prefix + "/routes":        "registered routes",
+		prefix + "/peers":         "connected peers",
+		prefix + "/stats":         "per-route request statistics and handler pool counters",
+		prefix + "/build":         "Go version and build info",
+		prefix + "/vars":          "expvar variables",
+		prefix + "/pprof/:name":   "runtime/pprof profile; ?debug=1 for text",
+		prefix + "/pprof/profile": "CPU profile; ?seconds=N",
+	}
+}
+
+// debugPeer is the JSON form of a ConnInfo.
+type debugPeer struct {
+	Peer              string            `json:"peer"`
+	ConnectedAt       time.Time         `json:"connected_at"`
+	InFlight          int               `json:"in_flight"`
+	PushStreams       int               `json:"push_streams"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	RemoteAddr        string            `json:"remote_addr,omitempty"`
+	HandshakeDuration time.Duration     `json:"handshake_duration,omitempty"`
+	BytesIn           uint64            `json:"bytes_in,omitempty"`
+	BytesOut          uint64            `json:"bytes_out,omitempty"`
+	Streams           uint64            `json:"streams,omitempty"`
+	Role              string            `json:"role,omitempty"`
+}
+
+func debugPeers(infos []ConnInfo) []debugPeer {
+	out := make([]debugPeer, len(infos))
+	for i, info := range infos {
+		out[i] = debugPeer{
+			Peer:              FormatNodeID(info.Peer),
+			ConnectedAt:       info.ConnectedAt,
+			InFlight:          info.InFlight,
+			PushStreams:       info.PushStreams,
+			Tags:              info.Tags,
+			RemoteAddr:        info.RemoteAddr,
+			HandshakeDuration: info.HandshakeDuration,
+			BytesIn:           info.BytesIn,
+			BytesOut:          info.BytesOut,
+			Streams:           info.Streams,
+			Role:              info.Role,
+		}
+	}
+	return out
+}
+
+func debugBuild() map[string]any {
+	out := map[string]any{
+		"go":         runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"cpus":       runtime.NumCPU(),
+		"goroutines": runtime.NumGoroutine(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		out["path"] = bi.Path
+		out["main"] = bi.Main
+		out["deps"] = bi.Deps
+		settings := make(map[string]string, len(bi.Settings))
+		for _, s := range bi.Settings {
+			settings[s.Key] = s.Value
+		}
+		out["settings"] = settings
+	}
+	return out
+}
+
+// debugProfile serves the runtime/pprof profile named by the "name" path
+// parameter.
+func debugProfile(c *Context) error {
+	p := pprof.Lookup(c.Param("name"))
+	if p == nil {
+		return c.NotFound("unknown profile")
+	}
+	dbg, _ := strconv.Atoi(c.QueryParam("debug"))
+	var buf bytes.Buffer
+	if err := p.WriteTo(&buf, dbg); err != nil {
+		return err
+	}
+	if dbg > 0 {
+		c.SetHeader("content-type", "text/plain; charset=utf-8")
+	} else {
+		c.SetHeader("content-type", "application/octet-stream")
+	}
+	return c.OK(buf.Bytes())
+}
+
+// debugCPUProfile records a CPU profile for the number of seconds in the
+// "seconds" query parameter, stopping early if the request is canceled.
+func debugCPUProfile(c *Context) error {
+	d := 30 * time.Second
+	if v := c.QueryParam("seconds"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			return c.BadRequest("seconds must be a positive integer")
+		}
+		d = min(time.Duration(secs)*time.Second, maxCPUProfile)
+	}
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		return c.Error(StatusConflict, "a CPU profile is already being recorded")
+	}
+	t := time.NewTimer(d)
+	select {
+	case <-t.C:
+	case <-c.Ctx().Done():
+		t.Stop()
+	}
+	pprof.StopCPUProfile()
+	c.SetHeader("content-type", "application/octet-stream")
+	return c.OK(buf.Bytes())
+}
@@ -0,0 +1,41 @@
+package velocity
+
+import (
+	"encoding/json"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestDebug(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Debug without allowed peers did not panic")
+		}
+	}()
+
+	s := &Server{router: NewRouter()}
+	Debug(s, DebugOptions{AllowPeers: []nwep.NodeID{{}}})
+
+	h, pattern := s.router.find(DefaultDebugPrefix+"/routes", MethodRead, nil, nil)
+	if pattern != DefaultDebugPrefix+"/routes" {
+		t.Fatalf("pattern = %q", pattern)
+	}
+	c, rec := NewTestContext(MethodRead, DefaultDebugPrefix+"/routes", nil)
+	if err := h(c); err != nil {
+		t.Fatal(err)
+	}
+	var routes []RouteInfo
+	if err := json.Unmarshal(rec.Body, &routes); err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 8 {
+		t.Errorf("got %d routes, want 8", len(routes))
+	}
+
+	if _, pattern := s.router.find(DefaultDebugPrefix+"/pprof/heap", MethodRead, nil, nil); pattern != DefaultDebugPrefix+"/pprof/:name" {
+		t.Errorf("pprof pattern = %q", pattern)
+	}
+
+	Debug(s, DebugOptions{})
+}
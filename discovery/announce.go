@@ -0,0 +1,126 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/usenwep/velocity"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// withdrawTimeout bounds the withdrawal an announcing server sends when it
+// shuts down.
+const withdrawTimeout = 2 * time.Second
+
+// Announce returns a velocity.Option that registers the server with the
+// discovery node at registryURL once it starts, and again every interval, so
+// that its record stays fresh: each announcement lasts three intervals. It
+// announces the server's URL and role (see velocity.WithRole), connecting as
+// the server, with its keypair, and withdraws the record when the server
+// shuts down. Failed announcements are logged and retried at the next
+// interval.
+//
+// The option returns an error if registryURL has no node ID or interval is
+// not positive.
+func Announce(registryURL string, interval time.Duration) velocity.Option {
+	return func(s *velocity.Server) error {
+		if _, err := velocity.NodeIDFromURL(registryURL); err != nil {
+			return err
+		}
+		if interval <= 0 {
+			return fmt.Errorf("discovery: announce interval must be positive, got %s", interval)
+		}
+		a := &announcer{srv: s, url: registryURL, interval: interval}
+		a.ctx, a.stop = context.WithCancel(context.Background())
+		if err := velocity.OnStart(func(*velocity.Server) { a.wg.Go(a.loop) })(s); err != nil {
+			return err
+		}
+		return velocity.OnShutdown(func(*velocity.Server) { a.close() })(s)
+	}
+}
+
+// announcer keeps one server's record fresh.
+type announcer struct {
+	srv      *velocity.Server
+	url      string
+	interval time.Duration
+
+	client *velocity.Client
+	kp     *nwep.Keypair // the keypair client authenticates with
+
+	ctx  context.Context // canceled by close
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+func (a *announcer) loop() {
+	t := time.NewTicker(a.interval)
+	defer t.Stop()
+	for {
+		if err := a.announce(); err != nil {
+			a.srv.Logger().Warn("discovery announce failed", "registry", a.url, "error", err.Error())
+		}
+		select {
+		case <-t.C:
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+// announce sends one announcement, connecting first if there is no
+// connection or the server's keypair has been rotated since it was made.
+func (a *announcer) announce() error {
+	kp := a.srv.Keypair()
+	if a.client == nil || a.kp != kp {
+		if a.client != nil {
+			a.client.Close()
+			a.client = nil
+		}
+		c, err := velocity.NewClient(a.url, velocity.ClientOptions{Keypair: kp, Logger: a.srv.Logger()})
+		if err != nil {
+			return err
+		}
+		a.client, a.kp = c, kp
+	}
+	ctx, cancel := context.WithTimeout(a.ctx, a.interval)
+	defer cancel()
+	body := Announcement{
+		URL:  a.srv.URL("/"),
+		Role: a.srv.Role(),
+		TTL:  int((3 * a.interval).Seconds()),
+	}
+	return a.client.WriteJSON(ctx, PathPrefix+"/announce", body, nil)
+}
+
+// close stops announcing and withdraws the record.
+func (a *announcer) close() {
+	a.stop()
+	a.wg.Wait()
+	if a.client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), withdrawTimeout)
+	defer cancel()
+	resp, err := a.client.Delete(ctx, PathPrefix+"/announce")
+	if err == nil {
+		err = velocity.ResponseError(resp)
+	}
+	if err != nil {
+		a.srv.Logger().Warn("discovery withdraw failed", "registry", a.url, "error", err.Error())
+	}
+	a.client.Close()
+}
+
+// Lookup asks the discovery node client is connected to for the servers q
+// selects.
+func Lookup(ctx context.Context, client *velocity.Client, q Query) ([]Record, error) {
+	var resp lookupResponse
+	if err := client.ReadJSON(ctx, PathPrefix+"/lookup"+q.encode(), &resp); err != nil {
+		return nil, err
+	}
+	return resp.Peers, nil
+}
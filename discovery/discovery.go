@@ -0,0 +1,279 @@
+// Package discovery is a registry of WEB/1 servers, a DNS-like layer for
+// web:// URLs. A discovery node serves a Registry, to which servers announce
+// their URL and role, and which clients and servers query to find peers by
+// role or node ID prefix:
+//
+//	reg, _ := discovery.NewRegistry(discovery.RegistryOptions{})
+//	reg.Mount(discoverySrv)
+//
+// A server registers itself, and keeps its record fresh, with the Announce
+// option:
+//
+//	srv, err := velocity.New(":6937",
+//	    velocity.WithRole("log_server"),
+//	    discovery.Announce(discoveryURL, time.Minute),
+//	)
+//
+// and anyone looks servers up over a velocity.Client:
+//
+//	logs, err := discovery.Lookup(ctx, client, discovery.Query{Role: "log_server"})
+//
+// Announcements are authenticated by the WEB/1 handshake: a server can only
+// register a URL that names its own node ID.
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usenwep/velocity"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// PathPrefix is the path prefix of the registry routes.
+const PathPrefix = "/discovery"
+
+// Defaults for RegistryOptions.
+const (
+	DefaultMaxTTL     = time.Hour
+	DefaultMaxRecords = 10000
+	DefaultLimit      = 100
+)
+
+// Record is a registered server.
+type Record struct {
+	// NodeID is the server's node ID, in the form of velocity.FormatNodeID.
+	NodeID string `json:"node_id"`
+
+	URL  string `json:"url"`
+	Role string `json:"role,omitempty"`
+
+	// Expires is when the record is dropped unless announced again.
+	Expires time.Time `json:"expires"`
+}
+
+// Announcement is the body a server writes to PathPrefix+"/announce".
+type Announcement struct {
+	// URL is the server's web:// URL. Its node ID must be the announcing
+	// peer's.
+	URL  string `json:"url"`
+	Role string `json:"role,omitempty"`
+
+	// TTL is how many seconds the record lasts, capped at the registry's
+	// MaxTTL. Zero means MaxTTL.
+	TTL int `json:"ttl,omitempty"`
+}
+
+// Query selects records. Empty fields match every record.
+type Query struct {
+	// Role selects records with this role.
+	Role string
+
+	// Prefix selects records whose node ID, in hex, starts with it.
+	Prefix string
+
+	// Limit is the most records returned. Zero means DefaultLimit.
+	Limit int
+}
+
+// match reports whether r satisfies q.
+func (q Query) match(r *Record) bool {
+	return (q.Role == "" || r.Role == q.Role) && strings.HasPrefix(r.NodeID, strings.ToLower(q.Prefix))
+}
+
+// encode returns q as a query string, with the leading "?" if not empty.
+func (q Query) encode() string {
+	v := url.Values{}
+	if q.Role != "" {
+		v.Set("role", q.Role)
+	}
+	if q.Prefix != "" {
+		v.Set("prefix", q.Prefix)
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if len(v) == 0 {
+		return ""
+	}
+	return "?" + v.Encode()
+}
+
+// RegistryOptions configures a Registry.
+type RegistryOptions struct {
+	// MaxTTL caps how long an announcement lasts. Zero means
+	// DefaultMaxTTL.
+	MaxTTL time.Duration
+
+	// MaxRecords bounds the registry; announcements of new servers beyond
+	// it are refused. Zero means DefaultMaxRecords.
+	MaxRecords int
+
+	// Authorize, if set, decides whether peer may register rec, for
+	// example to restrict which roles a peer may claim. An error refuses
+	// the announcement with forbidden.
+	Authorize func(peer nwep.NodeID, rec Record) error
+}
+
+// Registry is an in-memory registry of servers, served by Mount. It is safe
+// for concurrent use.
+type Registry struct {
+	opts RegistryOptions
+
+	mu      sync.Mutex
+	records map[string]*Record // by node ID
+}
+
+// NewRegistry returns an empty registry. This function returns an error if
+// opts has a negative field.
+func NewRegistry(opts RegistryOptions) (*Registry, error) {
+	if opts.MaxTTL < 0 || opts.MaxRecords < 0 {
+		return nil, errors.New("discovery: registry options must not be negative")
+	}
+	if opts.MaxTTL == 0 {
+		opts.MaxTTL = DefaultMaxTTL
+	}
+	if opts.MaxRecords == 0 {
+		opts.MaxRecords = DefaultMaxRecords
+	}
+	return &Registry{opts: opts, records: make(map[string]*Record)}, nil
+}
+
+// Register adds rec, or replaces the record with its node ID, and returns
+// the stored record. A zero rec.Expires is set to MaxTTL from now. Use it for
+// servers configured statically on the discovery node; announcements go
+// through Mount's routes. This function returns an error if rec.URL is not a
+// web:// URL naming rec.NodeID, or if the registry is full.
+func (r *Registry) Register(rec Record) (Record, error) {
+	id, err := velocity.NodeIDFromURL(rec.URL)
+	if err != nil {
+		return Record{}, err
+	}
+	if rec.NodeID == "" {
+		rec.NodeID = velocity.FormatNodeID(id)
+	}
+	if rec.NodeID != velocity.FormatNodeID(id) {
+		return Record{}, fmt.Errorf("discovery: URL %s is not node %s", rec.URL, rec.NodeID)
+	}
+	now := time.Now()
+	if rec.Expires.IsZero() || rec.Expires.Sub(now) > r.opts.MaxTTL {
+		rec.Expires = now.Add(r.opts.MaxTTL)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(now)
+	if _, ok := r.records[rec.NodeID]; !ok && len(r.records) >= r.opts.MaxRecords {
+		return Record{}, errors.New("discovery: registry full")
+	}
+	r.records[rec.NodeID] = &rec
+	return rec, nil
+}
+
+// Remove drops the record of the node with the given ID, in the form of
+// velocity.FormatNodeID.
+func (r *Registry) Remove(nodeID string) {
+	r.mu.Lock()
+	delete(r.records, nodeID)
+	r.mu.Unlock()
+}
+
+// Lookup returns the live records q selects, sorted by node ID.
+func (r *Registry) Lookup(q Query) []Record {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(time.Now())
+	var out []Record
+	for _, rec := range r.records {
+		if q.match(rec) {
+			out = append(out, *rec)
+		}
+	}
+	slices.SortFunc(out, func(a, b Record) int { return strings.Compare(a.NodeID, b.NodeID) })
+	return out[:min(len(out), limit)]
+}
+
+// prune drops expired records. The caller must hold r.mu.
+func (r *Registry) prune(now time.Time) {
+	for id, rec := range r.records {
+		if now.After(rec.Expires) {
+			delete(r.records, id)
+		}
+	}
+}
+
+// Mount registers the registry's routes on srv under PathPrefix and returns
+// their group, to which mw applies:
+//
+//   - write /announce registers the announcing peer from an Announcement
+//     body and responds with its Record;
+//   - delete /announce withdraws the announcing peer's record;
+//   - read /lookup responds with {"peers": [Record, ...]}, selected by the
+//     role, prefix, and limit query parameters.
+//
+// Announcements from unauthenticated peers are refused with unauthorized.
+func (r *Registry) Mount(srv *velocity.Server, mw ...velocity.MiddlewareFunc) *velocity.Group {
+	g := srv.Group(PathPrefix, mw...)
+	g.Write("/announce", func(c *velocity.Context) error {
+		peer := c.PeerNodeID()
+		if peer.IsZero() {
+			return c.Error(velocity.StatusUnauthorized, "authentication required")
+		}
+		var a Announcement
+		if err := c.Bind(&a); err != nil {
+			return velocity.ErrBadRequestf("announcement: %v", err)
+		}
+		if a.TTL < 0 {
+			return velocity.ErrBadRequestf("negative ttl %d", a.TTL)
+		}
+		rec := Record{NodeID: velocity.FormatNodeID(peer), URL: a.URL, Role: a.Role}
+		if a.TTL > 0 {
+			rec.Expires = time.Now().Add(time.Duration(a.TTL) * time.Second)
+		}
+		if r.opts.Authorize != nil {
+			if err := r.opts.Authorize(peer, rec); err != nil {
+				return c.Error(velocity.StatusForbidden, err.Error())
+			}
+		}
+		rec, err := r.Register(rec)
+		if err != nil {
+			return velocity.ErrBadRequestf("%v", err)
+		}
+		return c.JSON(rec)
+	})
+	g.Delete("/announce", func(c *velocity.Context) error {
+		peer := c.PeerNodeID()
+		if peer.IsZero() {
+			return c.Error(velocity.StatusUnauthorized, "authentication required")
+		}
+		r.Remove(velocity.FormatNodeID(peer))
+		return c.NoContent()
+	})
+	g.Read("/lookup", func(c *velocity.Context) error {
+		q := Query{Role: c.QueryParam("role"), Prefix: c.QueryParam("prefix")}
+		if s := c.QueryParam("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return velocity.ErrBadRequestf("invalid limit %q", s)
+			}
+			q.Limit = n
+		}
+		return c.JSON(lookupResponse{Peers: r.Lookup(q)})
+	})
+	return g
+}
+
+// lookupResponse is the body of read /lookup.
+type lookupResponse struct {
+	Peers []Record `json:"peers"`
+}
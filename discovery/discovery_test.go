@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/usenwep/velocity"
+)
+
+// testURL returns the URL of the node whose ID is 31 zero bytes followed by
+// n, at 0.0.0.0: base58 digit "1" encodes a leading zero byte.
+func testURL(n int) string {
+	return "web://" + strings.Repeat("1", 35) + string("123456789ABCDEFGH"[n]) + ":6937/"
+}
+
+func TestRegistry(t *testing.T) {
+	if _, err := NewRegistry(RegistryOptions{MaxTTL: -time.Second}); err == nil {
+		t.Fatal("negative MaxTTL accepted")
+	}
+	r, err := NewRegistry(RegistryOptions{MaxRecords: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := r.Register(Record{URL: testURL(1), Role: "log_server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(a.NodeID, "01") || a.Expires.IsZero() {
+		t.Fatalf("record = %+v", a)
+	}
+	if _, err := r.Register(Record{NodeID: a.NodeID, URL: testURL(2)}); err == nil {
+		t.Fatal("URL of another node accepted")
+	}
+	b, err := r.Register(Record{URL: testURL(2), Role: "anchor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Register(Record{URL: testURL(3)}); err == nil {
+		t.Fatal("registry grew past MaxRecords")
+	}
+
+	if got := r.Lookup(Query{Role: "log_server"}); len(got) != 1 || got[0].NodeID != a.NodeID {
+		t.Fatalf("by role = %v", got)
+	}
+	if got := r.Lookup(Query{Prefix: strings.ToUpper(b.NodeID[:64])}); len(got) != 1 || got[0].NodeID != b.NodeID {
+		t.Fatalf("by prefix = %v", got)
+	}
+	if got := r.Lookup(Query{Limit: 1}); len(got) != 1 || got[0].NodeID != a.NodeID {
+		t.Fatalf("limited = %v", got)
+	}
+
+	// Expired records disappear, making room for new ones.
+	if _, err := r.Register(Record{URL: testURL(2), Expires: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Lookup(Query{}); len(got) != 1 {
+		t.Fatalf("after expiry = %v", got)
+	}
+	if _, err := r.Register(Record{URL: testURL(3)}); err != nil {
+		t.Fatal(err)
+	}
+	r.Remove(a.NodeID)
+	if got := r.Lookup(Query{Role: "log_server"}); len(got) != 0 {
+		t.Fatalf("after Remove = %v", got)
+	}
+}
+
+func TestRegistryRoutes(t *testing.T) {
+	srv, err := velocity.New(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := NewRegistry(RegistryOptions{})
+	r.Mount(srv)
+	r.Register(Record{URL: testURL(1), Role: "log_server"})
+	r.Register(Record{URL: testURL(2), Role: "anchor"})
+
+	call := func(method, path string, body []byte) *velocity.ResponseRecorder {
+		h := srv.Router().Find(path, method, nil)
+		if h == nil {
+			t.Fatalf("no route for %s %s", method, path)
+		}
+		c, rec := velocity.NewTestContext(method, path, body)
+		if err := h(c); err != nil {
+			velocity.DefaultErrorHandler(c, err)
+		}
+		return rec
+	}
+
+	rec := call(velocity.MethodRead, PathPrefix+"/lookup"+Query{Role: "anchor"}.encode(), nil)
+	var resp lookupResponse
+	if err := json.Unmarshal(rec.Body, &resp); err != nil || len(resp.Peers) != 1 || resp.Peers[0].Role != "anchor" {
+		t.Fatalf("lookup = %s %q", rec.Status, rec.Body)
+	}
+	if rec := call(velocity.MethodRead, PathPrefix+"/lookup?limit=x", nil); rec.Status != velocity.StatusBadRequest {
+		t.Fatalf("bad limit = %s", rec.Status)
+	}
+	body, _ := json.Marshal(Announcement{URL: testURL(3)})
+	if rec := call(velocity.MethodWrite, PathPrefix+"/announce", body); rec.Status != velocity.StatusUnauthorized {
+		t.Fatalf("anonymous announce = %s", rec.Status)
+	}
+}
+
+func TestAnnounceOption(t *testing.T) {
+	if _, err := velocity.New(":0", Announce("web://nowhere", time.Minute)); err == nil {
+		t.Fatal("registry URL without node ID accepted")
+	}
+	if _, err := velocity.New(":0", Announce(testURL(1), 0)); err == nil {
+		t.Fatal("zero interval accepted")
+	}
+	if _, err := velocity.New(":0", Announce(testURL(1), time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -20,4 +20,90 @@ var (
 	// down. The caller must ensure the server is running before sending
 	// notifications.
 	ErrServerNotRunning = errors.New("velocity: server not running")
+
+	// ErrNotifyRateLimited is returned by Notify, NotifyWithOptions,
+	// and NotifyJSON when the server is configured with
+	// WithNotifyRateLimit and the target peer has exceeded its rate:
+	// in NotifyDrop mode at once, in NotifyThrottle mode once the
+	// peer's delayed notifications amount to a full burst. The
+	// notification was not sent.
+	ErrNotifyRateLimited = errors.New("velocity: notification rate limited")
+
+	// ErrPeerNotConnected is returned by Server.DisconnectPeer and
+	// Server.TagConn when the server has no connection for the given
+	// peer.
+	ErrPeerNotConnected = errors.New("velocity: peer not connected")
+
+	// ErrMiddlewareOrder is wrapped by the errors returned from
+	// Server.ValidateMiddleware when a middleware chain violates an
+	// ordering rule.
+	ErrMiddlewareOrder = errors.New("velocity: middleware order")
+
+	// ErrRequestCancelled is the cause (see context.Cause) of a request
+	// context cancelled by Server.CancelAll. Handlers can check for it to
+	// tell an emergency cancellation from a timeout.
+	ErrRequestCancelled = errors.New("velocity: request cancelled")
+
+	// ErrNotifyNotAcked is returned by Server.NotifyWithAck when the peer
+	// did not acknowledge the notification within the retry policy.
+	ErrNotifyNotAcked = errors.New("velocity: notification not acknowledged")
+
+	// ErrPeerBusy is returned by Server.TryNotify when the peer's
+	// notification queue depth has reached the high-water mark. The
+	// notification was not sent.
+	ErrPeerBusy = errors.New("velocity: peer busy")
+
+	// ErrPeerDisconnected is the cause (see context.Cause) of a request
+	// context cancelled because the peer's connection closed while the
+	// request was being handled. No response can be delivered.
+	ErrPeerDisconnected = errors.New("velocity: peer disconnected")
+
+	// ErrStreamClosed is returned by PushStream.Write after the stream
+	// was closed with Close or Abort, or by Server.Shutdown.
+	ErrStreamClosed = errors.New("velocity: stream closed")
+
+	// ErrRedirectLoop is returned by FollowRedirects when a chain of
+	// redirects revisits a URL or exceeds the hop limit. The last
+	// redirect response is returned alongside it.
+	ErrRedirectLoop = errors.New("velocity: redirect loop")
+
+	// ErrClientClosed is returned by Client requests after Client.Close.
+	ErrClientClosed = errors.New("velocity: client closed")
+
+	// ErrUnknownPeer is returned by ClientPool requests to a node ID that
+	// was not added to the pool, or has been removed.
+	ErrUnknownPeer = errors.New("velocity: peer not in pool")
+
+	// ErrKeyNotExportable is returned by a KeyProvider whose key cannot
+	// leave its store, such as an HSM, when asked for a keypair. nwep needs
+	// the seed for the handshake, so WithKeyProvider fails with it.
+	ErrKeyNotExportable = errors.New("velocity: key not exportable")
+
+	// ErrNoTrustStore is returned by Server.AddAnchor and
+	// Server.RemoveAnchor when the server was configured without a trust
+	// store (see WithTrust).
+	ErrNoTrustStore = errors.New("velocity: no trust store configured")
+
+	// ErrLogDiverged is returned, wrapped, by LogMirror.Sync once the
+	// upstream log no longer matches the mirrored copy: its entries do not
+	// hash to its root or a checkpoint's, no consistency proof links them,
+	// or its log has shrunk. The mirror copies nothing more.
+	ErrLogDiverged = errors.New("velocity: mirrored log diverged from upstream")
+
+	// ErrNoCheckpointSigner is returned by Server.IssueCheckpoint when the
+	// server has no log and BLS keypair (WithCheckpointSigner) or
+	// no anchor server (WithAnchorServer) to publish through.
+	ErrNoCheckpointSigner = errors.New("velocity: no checkpoint signer")
+
+	// ErrAuditTampered is returned, wrapped, by VerifyAuditChain when a
+	// record does not match its hash or does not follow the record
+	// before it.
+	ErrAuditTampered = errors.New("velocity: audit chain broken")
+
+	// ErrDecline is returned by a handler, typically via Context.Decline,
+	// to pass a request on without responding. In a chain registered
+	// with Router.HandleChain, the next handler is tried; when no handler
+	// is left, or outside a chain, the request is answered by the
+	// not-found handler. ErrDecline is not logged as a handler error.
+	ErrDecline = errors.New("velocity: handler declined request")
 )
@@ -0,0 +1,94 @@
+package eventbus
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/usenwep/velocity"
+)
+
+// BridgeOptions configures a Bridge for events of type E.
+type BridgeOptions[E any] struct {
+	// Topic maps an event to the topic whose subscribers are notified of
+	// it. Nil means the notification's event name, for every event.
+	Topic func(event E) string
+
+	// Event is the event name of the notifications. Empty means the name
+	// of E, such as "OrderCreated", or of the type E points to.
+	Event string
+
+	// Path maps an event to the path of its notification. Nil means "/".
+	Path func(event E) string
+
+	// Encoding is the media type the events are encoded in as notification
+	// bodies: velocity.MIMEJSON, the default, velocity.MIMECBOR,
+	// velocity.MIMEMsgPack, or any media type with a codec registered with
+	// velocity.RegisterCodec. Notifications carry no content type, so
+	// receivers must know the encoding of each event.
+	Encoding string
+
+	// Filter, if set, reports whether an event is forwarded at all.
+	Filter func(event E) bool
+}
+
+// Bridge forwards the events of type E published to b to the peers subscribed
+// to their topic on p, usually a *velocity.Server, as notifications, and
+// returns a function that stops forwarding. Delivery follows Publish on p: on
+// a velocity server it never blocks, and delivery errors are logged rather
+// than returned. The bridge's subscriber fails only if an event cannot be
+// encoded, in which case b.Publish returns the error.
+//
+// Bridge panics if p is nil, if opts.Encoding has no codec, or if E has no
+// name and opts.Event is empty.
+func Bridge[E any](b *Bus, p Publisher, opts BridgeOptions[E]) (stop func()) {
+	if p == nil {
+		panic("eventbus: Bridge: nil publisher")
+	}
+	marshal := encoder(opts.Encoding)
+	name := opts.Event
+	if name == "" {
+		t := reflect.TypeFor[E]()
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if name = t.Name(); name == "" {
+			panic(fmt.Sprintf("eventbus: Bridge: event type %s has no name; set BridgeOptions.Event", t))
+		}
+	}
+	return Subscribe(b, func(ctx context.Context, event E) error {
+		if opts.Filter != nil && !opts.Filter(event) {
+			return nil
+		}
+		body, err := marshal(event)
+		if err != nil {
+			return fmt.Errorf("eventbus: encoding %s: %w", name, err)
+		}
+		topic, path := name, "/"
+		if opts.Topic != nil {
+			topic = opts.Topic(event)
+		}
+		if opts.Path != nil {
+			path = opts.Path(event)
+		}
+		p.Publish(topic, name, path, body)
+		return nil
+	})
+}
+
+// encoder returns the function that encodes events in the media type mt.
+func encoder(mt string) func(v any) ([]byte, error) {
+	switch mt {
+	case velocity.MIMECBOR:
+		return velocity.MarshalCBOR
+	case velocity.MIMEMsgPack:
+		return velocity.MarshalMsgPack
+	case "":
+		mt = velocity.MIMEJSON
+	}
+	codec, ok := velocity.LookupCodec(mt)
+	if !ok {
+		panic(fmt.Sprintf("eventbus: Bridge: no codec registered for %q", mt))
+	}
+	return codec.Marshal
+}
@@ -0,0 +1,127 @@
+// Package eventbus is an in-process event bus for velocity services. Business
+// logic publishes typed events to a Bus without knowing who consumes them:
+//
+//	bus := eventbus.New()
+//	eventbus.Subscribe(bus, func(ctx context.Context, e OrderCreated) error {
+//		return mailer.SendReceipt(ctx, e.Customer, e.ID)
+//	})
+//
+//	srv.Router().Write("/orders", func(c *velocity.Context) error {
+//		order, err := createOrder(c)
+//		if err != nil {
+//			return err
+//		}
+//		return bus.Publish(c.Ctx(), OrderCreated{ID: order.ID, Customer: order.Customer})
+//	})
+//
+// A bridge forwards the events of one type to the peers subscribed to a
+// topic (see velocity.Topics) as notifications, so that handlers need not
+// call the notify API themselves:
+//
+//	eventbus.Bridge(bus, srv, eventbus.BridgeOptions[OrderCreated]{
+//		Topic: func(e OrderCreated) string { return "orders/" + e.Customer },
+//		Path:  func(e OrderCreated) string { return "/orders/" + e.ID },
+//	})
+//
+// Events are dispatched by their exact dynamic type: a subscriber for
+// OrderCreated does not see *OrderCreated, and there is no subscribing to an
+// interface type.
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/usenwep/velocity"
+)
+
+// Bus dispatches published events to the subscribers of their type. Create
+// one with New. All methods are safe for concurrent use.
+type Bus struct {
+	mu     sync.RWMutex
+	nextID uint64
+	subs   map[reflect.Type][]subscriber
+}
+
+// subscriber is one subscription to an event type.
+type subscriber struct {
+	id uint64
+	fn func(ctx context.Context, event any) error
+}
+
+// New returns an empty Bus.
+func New() *Bus {
+	return &Bus{subs: make(map[reflect.Type][]subscriber)}
+}
+
+// Subscribe registers fn for the events of type E published to b, and
+// returns a function that removes the subscription. Subscribers of a type run
+// in the order they subscribed.
+func Subscribe[E any](b *Bus, fn func(ctx context.Context, event E) error) (unsubscribe func()) {
+	if fn == nil {
+		panic("eventbus: Subscribe: nil handler")
+	}
+	return b.subscribe(reflect.TypeFor[E](), func(ctx context.Context, event any) error {
+		return fn(ctx, event.(E))
+	})
+}
+
+// subscribe adds fn to the subscribers of t.
+func (b *Bus) subscribe(t reflect.Type, fn func(ctx context.Context, event any) error) func() {
+	b.mu.Lock()
+	b.nextID++
+	id := b.nextID
+	b.subs[t] = append(b.subs[t], subscriber{id: id, fn: fn})
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			subs := b.subs[t]
+			for i, s := range subs {
+				if s.id == id {
+					// Copy rather than splice, since Publish may be
+					// iterating over the old slice.
+					b.subs[t] = append(subs[:i:i], subs[i+1:]...)
+					break
+				}
+			}
+			if len(b.subs[t]) == 0 {
+				delete(b.subs, t)
+			}
+		})
+	}
+}
+
+// Publish calls every subscriber of event's type with ctx and event, in
+// order, on the calling goroutine, and returns once they have all returned.
+// Every subscriber runs even if an earlier one fails; Publish returns their
+// errors joined, or nil if none failed or event's type has no subscribers.
+// If ctx is already done, Publish calls no subscriber and returns ctx.Err().
+func (b *Bus) Publish(ctx context.Context, event any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.RLock()
+	subs := b.subs[reflect.TypeOf(event)]
+	b.mu.RUnlock()
+	var errs []error
+	for _, s := range subs {
+		if err := s.fn(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Publisher sends a notification to the peers subscribed to a topic and
+// returns how many it was addressed to. *velocity.Server implements it.
+type Publisher interface {
+	Publish(topic, event, path string, body []byte) int
+}
+
+var _ Publisher = (*velocity.Server)(nil)
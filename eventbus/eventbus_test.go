@@ -0,0 +1,107 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/usenwep/velocity"
+)
+
+type orderCreated struct {
+	ID       string `json:"id"`
+	Customer string `json:"customer"`
+}
+
+type published struct {
+	topic, event, path, body string
+}
+
+type recordingPublisher []published
+
+func (r *recordingPublisher) Publish(topic, event, path string, body []byte) int {
+	*r = append(*r, published{topic, event, path, string(body)})
+	return 1
+}
+
+func TestPublish(t *testing.T) {
+	bus := New()
+	var got []string
+	unsub := Subscribe(bus, func(ctx context.Context, e orderCreated) error {
+		got = append(got, "a "+e.ID)
+		return errors.New("a failed")
+	})
+	Subscribe(bus, func(ctx context.Context, e orderCreated) error {
+		got = append(got, "b "+e.ID)
+		return nil
+	})
+	Subscribe(bus, func(ctx context.Context, e *orderCreated) error {
+		got = append(got, "pointer")
+		return nil
+	})
+
+	ctx := context.Background()
+	if err := bus.Publish(ctx, orderCreated{ID: "7"}); err == nil || err.Error() != "a failed" {
+		t.Fatalf("Publish = %v, want the first subscriber's error", err)
+	}
+	if len(got) != 2 || got[0] != "a 7" || got[1] != "b 7" {
+		t.Fatalf("subscribers saw %q", got)
+	}
+
+	unsub()
+	unsub()
+	got = nil
+	if err := bus.Publish(ctx, orderCreated{ID: "8"}); err != nil || len(got) != 1 || got[0] != "b 8" {
+		t.Fatalf("after unsubscribing: Publish = %v, subscribers saw %q", err, got)
+	}
+	if err := bus.Publish(ctx, "unrelated"); err != nil {
+		t.Fatalf("Publish of an event without subscribers = %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	got = nil
+	if err := bus.Publish(cancelled, orderCreated{}); !errors.Is(err, context.Canceled) || len(got) != 0 {
+		t.Fatalf("Publish with a cancelled context = %v, subscribers saw %q", err, got)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	bus := New()
+	var pub recordingPublisher
+	stop := Bridge(bus, &pub, BridgeOptions[orderCreated]{
+		Topic:  func(e orderCreated) string { return "orders/" + e.Customer },
+		Path:   func(e orderCreated) string { return "/orders/" + e.ID },
+		Filter: func(e orderCreated) bool { return e.Customer != "" },
+	})
+	ctx := context.Background()
+	if err := bus.Publish(ctx, orderCreated{ID: "7", Customer: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish(ctx, orderCreated{ID: "8"})
+	want := published{"orders/acme", "orderCreated", "/orders/7", `{"id":"7","customer":"acme"}`}
+	if len(pub) != 1 || pub[0] != want {
+		t.Fatalf("published %+v, want %+v", pub, want)
+	}
+	stop()
+	bus.Publish(ctx, orderCreated{ID: "9", Customer: "acme"})
+	if len(pub) != 1 {
+		t.Fatalf("published after stop: %+v", pub[1:])
+	}
+
+	pub = nil
+	Bridge(bus, &pub, BridgeOptions[*orderCreated]{Event: "order.created", Encoding: velocity.MIMECBOR})
+	bus.Publish(ctx, &orderCreated{ID: "7"})
+	cbor, _ := velocity.MarshalCBOR(&orderCreated{ID: "7"})
+	want = published{"order.created", "order.created", "/", string(cbor)}
+	if len(pub) != 1 || pub[0] != want {
+		t.Fatalf("published %+v, want %+v", pub, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Bridge accepted an encoding without a codec")
+		}
+	}()
+	Bridge(bus, &pub, BridgeOptions[orderCreated]{Encoding: "application/x-unknown"})
+}
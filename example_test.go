@@ -1,7 +1,18 @@
 package velocity_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
 	"github.com/usenwep/velocity"
+	"github.com/usenwep/velocity/eventbus"
+	"github.com/usenwep/velocity/httpgw"
+	"github.com/usenwep/velocity/rpc"
 
 	nwep "github.com/usenwep/nwep-go"
 )
@@ -15,15 +26,31 @@ var _ = func() {
 		velocity.WithLogger(velocity.DefaultLogger()),
 		velocity.OnStart(func(s *velocity.Server) {}),
 		velocity.OnShutdown(func(s *velocity.Server) {}),
+		velocity.WithErrorHandler(velocity.DefaultErrorHandler),
+		velocity.WithTimeout(5*time.Second),
+		velocity.WithJSONErrors(),
+		velocity.WithMaxBodySize(8<<20),
+		velocity.WithStreamingUploads(),
+		velocity.WithSessionStore(&velocity.MemorySessionStore{}),
+		velocity.WithNotifyQueue(velocity.QueueConfig{MaxPerPeer: 100, TTL: time.Minute, Storage: &velocity.MemoryQueue{}}),
 	)
 
 	srv.Use(velocity.Recover(), velocity.RequestLogger())
+	srv.WarmPool(64)
+	_ = srv.Router().HasRoute("/hello")
+	srv.Router().HandleChain("/chain",
+		func(c *velocity.Context) error { return c.Decline() },
+		func(c *velocity.Context) error { return c.NoContent() },
+	)
+	_ = srv.Router().HasMethodRoute(velocity.MethodRead, "/hello")
+	srv.Features().Set("beta", true)
 
 	srv.Handle("/hello", func(c *velocity.Context) error {
 		return c.OK([]byte("hello from velocity"))
 	})
 
 	api := srv.Group("/api/v1")
+	api.SetContentType("application/json")
 	api.Read("/items", func(c *velocity.Context) error {
 		return c.JSON(map[string]string{"status": "ok"})
 	})
@@ -34,22 +61,74 @@ var _ = func() {
 		}
 		return c.Created(nil)
 	})
+	api.Write("/jobs", func(c *velocity.Context) error {
+		var body map[string]any
+		if err := c.Bind(&body); err != nil {
+			return c.JSONStatus(velocity.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		c.SetStatus(velocity.StatusAccepted)
+		return c.JSON(map[string]string{"status": "queued"})
+	})
+
+	api.Read("/typed", velocity.Typed(func(c *velocity.Context) (map[string]int, error) {
+		return map[string]int{"n": 1}, nil
+	}))
+	api.Read("/missing", func(c *velocity.Context) error {
+		if c.Param("id") == "" {
+			return velocity.ErrBadRequest("id required").WithDetails(map[string]string{"field": "id"})
+		}
+		return velocity.ErrNotFoundf("no item %s", c.Param("id"))
+	})
+	_ = velocity.NewError(velocity.StatusConflict, "exists")
+	_ = velocity.Errorf(velocity.StatusForbidden, "peer %s", "x")
+	_ = velocity.ErrForbidden("nope").Payload()
 
 	srv.Handle("/echo", func(c *velocity.Context) error {
 		_ = c.Method()
 		_ = c.Path()
+		_ = c.RoutePattern()
+		_ = c.Param("id")
+		_ = c.QueryParam("page")
+		_ = c.QueryParams()
+		_ = c.Ctx()
+		_ = c.Context()
+		c.WithContext(c.Ctx())
+		_ = c.Feature("beta")
+		_ = c.ErrorJSON(velocity.StatusBadRequest, "invalid", nil)
+		_ = c.Redirect(srv.URL("/echo"))
+		var p struct {
+			ID int `param:"id"`
+		}
+		_ = c.BindParams(&p)
+		c.RangeHeaders(func(name, value string) bool { return true })
+		c.RangeResponseHeaders(func(name, value string) bool { return true })
 		_ = c.Body()
+		_ = c.NDJSON(func(raw json.RawMessage) error { return nil })
 		_ = c.RequestID()
 		_ = c.TraceID()
 		_ = c.PeerNodeID()
+		_ = c.PeerNodeIDString()
 		_ = c.Conn()
+		_ = c.ConnectedAt()
+		_, _ = c.HandshakeDuration()
+		_, _ = c.ConnSettings()
 		c.Set("key", "value")
 		_ = c.MustGet("key")
 		_ = c.Logger()
 		_ = c.Server()
+		_ = c.CloseSend()
+		_ = c.CloseRecv()
+		_ = c.Committed()
+		_ = c.Notify(c.PeerNodeID(), "echo", "/echo", nil)
+		c.NotifyAll("echo", "/echo", nil)
 		return c.NoContent()
 	})
 
+	srv.AddMiddlewareRule(velocity.MiddlewareBefore("velocity.RequirePeer", "velocity.RequestLogger"))
+	_ = srv.ValidateMiddleware()
+	_ = velocity.MiddlewareName(velocity.Recover())
+	_ = velocity.WithMiddlewareValidation(true)
+
 	_ = srv.Router()
 	_ = srv.NodeID()
 
@@ -59,23 +138,471 @@ var _ = func() {
 	_ = srv.Notify(peer, "update", "/data", []byte("{}"))
 	_ = srv.NotifyJSON(peer, "update", "/data", map[string]string{"a": "b"})
 	srv.NotifyAll("update", "/data", nil)
+	_ = srv.NotifyWithAck(peer, "update", "/data", nil, time.Second)
+	_ = velocity.AckPath("id")
+	_, _ = velocity.NotifyID(nil)
+	_ = velocity.WithNotifyRetry(velocity.DefaultRetryPolicy)
+	_ = srv.NotifyPeers([]nwep.NodeID{peer}, "update", "/data", nil)
+	if info, ok := srv.ConnInfo(peer); ok {
+		_, _, _, _ = info.RemoteAddr, info.BytesIn, info.Streams, info.Role
+	}
+	_ = srv.ConnInfos()
+	if st, err := srv.OpenStream(peer, "/logs", nil); err == nil {
+		_, _ = st.Write([]byte("line\n"))
+		<-st.Done()
+		_, _ = st.Peer(), st.Path()
+		st.Abort(1)
+		_ = st.Close()
+	}
+	srv.Handle("/follow", func(c *velocity.Context) error {
+		_, err := c.OpenStream("/logs", []nwep.Header{{Name: "content-type", Value: "text/plain"}})
+		return err
+	})
+	srv.Handle("/subscribe", srv.Topics().Handler())
+	srv.Topics().Subscribe(peer, "prices")
+	_ = srv.Topics().Subscribers("prices")
+	_ = srv.Publish("prices", "tick", "/prices", nil)
+	_, _ = srv.PublishJSON("prices", "tick", "/prices", map[string]int{"btc": 1})
 	_ = srv.NotifyAllJSON("update", "/data", nil)
+	_ = srv.NotifyError(peer, "job.failed", "/jobs/7", velocity.StatusInternalError, "worker crashed")
+	if p, err := velocity.ParseErrorPayload(nil); err == nil {
+		_ = p.Error()
+	}
+	_ = srv.CancelAll()
+	_ = srv.InFlight()
+	srv.Drain()
+	_ = srv.Draining()
+	srv.Resume()
+	_ = velocity.WithDrainResponse(velocity.StatusUnavailable, "draining")
+	_, _ = velocity.RedirectTarget(nil)
+	_, _ = velocity.FollowRedirects("web://example/", 3, func(url string) (*nwep.Response, error) { return nil, nil })
+	_ = srv.PoolStats()
 	_ = srv.ConnectionCount()
 	_ = srv.ConnectedPeers()
+	_ = srv.NotifyStats()
+	_ = srv.DisconnectPeer(peer, time.Second)
+	_ = velocity.WithNotifyRateLimit(10, 20)
+	_ = velocity.WithNotifyLimitMode(velocity.NotifyDrop)
+	_ = velocity.WithNotifyCorrelation()
+	_, _ = velocity.CorrelationID(nil)
+	srv.SetNotifyTransform(func(event, path string, body []byte) []byte { return body })
 
 	_ = velocity.RequirePeer()
 	_ = velocity.AllowPeers(peer)
+	if id, err := velocity.NodeIDFromString(velocity.FormatNodeID(peer)); err == nil {
+		_ = velocity.AllowPeers(id)
+	}
 	_ = velocity.MethodFilter(velocity.MethodRead, velocity.MethodWrite)
+	_ = velocity.RequireHeaders("idempotency-key")
+	_ = velocity.ContentType("text/plain")
+	_ = velocity.Timeout(time.Minute)
+	_ = velocity.SerializePerPeer(16)
+	_ = velocity.BodyLimit(64 << 10)
+	_ = velocity.Idempotency(velocity.IdempotencyOptions{TTL: velocity.DefaultIdempotencyTTL, UseRequestID: true})
+	_, _ = velocity.HeaderIdempotencyKey, velocity.HeaderIdempotentReplay
+	_ = velocity.RateLimit(10, 20,
+		velocity.RateLimitKey(velocity.KeyByPath),
+		velocity.RateLimitKey(velocity.KeyGlobal),
+		velocity.RateLimitKey(velocity.KeyByPeer),
+		velocity.RateLimitMaxKeys(1000),
+	)
+	srv.Use(velocity.Metrics())
+	srv.Handle("/metrics", velocity.MetricsHandler())
+	for _, st := range srv.RouteStats() {
+		_, _, _ = st.Route, st.P99, st.ByStatus
+	}
+	if client, err := velocity.NewClient(srv.URL("/"), velocity.ClientOptions{Timeout: time.Second}); err == nil {
+		ctx := context.Background()
+		_, _ = client.Read(ctx, "/health", nwep.Header{Name: "accept", Value: velocity.MIMEJSON})
+		_, _ = client.Write(ctx, "/items", nil)
+		_, _ = client.Update(ctx, "/items/1", nil)
+		_, _ = client.Delete(ctx, "/items/1")
+		var out map[string]any
+		_ = client.ReadJSON(ctx, "/items/1", &out)
+		_ = client.WriteJSON(ctx, "/items", out, nil)
+		if resp, err := client.Do(ctx, velocity.MethodRead, "/", nil); err == nil {
+			_ = velocity.ResponseError(resp)
+		}
+		client.OnNotify("update", "/items/:id", func(n *velocity.Notification) { _, _, _ = n.Event, n.Body, n.Param("id") })
+		client.Close()
+	}
+	if pool, err := velocity.NewClientPool(velocity.ClientPoolOptions{HealthCheckPath: "/health"}); err == nil {
+		if id, err := pool.Add(srv.URL("/")); err == nil {
+			_, _ = pool.Do(context.Background(), id, velocity.MethodRead, "/stats", nil)
+			_, _ = pool.Client(context.Background(), id)
+			if h, ok := pool.Health(id); ok {
+				_, _, _ = h.Healthy, h.Connected, h.Err
+			}
+			pool.Remove(id)
+		}
+		for _, r := range pool.DoAll(context.Background(), velocity.MethodRead, "/stats", nil) {
+			_, _, _ = r.Peer, r.Response, r.Err
+		}
+		_, _ = pool.Peers(), pool.HealthyPeers()
+		pool.Close()
+	}
+	_, _ = velocity.NodeIDFromURL(srv.URL("/"))
+	_ = rpc.Register(srv, "echo", struct{}{})
+	if client, err := velocity.NewClient(srv.URL("/"), velocity.ClientOptions{}); err == nil {
+		var out string
+		_ = rpc.NewClient(client, "echo").Call(context.Background(), "Echo", "hi", &out)
+		if c, err := rpc.NewClient(client, "echo").WithContentType(velocity.MIMEJSON); err == nil {
+			_ = c.Call(context.Background(), "Echo", "hi", nil)
+		}
+	}
+	_, _ = rpc.RequestContext(context.Background())
+	bus := eventbus.New()
+	stopBridge := eventbus.Bridge(bus, srv, eventbus.BridgeOptions[velocity.Notification]{
+		Topic:    func(n velocity.Notification) string { return n.Event },
+		Encoding: velocity.MIMECBOR,
+	})
+	eventbus.Subscribe(bus, func(ctx context.Context, n velocity.Notification) error { return nil })()
+	_ = bus.Publish(context.Background(), velocity.Notification{Event: "update"})
+	stopBridge()
+	_, _ = velocity.MarshalCBOR(nil)
+	_, _ = velocity.MarshalMsgPack(nil)
+	_ = rpc.Path("echo", "Echo")
+	var mux velocity.NotifyMux
+	mux.On("update", "/orders/*", func(n *velocity.Notification) {})
+	mux.SetNotFound(func(n *velocity.Notification) {})
+	_ = nwep.WithOnNotify(mux.Dispatch)
+	_ = velocity.WithAPISpec("orders", "1.0.0")
+	srv.Router().Write("/orders", nil, velocity.WithSchema(struct{ Item string }{}, nil, "Place an order"))
+	_, _ = srv.APISpec()
+	for _, r := range srv.Router().Routes() {
+		_, _, _ = r.Method, r.Pattern, r.Prefix
+	}
+	velocity.Debug(srv, velocity.DebugOptions{AllowPeers: []nwep.NodeID{peer}}).Read("/extra", nil)
+	_ = velocity.OncePerConnection(velocity.RequestLogger())
+	_ = velocity.RequestLoggerWith(velocity.RequestLoggerOptions{Compression: true})
+	_ = velocity.AccessLog(velocity.AccessLogConfig{
+		Format:     velocity.AccessLogCommon,
+		Output:     nil,
+		Logger:     velocity.DefaultLogger(),
+		SampleRate: 0.5,
+		Fields: []string{velocity.AccessFieldTime, velocity.AccessFieldMethod, velocity.AccessFieldPath,
+			velocity.AccessFieldRoute, velocity.AccessFieldStatus, velocity.AccessFieldBytesIn,
+			velocity.AccessFieldBytesOut, velocity.AccessFieldDuration, velocity.AccessFieldPeer,
+			velocity.AccessFieldRequestID, velocity.AccessFieldCompression, velocity.AccessFieldError},
+	})
+	_ = velocity.Unless(velocity.RequirePeer(), velocity.SkipRoutes("/health"))
+	_ = velocity.RateLimitSkipper(func(c *velocity.Context) bool { return c.RoutePattern() == "/metrics" })
+	_ = velocity.RequestLoggerWith(velocity.RequestLoggerOptions{Skipper: velocity.SkipRoutes("/health")})
+	_ = velocity.When(velocity.MatchAll(velocity.MatchMethods(velocity.MethodWrite), velocity.MatchPath("/admin/**")), velocity.BodyLimit(1<<20))
+	_ = velocity.Unless(velocity.Metrics(), velocity.Skipper(velocity.MatchPath("/health")))
+	_ = velocity.AccessLogJSON
+	_ = velocity.BufferResponse()
+	_ = func(c *velocity.Context) {
+		if b := c.ResponseBuffer(); b != nil && b.Responded() && !b.Streaming() {
+			_, _ = b.Header("etag")
+			b.DelHeader("x-debug")
+			b.SetBody(b.Body())
+			_ = b.Status()
+		}
+	}
+	_ = velocity.BodyDump(func(c *velocity.Context, req, resp []byte) {})
+	_ = velocity.BodyDumpWith(nil, velocity.BodyDumpOptions{
+		MaxBytes: velocity.DefaultBodyDumpMax,
+		Redact:   func(c *velocity.Context, req, resp []byte) ([]byte, []byte) { return req, resp },
+	})
+	_ = velocity.RecoverWith(velocity.RecoverConfig{
+		StackSize: velocity.DefaultRecoverStackSize,
+		LogStack:  true,
+		OnPanic:   func(c *velocity.Context, recovered any, stack []byte) {},
+		Response:  func(c *velocity.Context) (string, []byte) { return velocity.StatusInternalError, nil },
+	})
+	_ = velocity.RecoverWithResponse(func(c *velocity.Context) (string, []byte) {
+		return velocity.StatusInternalError, nil
+	})
 
 	_ = velocity.StatusOK
 	_ = velocity.StatusNotFound
 	_ = velocity.MethodRead
 
+	if c, rec := velocity.NewTestContext(velocity.MethodRead, "/hello", nil); c != nil {
+		_, _ = rec.Header("content-type")
+	}
+	var w velocity.ResponseWriter
+	_ = velocity.NewContext(w, &nwep.Request{Method: velocity.MethodRead, Path: "/"}, srv)
+	srv.ServeWEB(w, &nwep.Request{Method: velocity.MethodRead, Path: "/"})
+	_ = velocity.HTTPStatus(velocity.StatusNotFound)
+	_, _ = velocity.MethodFromHTTP("GET")
+	_, _ = velocity.HTTPMethod(velocity.MethodRead)
+	_ = velocity.StatusFromHTTP(404)
+	if backend, err := url.Parse("http://127.0.0.1:8080/api"); err == nil {
+		srv.Router().HandlePrefix("/api/", velocity.ReverseProxy(backend))
+	}
+	_ = httpgw.New(srv)
+	srv.Handle("/users", func(c *velocity.Context) error {
+		var req struct {
+			Name string `json:"name" validate:"required,max=64"`
+		}
+		if err := c.BindAndValidate(&req); err != nil {
+			var ve *velocity.ValidationError
+			if errors.As(err, &ve) {
+				_ = []velocity.FieldError(ve.Fields)
+			}
+			return err
+		}
+		return velocity.Validate(&req)
+	})
+	if codec, ok := velocity.LookupCodec(velocity.MIMEJSON); ok {
+		velocity.RegisterCodec("application/vnd.example+json", codec)
+	}
+	srv.Handle("/session", func(c *velocity.Context) error {
+		sess := c.Session()
+		sess.Set("visits", 1)
+		_, _ = sess.Get("visits")
+		sess.Delete("visits")
+		_, _, _ = sess.Keys(), sess.Values(), sess.Peer()
+		sess.Clear()
+		if other, ok := c.Server().Session(c.PeerNodeID()); ok {
+			_ = other
+		}
+		return nil
+	})
+	var _ velocity.SessionStore = &velocity.MemorySessionStore{}
+	srv.Handle("/cbor", func(c *velocity.Context) error { return c.CBOR(map[string]int{"n": 1}) })
+	srv.Handle("/msgpack", func(c *velocity.Context) error { return c.MsgPack([]string{velocity.MIMECBOR, velocity.MIMEMsgPack}) })
+	srv.Handle("/render", func(c *velocity.Context) error {
+		return c.Render(velocity.StatusOK, map[string]string{"hello": "world"})
+	})
+	srv.Handle("/upload", func(c *velocity.Context) error {
+		_, err := io.Copy(io.Discard, c.BodyReader())
+		return err
+	})
+	srv.Handle("/export", func(c *velocity.Context) error {
+		return c.Stream(func(w io.Writer) error { _, err := io.WriteString(w, "row\n"); return err })
+	})
+	srv.Router().HandlePrefix("/assets/", velocity.Static("/assets/", "./public"))
+	srv.Handle("/robots.txt", func(c *velocity.Context) error { return c.File("./public/robots.txt") })
+	srv.Handle("/logo.png", func(c *velocity.Context) error { return c.FileFromFS(os.DirFS("./public"), "logo.png") })
+	if gw, err := httpgw.Dial(srv.URL("/"), nil); err == nil {
+		gw.Close()
+	}
+
+	_ = velocity.RequireVerified()
+	_ = velocity.RequireTrust(velocity.TrustPolicy{AllowedRoles: []string{"anchor"}})
+	_ = velocity.TrustPolicy{MaxAge: time.Hour, RequiredLogIndex: 1}
+
+	_ = srv.TrustStore()
+	_ = srv.Anchors()
+	_ = srv.AddAnchor(nwep.BLSPubkey{})
+	_ = srv.RemoveAnchor(nwep.BLSPubkey{})
+	_ = velocity.AnchorAdmin(srv, velocity.AnchorAdminOptions{AllowPeers: []nwep.NodeID{{}}})
+
+	if syncer, err := velocity.NewCheckpointSyncer(srv, velocity.CheckpointSyncOptions{
+		AnchorURLs:   []string{"web://..."},
+		Interval:     velocity.DefaultCheckpointSyncInterval,
+		Jitter:       time.Minute,
+		OnCheckpoint: func(url string, cp *nwep.Checkpoint) {},
+		OnError:      func(url string, err error) {},
+	}); err == nil {
+		_ = syncer.Sync(context.Background())
+		_, _, _ = syncer.Status()
+		syncer.Close()
+	}
+
+	srv.Use(velocity.RejectRevoked())
+	_ = srv.Revoke(nwep.NodeID{})
+	srv.Unrevoke(nwep.NodeID{})
+	_ = srv.IsRevoked(nwep.NodeID{})
+	_ = srv.Revoked()
+	_ = velocity.WithRevocationSource(velocity.RevocationFile("revoked"), velocity.DefaultRevocationInterval)
+	_ = velocity.WithRevocationSource(velocity.RevocationSourceFunc(func(context.Context) ([]nwep.NodeID, error) { return nil, nil }), 0)
+	if lc, err := velocity.NewClient("web://...", velocity.ClientOptions{}); err == nil {
+		_ = velocity.LogRevocations(lc)
+	}
+
+	policy := velocity.NewRolePolicy(map[nwep.NodeID][]string{{}: {"admin"}})
+	policy.SetRoles(nwep.NodeID{}, "admin", "deploy")
+	policy.SetDefaultRoles("reader")
+	policy.Replace(nil, nil)
+	_ = velocity.WithPolicyStore(policy)
+	if fp, err := velocity.LoadPolicyFile("roles"); err == nil {
+		_ = fp.Reload()
+		_ = fp.Err()
+		srv.SetPolicyStore(fp)
+	}
+	srv.SetPolicyStore(velocity.PolicyStoreFunc(func(context.Context, nwep.NodeID, *nwep.VerifiedIdentity) ([]string, error) { return nil, nil }))
+	_ = srv.PolicyStore()
+	srv.Group("/admin", velocity.RequireRole("admin"))
+	srv.Handle("/whoami", func(c *velocity.Context) error {
+		roles, err := velocity.PeerRoles(c)
+		if err != nil {
+			return err
+		}
+		return c.JSON(roles)
+	})
+
+	if storage, err := velocity.OpenFileLogStorage("log", true); err == nil {
+		if logSvc, err := velocity.NewLogService(storage, velocity.LogServiceOptions{
+			Prefix:    velocity.DefaultLogServicePrefix,
+			MaxRange:  velocity.DefaultLogRangeLimit,
+			Authorize: func(nwep.NodeID, *nwep.MerkleEntry) error { return nil },
+		}); err == nil {
+			logSvc.Mount(srv)
+			_, _ = logSvc.Append(&nwep.MerkleEntry{})
+			_, _ = logSvc.Entry(0)
+			_ = logSvc.Size()
+			root, _ := logSvc.Root(1)
+			path, _ := logSvc.InclusionProof(0, 1)
+			_ = velocity.VerifyInclusion(velocity.MerkleLeafHash(nil), 0, 1, path, root)
+			proof, _ := logSvc.ConsistencyProof(1, 1)
+			_ = velocity.VerifyConsistency(1, 1, root, root, proof)
+			_ = logSvc.MerkleLog()
+			_ = []any{velocity.LogTreeHead{}, velocity.LogRange{}, velocity.LogInclusionProof{}, velocity.LogConsistencyProof{}}
+			logSvc.Close()
+		}
+		storage.Close()
+	}
+	_, _ = velocity.OpenSQLLogStorage(nil, "log_entries")
+	if mirror, err := velocity.NewLogMirror(srv, nil, nil, velocity.LogMirrorOptions{
+		Interval:    velocity.DefaultLogMirrorInterval,
+		MaxPerSync:  velocity.DefaultLogMirrorBatch,
+		Checkpoints: nil,
+		OnError:     func(error) {},
+	}); err == nil {
+		_ = mirror.Sync(context.Background())
+		st := mirror.Stats()
+		_, _, _ = st.Lag, st.LagTime, st.Verified
+		mirror.Close()
+	}
+	if syncer, err := velocity.NewCheckpointSyncer(srv, velocity.CheckpointSyncOptions{}); err == nil {
+		_ = syncer.Latest()
+	}
+	if w, err := velocity.NewWitness(srv, velocity.WitnessOptions{
+		AnchorURLs:    []string{"web://anchor"},
+		Prefix:        velocity.DefaultWitnessPrefix,
+		Verify:        func(*nwep.Checkpoint) error { return nil },
+		OnDiscrepancy: func(d velocity.WitnessDiscrepancy) { _, _, _ = d.Kind, d.Anchor, d.Detail },
+	}); err == nil {
+		w.Poll(context.Background())
+		st := w.Status()
+		_, _, _ = st.Consistent, st.Anchors, st.CoSignedEpoch
+		_ = w.CoSigned()
+		_ = w.Mount()
+		_ = []string{velocity.DiscrepancyRollback, velocity.DiscrepancyShrink, velocity.DiscrepancyFork, velocity.DiscrepancyInvalid}
+		w.Close()
+	}
+
+	_, _ = velocity.New(":0",
+		velocity.WithCheckpointSigner((*velocity.LogService)(nil), (*nwep.BLSKeypair)(nil)),
+		velocity.WithCheckpointState("checkpoint.json"),
+		velocity.WithCheckpointInterval(time.Minute),
+	)
+	if cp, err := srv.IssueCheckpoint(); err == nil {
+		_ = cp.Epoch
+	}
+	_ = srv.LatestCheckpoint()
+	if g, err := velocity.NewGossip(srv, velocity.GossipOptions{
+		Seeds:        []string{"web://seed"},
+		Interval:     velocity.DefaultGossipInterval,
+		Fanout:       velocity.DefaultGossipFanout,
+		MaxPeers:     velocity.DefaultGossipMaxPeers,
+		TTL:          velocity.DefaultGossipTTL,
+		OnPeer:       func(p velocity.GossipPeer) { _, _, _ = p.NodeID, p.URL, p.Issued },
+		OnCheckpoint: func(*nwep.Checkpoint) {},
+	}); err == nil {
+		g.Round(context.Background())
+		_ = g.AddCheckpoint(nil)
+		_, _ = g.Peers(), g.Checkpoint()
+		_ = velocity.GossipMessage{ID: "", TTL: 0, Peers: nil, Checkpoint: nil}
+		_ = velocity.GossipPath
+		g.Close()
+	}
+	_, _, _ = srv.Keypair(), srv.Role(), srv.Logger()
+	_ = velocity.Health(srv, velocity.HealthOptions{Prefix: "", Timeout: velocity.DefaultHealthTimeout})
+	srv.AddReadinessCheck("db", velocity.PingCheck(nil))
+	srv.AddReadinessCheck("trust", velocity.CheckpointCheck(nil, time.Hour))
+	srv.AddReadinessCheck("log", velocity.LogMirrorCheck(nil, 1000))
+	srv.RemoveReadinessCheck("db")
+	if r := srv.CheckReadiness(context.Background(), time.Second); r.Status == velocity.HealthOK || r.Status == velocity.HealthFail {
+		_ = r.Checks["trust"].Duration
+	}
+	_ = velocity.WithHandlerPool(64)
+	_ = velocity.WithMaxConcurrentPerPeer(32)
+	_ = velocity.WithMaxInFlight(256)
+	_ = velocity.WithQueue(1024, 2*time.Second)
+	_ = velocity.WithPriority(velocity.PriorityFromHeader("priority"))
+	_ = []velocity.Priority{velocity.PriorityLow, velocity.PriorityNormal, velocity.PriorityHigh}
+	removeConnect := srv.OnConnect(func(c *nwep.Conn) {})
+	removeConnect()
+	srv.OnDisconnect(func(c *nwep.Conn, code int) {})()
+	_ = srv.TagConn(peer, "tenant", "acme")
+	srv.UntagConn(peer, "tenant")
+	_ = srv.ConnTags(peer)
+	_ = srv.PeersWithTag("tenant", "acme")
+	_ = velocity.WithTenantResolver(velocity.RequireTenant(velocity.TenantFromHeader("x-tenant")))
+	_ = velocity.WithMetricsTenants("acme", "globex")
+	_ = velocity.MetricsOtherTenant
+	_ = velocity.TenantFromParam("tenant")
+	_ = velocity.TenantFromPeer(func(id nwep.NodeID) (string, bool) { return "acme", true })
+	_ = velocity.RateLimitKey(velocity.KeyByTenant)
+	_ = srv.NotifyTenant("acme", "update", "/plans", nil)
+	_ = velocity.TenantTag
+	srv.Mount("api.example", velocity.NewRouter())
+	_ = srv.Mounted("api.example")
+	_ = velocity.HostHeader
+	srv.Router().Mount("/billing", velocity.NewRouter(), velocity.RequirePeer())
+	_ = func(c *velocity.Context) string { return c.Tenant() }
+	_ = velocity.WithAudit(velocity.AuditOptions{Sinks: []velocity.AuditSink{velocity.AuditWriter(io.Discard)}})
+	_ = srv.Audit("rotate-key", "keypair", velocity.AuditSuccess)
+	_ = func(c *velocity.Context) error {
+		return c.Audit("delete", "/users/7", velocity.AuditDenied, "role", "reader")
+	}
+	_ = velocity.AuditSinkFunc(func(rec *velocity.AuditRecord) error { return nil })
+	_ = velocity.VerifyAuditChain(nil)
+	_, _ = velocity.LastAuditRecord(os.Stdin)
+	_ = velocity.AuditOptions{Resume: &velocity.AuditRecord{}}
+	_ = velocity.LogEntryAudit
+	_ = velocity.WithNotifyObserver(func(e velocity.NotifyEvent) {
+		_ = e.Outcome == velocity.NotifySent || e.Outcome == velocity.NotifyFailed || e.Outcome == velocity.NotifyQueued ||
+			e.Outcome == velocity.NotifyRateLimited || e.Outcome == velocity.NotifyDropped
+	})
+	_ = velocity.WithNotifyBatching(5*time.Millisecond, 64)
+	_ = srv.NotifyBatch(peer, []velocity.Notification{{Event: "update", Path: "/orders/7"}})
+	_, _ = velocity.DecodeNotifyBatch(velocity.EncodeNotifyBatch(nil))
+	_ = velocity.NotifyBatchEvent
+	_ = velocity.WithNotifyHighWater(velocity.DefaultNotifyHighWater)
+	_ = srv.TryNotify(peer, "progress", "/jobs/7", nil)
+	_ = srv.NotifyQueueDepth(peer)
 	tc := &velocity.TrustConfig{}
 	_, _ = tc.Build()
 
 	cfg := velocity.DefaultConfig()
-	_ = cfg
+	cfg.Compression = "zstd,gzip"
+	_ = cfg.Validate()
+	_ = velocity.CompressionAlgorithms
+	cfg.RequestTimeout, cfg.MaxBodySize, cfg.TrustAnchors = time.Second, 1<<20, nil
+	cfg.Recover, cfg.RequestLogger, cfg.JSONErrors = true, true, false
+	_ = cfg.LoadEnv()
+	if loaded, err := velocity.LoadConfig("velocity.yaml"); err == nil {
+		_ = velocity.WithConfig(loaded)
+	}
+	_ = velocity.ConfigEnvPrefix
+	cfg.AdditionalAddrs = []string{":6938"}
+	_ = velocity.WithAdditionalAddr(":6938")
+	_ = velocity.WithAdvertisedAddr("203.0.113.7", 6937)
+	_ = velocity.OnKeyRotate(func(s *velocity.Server, r *velocity.KeyRotation) error {
+		_, _, _, _ = r.Old, r.New, r.OldID, r.NewID
+		return velocity.AppendKeyBinding(nil)(s, r)
+	})
+	_ = velocity.OnKeyRotate(velocity.PostKeyBinding(nil))
+	if kp, err := nwep.GenerateKeypair(); err == nil {
+		_, _ = velocity.KeyBindingEntry(kp)
+		_ = srv.RotateKeypair(kp)
+	}
+	_ = velocity.WithKeyProvider(velocity.LocalKeyProvider{File: "server.key", Env: "SERVER_KEY"})
+	_ = velocity.WithKeyProvider(velocity.KeyProviderFunc(func(ctx context.Context) (*nwep.Keypair, error) {
+		return nil, velocity.ErrKeyNotExportable
+	}))
+	if kp, err := nwep.GenerateKeypair(); err == nil {
+		var signer velocity.Signer = velocity.KeypairSigner(kp)
+		_, _ = velocity.SignKeyBinding(signer)
+	}
+	cfg.AdvertisedAddr = "[2001:db8::7]:6937"
+	_, _ = srv.Addrs(), srv.URLs("/")
 
 	// compile check for log and anchor
 	_ = velocity.WithLogServer(nil)
@@ -84,5 +611,7 @@ var _ = func() {
 	_ = srv.AnchorServer()
 
 	// lifecycle
+	_ = velocity.WithSignals(os.Interrupt)
+	_ = srv.RunContext(context.Background())
 	_ = srv
 }
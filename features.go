@@ -0,0 +1,89 @@
+package velocity
+
+import (
+	"sync"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// FeatureFlags is a set of named boolean flags that can be flipped at
+// runtime, for rolling out handler behavior gradually without redeploying. A
+// flag can also be overridden for individual peers, keyed by node ID, so that
+// a change can be enabled for a few peers first.
+//
+// Flags that have never been set are disabled. The zero value is an empty set
+// ready to use, and all methods are safe for concurrent use. Each Server has
+// its own set, available from Server.Features; handlers read it with
+// Context.Feature.
+type FeatureFlags struct {
+	mu    sync.RWMutex
+	flags map[string]bool
+	peers map[string]map[nwep.NodeID]bool
+}
+
+// Set enables or disables the flag name for all peers that have no override.
+func (f *FeatureFlags) Set(name string, enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.flags == nil {
+		f.flags = make(map[string]bool)
+	}
+	f.flags[name] = enabled
+}
+
+// SetForPeer overrides the flag name for peer, taking precedence over the
+// value set with Set.
+func (f *FeatureFlags) SetForPeer(name string, peer nwep.NodeID, enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.peers == nil {
+		f.peers = make(map[string]map[nwep.NodeID]bool)
+	}
+	if f.peers[name] == nil {
+		f.peers[name] = make(map[nwep.NodeID]bool)
+	}
+	f.peers[name][peer] = enabled
+}
+
+// ClearForPeer removes peer's override for the flag name, so that the peer
+// follows the value set with Set again.
+func (f *FeatureFlags) ClearForPeer(name string, peer nwep.NodeID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.peers[name], peer)
+	if len(f.peers[name]) == 0 {
+		delete(f.peers, name)
+	}
+}
+
+// IsEnabled reports whether the flag name is enabled, ignoring per-peer
+// overrides.
+func (f *FeatureFlags) IsEnabled(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.flags[name]
+}
+
+// IsEnabledFor reports whether the flag name is enabled for peer: the peer's
+// override if it has one, and otherwise the value set with Set.
+func (f *FeatureFlags) IsEnabledFor(name string, peer nwep.NodeID) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if enabled, ok := f.peers[name][peer]; ok {
+		return enabled
+	}
+	return f.flags[name]
+}
+
+// Features returns the server's feature flags. Flags may be changed at any
+// time, including while the server is running.
+func (s *Server) Features() *FeatureFlags {
+	return &s.features
+}
+
+// Feature reports whether the feature flag name is enabled for the peer that
+// sent this request, taking per-peer overrides into account. See
+// Server.Features.
+func (c *Context) Feature(name string) bool {
+	return c.server.features.IsEnabledFor(name, c.PeerNodeID())
+}
@@ -0,0 +1,32 @@
+package velocity
+
+import (
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	var f FeatureFlags
+	var a, b nwep.NodeID
+	a[0], b[0] = 1, 2
+
+	if f.IsEnabled("x") || f.IsEnabledFor("x", a) {
+		t.Fatal("unset flag should be disabled")
+	}
+	f.Set("x", true)
+	f.SetForPeer("x", a, false)
+	if f.IsEnabledFor("x", a) {
+		t.Fatal("peer override should win")
+	}
+	if !f.IsEnabledFor("x", b) || !f.IsEnabled("x") {
+		t.Fatal("flag should be enabled for peers without an override")
+	}
+	f.ClearForPeer("x", a)
+	if !f.IsEnabledFor("x", a) {
+		t.Fatal("cleared override should fall back to the flag")
+	}
+	if len(f.peers) != 0 {
+		t.Fatalf("override map holds %d flags, want 0", len(f.peers))
+	}
+}
@@ -0,0 +1,136 @@
+package velocity
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fileStreamThreshold is the size above which File and FileFromFS stream a
+// file with StreamWrite instead of reading it into memory.
+const fileStreamThreshold = 64 << 10
+
+// File sends the contents of the file at name with status "ok". The
+// content-type header is derived from the file extension, falling back to
+// sniffing the first bytes, unless the handler has already set one. The
+// last-modified and etag headers are set from the file's size and
+// modification time, so that clients can cache the file and revalidate it.
+//
+// Files larger than 64 KiB are streamed with Stream, so that large files are
+// never held in memory. A missing file or a directory is answered with status
+// "not_found". Other errors are returned without responding.
+func (c *Context) File(name string) error {
+	dir, file := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	return c.FileFromFS(os.DirFS(dir), file)
+}
+
+// FileFromFS is like File, but reads the file name from fsys, such as an
+// embed.FS. name must be a valid fs.FS path, without a leading slash.
+func (c *Context) FileFromFS(fsys fs.FS, name string) error {
+	f, err := fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+		return c.NotFound("file not found")
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return c.NotFound("file not found")
+	}
+
+	// Read the first chunk up front: it sniffs the content type, and it is
+	// the whole file when the file is small.
+	buf := make([]byte, min(info.Size(), fileStreamThreshold)+1)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	buf = buf[:n]
+
+	if _, ok := c.responseHeader("content-type"); !ok {
+		ct := mime.TypeByExtension(path.Ext(name))
+		if ct == "" {
+			ct = http.DetectContentType(buf)
+		}
+		c.SetHeader("content-type", ct)
+	}
+	mod := info.ModTime()
+	if !mod.IsZero() {
+		c.SetHeader("last-modified", mod.UTC().Format(http.TimeFormat))
+	}
+	c.SetHeader("etag", fmt.Sprintf(`"%x-%x"`, mod.UnixNano(), info.Size()))
+
+	if n <= fileStreamThreshold {
+		return c.Respond(StatusOK, buf)
+	}
+
+	c.SetStatus(StatusOK)
+	return c.Stream(func(w io.Writer) error {
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, f)
+		return err
+	})
+}
+
+// responseHeader returns the value of a response header set with SetHeader.
+func (c *Context) responseHeader(name string) (string, bool) {
+	for _, h := range c.respHeaders {
+		if h.Name == name {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// Static returns a handler that serves the files under dir, for use with a
+// prefix route registered with the same prefix:
+//
+//	srv.Router().HandlePrefix("/assets/", velocity.Static("/assets/", "./public"))
+//
+// The request path, without prefix and query string, names a file relative to
+// dir; "/assets/css/site.css" serves "./public/css/site.css". A path naming a
+// directory serves the directory's index.html. Files are sent with File, so
+// they carry content-type and caching headers and large files are streamed.
+// Paths that would escape dir are answered with status "not_found", and
+// methods other than read with status "bad_request".
+func Static(prefix, dir string) HandlerFunc {
+	fsys := os.DirFS(dir)
+	return func(c *Context) error {
+		if c.Method() != MethodRead {
+			return c.BadRequest("method not allowed")
+		}
+		p, _, _ := strings.Cut(c.Path(), "?")
+		name, ok := strings.CutPrefix(p, prefix)
+		if !ok {
+			return c.NotFound("file not found")
+		}
+		name = strings.Trim(name, "/")
+		if name == "" {
+			name = "."
+		}
+		if !fs.ValidPath(name) {
+			return c.NotFound("file not found")
+		}
+		if info, err := fs.Stat(fsys, name); err == nil && info.IsDir() {
+			name = path.Join(name, "index.html")
+		}
+		return c.FileFromFS(fsys, name)
+	}
+}
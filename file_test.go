@@ -0,0 +1,52 @@
+package velocity
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFileFromFS(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), fileStreamThreshold+streamBufferSize+10)
+	fsys := fstest.MapFS{
+		"site.css":        {Data: []byte("body{}")},
+		"big.bin":         {Data: big},
+		"docs/index.html": {Data: []byte("<p>hi</p>")},
+	}
+
+	c, rec := NewTestContext(MethodRead, "/site.css", nil)
+	if err := c.FileFromFS(fsys, "site.css"); err != nil {
+		t.Fatal(err)
+	}
+	if ct, _ := rec.Header("content-type"); ct != "text/css; charset=utf-8" || string(rec.Body) != "body{}" {
+		t.Fatalf("small file: %q %q", ct, rec.Body)
+	}
+	if _, ok := rec.Header("etag"); !ok || rec.Closed {
+		t.Fatal("small file: missing etag or stream closed")
+	}
+
+	c, rec = NewTestContext(MethodRead, "/big.bin", nil)
+	if err := c.FileFromFS(fsys, "big.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rec.Body, big) || !rec.Closed || rec.CloseCode != 0 {
+		t.Fatalf("big file: %d bytes, closed = %v", len(rec.Body), rec.Closed)
+	}
+
+	c, rec = NewTestContext(MethodRead, "/missing", nil)
+	if err := c.FileFromFS(fsys, "missing"); err != nil || rec.Status != StatusNotFound {
+		t.Fatalf("missing file: %v %q", err, rec.Status)
+	}
+}
+
+func TestStatic(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := NewTestContext(MethodRead, "/assets/../etc/passwd", nil)
+	h := Static("/assets/", dir)
+	if err := h(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.status != StatusNotFound {
+		t.Fatalf("traversal: status = %q", c.status)
+	}
+}
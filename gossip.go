@@ -0,0 +1,509 @@
+package velocity
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mrand "math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// Defaults for GossipOptions.
+const (
+	DefaultGossipInterval = 30 * time.Second
+	DefaultGossipFanout   = 3
+	DefaultGossipMaxPeers = 1024
+	DefaultGossipTTL      = 3
+)
+
+// GossipPath is the route gossip messages are written to and the path of the
+// gossip notifications; gossipEvent is the notification event.
+const (
+	GossipPath  = "/gossip"
+	gossipEvent = "gossip"
+)
+
+// gossipSeen is how many message IDs a Gossip remembers, to drop messages
+// that come back to it.
+const gossipSeen = 4096
+
+// gossipSkew is how far in the future a peer record's timestamp may be.
+const gossipSkew = time.Minute
+
+// GossipOptions configures a Gossip.
+type GossipOptions struct {
+	// Seeds are the web:// URLs of servers to connect to at start. Others
+	// are learned from them.
+	Seeds []string
+
+	// Interval is the time between gossip rounds. Zero means
+	// DefaultGossipInterval. A peer record that has not been refreshed for
+	// ten intervals is forgotten.
+	Interval time.Duration
+
+	// Fanout is how many known servers each round connects to and pushes
+	// to, besides the peers connected to this server. Zero means
+	// DefaultGossipFanout.
+	Fanout int
+
+	// MaxPeers bounds the peer records kept. Zero means
+	// DefaultGossipMaxPeers.
+	MaxPeers int
+
+	// TTL is how many hops a message travels. Zero means DefaultGossipTTL.
+	TTL int
+
+	// Client configures the connections to other servers.
+	Client ClientOptions
+
+	// OnPeer, if set, is called for each server learned of.
+	OnPeer func(GossipPeer)
+
+	// OnCheckpoint, if set, is called for each newer checkpoint accepted.
+	OnCheckpoint func(*nwep.Checkpoint)
+}
+
+// GossipPeer is a server's signed announcement of its own address.
+type GossipPeer struct {
+	NodeID    string    `json:"node_id"`
+	URL       string    `json:"url"`
+	PublicKey []byte    `json:"public_key"`
+	Issued    time.Time `json:"issued"`
+	Signature []byte    `json:"signature"`
+}
+
+// signed returns the bytes p's signature covers.
+func (p *GossipPeer) signed() []byte {
+	return []byte("velocity-gossip-peer\x00" + p.NodeID + "\x00" + p.URL + "\x00" +
+		strconv.FormatInt(p.Issued.UnixNano(), 10))
+}
+
+// verify checks p's signature, that its public key is the one its node ID
+// is derived from, and that its URL names its node ID. Without the key check,
+// anyone could sign a record for another server's node ID and point it at an
+// address of their choosing.
+func (p *GossipPeer) verify() error {
+	if len(p.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(p.PublicKey, p.signed(), p.Signature) {
+		return errors.New("velocity: gossip peer record has a bad signature")
+	}
+	owner, err := nwep.NodeIDFromPubkey([32]byte(p.PublicKey))
+	if err != nil {
+		return fmt.Errorf("velocity: gossip peer record: %w", err)
+	}
+	if FormatNodeID(owner) != p.NodeID {
+		return fmt.Errorf("velocity: gossip peer record for %s is signed by the key of %s", p.NodeID, FormatNodeID(owner))
+	}
+	id, err := NodeIDFromURL(p.URL)
+	if err != nil {
+		return err
+	}
+	if FormatNodeID(id) != p.NodeID {
+		return fmt.Errorf("velocity: gossip peer record for %s has URL of %s", p.NodeID, FormatNodeID(id))
+	}
+	return nil
+}
+
+// GossipMessage is the body written to GossipPath and sent in gossip
+// notifications.
+type GossipMessage struct {
+	// ID identifies the message, so that a server drops copies that reach
+	// it by several paths.
+	ID string `json:"id"`
+
+	// TTL is the number of hops the message may still take.
+	TTL int `json:"ttl"`
+
+	Peers []GossipPeer `json:"peers,omitempty"`
+
+	// Checkpoint is the sender's latest checkpoint, encoded.
+	Checkpoint []byte `json:"checkpoint,omitempty"`
+}
+
+// Gossip exchanges checkpoints and peer addresses with other velocity
+// servers, so that a mesh of WEB/1 nodes can be bootstrapped from a few seed
+// URLs instead of static configuration everywhere. Every interval, a server
+// signs a record of its own URL and sends it, with the records it knows and
+// its latest checkpoint, to its connected peers as a notification and to a
+// few known servers through a write to GossipPath; the servers it writes to
+// answer with their own state. What is new to a server travels on, until its
+// TTL runs out, and a message that comes back is dropped.
+//
+// Peer records are signed by the server they describe, with the key its node
+// ID is derived from, and a record whose signature or key does not verify is
+// dropped. Checkpoints are added to the server's trust store, which verifies
+// their quorum signatures, before they are kept or relayed; without a trust
+// store that accepts checkpoints, none are.
+//
+//	g, err := velocity.NewGossip(srv, velocity.GossipOptions{
+//	    Seeds: []string{seedURL},
+//	})
+//	...
+//	defer g.Close()
+//
+// A Gossip is safe for concurrent use.
+type Gossip struct {
+	srv  *Server
+	opts GossipOptions
+	pool *ClientPool
+
+	mu         sync.Mutex
+	peers      map[string]GossipPeer // by node ID
+	checkpoint []byte
+	latest     *nwep.Checkpoint
+	seen       map[string]struct{}
+	seenOrder  []string
+	listening  map[*Client]bool
+
+	ctx       context.Context // canceled by Close
+	stop      context.CancelFunc
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewGossip starts gossip on srv and registers its route, write GossipPath.
+// Rounds run in the background from when srv starts, or at once if it has
+// started, until Close or shutdown. This function returns an error if opts is
+// invalid or a seed URL has no node ID.
+func NewGossip(srv *Server, opts GossipOptions) (*Gossip, error) {
+	if opts.Interval < 0 || opts.Fanout < 0 || opts.MaxPeers < 0 || opts.TTL < 0 {
+		return nil, errors.New("velocity: gossip options must not be negative")
+	}
+	if opts.Interval == 0 {
+		opts.Interval = DefaultGossipInterval
+	}
+	if opts.Fanout == 0 {
+		opts.Fanout = DefaultGossipFanout
+	}
+	if opts.MaxPeers == 0 {
+		opts.MaxPeers = DefaultGossipMaxPeers
+	}
+	if opts.TTL == 0 {
+		opts.TTL = DefaultGossipTTL
+	}
+	pool, err := NewClientPool(ClientPoolOptions{Client: opts.Client})
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range opts.Seeds {
+		if _, err := pool.Add(url); err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+	g := &Gossip{
+		srv:       srv,
+		opts:      opts,
+		pool:      pool,
+		peers:     make(map[string]GossipPeer),
+		seen:      make(map[string]struct{}),
+		listening: make(map[*Client]bool),
+	}
+	g.ctx, g.stop = context.WithCancel(context.Background())
+	srv.router.Write(GossipPath, g.handle)
+	if srv.nwep != nil {
+		g.wg.Go(g.loop)
+	} else {
+		srv.onStart = append(srv.onStart, func(*Server) { g.wg.Go(g.loop) })
+	}
+	srv.onShutdown = append(srv.onShutdown, func(*Server) { g.Close() })
+	return g, nil
+}
+
+// loop runs a round now and then after every interval until Close.
+func (g *Gossip) loop() {
+	t := time.NewTicker(g.opts.Interval)
+	defer t.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(g.ctx, g.opts.Interval)
+		g.Round(ctx)
+		cancel()
+		select {
+		case <-t.C:
+		case <-g.ctx.Done():
+			return
+		}
+	}
+}
+
+// handle serves write GossipPath: it takes in the message in the body and
+// responds with this server's own state.
+func (g *Gossip) handle(c *Context) error {
+	var msg GossipMessage
+	if err := c.Bind(&msg); err != nil {
+		return ErrBadRequestf("gossip message: %v", err)
+	}
+	g.receive(c.PeerNodeID(), &msg)
+	return c.JSON(g.message())
+}
+
+// Round runs one gossip round, as the server does every interval: it
+// refreshes the server's own record, forgets stale records, and sends its
+// state to every connected peer and to up to Fanout known servers, taking in
+// their answers.
+func (g *Gossip) Round(ctx context.Context) {
+	g.refresh()
+	msg := g.message()
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	g.srv.NotifyPeers(g.srv.ConnectedPeers(), gossipEvent, GossipPath, body)
+
+	var wg sync.WaitGroup
+	for _, peer := range g.targets() {
+		wg.Go(func() { g.push(ctx, peer, body) })
+	}
+	wg.Wait()
+}
+
+// push writes body to peer and takes in its answer.
+func (g *Gossip) push(ctx context.Context, peer nwep.NodeID, body []byte) {
+	client, err := g.pool.Client(ctx, peer)
+	if err != nil {
+		return
+	}
+	g.listen(peer, client)
+	resp, err := client.Write(ctx, GossipPath, body)
+	if err == nil {
+		err = ResponseError(resp)
+	}
+	if err != nil {
+		g.srv.logger.Debug("gossip push failed", "peer", FormatNodeID(peer), "error", err.Error())
+		return
+	}
+	var answer GossipMessage
+	if err := json.Unmarshal(resp.Body, &answer); err == nil {
+		g.receive(peer, &answer)
+	}
+}
+
+// listen takes in the gossip notifications peer sends over client.
+func (g *Gossip) listen(peer nwep.NodeID, client *Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.listening[client] {
+		return
+	}
+	g.listening[client] = true
+	client.OnNotify(gossipEvent, GossipPath, func(n *Notification) {
+		var msg GossipMessage
+		if err := json.Unmarshal(n.Body, &msg); err == nil {
+			go g.receive(peer, &msg)
+		}
+	})
+}
+
+// targets adds known servers to the pool until it has Fanout of them and
+// returns up to Fanout pool members, chosen at random.
+func (g *Gossip) targets() []nwep.NodeID {
+	pooled := g.pool.Peers()
+	in := make(map[string]bool, len(pooled))
+	for _, p := range pooled {
+		in[FormatNodeID(p)] = true
+	}
+	g.mu.Lock()
+	var candidates []string
+	for id, rec := range g.peers {
+		if !in[id] {
+			candidates = append(candidates, rec.URL)
+		}
+	}
+	g.mu.Unlock()
+	mrand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	for _, url := range candidates {
+		if len(pooled) >= g.opts.Fanout {
+			break
+		}
+		if peer, err := g.pool.Add(url); err == nil {
+			pooled = append(pooled, peer)
+		}
+	}
+	mrand.Shuffle(len(pooled), func(i, j int) { pooled[i], pooled[j] = pooled[j], pooled[i] })
+	return pooled[:min(len(pooled), g.opts.Fanout)]
+}
+
+// refresh signs a new record of the server's own URL and forgets records
+// that have not been refreshed for ten intervals.
+func (g *Gossip) refresh() {
+	now := time.Now()
+	g.mu.Lock()
+	for id, rec := range g.peers {
+		if now.Sub(rec.Issued) > 10*g.opts.Interval {
+			delete(g.peers, id)
+		}
+	}
+	g.mu.Unlock()
+
+	url := g.srv.URL("/")
+	if url == "" {
+		return
+	}
+	rec := GossipPeer{NodeID: FormatNodeID(g.srv.NodeID()), URL: url, Issued: now}
+	g.srv.keyMu.Lock()
+	pub := g.srv.keypair.PublicKey()
+	sig, err := nwep.Sign(g.srv.keypair, rec.signed())
+	g.srv.keyMu.Unlock()
+	if err != nil {
+		g.srv.logger.Warn("gossip cannot sign peer record", "error", err.Error())
+		return
+	}
+	rec.PublicKey, rec.Signature = pub[:], sig[:]
+	g.mu.Lock()
+	g.peers[rec.NodeID] = rec
+	g.mu.Unlock()
+}
+
+// message returns a new message with the server's whole state.
+func (g *Gossip) message() *GossipMessage {
+	var id [16]byte
+	rand.Read(id[:])
+	msg := &GossipMessage{ID: hex.EncodeToString(id[:]), TTL: g.opts.TTL}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.markSeen(msg.ID)
+	for _, rec := range g.peers {
+		msg.Peers = append(msg.Peers, rec)
+	}
+	msg.Checkpoint = g.checkpoint
+	return msg
+}
+
+// markSeen records a message ID, forgetting the oldest beyond gossipSeen. It
+// reports whether the ID was new. The caller must hold g.mu.
+func (g *Gossip) markSeen(id string) bool {
+	if _, ok := g.seen[id]; ok {
+		return false
+	}
+	g.seen[id] = struct{}{}
+	g.seenOrder = append(g.seenOrder, id)
+	if len(g.seenOrder) > gossipSeen {
+		delete(g.seen, g.seenOrder[0])
+		g.seenOrder = g.seenOrder[1:]
+	}
+	return true
+}
+
+// receive takes in msg, from peer, and relays what was new in it to the
+// other connected peers while its TTL lasts.
+func (g *Gossip) receive(from nwep.NodeID, msg *GossipMessage) {
+	g.mu.Lock()
+	if msg.ID == "" || !g.markSeen(msg.ID) {
+		g.mu.Unlock()
+		return
+	}
+	g.mu.Unlock()
+
+	fresh := &GossipMessage{ID: msg.ID, TTL: msg.TTL - 1}
+	self := FormatNodeID(g.srv.NodeID())
+	now := time.Now()
+	for _, rec := range msg.Peers {
+		if rec.NodeID == self || rec.Issued.After(now.Add(gossipSkew)) ||
+			now.Sub(rec.Issued) > 10*g.opts.Interval || rec.verify() != nil {
+			continue
+		}
+		g.mu.Lock()
+		old, known := g.peers[rec.NodeID]
+		take := rec.Issued.After(old.Issued) && (known || len(g.peers) < g.opts.MaxPeers)
+		if take {
+			g.peers[rec.NodeID] = rec
+		}
+		g.mu.Unlock()
+		if !take {
+			continue
+		}
+		fresh.Peers = append(fresh.Peers, rec)
+		if !known && g.opts.OnPeer != nil {
+			g.opts.OnPeer(rec)
+		}
+	}
+	if len(msg.Checkpoint) > 0 && g.AddCheckpoint(msg.Checkpoint) == nil {
+		fresh.Checkpoint = msg.Checkpoint
+	}
+
+	if fresh.TTL <= 0 || (len(fresh.Peers) == 0 && fresh.Checkpoint == nil) {
+		return
+	}
+	body, err := json.Marshal(fresh)
+	if err != nil {
+		return
+	}
+	var to []nwep.NodeID
+	for _, peer := range g.srv.ConnectedPeers() {
+		if peer != from {
+			to = append(to, peer)
+		}
+	}
+	g.srv.NotifyPeers(to, gossipEvent, GossipPath, body)
+}
+
+// AddCheckpoint adds an encoded checkpoint to the server's trust store and,
+// if its epoch is newer than the latest one's, makes it the checkpoint the
+// server gossips. Use it to start spreading a checkpoint fetched some other
+// way, such as from an anchor server.
+//
+// This function returns an error if the checkpoint cannot be decoded, is not
+// newer, or is rejected by the trust store; ErrNoTrustStore means the server
+// cannot verify checkpoints.
+func (g *Gossip) AddCheckpoint(encoded []byte) error {
+	cp, err := nwep.CheckpointDecode(encoded)
+	if err != nil {
+		return fmt.Errorf("velocity: decode checkpoint: %w", err)
+	}
+	g.mu.Lock()
+	if g.latest != nil && cp.Epoch <= g.latest.Epoch {
+		g.mu.Unlock()
+		return fmt.Errorf("velocity: checkpoint epoch %d is not newer than %d", cp.Epoch, g.latest.Epoch)
+	}
+	if err := g.srv.addCheckpoint(cp); err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	g.latest, g.checkpoint = cp, append([]byte(nil), encoded...)
+	g.mu.Unlock()
+	if g.opts.OnCheckpoint != nil {
+		g.opts.OnCheckpoint(cp)
+	}
+	return nil
+}
+
+// Checkpoint returns the newest checkpoint the server has accepted through
+// gossip or AddCheckpoint, or nil if none.
+func (g *Gossip) Checkpoint() *nwep.Checkpoint {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.latest
+}
+
+// Peers returns the peer records the server knows, its own included once it
+// has run a round.
+func (g *Gossip) Peers() []GossipPeer {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	peers := make([]GossipPeer, 0, len(g.peers))
+	for _, rec := range g.peers {
+		peers = append(peers, rec)
+	}
+	slices.SortFunc(peers, func(a, b GossipPeer) int { return strings.Compare(a.NodeID, b.NodeID) })
+	return peers
+}
+
+// Close stops gossip, cancelling a round in progress, and closes the
+// connections it opened. The route stays registered and keeps taking in
+// messages. Close is called when the server shuts down.
+func (g *Gossip) Close() {
+	g.closeOnce.Do(func() {
+		g.stop()
+		g.wg.Wait()
+		g.pool.Close()
+	})
+}
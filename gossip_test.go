@@ -0,0 +1,116 @@
+package velocity
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func testGossipKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+// testGossipPeer returns a record signed with key for the node ID derived
+// from it.
+func testGossipPeer(t *testing.T, key ed25519.PrivateKey, issued time.Time) GossipPeer {
+	t.Helper()
+	pub := key.Public().(ed25519.PublicKey)
+	id, err := nwep.NodeIDFromPubkey([32]byte(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := GossipPeer{NodeID: FormatNodeID(id), URL: testPoolURL(id), PublicKey: pub, Issued: issued}
+	rec.Signature = ed25519.Sign(key, rec.signed())
+	return rec
+}
+
+func TestGossipPeerVerify(t *testing.T) {
+	rec := testGossipPeer(t, testGossipKey(t), time.Now())
+	if err := rec.verify(); err != nil {
+		t.Fatal(err)
+	}
+	moved := rec
+	moved.URL = testPoolURL(nwep.NodeID{1, 2, 3, 4})
+	if moved.verify() == nil {
+		t.Fatal("record with a changed URL verified")
+	}
+	other := testGossipPeer(t, testGossipKey(t), rec.Issued)
+	tampered := rec
+	tampered.NodeID, tampered.URL = other.NodeID, other.URL
+	if tampered.verify() == nil {
+		t.Fatal("record altered to name another node verified")
+	}
+
+	// An attacker signs, with their own key, a record claiming the
+	// victim's node ID and pointing at an address of their choosing.
+	attacker := testGossipKey(t)
+	forged := GossipPeer{
+		NodeID:    rec.NodeID,
+		URL:       rec.URL,
+		PublicKey: attacker.Public().(ed25519.PublicKey),
+		Issued:    rec.Issued.Add(time.Second),
+	}
+	forged.Signature = ed25519.Sign(attacker, forged.signed())
+	if forged.verify() == nil {
+		t.Fatal("record signed with a key other than the node's verified")
+	}
+}
+
+func TestGossipReceive(t *testing.T) {
+	s, err := New(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var learned []string
+	g, err := NewGossip(s, GossipOptions{MaxPeers: 2, OnPeer: func(p GossipPeer) { learned = append(learned, p.NodeID) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	now := time.Now()
+	keyA := testGossipKey(t)
+	a := testGossipPeer(t, keyA, now)
+	b := testGossipPeer(t, testGossipKey(t), now)
+	bad := testGossipPeer(t, testGossipKey(t), now)
+	bad.Signature[0] ^= 1
+	stale := testGossipPeer(t, testGossipKey(t), now.Add(-time.Hour))
+	g.receive(nwep.NodeID{}, &GossipMessage{ID: "m1", TTL: 1, Peers: []GossipPeer{a, bad, stale}})
+	if peers := g.Peers(); len(peers) != 1 || peers[0].NodeID != a.NodeID {
+		t.Fatalf("peers = %v", peers)
+	}
+
+	// A message seen before is dropped.
+	g.receive(nwep.NodeID{}, &GossipMessage{ID: "m1", TTL: 1, Peers: []GossipPeer{b}})
+	if len(g.Peers()) != 1 {
+		t.Fatal("repeated message taken in")
+	}
+	g.receive(nwep.NodeID{}, &GossipMessage{ID: "m2", TTL: 1, Peers: []GossipPeer{b}})
+	if len(g.Peers()) != 2 {
+		t.Fatal("new message dropped")
+	}
+
+	// MaxPeers bounds new records, but known ones are still refreshed.
+	c := testGossipPeer(t, testGossipKey(t), now)
+	newer := testGossipPeer(t, keyA, now.Add(time.Second))
+	g.receive(nwep.NodeID{}, &GossipMessage{ID: "m3", TTL: 1, Peers: []GossipPeer{c, newer}})
+	peers := g.Peers()
+	if len(peers) != 2 {
+		t.Fatalf("peers = %v", peers)
+	}
+	for _, p := range peers {
+		if p.NodeID == a.NodeID && !p.Issued.Equal(newer.Issued) {
+			t.Fatalf("record of %s not refreshed: %v", a.NodeID, peers)
+		}
+	}
+	if len(learned) != 2 {
+		t.Fatalf("OnPeer called for %v", learned)
+	}
+}
@@ -0,0 +1,118 @@
+package velocity
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// handlerQueuePerWorker is how many requests per worker may wait for a free
+// worker in a handler pool.
+const handlerQueuePerWorker = 64
+
+// handlerPool runs handlers on a fixed set of goroutines.
+type handlerPool struct {
+	jobs chan handlerJob
+	wg   sync.WaitGroup
+}
+
+// handlerJob is a request waiting for a worker.
+type handlerJob struct {
+	w        *nwep.ResponseWriter
+	r        *nwep.Request
+	enqueued time.Time
+	done     chan struct{} // closed once the request has been served
+}
+
+// WithHandlerPool runs handlers on a pool of n goroutines instead of inline
+// in the nwep callback, so that at most n handlers run at once however many
+// requests the transport delivers. The callback queues the request and waits
+// for a worker to serve it, since the request, its body, and its writer are
+// valid only until the callback returns. Up to 64 requests per worker wait
+// for a free worker; beyond that, requests are rejected at once with status
+// "rate_limited". A request that waited longer than the WithTimeout deadline
+// is answered with status "unavailable" without running its handler.
+//
+// A panic in a handler running on the pool is recovered and logged with its
+// stack trace, and answered with status "internal_error" if no response was
+// sent, so that it cannot take the server down; Recover remains the way to
+// customize that response. PoolStats reports the workers, the queue, and the
+// requests rejected. Requests passed to ServeWEB directly, such as by the
+// httpgw package, do not go through the pool. This function returns an error
+// if n is less than 1.
+func WithHandlerPool(n int) Option {
+	return func(s *Server) error {
+		if n < 1 {
+			return fmt.Errorf("velocity: handler pool size must be at least 1, got %d", n)
+		}
+		s.pool.workers = n
+		return nil
+	}
+}
+
+// startHandlerPool starts the pool's workers and returns the nwep callback
+// that feeds them. The callback returns once its request has been served.
+func (s *Server) startHandlerPool() nwep.HandlerFunc {
+	n := s.pool.workers
+	p := &handlerPool{jobs: make(chan handlerJob, n*handlerQueuePerWorker)}
+	for range n {
+		p.wg.Go(func() {
+			for job := range p.jobs {
+				s.pool.queued.Add(-1)
+				s.runJob(job)
+				close(job.done)
+			}
+		})
+	}
+	s.handlers = p
+	return func(w *nwep.ResponseWriter, r *nwep.Request) {
+		s.pool.queued.Add(1)
+		done := make(chan struct{})
+		select {
+		case p.jobs <- handlerJob{w: w, r: r, enqueued: time.Now(), done: done}:
+			<-done
+		default:
+			s.pool.queued.Add(-1)
+			s.pool.rejected.Add(1)
+			_ = w.Respond(StatusRateLimited, []byte("server overloaded"))
+		}
+	}
+}
+
+// runJob serves a request taken from the queue.
+func (s *Server) runJob(job handlerJob) {
+	if s.timeout > 0 && time.Since(job.enqueued) >= s.timeout {
+		_ = job.w.Respond(StatusUnavailable, []byte("request timed out"))
+		return
+	}
+	s.ServeWEB(job.w, job.r)
+}
+
+// stopHandlerPool lets the workers finish the queued requests and waits for
+// them.
+func (s *Server) stopHandlerPool() {
+	if s.handlers == nil {
+		return
+	}
+	close(s.handlers.jobs)
+	s.handlers.wg.Wait()
+	s.handlers = nil
+}
+
+// isolatePanic recovers a panic that escaped the middleware chain of a
+// request running on the handler pool. It must be deferred by ServeWEB.
+func (s *Server) isolatePanic(c *Context) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := make([]byte, DefaultRecoverStackSize)
+	stack = stack[:runtime.Stack(stack, false)]
+	s.logger.Error("handler panic", "panic", fmt.Sprint(r), "path", c.Path(), "stack", string(stack))
+	if !c.Committed() {
+		_ = c.Error(StatusInternalError, "internal error")
+	}
+}
@@ -0,0 +1,94 @@
+package velocity
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestHandlerPool(t *testing.T) {
+	s := &Server{logger: SlogLogger(slog.New(slog.DiscardHandler)), router: NewRouter(), peers: newPeerTracker()}
+	if err := WithHandlerPool(1)(s); err != nil {
+		t.Fatal(err)
+	}
+	started, unblock := make(chan struct{}), make(chan struct{})
+	var served atomic.Int64
+	s.Handle("/slow", func(c *Context) error {
+		close(started)
+		<-unblock
+		return c.NoContent()
+	})
+	s.Handle("/panic", func(c *Context) error { panic("boom") })
+	s.Handle("/ok", func(c *Context) error {
+		served.Add(1)
+		return c.NoContent()
+	})
+	handle := s.buildHandler()
+	serve := func(path string) { handle(&nwep.ResponseWriter{}, &nwep.Request{Method: MethodRead, Path: path}) }
+
+	// Callbacks wait for their requests, so queue them from goroutines.
+	var callbacks sync.WaitGroup
+	callbacks.Go(func() { serve("/slow") })
+	<-started
+	callbacks.Go(func() { serve("/panic") })
+	for range handlerQueuePerWorker - 1 {
+		callbacks.Go(func() { serve("/ok") })
+	}
+	for s.PoolStats().Queued != handlerQueuePerWorker {
+		time.Sleep(time.Millisecond)
+	}
+	serve("/ok") // rejected at once
+	if st := s.PoolStats(); st.Workers != 1 || st.Queued != handlerQueuePerWorker || st.Rejected != 1 {
+		t.Fatalf("stats with a full queue = %+v", st)
+	}
+	close(unblock)
+	callbacks.Wait()
+	s.stopHandlerPool()
+	// The worker survived the panic and served the rest of the queue.
+	if n := served.Load(); n != handlerQueuePerWorker-1 {
+		t.Fatalf("served %d requests, want %d", n, handlerQueuePerWorker-1)
+	}
+	if st := s.PoolStats(); st.Queued != 0 || st.Active != 0 {
+		t.Fatalf("stats after stopping = %+v", st)
+	}
+}
+
+func TestHandlerPoolOwnsRequest(t *testing.T) {
+	s := &Server{logger: DefaultLogger(), router: NewRouter(), peers: newPeerTracker()}
+	if err := WithHandlerPool(2)(s); err != nil {
+		t.Fatal(err)
+	}
+	var seen string
+	s.Handle("/echo", func(c *Context) error {
+		time.Sleep(10 * time.Millisecond)
+		seen = string(c.Body())
+		return c.NoContent()
+	})
+	handle := s.buildHandler()
+	defer s.stopHandlerPool()
+
+	body := []byte("hello")
+	handle(&nwep.ResponseWriter{}, &nwep.Request{Method: MethodWrite, Path: "/echo", Body: body})
+	// nwep reuses the request's memory once the callback returns.
+	body[0] = 'j'
+	if seen != "hello" {
+		t.Fatalf("handler saw body %q after the callback returned", seen)
+	}
+}
+
+func TestHandlerPoolQueueTimeout(t *testing.T) {
+	s := &Server{logger: DefaultLogger(), router: NewRouter(), peers: newPeerTracker(), timeout: time.Millisecond}
+	var served bool
+	s.Handle("/ok", func(c *Context) error {
+		served = true
+		return c.NoContent()
+	})
+	s.runJob(handlerJob{w: &nwep.ResponseWriter{}, r: &nwep.Request{Path: "/ok"}, enqueued: time.Now().Add(-time.Second)})
+	if served {
+		t.Fatal("handler ran after its deadline passed in the queue")
+	}
+}
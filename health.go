@@ -0,0 +1,212 @@
+package velocity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+// DefaultHealthTimeout bounds each readiness check unless HealthOptions
+// says otherwise.
+const DefaultHealthTimeout = 5 * time.Second
+
+// Health check statuses, as reported in HealthReport and HealthResult.
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// HealthCheckFunc is a readiness check: it returns nil if the dependency it
+// checks, such as a database, is usable, and an error saying why not
+// otherwise. It should return promptly once ctx is done.
+type HealthCheckFunc func(ctx context.Context) error
+
+// HealthResult is the outcome of one readiness check.
+type HealthResult struct {
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// HealthReport is the outcome of a server's readiness checks, as served at
+// /readyz. Status is HealthOK if every check passed.
+type HealthReport struct {
+	Status string                  `json:"status"`
+	Checks map[string]HealthResult `json:"checks,omitempty"`
+}
+
+// healthChecks is the set of a server's readiness checks.
+type healthChecks struct {
+	mu     sync.RWMutex
+	checks map[string]HealthCheckFunc
+}
+
+// AddReadinessCheck registers fn as the readiness check called name,
+// replacing any check with that name. Checks may be added and removed while
+// the server runs. AddReadinessCheck panics if name is empty or fn is nil.
+//
+//	srv.AddReadinessCheck("db", velocity.PingCheck(db))
+//	srv.AddReadinessCheck("trust", velocity.CheckpointCheck(syncer, time.Hour))
+func (s *Server) AddReadinessCheck(name string, fn HealthCheckFunc) {
+	if name == "" || fn == nil {
+		panic("velocity: AddReadinessCheck requires a name and a function")
+	}
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	if s.health.checks == nil {
+		s.health.checks = make(map[string]HealthCheckFunc)
+	}
+	s.health.checks[name] = fn
+}
+
+// RemoveReadinessCheck removes the readiness check called name, if any.
+func (s *Server) RemoveReadinessCheck(name string) {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	delete(s.health.checks, name)
+}
+
+// CheckReadiness runs every readiness check concurrently, each bounded by
+// timeout if it is positive, and reports the results. A server draining (see
+// Server.Drain) is not ready, whatever its checks say; it is reported as a
+// failed check called "drain".
+func (s *Server) CheckReadiness(ctx context.Context, timeout time.Duration) HealthReport {
+	s.health.mu.RLock()
+	checks := maps.Clone(s.health.checks)
+	s.health.mu.RUnlock()
+
+	report := HealthReport{Status: HealthOK, Checks: make(map[string]HealthResult, len(checks))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, fn := range checks {
+		wg.Go(func() {
+			res := runHealthCheck(ctx, fn, timeout)
+			mu.Lock()
+			report.Checks[name] = res
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	if s.Draining() {
+		report.Checks["drain"] = HealthResult{Status: HealthFail, Error: "server draining"}
+	}
+	for _, res := range report.Checks {
+		if res.Status != HealthOK {
+			report.Status = HealthFail
+		}
+	}
+	return report
+}
+
+// runHealthCheck runs fn, recovering a panic as a failure.
+func runHealthCheck(ctx context.Context, fn HealthCheckFunc, timeout time.Duration) (res HealthResult) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	defer func() {
+		if v := recover(); v != nil {
+			res = HealthResult{Status: HealthFail, Error: fmt.Sprintf("panic: %v", v)}
+		}
+		res.Duration = time.Since(start)
+	}()
+	if err := fn(ctx); err != nil {
+		return HealthResult{Status: HealthFail, Error: err.Error()}
+	}
+	return HealthResult{Status: HealthOK}
+}
+
+// HealthOptions configures Health.
+type HealthOptions struct {
+	// Prefix is prepended to /healthz and /readyz. Empty mounts them at
+	// the root.
+	Prefix string
+
+	// Timeout bounds each readiness check. Zero means
+	// DefaultHealthTimeout.
+	Timeout time.Duration
+}
+
+// Health mounts liveness and readiness endpoints and returns their group:
+//
+//	velocity.Health(srv, velocity.HealthOptions{})
+//	srv.AddReadinessCheck("db", velocity.PingCheck(db))
+//
+// read /healthz responds {"status":"ok"} as long as the server is serving
+// requests. read /readyz runs the readiness checks registered with
+// Server.AddReadinessCheck and responds with the HealthReport, with status
+// "ok" if every check passed and "unavailable" otherwise, so that a load
+// balancer or orchestrator stops routing to the server. Health panics if
+// opts.Timeout is negative.
+func Health(srv *Server, opts HealthOptions) *Group {
+	if opts.Timeout < 0 {
+		panic("velocity: Health timeout must not be negative")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultHealthTimeout
+	}
+	g := srv.Group(opts.Prefix)
+	g.Read("/healthz", func(c *Context) error {
+		return c.JSON(HealthReport{Status: HealthOK})
+	})
+	g.Read("/readyz", func(c *Context) error {
+		report := srv.CheckReadiness(c.Context(), opts.Timeout)
+		if report.Status != HealthOK {
+			return c.JSONStatus(StatusUnavailable, report)
+		}
+		return c.JSON(report)
+	})
+	return g
+}
+
+// Pinger is implemented by *sql.DB and other clients whose connection can
+// be checked.
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// PingCheck returns a readiness check that pings p, such as a *sql.DB.
+func PingCheck(p Pinger) HealthCheckFunc {
+	return func(ctx context.Context) error { return p.PingContext(ctx) }
+}
+
+// CheckpointCheck returns a readiness check that fails unless cs has
+// accepted a checkpoint, its last sync succeeded, and that sync finished
+// within maxAge, so that a server whose trust store has gone stale is taken
+// out of rotation.
+func CheckpointCheck(cs *CheckpointSyncer, maxAge time.Duration) HealthCheckFunc {
+	return func(context.Context) error {
+		epoch, synced, err := cs.Status()
+		switch {
+		case err != nil:
+			return err
+		case epoch == 0:
+			return errors.New("no checkpoint yet")
+		case time.Since(synced) > maxAge:
+			return fmt.Errorf("last checkpoint sync %s ago", time.Since(synced).Round(time.Second))
+		}
+		return nil
+	}
+}
+
+// LogMirrorCheck returns a readiness check that fails if m's last sync failed
+// or m is more than maxLag entries behind its upstream.
+func LogMirrorCheck(m *LogMirror, maxLag uint64) HealthCheckFunc {
+	return func(context.Context) error {
+		st := m.Stats()
+		if st.Err != nil {
+			return st.Err
+		}
+		if st.Lag > maxLag {
+			return fmt.Errorf("%d entries behind %s", st.Lag, st.Upstream)
+		}
+		return nil
+	}
+}
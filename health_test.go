@@ -0,0 +1,78 @@
+package velocity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadinessChecks(t *testing.T) {
+	s, err := New(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Health(s, HealthOptions{Timeout: 50 * time.Millisecond})
+	call := func(path string) *ResponseRecorder {
+		h := s.router.Find(path, MethodRead, nil)
+		if h == nil {
+			t.Fatalf("no route for %s", path)
+		}
+		c, rec := NewTestContext(MethodRead, path, nil)
+		if err := h(c); err != nil {
+			DefaultErrorHandler(c, err)
+		}
+		return rec
+	}
+
+	if rec := call("/readyz"); rec.Status != StatusOK {
+		t.Fatalf("no checks: %s %q", rec.Status, rec.Body)
+	}
+	s.AddReadinessCheck("db", func(context.Context) error { return nil })
+	s.AddReadinessCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	s.AddReadinessCheck("broken", func(context.Context) error { panic("boom") })
+
+	rec := call("/readyz")
+	var report HealthReport
+	if err := json.Unmarshal(rec.Body, &report); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != StatusUnavailable || report.Status != HealthFail {
+		t.Fatalf("readyz = %s %q", rec.Status, rec.Body)
+	}
+	if report.Checks["db"].Status != HealthOK || report.Checks["slow"].Status != HealthFail ||
+		report.Checks["broken"].Error != "panic: boom" {
+		t.Fatalf("checks = %+v", report.Checks)
+	}
+
+	s.RemoveReadinessCheck("slow")
+	s.RemoveReadinessCheck("broken")
+	if rec := call("/readyz"); rec.Status != StatusOK {
+		t.Fatalf("after removal: %s %q", rec.Status, rec.Body)
+	}
+	s.Drain()
+	if r := s.CheckReadiness(context.Background(), 0); r.Status != HealthFail || r.Checks["drain"].Status != HealthFail {
+		t.Fatalf("draining: %+v", r)
+	}
+	s.Resume()
+	if rec := call("/healthz"); rec.Status != StatusOK {
+		t.Fatalf("healthz = %s", rec.Status)
+	}
+}
+
+func TestLogMirrorCheck(t *testing.T) {
+	m := &LogMirror{}
+	m.stats.Lag = 5
+	check := LogMirrorCheck(m, 10)
+	if err := check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m.stats.Err = errors.New("upstream down")
+	if err := check(context.Background()); err == nil {
+		t.Fatal("failed sync passed")
+	}
+}
@@ -0,0 +1,120 @@
+package velocity
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error is an error that carries the response it should produce: a WEB/1
+// status, a message for the peer, and optional details. Handlers return it
+// instead of responding themselves, and the error handler turns it into a
+// response:
+//
+//	srv, _ := velocity.New(addr, velocity.WithErrorHandler(velocity.DefaultErrorHandler))
+//
+//	srv.Router().Read("/admin", func(c *velocity.Context) error {
+//	    if !isAdmin(c) {
+//	        return velocity.ErrForbidden("admins only")
+//	    }
+//	    ...
+//	})
+//
+// An Error may be wrapped; error handlers find it with errors.As.
+type Error struct {
+	// Status is the WEB/1 response status, such as StatusNotFound.
+	Status string
+
+	// Message is sent to the peer as the response body.
+	Message string
+
+	// Details is optional data sent with the message as an
+	// ErrorPayload JSON body. If nil, the response body is the plain
+	// message, or an ErrorPayload without details under WithJSONErrors.
+	Details any
+}
+
+// Error returns the status and message.
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Status
+	}
+	return e.Status + ": " + e.Message
+}
+
+// Payload returns the ErrorPayload form of e.
+func (e *Error) Payload() ErrorPayload {
+	return ErrorPayload{Status: e.Status, Message: e.Message, Details: e.Details}
+}
+
+// WithDetails returns a copy of e carrying details.
+func (e *Error) WithDetails(details any) *Error {
+	cp := *e
+	cp.Details = details
+	return &cp
+}
+
+// respond sends e as the response for c.
+func (e *Error) respond(c *Context) error {
+	if e.Details != nil {
+		return c.ErrorJSON(e.Status, e.Message, e.Details)
+	}
+	return c.Error(e.Status, e.Message)
+}
+
+// NewError returns an Error with the given status and message.
+func NewError(status, msg string) *Error {
+	return &Error{Status: status, Message: msg}
+}
+
+// Errorf returns an Error with the given status and a message formatted
+// according to format.
+func Errorf(status, format string, args ...any) *Error {
+	return &Error{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrBadRequest returns an Error with status "bad_request".
+func ErrBadRequest(msg string) *Error { return NewError(StatusBadRequest, msg) }
+
+// ErrBadRequestf is like ErrBadRequest with a formatted message.
+func ErrBadRequestf(format string, args ...any) *Error {
+	return Errorf(StatusBadRequest, format, args...)
+}
+
+// ErrUnauthorized returns an Error with status "unauthorized".
+func ErrUnauthorized(msg string) *Error { return NewError(StatusUnauthorized, msg) }
+
+// ErrUnauthorizedf is like ErrUnauthorized with a formatted message.
+func ErrUnauthorizedf(format string, args ...any) *Error {
+	return Errorf(StatusUnauthorized, format, args...)
+}
+
+// ErrForbidden returns an Error with status "forbidden".
+func ErrForbidden(msg string) *Error { return NewError(StatusForbidden, msg) }
+
+// ErrForbiddenf is like ErrForbidden with a formatted message.
+func ErrForbiddenf(format string, args ...any) *Error {
+	return Errorf(StatusForbidden, format, args...)
+}
+
+// ErrNotFound returns an Error with status "not_found".
+func ErrNotFound(msg string) *Error { return NewError(StatusNotFound, msg) }
+
+// ErrNotFoundf is like ErrNotFound with a formatted message.
+func ErrNotFoundf(format string, args ...any) *Error {
+	return Errorf(StatusNotFound, format, args...)
+}
+
+// ErrConflict returns an Error with status "conflict".
+func ErrConflict(msg string) *Error { return NewError(StatusConflict, msg) }
+
+// ErrConflictf is like ErrConflict with a formatted message.
+func ErrConflictf(format string, args ...any) *Error {
+	return Errorf(StatusConflict, format, args...)
+}
+
+// asError returns the *Error in err's chain, if any.
+func asError(err error) (*Error, bool) {
+	var e *Error
+	ok := errors.As(err, &e)
+	return e, ok
+}
@@ -0,0 +1,132 @@
+// Package httpgw exposes WEB/1 services to HTTP clients. A Gateway is an
+// http.Handler that translates each HTTP request into a WEB/1 request, sends
+// it to a velocity server, and writes the WEB/1 response back as HTTP. It is
+// meant for migrations, where legacy HTTP clients must keep working while
+// services move to WEB/1.
+//
+// Methods are mapped with velocity.MethodFromHTTP and statuses with
+// velocity.HTTPStatus. The HTTP request path, including its query string,
+// becomes the WEB/1 path.
+package httpgw
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/usenwep/velocity"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// DefaultMaxBodyBytes is the default value of Gateway.MaxBodyBytes.
+const DefaultMaxBodyBytes = 10 << 20
+
+// Gateway is an http.Handler that forwards requests to a WEB/1 server. Create
+// one with New, for a server in the same process, or Dial, for a remote one.
+type Gateway struct {
+	// MaxBodyBytes is the largest HTTP request body forwarded. Larger
+	// requests receive 413 Request Entity Too Large. New and Dial set it
+	// to DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+
+	srv *velocity.Server
+
+	mu     sync.Mutex // serializes use of client
+	client *nwep.Client
+}
+
+// New returns a Gateway that serves HTTP requests with srv's router and
+// middleware in process, without a network round trip. Responses are
+// streamed: headers set by the handler become HTTP headers, and data written
+// with StreamWrite is flushed to the HTTP client as it is written. srv does
+// not need to be started.
+//
+// WEB/1 requests built by the gateway come from an unauthenticated peer and
+// carry no headers, since an nwep.Request cannot be given headers outside
+// the transport. Handlers that depend on request headers or on peer identity
+// should be exposed with Dial instead, or not through the gateway.
+func New(srv *velocity.Server) *Gateway {
+	return &Gateway{MaxBodyBytes: DefaultMaxBodyBytes, srv: srv}
+}
+
+// Dial connects to the WEB/1 server at url, a web:// URL such as one returned
+// by velocity.Server.URL, authenticating with kp, and returns a Gateway that
+// forwards HTTP requests over that connection. Requests are sent one at a
+// time, and responses carry the WEB/1 status and body only. Close the Gateway
+// to close the connection.
+func Dial(url string, kp *nwep.Keypair) (*Gateway, error) {
+	client, err := nwep.NewClient(kp)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Connect(url); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &Gateway{MaxBodyBytes: DefaultMaxBodyBytes, client: client}, nil
+}
+
+// Close closes the connection opened by Dial. It is a no-op for a Gateway
+// created with New.
+func (g *Gateway) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client != nil {
+		g.client.Close()
+		g.client = nil
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := velocity.MethodFromHTTP(r.Method)
+	if !ok {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, g.MaxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) == 0 {
+		body = nil
+	}
+	req := &nwep.Request{Method: method, Path: r.URL.RequestURI(), Body: body}
+
+	if g.srv != nil {
+		hw := &httpWriter{w: w}
+		g.srv.ServeWEB(hw, req)
+		hw.finish()
+		return
+	}
+	g.forward(w, req)
+}
+
+// forward sends req over the Dial connection and copies the response to w.
+func (g *Gateway) forward(w http.ResponseWriter, req *nwep.Request) {
+	g.mu.Lock()
+	resp, err := g.send(req)
+	g.mu.Unlock()
+	if err != nil {
+		http.Error(w, "upstream: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(velocity.HTTPStatus(resp.Status))
+	_, _ = w.Write(resp.Body)
+}
+
+// send issues req with the client. The caller must hold g.mu.
+func (g *Gateway) send(req *nwep.Request) (*nwep.Response, error) {
+	if g.client == nil {
+		return nil, errors.New("httpgw: gateway closed")
+	}
+	return g.client.Do(req.Method, req.Path, req.Body)
+}
@@ -0,0 +1,65 @@
+package httpgw
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/usenwep/velocity"
+)
+
+func TestGatewayInProcess(t *testing.T) {
+	srv, err := velocity.New(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Router().Read("/items", func(c *velocity.Context) error {
+		return c.JSON(map[string]string{"page": c.QueryParam("page")})
+	})
+	srv.Router().Write("/items", func(c *velocity.Context) error {
+		return c.Created(c.Body())
+	})
+
+	ts := httptest.NewServer(New(srv))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/items?page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != `{"page":"2"}` {
+		t.Fatalf("GET = %d %s", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("content-type"); ct != "application/json" {
+		t.Fatalf("content-type = %q", ct)
+	}
+
+	resp, err = http.Post(ts.URL+"/items", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || string(body) != "x" {
+		t.Fatalf("POST = %d %s", resp.StatusCode, body)
+	}
+
+	for method, want := range map[string]int{
+		http.MethodDelete:  http.StatusNotFound,
+		http.MethodOptions: http.StatusMethodNotAllowed,
+	} {
+		req, _ := http.NewRequest(method, ts.URL+"/items", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s = %d, want %d", method, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package httpgw
+
+import (
+	"net/http"
+
+	"github.com/usenwep/velocity"
+)
+
+// httpWriter is a velocity.ResponseWriter that writes a WEB/1 response to an
+// http.ResponseWriter as it is produced.
+type httpWriter struct {
+	w           http.ResponseWriter
+	status      string
+	wroteHeader bool
+}
+
+func (hw *httpWriter) SetStatus(status string) { hw.status = status }
+
+func (hw *httpWriter) SetHeader(name, value string) {
+	if !hw.wroteHeader {
+		hw.w.Header().Set(name, value)
+	}
+}
+
+func (hw *httpWriter) Respond(status string, body []byte) error {
+	hw.status = status
+	return hw.Write(body)
+}
+
+func (hw *httpWriter) Write(body []byte) error {
+	hw.writeHeader()
+	_, err := hw.w.Write(body)
+	return err
+}
+
+func (hw *httpWriter) StreamWrite(data []byte) (int, error) {
+	hw.writeHeader()
+	n, err := hw.w.Write(data)
+	if f, ok := hw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// StreamClose ends the response. A non-zero code cannot be expressed in HTTP
+// once the response has started, so it is dropped.
+func (hw *httpWriter) StreamClose(errCode int) { hw.writeHeader() }
+
+func (hw *httpWriter) StreamID() int64 { return 0 }
+
+func (hw *httpWriter) IsServerInitiated() bool { return false }
+
+// CloseWrite sends the status line and headers if they have not been sent.
+// The response itself ends when the handler returns.
+func (hw *httpWriter) CloseWrite() error {
+	hw.writeHeader()
+	return nil
+}
+
+// CloseRead does nothing: the gateway has read the whole request body before
+// the handler runs.
+func (hw *httpWriter) CloseRead() error { return nil }
+
+func (hw *httpWriter) writeHeader() {
+	if hw.wroteHeader {
+		return
+	}
+	hw.wroteHeader = true
+	status := hw.status
+	if status == "" {
+		status = velocity.StatusOK
+	}
+	hw.w.WriteHeader(velocity.HTTPStatus(status))
+}
+
+// finish sends the headers if the handler sent no response at all, so that
+// the HTTP client is not left with an implicit 200.
+func (hw *httpWriter) finish() {
+	if !hw.wroteHeader {
+		if hw.status == "" {
+			hw.status = velocity.StatusNoContent
+		}
+		hw.writeHeader()
+	}
+}
@@ -0,0 +1,104 @@
+package velocity
+
+import "net/http"
+
+// HTTPStatus returns the HTTP status code corresponding to a WEB/1 status,
+// for adapters between WEB/1 and HTTP such as the httpgw package. Unknown
+// statuses map to 502 Bad Gateway.
+func HTTPStatus(status string) int {
+	switch status {
+	case StatusOK:
+		return http.StatusOK
+	case StatusCreated:
+		return http.StatusCreated
+	case StatusAccepted:
+		return http.StatusAccepted
+	case StatusNoContent:
+		return http.StatusNoContent
+	case StatusBadRequest:
+		return http.StatusBadRequest
+	case StatusUnauthorized:
+		return http.StatusUnauthorized
+	case StatusForbidden:
+		return http.StatusForbidden
+	case StatusNotFound:
+		return http.StatusNotFound
+	case StatusConflict:
+		return http.StatusConflict
+	case StatusRateLimited:
+		return http.StatusTooManyRequests
+	case StatusInternalError:
+		return http.StatusInternalServerError
+	case StatusUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// MethodFromHTTP returns the WEB/1 method corresponding to an HTTP method:
+// GET and HEAD map to read, POST to write, PUT and PATCH to update, and
+// DELETE to delete. The second return value is false for other methods.
+func MethodFromHTTP(method string) (string, bool) {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return MethodRead, true
+	case http.MethodPost:
+		return MethodWrite, true
+	case http.MethodPut, http.MethodPatch:
+		return MethodUpdate, true
+	case http.MethodDelete:
+		return MethodDelete, true
+	}
+	return "", false
+}
+
+// StatusFromHTTP returns the WEB/1 status corresponding to an HTTP status
+// code, for adapters such as ReverseProxy. Codes without a close WEB/1
+// equivalent map to the nearest class: other 2xx codes to ok, other 4xx codes
+// to bad_request, and 1xx, 3xx, and other 5xx codes to internal_error.
+func StatusFromHTTP(code int) string {
+	switch code {
+	case http.StatusCreated:
+		return StatusCreated
+	case http.StatusAccepted:
+		return StatusAccepted
+	case http.StatusNoContent:
+		return StatusNoContent
+	case http.StatusUnauthorized:
+		return StatusUnauthorized
+	case http.StatusForbidden:
+		return StatusForbidden
+	case http.StatusNotFound, http.StatusGone:
+		return StatusNotFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return StatusConflict
+	case http.StatusTooManyRequests:
+		return StatusRateLimited
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return StatusUnavailable
+	}
+	switch {
+	case code >= 200 && code < 300:
+		return StatusOK
+	case code >= 400 && code < 500:
+		return StatusBadRequest
+	}
+	return StatusInternalError
+}
+
+// HTTPMethod returns the HTTP method corresponding to a WEB/1 method: read
+// maps to GET, write to POST, update to PUT, and delete to DELETE. The second
+// return value is false for other methods.
+func HTTPMethod(method string) (string, bool) {
+	switch method {
+	case MethodRead:
+		return http.MethodGet, true
+	case MethodWrite:
+		return http.MethodPost, true
+	case MethodUpdate:
+		return http.MethodPut, true
+	case MethodDelete:
+		return http.MethodDelete, true
+	}
+	return "", false
+}
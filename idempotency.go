@@ -0,0 +1,226 @@
+package velocity
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HeaderIdempotencyKey is the request header that names a write for
+// Idempotency. Clients send the same key when they retransmit the request.
+const HeaderIdempotencyKey = "idempotency-key"
+
+// HeaderIdempotentReplay is set to "true" on responses that Idempotency
+// replayed from an earlier request instead of running the handler.
+const HeaderIdempotentReplay = "idempotent-replay"
+
+// DefaultIdempotencyTTL is how long Idempotency keeps a response for replay
+// unless IdempotencyOptions.TTL says otherwise.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// IdempotencyOptions configures Idempotency.
+type IdempotencyOptions struct {
+	// TTL is how long a response is kept for replay after the request
+	// that produced it completes. Zero means DefaultIdempotencyTTL.
+	TTL time.Duration
+
+	// UseRequestID keys requests without a HeaderIdempotencyKey header by
+	// their request ID (see Context.RequestID), for clients whose
+	// retransmissions reuse it. Without it, such requests are not
+	// deduplicated.
+	UseRequestID bool
+
+	// Skipper, if set, selects write and update requests to run without
+	// deduplication, as if they carried no key.
+	Skipper Skipper
+}
+
+// Idempotency returns middleware that makes write and update requests safe to
+// retry. The first request with a given HeaderIdempotencyKey runs the handler
+// and its response is stored; a retry with the same key, from the same peer
+// to the same method and path, within opts.TTL gets the stored response,
+// marked with HeaderIdempotentReplay, without running the handler again:
+//
+//	srv.Router().Write("/orders", createOrder, velocity.Idempotency(velocity.IdempotencyOptions{}))
+//
+// A retry that arrives while the first request is still running is answered
+// with status "conflict". Responses are not stored, so that a retry runs the
+// handler again, if the handler returns an error, aborts a stream, or
+// responds with "internal_error" or "unavailable". Requests with other
+// methods pass through untouched.
+//
+// Responses are kept in memory, per call to Idempotency, and expired ones are
+// discarded as new requests arrive. Idempotency panics if opts.TTL is
+// negative.
+func Idempotency(opts IdempotencyOptions) MiddlewareFunc {
+	if opts.TTL < 0 {
+		panic(fmt.Sprintf("velocity: idempotency TTL must not be negative, got %s", opts.TTL))
+	}
+	if opts.TTL == 0 {
+		opts.TTL = DefaultIdempotencyTTL
+	}
+	st := &idempotencyStore{ttl: opts.TTL, entries: make(map[string]*idempotencyEntry)}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if m := c.Method(); m != MethodWrite && m != MethodUpdate {
+				return next(c)
+			}
+			if opts.Skipper != nil && opts.Skipper(c) {
+				return next(c)
+			}
+			key, _ := c.Header(HeaderIdempotencyKey)
+			if key == "" {
+				if !opts.UseRequestID {
+					return next(c)
+				}
+				id := c.RequestID()
+				key = hex.EncodeToString(id[:])
+			}
+			peer := c.PeerNodeID()
+			key = string(peer[:]) + "\x00" + c.Method() + "\x00" + c.Path() + "\x00" + key
+
+			resp, busy := st.begin(key, time.Now())
+			if resp != nil {
+				for _, h := range resp.Headers {
+					c.SetHeader(h.Name, h.Value)
+				}
+				c.SetHeader(HeaderIdempotentReplay, "true")
+				return c.Respond(resp.Status, resp.Body)
+			}
+			if busy {
+				return c.Error(StatusConflict, "request with this idempotency key is in progress")
+			}
+
+			// The deferred calls also run if next panics, so that a
+			// recovering middleware further out does not leave the key
+			// reserved or the context writing through the recorder.
+			rec := NewRecorder()
+			w := c.w
+			c.w = &teeWriter{ResponseWriter: w, rec: rec}
+			stored := false
+			defer func() {
+				c.w = w
+				if !stored {
+					st.abort(key)
+				}
+			}()
+			err := next(c)
+			if err == nil && idempotentStorable(rec) {
+				st.finish(key, rec, time.Now())
+				stored = true
+			}
+			return err
+		}
+	}
+}
+
+// idempotentStorable reports whether rec is a complete response worth
+// replaying.
+func idempotentStorable(rec *ResponseRecorder) bool {
+	if !rec.Responded && !(rec.Closed && rec.CloseCode == 0) {
+		return false
+	}
+	if rec.Status == "" {
+		rec.Status = StatusOK
+	}
+	return rec.Status != StatusInternalError && rec.Status != StatusUnavailable
+}
+
+// idempotencyEntry is a request seen by Idempotency. resp is nil while the
+// request is running.
+type idempotencyEntry struct {
+	resp    *ResponseRecorder
+	expires time.Time
+}
+
+type idempotencyStore struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+// begin looks up key. It returns the stored response if there is one, and
+// busy if a request with key is running. Otherwise it reserves key for the
+// caller, which must then call finish or abort.
+func (st *idempotencyStore) begin(key string, now time.Time) (resp *ResponseRecorder, busy bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.sweep(now)
+	if e, ok := st.entries[key]; ok {
+		if e.resp == nil {
+			return nil, true
+		}
+		if now.Before(e.expires) {
+			return e.resp, false
+		}
+	}
+	st.entries[key] = &idempotencyEntry{}
+	return nil, false
+}
+
+func (st *idempotencyStore) finish(key string, resp *ResponseRecorder, now time.Time) {
+	st.mu.Lock()
+	st.entries[key] = &idempotencyEntry{resp: resp, expires: now.Add(st.ttl)}
+	st.mu.Unlock()
+}
+
+func (st *idempotencyStore) abort(key string) {
+	st.mu.Lock()
+	delete(st.entries, key)
+	st.mu.Unlock()
+}
+
+// sweep discards expired responses. It runs at most once a minute, or once
+// per TTL if that is shorter. The caller must hold st.mu.
+func (st *idempotencyStore) sweep(now time.Time) {
+	if now.Sub(st.lastSweep) < min(st.ttl, time.Minute) {
+		return
+	}
+	st.lastSweep = now
+	for k, e := range st.entries {
+		if e.resp != nil && !now.Before(e.expires) {
+			delete(st.entries, k)
+		}
+	}
+}
+
+// teeWriter is a ResponseWriter that passes everything on to the wrapped
+// ResponseWriter and also records it in rec.
+type teeWriter struct {
+	ResponseWriter
+	rec *ResponseRecorder
+}
+
+func (t *teeWriter) SetStatus(status string) {
+	t.rec.SetStatus(status)
+	t.ResponseWriter.SetStatus(status)
+}
+
+func (t *teeWriter) SetHeader(name, value string) {
+	t.rec.SetHeader(name, value)
+	t.ResponseWriter.SetHeader(name, value)
+}
+
+func (t *teeWriter) Respond(status string, body []byte) error {
+	_ = t.rec.Respond(status, body)
+	return t.ResponseWriter.Respond(status, body)
+}
+
+func (t *teeWriter) Write(body []byte) error {
+	_ = t.rec.Write(body)
+	return t.ResponseWriter.Write(body)
+}
+
+func (t *teeWriter) StreamWrite(data []byte) (int, error) {
+	n, err := t.ResponseWriter.StreamWrite(data)
+	_, _ = t.rec.StreamWrite(data[:n])
+	return n, err
+}
+
+func (t *teeWriter) StreamClose(errCode int) {
+	t.rec.StreamClose(errCode)
+	t.ResponseWriter.StreamClose(errCode)
+}
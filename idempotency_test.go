@@ -0,0 +1,94 @@
+package velocity
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	h := Idempotency(IdempotencyOptions{UseRequestID: true})(func(c *Context) error {
+		calls++
+		c.SetHeader("x-order", "42")
+		return c.Created([]byte("order 42"))
+	})
+
+	serve := func(method string, id byte) *ResponseRecorder {
+		c, rec := NewTestContext(method, "/orders", nil)
+		c.Request.RequestID[0] = id
+		if err := h(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	first := serve(MethodWrite, 1)
+	retry := serve(MethodWrite, 1)
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls)
+	}
+	if v, _ := retry.Header(HeaderIdempotentReplay); v != "true" || retry.Status != first.Status || string(retry.Body) != "order 42" {
+		t.Fatalf("replay: %q %q %q", v, retry.Status, retry.Body)
+	}
+	if v, _ := retry.Header("x-order"); v != "42" {
+		t.Fatal("replay lost headers")
+	}
+
+	serve(MethodWrite, 2)
+	serve(MethodRead, 1)
+	serve(MethodRead, 1)
+	if calls != 4 {
+		t.Fatalf("handler ran %d times, want 4", calls)
+	}
+}
+
+func TestIdempotencyPanic(t *testing.T) {
+	fail := true
+	h := Idempotency(IdempotencyOptions{UseRequestID: true})(func(c *Context) error {
+		if fail {
+			panic("boom")
+		}
+		return c.OK([]byte("done"))
+	})
+
+	c, rec := NewTestContext(MethodWrite, "/orders", nil)
+	w := c.w
+	func() {
+		defer func() { recover() }()
+		h(c)
+	}()
+	if c.w != w {
+		t.Fatal("writer not restored after a panic")
+	}
+
+	fail = false
+	c, rec = NewTestContext(MethodWrite, "/orders", nil)
+	if err := h(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != StatusOK || string(rec.Body) != "done" {
+		t.Fatalf("retry after a panic: %q %q", rec.Status, rec.Body)
+	}
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	st := &idempotencyStore{ttl: time.Minute, entries: make(map[string]*idempotencyEntry)}
+	now := time.Now()
+	if resp, busy := st.begin("k", now); resp != nil || busy {
+		t.Fatal("fresh key not reserved")
+	}
+	if _, busy := st.begin("k", now); !busy {
+		t.Fatal("running key not busy")
+	}
+	st.finish("k", &ResponseRecorder{Status: StatusOK}, now)
+	if resp, _ := st.begin("k", now.Add(time.Second)); resp == nil {
+		t.Fatal("stored response not replayed")
+	}
+	if resp, busy := st.begin("k", now.Add(2*time.Minute)); resp != nil || busy {
+		t.Fatal("expired response replayed")
+	}
+	st.abort("k")
+	if len(st.entries) != 0 {
+		t.Fatal("aborted key kept")
+	}
+}
@@ -0,0 +1,150 @@
+package velocity
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// requestSet tracks the requests currently being handled, so that they can be
+// cancelled together. Each entry holds the cancel function of the request's
+// root context, which every context returned by Context.Ctx derives from, and
+// the connection the request arrived on.
+type requestSet struct {
+	mu     sync.Mutex
+	active map[*Context]activeRequest
+}
+
+type activeRequest struct {
+	cancel context.CancelCauseFunc
+	conn   *nwep.Conn
+}
+
+// begin creates the root context for c's request and starts tracking it. The
+// caller must call end when the request finishes.
+func (rs *requestSet) begin(c *Context) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	c.base = ctx
+	var conn *nwep.Conn
+	if c.Request != nil {
+		conn = c.Request.Conn
+	}
+	rs.mu.Lock()
+	if rs.active == nil {
+		rs.active = make(map[*Context]activeRequest)
+	}
+	rs.active[c] = activeRequest{cancel: cancel, conn: conn}
+	rs.mu.Unlock()
+}
+
+// end stops tracking c's request and releases its root context.
+func (rs *requestSet) end(c *Context) {
+	rs.mu.Lock()
+	ar, ok := rs.active[c]
+	delete(rs.active, c)
+	rs.mu.Unlock()
+	if ok {
+		ar.cancel(context.Canceled)
+	}
+}
+
+// cancelAll cancels every tracked request with cause and returns how many
+// there were.
+func (rs *requestSet) cancelAll(cause error) int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, ar := range rs.active {
+		ar.cancel(cause)
+	}
+	return len(rs.active)
+}
+
+// count returns the number of tracked requests.
+func (rs *requestSet) count() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return len(rs.active)
+}
+
+// cancelConn cancels the tracked requests that arrived on conn with cause.
+func (rs *requestSet) cancelConn(conn *nwep.Conn, cause error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, ar := range rs.active {
+		if ar.conn == conn {
+			ar.cancel(cause)
+		}
+	}
+}
+
+// CancelAll cancels the Context.Ctx of every request currently being handled,
+// so that handlers observing it stop early, and returns the number of
+// requests cancelled. It is an emergency lever, distinct from Shutdown: the
+// server keeps running, and requests that arrive afterwards are served
+// normally.
+//
+// Cancellation is cooperative. Handlers are not interrupted, but their
+// context reports ErrRequestCancelled as its cause (see context.Cause). If a
+// cancelled handler returns without responding, the server responds with
+// status "unavailable".
+func (s *Server) CancelAll() int {
+	n := s.requests.cancelAll(ErrRequestCancelled)
+	if n > 0 {
+		s.logger.Warn("cancelled in-flight requests", "count", n)
+	}
+	return n
+}
+
+// cancelled reports whether the request was cancelled by Server.CancelAll.
+func (c *Context) cancelled() bool {
+	return c.base != nil && context.Cause(c.base) == ErrRequestCancelled
+}
+
+// InFlight returns the number of requests whose handlers are currently
+// running. Requests rejected before routing, for example while draining, are
+// not counted.
+func (s *Server) InFlight() int {
+	return s.requests.count()
+}
+
+// Drain puts the server into drain mode, for taking it out of a load
+// balancer's rotation without dropping work: requests that arrive afterwards
+// are rejected with status "unavailable" and the message "server draining",
+// or the response set with WithDrainResponse, while requests already being
+// handled run to completion. Poll InFlight to learn when they have finished,
+// then call Shutdown. Acknowledgements for NotifyWithAck are still accepted.
+//
+// Drain returns immediately. Calling it again has no further effect, and
+// Resume leaves drain mode.
+func (s *Server) Drain() {
+	if !s.draining.Swap(true) {
+		s.logger.Info("draining", "in_flight", s.InFlight())
+	}
+}
+
+// Resume leaves drain mode, so that new requests are served again.
+func (s *Server) Resume() {
+	s.draining.Store(false)
+}
+
+// Draining reports whether the server is in drain mode.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// WithDrainResponse sets the status and message sent to requests that arrive
+// while the server is draining (see Server.Drain). The default is status
+// "unavailable" with the message "server draining". This function returns an
+// error if status is empty.
+func WithDrainResponse(status, message string) Option {
+	return func(s *Server) error {
+		if status == "" {
+			return errors.New("velocity: drain status must not be empty")
+		}
+		s.drainStatus = status
+		s.drainMessage = message
+		return nil
+	}
+}
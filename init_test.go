@@ -0,0 +1,43 @@
+package velocity
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInitRetriesAfterFailure(t *testing.T) {
+	saved := nwepInit
+	initMu.Lock()
+	savedDone := initDone
+	initDone = false
+	initMu.Unlock()
+	t.Cleanup(func() {
+		nwepInit = saved
+		initMu.Lock()
+		initDone = savedDone
+		initMu.Unlock()
+	})
+
+	calls := 0
+	errInit := errors.New("init failed")
+	nwepInit = func() error {
+		calls++
+		if calls == 1 {
+			return errInit
+		}
+		return nil
+	}
+
+	if err := initNWEP(); !errors.Is(err, errInit) {
+		t.Fatalf("first init: err = %v, want %v", err, errInit)
+	}
+	if err := initNWEP(); err != nil {
+		t.Fatalf("retry: err = %v, want nil", err)
+	}
+	if err := initNWEP(); err != nil {
+		t.Fatalf("after success: err = %v, want nil", err)
+	}
+	if calls != 2 {
+		t.Fatalf("nwep.Init called %d times, want 2", calls)
+	}
+}
@@ -0,0 +1,99 @@
+package velocity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// KeyProvider supplies a server's keypair from wherever keys are kept: a
+// file, the environment, or a secrets manager such as HashiCorp Vault or a
+// cloud KMS. LocalKeyProvider is the file and environment implementation.
+//
+// The WEB/1 handshake is performed by nwep, which needs the Ed25519 seed in
+// an *nwep.Keypair. A provider whose key cannot leave its store, such as a
+// PKCS#11 token, should return ErrKeyNotExportable from Keypair and
+// implement Signer instead; velocity uses a Signer for the signatures it
+// makes itself (see SignKeyBinding), but cannot serve connections with one
+// until nwep accepts an external signer for the handshake.
+type KeyProvider interface {
+	// Keypair returns the keypair to serve with. ctx bounds any remote
+	// calls the provider makes.
+	Keypair(ctx context.Context) (*nwep.Keypair, error)
+}
+
+// KeyProviderFunc adapts a function to the KeyProvider interface.
+type KeyProviderFunc func(ctx context.Context) (*nwep.Keypair, error)
+
+// Keypair calls f(ctx).
+func (f KeyProviderFunc) Keypair(ctx context.Context) (*nwep.Keypair, error) { return f(ctx) }
+
+// Signer signs with an Ed25519 private key that need not be exportable. It
+// is the interface a hardware- or KMS-backed KeyProvider implements for the
+// keys it holds. KeypairSigner adapts an *nwep.Keypair.
+type Signer interface {
+	// PublicKey returns the Ed25519 public key.
+	PublicKey() [32]byte
+
+	// NodeID returns the WEB/1 node ID of the key.
+	NodeID() (nwep.NodeID, error)
+
+	// Sign returns the Ed25519 signature of msg.
+	Sign(msg []byte) ([64]byte, error)
+}
+
+// KeypairSigner returns a Signer that signs with kp.
+func KeypairSigner(kp *nwep.Keypair) Signer { return keypairSigner{kp} }
+
+type keypairSigner struct{ kp *nwep.Keypair }
+
+func (k keypairSigner) PublicKey() [32]byte               { return k.kp.PublicKey() }
+func (k keypairSigner) NodeID() (nwep.NodeID, error)      { return k.kp.NodeID() }
+func (k keypairSigner) Sign(msg []byte) ([64]byte, error) { return nwep.Sign(k.kp, msg) }
+
+// LocalKeyProvider is a KeyProvider that reads the keypair from a seed file
+// or an environment variable, as WithKeyFile and WithKeyEnv do. File is tried
+// first: a missing file is generated, as by LoadOrGenerateKeypair. Env is
+// used only if File is empty.
+type LocalKeyProvider struct {
+	File string
+	Env  string
+}
+
+// Keypair loads the keypair. It returns an error if neither File nor Env is
+// set, or if loading fails.
+func (p LocalKeyProvider) Keypair(context.Context) (*nwep.Keypair, error) {
+	switch {
+	case p.File != "":
+		return LoadOrGenerateKeypair(p.File)
+	case p.Env != "":
+		return KeypairFromEnv(p.Env)
+	}
+	return nil, errors.New("velocity: local key provider: neither File nor Env is set")
+}
+
+// keyProviderTimeout bounds WithKeyProvider's call to the provider.
+const keyProviderTimeout = 30 * time.Second
+
+// WithKeyProvider sets the server's keypair to the one p supplies. The
+// provider is called once, while New runs, with a 30-second timeout. This
+// option returns an error if the provider fails; a provider returning
+// ErrKeyNotExportable cannot be used to serve, as KeyProvider explains.
+func WithKeyProvider(p KeyProvider) Option {
+	return func(s *Server) error {
+		ctx, cancel := context.WithTimeout(context.Background(), keyProviderTimeout)
+		defer cancel()
+		kp, err := p.Keypair(ctx)
+		if err != nil {
+			return fmt.Errorf("velocity: key provider: %w", err)
+		}
+		if kp == nil {
+			return errors.New("velocity: key provider returned no keypair")
+		}
+		s.keypair = kp
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package velocity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// KeyRotation describes a keypair rotation in progress. It is passed to the
+// OnKeyRotate hooks before the server switches to the new keypair.
+type KeyRotation struct {
+	Old, New     *nwep.Keypair
+	OldID, NewID nwep.NodeID
+
+	// Binding is the key-binding log entry for New, signed with New, ready
+	// to be appended to a Merkle log so that peers can look the new
+	// identity up. See KeyBindingEntry.
+	Binding *nwep.MerkleEntry
+}
+
+// KeyRotateFunc is a hook run by Server.RotateKeypair. See OnKeyRotate.
+type KeyRotateFunc func(s *Server, r *KeyRotation) error
+
+// OnKeyRotate registers fn to run during Server.RotateKeypair, after the new
+// keypair's binding entry is built and before the server switches to it.
+// Hooks run in registration order; if one returns an error the rotation is
+// abandoned and the server keeps its current keypair, so a key is never put
+// into service without its binding having been published. AppendKeyBinding
+// and PostKeyBinding return hooks that publish the binding to a log.
+func OnKeyRotate(fn KeyRotateFunc) Option {
+	return func(s *Server) error {
+		s.onKeyRotate = append(s.onKeyRotate, fn)
+		return nil
+	}
+}
+
+// KeyBindingEntry returns a key-binding Merkle log entry for kp, timestamped
+// now and signed with kp, in the form nwep log servers accept. It is
+// SignKeyBinding(KeypairSigner(kp)).
+func KeyBindingEntry(kp *nwep.Keypair) (*nwep.MerkleEntry, error) {
+	return SignKeyBinding(KeypairSigner(kp))
+}
+
+// SignKeyBinding returns a key-binding Merkle log entry for the key held by
+// signer, timestamped now and signed by it. Unlike KeyBindingEntry it works
+// with keys that cannot be exported (see KeyProvider).
+func SignKeyBinding(signer Signer) (*nwep.MerkleEntry, error) {
+	id, err := signer.NodeID()
+	if err != nil {
+		return nil, fmt.Errorf("velocity: key binding: %w", err)
+	}
+	entry := &nwep.MerkleEntry{
+		Type:      nwep.LogEntryKeyBinding,
+		Timestamp: uint64(time.Now().UnixNano()),
+		NodeID:    id,
+		Pubkey:    signer.PublicKey(),
+	}
+	encoded, err := nwep.MerkleEntryEncode(entry)
+	if err != nil {
+		return nil, fmt.Errorf("velocity: key binding: %w", err)
+	}
+	if entry.Signature, err = signer.Sign(encoded); err != nil {
+		return nil, fmt.Errorf("velocity: key binding: %w", err)
+	}
+	return entry, nil
+}
+
+// AppendKeyBinding returns a KeyRotateFunc that appends the new key's binding
+// to ml, for a server that runs its own log.
+func AppendKeyBinding(ml *nwep.MerkleLog) KeyRotateFunc {
+	return func(_ *Server, r *KeyRotation) error {
+		if _, err := ml.Append(r.Binding); err != nil {
+			return fmt.Errorf("velocity: append key binding: %w", err)
+		}
+		return nil
+	}
+}
+
+// PostKeyBinding returns a KeyRotateFunc that writes the new key's binding to
+// the log server c is connected to, at its /log/entry route. An error status
+// fails the rotation like any other error.
+func PostKeyBinding(c *Client) KeyRotateFunc {
+	return func(_ *Server, r *KeyRotation) error {
+		encoded, err := nwep.MerkleEntryEncode(r.Binding)
+		if err != nil {
+			return fmt.Errorf("velocity: encode key binding: %w", err)
+		}
+		resp, err := c.Write(context.Background(), "/log/entry", encoded)
+		if err == nil {
+			err = ResponseError(resp)
+		}
+		if err != nil {
+			return fmt.Errorf("velocity: post key binding: %w", err)
+		}
+		return nil
+	}
+}
+
+// RotateKeypair switches the server to kp without downtime. New connections
+// authenticate with kp, and so see the new node ID, while connections
+// established before the rotation stay open with the old identity until they
+// close; the caller should not Clear the old keypair while they may remain.
+// The rotation is logged, and NodeID and URL report the new identity
+// afterwards.
+//
+// Before switching, RotateKeypair builds the binding entry for kp and runs the
+// OnKeyRotate hooks; a hook error abandons the rotation and is returned. Before
+// Start, the keypair is simply replaced, once the hooks succeed.
+func (s *Server) RotateKeypair(kp *nwep.Keypair) error {
+	if kp == nil {
+		return errors.New("velocity: rotate keypair: nil keypair")
+	}
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+
+	r := &KeyRotation{Old: s.keypair, New: kp}
+	var err error
+	if r.OldID, err = s.keypair.NodeID(); err != nil {
+		return fmt.Errorf("velocity: rotate keypair: %w", err)
+	}
+	if r.NewID, err = kp.NodeID(); err != nil {
+		return fmt.Errorf("velocity: rotate keypair: %w", err)
+	}
+	if r.Binding, err = KeyBindingEntry(kp); err != nil {
+		return err
+	}
+	for _, fn := range s.onKeyRotate {
+		if err := fn(s, r); err != nil {
+			return fmt.Errorf("velocity: rotate keypair: %w", err)
+		}
+	}
+
+	listeners := s.allListeners()
+	for i, l := range listeners {
+		if err := l.SetKeypair(kp); err != nil {
+			// Put listeners already switched back on the old key, so that
+			// the server keeps one identity.
+			for _, prev := range listeners[:i] {
+				_ = prev.SetKeypair(r.Old)
+			}
+			return fmt.Errorf("velocity: rotate keypair: %w", err)
+		}
+	}
+	s.keypair = kp
+	s.logger.Info("keypair rotated", "old", FormatNodeID(r.OldID), "new", FormatNodeID(r.NewID))
+	return nil
+}
@@ -0,0 +1,75 @@
+package velocity
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestRotateKeypair(t *testing.T) {
+	var calls []*KeyRotation
+	fail := errors.New("log unavailable")
+	var failing bool
+	s, err := New(":0", OnKeyRotate(func(_ *Server, r *KeyRotation) error {
+		if failing {
+			return fail
+		}
+		calls = append(calls, r)
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := s.keypair
+	kp, err := nwep.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing = true
+	if err := s.RotateKeypair(kp); !errors.Is(err, fail) {
+		t.Fatalf("RotateKeypair with failing hook = %v", err)
+	}
+	if s.keypair != old {
+		t.Fatal("keypair replaced despite hook error")
+	}
+
+	failing = false
+	if err := s.RotateKeypair(kp); err != nil {
+		t.Fatal(err)
+	}
+	if s.keypair != kp {
+		t.Error("keypair not replaced")
+	}
+	if len(calls) != 1 || calls[0].Old != old || calls[0].New != kp || calls[0].Binding == nil {
+		t.Fatalf("hook calls = %+v", calls)
+	}
+	if b := calls[0].Binding; b.Type != nwep.LogEntryKeyBinding || b.NodeID != calls[0].NewID || b.Pubkey != kp.PublicKey() {
+		t.Errorf("binding entry = %+v", b)
+	}
+	if err := s.RotateKeypair(nil); err == nil {
+		t.Error("RotateKeypair accepted a nil keypair")
+	}
+}
+
+func TestKeyProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.key")
+	s := &Server{}
+	if err := WithKeyProvider(LocalKeyProvider{File: path, Env: "UNUSED"})(s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil || s.keypair == nil {
+		t.Errorf("key file not generated: %v", err)
+	}
+	if _, err := (LocalKeyProvider{}).Keypair(context.Background()); err == nil {
+		t.Error("empty LocalKeyProvider returned a keypair")
+	}
+	hsm := KeyProviderFunc(func(context.Context) (*nwep.Keypair, error) { return nil, ErrKeyNotExportable })
+	if err := WithKeyProvider(hsm)(s); !errors.Is(err, ErrKeyNotExportable) {
+		t.Errorf("WithKeyProvider(hsm) = %v", err)
+	}
+}
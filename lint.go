@@ -0,0 +1,192 @@
+package velocity
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// MiddlewareRule checks one composed middleware chain and returns a non-nil
+// error describing any ordering violation. chain holds the names of the
+// middleware in execution order, outermost first, as returned by
+// MiddlewareName. Rules are registered with Server.AddMiddlewareRule and run
+// by Server.ValidateMiddleware.
+type MiddlewareRule func(chain []string) error
+
+// middlewareAliases maps constructor names that build the same middleware to
+// the canonical name used in rules.
+var middlewareAliases = map[string]string{
+	"velocity.RecoverWith":                  "velocity.Recover",
+	"velocity.RequestLoggerWith":            "velocity.RequestLogger",
+	"velocity.AccessLog":                    "velocity.RequestLogger",
+	"velocity.BodyDumpWith":                 "velocity.BodyDump",
+	"velocity.(*routeSchema).middleware-fm": "velocity.WithSchema",
+}
+
+// defaultMiddlewareRules are the ordering constraints documented for the
+// built-in middleware. They are always checked by ValidateMiddleware.
+var defaultMiddlewareRules = []MiddlewareRule{
+	MiddlewareFirst("velocity.Recover"),
+	MiddlewareRequires("velocity.RequireTrust", "velocity.TrustVerify"),
+	MiddlewareRequires("velocity.RequireRole", "velocity.TrustVerify"),
+}
+
+// MiddlewareName returns a name for mw derived from the function that
+// constructed it, qualified by the last element of its package path - for
+// example "velocity.Recover" for the result of velocity.Recover(), or
+// "main.timing" for a middleware returned by a timing function in package
+// main. Middleware written as a named function rather than a closure is named
+// after that function. The name is used by MiddlewareRule checks.
+func MiddlewareName(mw MiddlewareFunc) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(mw).Pointer())
+	if fn == nil {
+		return ""
+	}
+	name := fn.Name()
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	// Strip the closure suffixes (".func1", ".func1.2", ...) to get the
+	// constructor.
+	for {
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		if !isClosureSuffix(name[i+1:]) {
+			break
+		}
+		name = name[:i]
+	}
+	if alias, ok := middlewareAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// isClosureSuffix reports whether s is a compiler-generated closure name
+// element such as "func1" or "2".
+func isClosureSuffix(s string) bool {
+	s = strings.TrimPrefix(s, "func")
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// MiddlewareFirst returns a rule requiring that the named middleware, when
+// present in a chain, is the outermost middleware.
+func MiddlewareFirst(name string) MiddlewareRule {
+	return func(chain []string) error {
+		if i := slices.Index(chain, name); i > 0 {
+			return fmt.Errorf("%w: %s must be the first middleware, found after %s",
+				ErrMiddlewareOrder, name, chain[i-1])
+		}
+		return nil
+	}
+}
+
+// MiddlewareBefore returns a rule requiring that, when both are present in a
+// chain, the middleware named before runs earlier than the one named after.
+func MiddlewareBefore(before, after string) MiddlewareRule {
+	return func(chain []string) error {
+		b, a := slices.Index(chain, before), slices.Index(chain, after)
+		if b >= 0 && a >= 0 && b > a {
+			return fmt.Errorf("%w: %s must come before %s", ErrMiddlewareOrder, before, after)
+		}
+		return nil
+	}
+}
+
+// MiddlewareRequires returns a rule requiring that, when the middleware named
+// name is present in a chain, the middleware named dep is also present and
+// runs earlier - for example a middleware that reads a value dep stores in
+// the Context.
+func MiddlewareRequires(name, dep string) MiddlewareRule {
+	return func(chain []string) error {
+		n := slices.Index(chain, name)
+		if n < 0 {
+			return nil
+		}
+		if d := slices.Index(chain, dep); d < 0 || d > n {
+			return fmt.Errorf("%w: %s requires a preceding %s", ErrMiddlewareOrder, name, dep)
+		}
+		return nil
+	}
+}
+
+// AddMiddlewareRule registers custom ordering rules that ValidateMiddleware
+// checks in addition to the built-in ones. It must be called before Run or
+// Start.
+func (s *Server) AddMiddlewareRule(rules ...MiddlewareRule) {
+	s.mwRules = append(s.mwRules, rules...)
+}
+
+// ValidateMiddleware checks every composed middleware chain - global
+// middleware followed by each route's group and route middleware, including
+// the routes of mounted services (see Mount) - against the built-in ordering
+// rules and those added with AddMiddlewareRule. The built-in rules encode the
+// documented constraints, such as Recover running first.
+//
+// It returns nil if all chains are valid, or an error joining one
+// ErrMiddlewareOrder violation per offending route. A violation within the
+// global middleware alone is reported once, for the global middleware. ValidateMiddleware is run
+// automatically by Start when the server is configured with
+// WithMiddlewareValidation.
+func (s *Server) ValidateMiddleware() error {
+	rules := append(slices.Clone(defaultMiddlewareRules), s.mwRules...)
+	global := middlewareNames(s.mw)
+
+	var errs []error
+	// A violation within the global middleware is reported once, not again
+	// for every route that adds middleware of its own.
+	globalErrs := make(map[string]bool)
+	check := func(where string, chain []string) {
+		for _, rule := range rules {
+			if err := rule(chain); err != nil && !globalErrs[err.Error()] {
+				errs = append(errs, fmt.Errorf("%s: %w", where, err))
+			}
+		}
+	}
+	check("global middleware", global)
+	for _, err := range errs {
+		globalErrs[errors.Unwrap(err).Error()] = true
+	}
+	checkRoutes := func(rt *Router, host string) {
+		for _, r := range rt.routes() {
+			if len(r.middleware) == 0 {
+				continue
+			}
+			where := fmt.Sprintf("route %q", r.pattern)
+			if host != "" {
+				where += fmt.Sprintf(" on %q", host)
+			}
+			check(where, append(slices.Clone(global), middlewareNames(r.middleware)...))
+		}
+	}
+	checkRoutes(s.router, "")
+	for _, host := range slices.Sorted(maps.Keys(s.hosts)) {
+		checkRoutes(s.hosts[host], host)
+	}
+	return errors.Join(errs...)
+}
+
+func middlewareNames(mw []MiddlewareFunc) []string {
+	names := make([]string, len(mw))
+	for i, m := range mw {
+		names[i] = MiddlewareName(m)
+	}
+	return names
+}
+
+// WithMiddlewareValidation makes Start run ValidateMiddleware. If strict is
+// true, a violation makes Start fail with the validation error; otherwise each
+// violation is logged at warn level and startup continues.
+func WithMiddlewareValidation(strict bool) Option {
+	return func(s *Server) error {
+		s.mwValidate = true
+		s.mwValidateStrict = strict
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package velocity
+
+import (
+	"errors"
+	"testing"
+)
+
+func tagMiddleware() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc { return next }
+}
+
+func TestMiddlewareName(t *testing.T) {
+	tests := []struct {
+		mw   MiddlewareFunc
+		want string
+	}{
+		{Recover(), "velocity.Recover"},
+		{RecoverWithResponse(nil), "velocity.Recover"},
+		{RequestLogger(), "velocity.RequestLogger"},
+		{tagMiddleware(), "velocity.tagMiddleware"},
+	}
+	for _, tt := range tests {
+		if got := MiddlewareName(tt.mw); got != tt.want {
+			t.Errorf("MiddlewareName = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestValidateMiddleware(t *testing.T) {
+	s := &Server{router: NewRouter()}
+	s.Use(RequestLogger())
+	s.Handle("/ok", nopHandler)
+	if err := s.ValidateMiddleware(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.Handle("/bad", nopHandler, Recover())
+	err := s.ValidateMiddleware()
+	if !errors.Is(err, ErrMiddlewareOrder) {
+		t.Fatalf("err = %v, want ErrMiddlewareOrder", err)
+	}
+
+	// A violation in the global middleware is reported once, not again for
+	// each route with middleware of its own.
+	s = &Server{router: NewRouter()}
+	s.Use(RequestLogger(), Recover())
+	s.Handle("/a", nopHandler, tagMiddleware())
+	s.Handle("/b", nopHandler, tagMiddleware())
+	err = s.ValidateMiddleware()
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 1 {
+		t.Fatalf("global violation reported %d times: %v", n, err)
+	}
+
+	s = &Server{router: NewRouter()}
+	s.AddMiddlewareRule(MiddlewareRequires("velocity.tagMiddleware", "velocity.RequirePeer"))
+	s.Handle("/tag", nopHandler, tagMiddleware(), RequirePeer())
+	if err := s.ValidateMiddleware(); !errors.Is(err, ErrMiddlewareOrder) {
+		t.Fatalf("err = %v, want ErrMiddlewareOrder", err)
+	}
+}
+
+func TestValidateMiddlewareRequireRole(t *testing.T) {
+	tests := []struct {
+		name string
+		mw   []MiddlewareFunc
+		ok   bool
+	}{
+		{"without TrustVerify", []MiddlewareFunc{RequireRole("admin")}, false},
+		{"before TrustVerify", []MiddlewareFunc{RequireRole("admin"), TrustVerify(nil)}, false},
+		{"after TrustVerify", []MiddlewareFunc{TrustVerify(nil), RequireRole("admin")}, true},
+	}
+	for _, tt := range tests {
+		s := &Server{router: NewRouter()}
+		s.Handle("/admin", nopHandler, tt.mw...)
+		err := s.ValidateMiddleware()
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrMiddlewareOrder) {
+			t.Errorf("%s: err = %v, want ErrMiddlewareOrder", tt.name, err)
+		}
+	}
+}
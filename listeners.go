@@ -0,0 +1,172 @@
+package velocity
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// WithAdditionalAddr makes the server also listen on addr, in "host:port"
+// format, alongside the address passed to New - for example an internal and
+// an external interface, or a second port. Every listener shares the
+// server's keypair, router, middleware, and peer state: a peer is the same
+// peer whichever listener it connects to, and notifications to it go out on
+// the listener its connection arrived on. The option may be given more than
+// once. This option returns an error if addr is empty.
+//
+// Addr and URL describe the primary listener, the one given to New; Addrs and
+// URLs describe them all.
+func WithAdditionalAddr(addr string) Option {
+	return func(s *Server) error {
+		if addr == "" {
+			return errors.New("velocity: additional address must not be empty")
+		}
+		s.extraAddrs = append(s.extraAddrs, addr)
+		return nil
+	}
+}
+
+// listenerSet holds the nwep servers for addresses added with
+// WithAdditionalAddr, and remembers which listener each connected peer
+// arrived on. The primary listener is Server.nwep.
+type listenerSet struct {
+	mu     sync.Mutex
+	extra  []*nwep.Server
+	byPeer map[nwep.NodeID]*nwep.Server
+}
+
+func (ls *listenerSet) add(srv *nwep.Server) {
+	ls.mu.Lock()
+	ls.extra = append(ls.extra, srv)
+	ls.mu.Unlock()
+}
+
+// servers returns the additional listeners.
+func (ls *listenerSet) servers() []*nwep.Server {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return slices.Clone(ls.extra)
+}
+
+// bind records that peer connected on srv.
+func (ls *listenerSet) bind(peer nwep.NodeID, srv *nwep.Server) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.byPeer == nil {
+		ls.byPeer = make(map[nwep.NodeID]*nwep.Server)
+	}
+	ls.byPeer[peer] = srv
+}
+
+// unbind forgets peer's listener if it is srv; the peer may already have
+// reconnected on another one.
+func (ls *listenerSet) unbind(peer nwep.NodeID, srv *nwep.Server) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.byPeer[peer] == srv {
+		delete(ls.byPeer, peer)
+	}
+}
+
+// lookup returns the listener peer is connected on, if known.
+func (ls *listenerSet) lookup(peer nwep.NodeID) *nwep.Server {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.byPeer[peer]
+}
+
+// listen creates an nwep server on addr whose connection callbacks record
+// the listener each peer arrives on.
+func (s *Server) listen(addr string, handler nwep.HandlerFunc, opts []nwep.ServerOption) (*nwep.Server, error) {
+	var srv *nwep.Server
+	opts = append(slices.Clone(opts),
+		nwep.WithOnConnect(func(conn *nwep.Conn) {
+			_, peer := conn.PeerIdentity()
+			s.listeners.bind(peer, srv)
+			s.handleConnect(conn)
+		}),
+		nwep.WithOnDisconnect(func(conn *nwep.Conn, code int) {
+			_, peer := conn.PeerIdentity()
+			s.listeners.unbind(peer, srv)
+			s.handleDisconnect(conn, code)
+		}),
+	)
+	var err error
+	srv, err = nwep.NewServer(addr, s.keypair, handler, opts...)
+	return srv, err
+}
+
+// startAdditional creates the listeners for the WithAdditionalAddr addresses
+// and runs their event loops in the background. On error, listeners already
+// created are shut down.
+func (s *Server) startAdditional(handler nwep.HandlerFunc, opts []nwep.ServerOption) error {
+	var started []*nwep.Server
+	for _, addr := range s.extraAddrs {
+		srv, err := s.listen(addr, handler, opts)
+		if err != nil {
+			for _, l := range started {
+				l.Shutdown()
+			}
+			return fmt.Errorf("velocity: start listener %s: %w", addr, err)
+		}
+		started = append(started, srv)
+	}
+	for _, srv := range started {
+		s.listeners.add(srv)
+		go func() {
+			if err := srv.RunWithoutSignals(); err != nil {
+				s.logger.Error("listener stopped", "addr", fmt.Sprint(srv.Addr()), "error", err.Error())
+			}
+		}()
+	}
+	return nil
+}
+
+// allListeners returns the primary listener followed by the additional ones.
+// It returns nil if the server has not been started.
+func (s *Server) allListeners() []*nwep.Server {
+	if s.nwep == nil {
+		return nil
+	}
+	return append([]*nwep.Server{s.nwep}, s.listeners.servers()...)
+}
+
+// listenerFor returns the listener to reach peer through: the one its
+// connection arrived on, or the primary listener if it is not connected.
+func (s *Server) listenerFor(peer nwep.NodeID) *nwep.Server {
+	if srv := s.listeners.lookup(peer); srv != nil {
+		return srv
+	}
+	return s.nwep
+}
+
+// Addrs returns the resolved address of every listener, the primary one
+// first and then those added with WithAdditionalAddr in order. It returns nil
+// if the server has not been started.
+func (s *Server) Addrs() []net.Addr {
+	var addrs []net.Addr
+	for _, srv := range s.allListeners() {
+		addrs = append(addrs, srv.Addr())
+	}
+	return addrs
+}
+
+// URLs returns the WEB/1 URL for path on every listener, in the order of
+// Addrs. The first URL is the one URL returns, carrying the address set by
+// WithAdvertisedAddr if there is one. It returns nil if the server has not
+// been started.
+func (s *Server) URLs(path string) []string {
+	var urls []string
+	for i, srv := range s.allListeners() {
+		if i == 0 {
+			urls = append(urls, s.URL(path))
+			continue
+		}
+		urls = append(urls, srv.URL(path))
+	}
+	return urls
+}
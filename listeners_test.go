@@ -0,0 +1,19 @@
+package velocity
+
+import "testing"
+
+func TestWithAdditionalAddr(t *testing.T) {
+	s := &Server{}
+	if err := WithAdditionalAddr("")(s); err == nil {
+		t.Error("WithAdditionalAddr accepted an empty address")
+	}
+	if err := (&Config{AdditionalAddrs: []string{":6938", ":6939"}}).Apply(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.extraAddrs) != 2 || s.extraAddrs[1] != ":6939" {
+		t.Errorf("extraAddrs = %v", s.extraAddrs)
+	}
+	if s.Addrs() != nil || s.URLs("/") != nil || s.ConnectionCount() != 0 {
+		t.Error("listener accessors report listeners before Start")
+	}
+}
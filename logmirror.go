@@ -0,0 +1,381 @@
+package velocity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// DefaultLogMirrorInterval is how often a LogMirror polls its upstream unless
+// LogMirrorOptions.Interval says otherwise.
+const DefaultLogMirrorInterval = 30 * time.Second
+
+// DefaultLogMirrorBatch is the most entries a LogMirror copies in one sync
+// unless LogMirrorOptions.MaxPerSync says otherwise.
+const DefaultLogMirrorBatch = 10000
+
+// LogMirrorOptions configures a LogMirror.
+type LogMirrorOptions struct {
+	// Interval is the time between syncs. Zero means
+	// DefaultLogMirrorInterval.
+	Interval time.Duration
+
+	// MaxPerSync is the most entries copied in one sync, so that a new
+	// mirror catches up over several syncs. Zero means
+	// DefaultLogMirrorBatch.
+	MaxPerSync int
+
+	// Checkpoints, if set, limits mirroring to the entries covered by the
+	// newest checkpoint it has accepted. Entries the upstream cannot prove
+	// with RFC 6962 proofs are then held back until all those the
+	// checkpoint covers have been read and their root matches the
+	// checkpoint's, and only then appended.
+	Checkpoints *CheckpointSyncer
+
+	// OnError, if set, is called when a sync fails. Failures are also
+	// logged at warn level.
+	OnError func(err error)
+}
+
+// LogMirror keeps a read replica of another WEB/1 log server's Merkle log. It
+// polls the upstream's /log/size, or /log/root where the upstream is a
+// LogService, copies new entries from /log/entry/{index} into a local
+// LogService, and reports how far behind it is:
+//
+//	upstream, _ := velocity.NewClient(primaryLogURL, velocity.ClientOptions{})
+//	local, _ := velocity.NewLogService(storage, velocity.LogServiceOptions{
+//	    Authorize: func(nwep.NodeID, *nwep.MerkleEntry) error { return errors.New("read-only replica") },
+//	})
+//	local.Mount(srv)
+//	mirror, err := velocity.NewLogMirror(srv, upstream, local, velocity.LogMirrorOptions{})
+//	...
+//	defer mirror.Close()
+//
+// Entries are verified before they are appended. When the upstream serves
+// RFC 6962 tree heads (/log/root) and proofs (/log/consistency), the copied
+// entries must hash to the upstream's root, or be shown by a consistency
+// proof to be a prefix of its log; with LogMirrorOptions.Checkpoints, the
+// local root must also match the newest checkpoint's. On any mismatch, or if
+// the upstream's log shrinks, the mirror stops with ErrLogDiverged and copies
+// nothing more: the upstream has rewritten its history, or served entries it
+// cannot prove.
+//
+// The local LogService must not take writes from anywhere else. The lag
+// reported by Stats is also exported by MetricsHandler.
+type LogMirror struct {
+	srv      *Server
+	upstream *Client
+	local    *LogService
+	opts     LogMirrorOptions
+
+	syncMu  sync.Mutex    // held while syncing
+	pending []mirrorEntry // read but not yet verified; guarded by syncMu
+
+	mu    sync.Mutex
+	stats LogMirrorStats
+
+	ctx       context.Context // canceled by Close
+	stop      context.CancelFunc
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// mirrorEntry is an entry read from the upstream.
+type mirrorEntry struct {
+	entry *nwep.MerkleEntry
+	leaf  LogHash
+}
+
+// LogMirrorStats reports a LogMirror's replication progress.
+type LogMirrorStats struct {
+	// Upstream is the upstream log server's URL.
+	Upstream string
+
+	// UpstreamSize and LocalSize are the sizes of the upstream and local
+	// logs as of the last sync, and Lag the difference.
+	UpstreamSize, LocalSize, Lag uint64
+
+	// Verified reports whether the entries last appended were checked
+	// against the upstream's RFC 6962 tree head or a checkpoint. Entries
+	// are only appended unchecked when the upstream serves no tree heads
+	// and LogMirrorOptions.Checkpoints is nil.
+	Verified bool
+
+	// LastSync is when the last sync finished, and CaughtUp when a sync
+	// last left the mirror with no lag, or when the mirror was created if
+	// none has. LagTime is how long the mirror has not been caught up,
+	// zero if it is.
+	LastSync, CaughtUp time.Time
+	LagTime            time.Duration
+
+	// Err is the last sync's error.
+	Err error
+}
+
+// NewLogMirror returns a mirror that copies the log served by upstream into
+// local. It syncs once right away, in the background, and then every
+// opts.Interval until Close. srv is used for logging and to report the
+// mirror's lag in MetricsHandler.
+//
+// This function returns an error if opts is invalid.
+func NewLogMirror(srv *Server, upstream *Client, local *LogService, opts LogMirrorOptions) (*LogMirror, error) {
+	if upstream == nil || local == nil {
+		return nil, errors.New("velocity: log mirror needs an upstream client and a local log")
+	}
+	if opts.Interval < 0 {
+		return nil, fmt.Errorf("velocity: log mirror interval must not be negative, got %s", opts.Interval)
+	}
+	if opts.Interval == 0 {
+		opts.Interval = DefaultLogMirrorInterval
+	}
+	if opts.MaxPerSync < 0 {
+		return nil, fmt.Errorf("velocity: log mirror MaxPerSync must not be negative, got %d", opts.MaxPerSync)
+	}
+	if opts.MaxPerSync == 0 {
+		opts.MaxPerSync = DefaultLogMirrorBatch
+	}
+	m := &LogMirror{srv: srv, upstream: upstream, local: local, opts: opts}
+	m.stats.Upstream = upstream.url
+	m.stats.LocalSize = local.Size()
+	m.stats.CaughtUp = time.Now()
+	m.ctx, m.stop = context.WithCancel(context.Background())
+	srv.logMirrors.add(m)
+	m.wg.Go(m.loop)
+	return m, nil
+}
+
+// loop syncs now and then after every interval until Close.
+func (m *LogMirror) loop() {
+	t := time.NewTicker(m.opts.Interval)
+	defer t.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(m.ctx, m.opts.Interval)
+		_ = m.Sync(ctx)
+		cancel()
+		select {
+		case <-t.C:
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// Sync copies new entries from the upstream now, as the mirror does every
+// interval. It returns ErrLogDiverged, wrapped, once the upstream's log
+// stops matching the local copy, and the error that stopped the sync
+// otherwise.
+func (m *LogMirror) Sync(ctx context.Context) error {
+	m.syncMu.Lock()
+	defer m.syncMu.Unlock()
+	m.mu.Lock()
+	prev := m.stats.Err
+	m.mu.Unlock()
+	if errors.Is(prev, ErrLogDiverged) {
+		return prev
+	}
+	upstreamSize, appended, verified, err := m.sync(ctx)
+	if err != nil && m.ctx.Err() == nil {
+		m.srv.logger.Warn("log mirror sync failed", "upstream", m.upstream.url, "error", err.Error())
+		if m.opts.OnError != nil {
+			m.opts.OnError(err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	st := &m.stats
+	st.LastSync, st.Err = now, err
+	st.LocalSize = m.local.Size()
+	if err == nil {
+		st.UpstreamSize = upstreamSize
+		if appended {
+			st.Verified = verified
+		}
+	}
+	st.Lag = 0
+	if st.UpstreamSize > st.LocalSize {
+		st.Lag = st.UpstreamSize - st.LocalSize
+	}
+	if st.Lag == 0 && err == nil {
+		st.CaughtUp = now
+	}
+	return err
+}
+
+// sync performs one sync and returns the upstream's size, whether entries
+// were appended, and whether they were verified first.
+func (m *LogMirror) sync(ctx context.Context) (size uint64, appended, verified bool, err error) {
+	defer func() {
+		if errors.Is(err, ErrLogDiverged) {
+			m.pending = nil
+		}
+	}()
+	head, rfc, err := m.upstreamHead(ctx)
+	if err != nil {
+		return 0, false, false, err
+	}
+	localSize := m.local.Size()
+	if head.Size < localSize {
+		return 0, false, false, fmt.Errorf("%w: upstream has %d entries, mirror %d", ErrLogDiverged, head.Size, localSize)
+	}
+
+	target := head.Size
+	var cp *nwep.Checkpoint
+	if m.opts.Checkpoints != nil {
+		if cp = m.opts.Checkpoints.Latest(); cp == nil {
+			return head.Size, false, false, nil
+		}
+		target = min(target, cp.LogSize)
+	}
+	next := localSize + uint64(len(m.pending))
+	target = min(target, next+uint64(m.opts.MaxPerSync))
+	for i := next; i < target; i++ {
+		resp, err := m.upstream.Read(ctx, "/log/entry/"+strconv.FormatUint(i, 10))
+		if err == nil {
+			err = ResponseError(resp)
+		}
+		if err != nil {
+			return 0, false, false, fmt.Errorf("velocity: log mirror: read entry %d: %w", i, err)
+		}
+		entry, err := nwep.MerkleEntryDecode(resp.Body)
+		if err != nil {
+			return 0, false, false, fmt.Errorf("velocity: log mirror: decode entry %d: %w", i, err)
+		}
+		m.pending = append(m.pending, mirrorEntry{entry: entry, leaf: MerkleLeafHash(resp.Body)})
+	}
+	if len(m.pending) == 0 {
+		return head.Size, false, false, nil
+	}
+
+	end := localSize + uint64(len(m.pending))
+	tree := m.local.compactRange()
+	for _, p := range m.pending {
+		tree.append(p.leaf)
+	}
+	root := tree.root()
+	if rfc {
+		if end == head.Size {
+			if root != head.Root {
+				return 0, false, false, fmt.Errorf("%w: root of %d entries does not match upstream's", ErrLogDiverged, end)
+			}
+		} else {
+			var p LogConsistencyProof
+			path := "/log/consistency/" + strconv.FormatUint(end, 10) + "/" + strconv.FormatUint(head.Size, 10)
+			if err := m.upstream.ReadJSON(ctx, path, &p); err != nil {
+				return 0, false, false, fmt.Errorf("velocity: log mirror: read consistency proof: %w", err)
+			}
+			if !VerifyConsistency(end, head.Size, root, head.Root, p.Proof) {
+				return 0, false, false, fmt.Errorf("%w: upstream's log of %d entries does not extend the first %d", ErrLogDiverged, head.Size, end)
+			}
+		}
+		verified = true
+	}
+	if cp != nil && end == cp.LogSize {
+		// A checkpoint's root is the root of nwep's Merkle log, which
+		// hashes entries as RFC 6962 does.
+		if root != LogHash(cp.MerkleRoot) {
+			return 0, false, false, fmt.Errorf("%w: root of %d entries does not match the checkpoint's", ErrLogDiverged, end)
+		}
+		verified = true
+	}
+	if cp != nil && !verified {
+		// Hold the entries back until the rest of the checkpoint's are
+		// read and the root can be checked.
+		return head.Size, false, false, nil
+	}
+
+	pending := m.pending
+	m.pending = nil
+	for i, p := range pending {
+		index, err := m.local.Append(p.entry)
+		if err != nil {
+			return 0, i > 0, verified, fmt.Errorf("velocity: log mirror: %w", err)
+		}
+		if index != localSize+uint64(i) {
+			return 0, true, verified, fmt.Errorf("%w: entry %d appended at local index %d", ErrLogDiverged, localSize+uint64(i), index)
+		}
+	}
+	return head.Size, true, verified, nil
+}
+
+// upstreamHead returns the upstream's log size and, if it serves /log/root,
+// its RFC 6962 root hash, reporting whether it did.
+func (m *LogMirror) upstreamHead(ctx context.Context) (LogTreeHead, bool, error) {
+	var head LogTreeHead
+	err := m.upstream.ReadJSON(ctx, "/log/root", &head)
+	if err == nil {
+		return head, true, nil
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Status != StatusNotFound {
+		return head, false, fmt.Errorf("velocity: log mirror: read tree head: %w", err)
+	}
+	if err := m.upstream.ReadJSON(ctx, "/log/size", &head); err != nil {
+		return head, false, fmt.Errorf("velocity: log mirror: read log size: %w", err)
+	}
+	return head, false, nil
+}
+
+// Stats returns the mirror's replication progress.
+func (m *LogMirror) Stats() LogMirrorStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.stats
+	if st.Lag > 0 || st.Err != nil {
+		st.LagTime = time.Since(st.CaughtUp)
+	}
+	return st
+}
+
+// Close stops mirroring, cancelling a sync in progress. It does not close
+// the upstream client or the local log.
+func (m *LogMirror) Close() {
+	m.closeOnce.Do(func() {
+		m.stop()
+		m.wg.Wait()
+		m.srv.logMirrors.remove(m)
+	})
+}
+
+// logMirrorSet is the set of a server's running LogMirrors, whose lag
+// MetricsHandler reports.
+type logMirrorSet struct {
+	mu      sync.Mutex
+	mirrors []*LogMirror
+}
+
+func (s *logMirrorSet) add(m *LogMirror) {
+	s.mu.Lock()
+	s.mirrors = append(s.mirrors, m)
+	s.mu.Unlock()
+}
+
+func (s *logMirrorSet) remove(m *LogMirror) {
+	s.mu.Lock()
+	s.mirrors = slices.DeleteFunc(s.mirrors, func(x *LogMirror) bool { return x == m })
+	s.mu.Unlock()
+}
+
+func (s *logMirrorSet) stats() []LogMirrorStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]LogMirrorStats, len(s.mirrors))
+	for i, m := range s.mirrors {
+		out[i] = m.Stats()
+	}
+	return out
+}
+
+// compactRange returns a copy of the compact range of the service's log.
+func (l *LogService) compactRange() merkleRange {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.tree.clone()
+}
@@ -0,0 +1,27 @@
+package velocity
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewLogMirrorErrors(t *testing.T) {
+	s, err := New(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLogMirror(s, nil, nil, LogMirrorOptions{}); err == nil {
+		t.Fatal("nil upstream accepted")
+	}
+	local, err := NewLogService(&FileLogStorage{}, LogServiceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	if _, err := NewLogMirror(s, &Client{}, local, LogMirrorOptions{Interval: -time.Second}); err == nil {
+		t.Fatal("negative interval accepted")
+	}
+	if len(s.logMirrors.stats()) != 0 {
+		t.Fatal("rejected mirror registered with the server")
+	}
+}
@@ -0,0 +1,377 @@
+package velocity
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// DefaultLogServicePrefix is the path prefix LogService.Mount registers its
+// routes under unless LogServiceOptions.Prefix says otherwise.
+const DefaultLogServicePrefix = "/log"
+
+// DefaultLogRangeLimit is the most entries one /log/range request returns
+// unless LogServiceOptions.MaxRange says otherwise.
+const DefaultLogRangeLimit = 1000
+
+// logEntryBufSize is the buffer LogService reads stored entries into. Encoded
+// Merkle entries are a fixed, much smaller size.
+const logEntryBufSize = 4096
+
+// LogServiceOptions configures a LogService.
+type LogServiceOptions struct {
+	// Prefix is the path the routes are mounted under. Empty means
+	// DefaultLogServicePrefix.
+	Prefix string
+
+	// Authorize decides whether peer may append entry, returning nil to
+	// allow it. Nil means a peer may append only entries for its own node
+	// ID, such as the key binding PostKeyBinding publishes.
+	Authorize func(peer nwep.NodeID, entry *nwep.MerkleEntry) error
+
+	// MaxRange is the most entries one /log/range request returns. Zero
+	// means DefaultLogRangeLimit.
+	MaxRange int
+}
+
+// LogService is a Merkle log served through velocity's router, as an
+// alternative to attaching a pre-built nwep.LogServer with WithLogServer.
+// Entries are kept in any nwep.LogStorage, such as FileLogStorage or
+// SQLLogStorage, and because the routes are ordinary velocity routes, the
+// server's middleware - logging, rate limits, RequireRole - applies to them.
+//
+// Alongside the nwep.MerkleLog it maintains over the storage, which anchor
+// checkpoints are built from, a LogService keeps the RFC 6962 leaf hashes of
+// every entry in memory, 32 bytes each, to serve tree heads, inclusion
+// proofs, and consistency proofs. They are computed from the stored entries
+// when the service is created, and the current tree head is kept up to date
+// as entries are appended. A LogService is safe for concurrent use.
+type LogService struct {
+	storage nwep.LogStorage
+	ml      *nwep.MerkleLog
+	opts    LogServiceOptions
+
+	mu     sync.RWMutex
+	leaves []LogHash
+	tree   merkleRange // compact range of leaves, for the current root
+}
+
+// NewLogService returns a LogService over storage, reading any entries it
+// already holds. Call Mount to serve it and Close to release it. This
+// function returns an error if opts is invalid, the nwep Merkle log cannot be
+// created, or an existing entry cannot be read.
+func NewLogService(storage nwep.LogStorage, opts LogServiceOptions) (*LogService, error) {
+	if storage == nil {
+		return nil, errors.New("velocity: nil log storage")
+	}
+	if opts.MaxRange < 0 {
+		return nil, fmt.Errorf("velocity: log MaxRange must not be negative, got %d", opts.MaxRange)
+	}
+	if opts.MaxRange == 0 {
+		opts.MaxRange = DefaultLogRangeLimit
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultLogServicePrefix
+	}
+	ml, err := nwep.NewMerkleLog(storage)
+	if err != nil {
+		return nil, fmt.Errorf("velocity: create merkle log: %w", err)
+	}
+	l := &LogService{storage: storage, ml: ml, opts: opts}
+	size := storage.Size()
+	l.leaves = make([]LogHash, 0, size)
+	for i := range size {
+		entry, err := l.Entry(i)
+		if err != nil {
+			ml.Free()
+			return nil, err
+		}
+		l.addLeaf(MerkleLeafHash(entry))
+	}
+	return l, nil
+}
+
+// MerkleLog returns the nwep Merkle log over the service's storage. Pass the
+// LogService itself to WithCheckpointSigner to issue checkpoints of it.
+// Append entries through the LogService, not the MerkleLog, so that the
+// service's proofs stay current.
+func (l *LogService) MerkleLog() *nwep.MerkleLog { return l.ml }
+
+// head returns the size and nwep root of the Merkle log, read under l.mu so
+// that an Append cannot fall between them.
+func (l *LogService) head() (uint64, nwep.MerkleHash, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	root, err := l.ml.Root()
+	return l.ml.Size(), root, err
+}
+
+// Close frees the nwep Merkle log. It does not close the storage.
+func (l *LogService) Close() { l.ml.Free() }
+
+// Append appends entry to the log and returns its index.
+func (l *LogService) Append(entry *nwep.MerkleEntry) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	index, err := l.ml.Append(entry)
+	if err != nil {
+		return 0, fmt.Errorf("velocity: append log entry: %w", err)
+	}
+	stored, err := l.Entry(index)
+	if err != nil {
+		return 0, err
+	}
+	l.addLeaf(MerkleLeafHash(stored))
+	return index, nil
+}
+
+// addLeaf records the leaf hash of a new entry. The caller holds l.mu or
+// has not yet shared l.
+func (l *LogService) addLeaf(leaf LogHash) {
+	l.leaves = append(l.leaves, leaf)
+	l.tree.append(leaf)
+}
+
+// Size returns the number of entries in the log.
+func (l *LogService) Size() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return uint64(len(l.leaves))
+}
+
+// Entry returns the encoded entry at index, as nwep.MerkleEntryDecode reads
+// it.
+func (l *LogService) Entry(index uint64) ([]byte, error) {
+	buf := make([]byte, logEntryBufSize)
+	n, err := l.storage.Get(index, buf)
+	if err != nil {
+		return nil, fmt.Errorf("velocity: read log entry %d: %w", index, err)
+	}
+	if n < 0 || n > len(buf) {
+		return nil, fmt.Errorf("velocity: read log entry %d: storage returned length %d", index, n)
+	}
+	return buf[:n], nil
+}
+
+// Root returns the RFC 6962 root hash of the first size entries of the log.
+// It returns an error if size exceeds the log's size.
+func (l *LogService) Root(size uint64) (LogHash, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if size > uint64(len(l.leaves)) {
+		return LogHash{}, fmt.Errorf("velocity: log has %d entries, not %d", len(l.leaves), size)
+	}
+	if size == l.tree.size {
+		return l.tree.root(), nil
+	}
+	return merkleRoot(l.leaves[:size]), nil
+}
+
+// InclusionProof returns the audit path proving that entry index is in the
+// first size entries of the log; see VerifyInclusion. It returns an error
+// unless index < size <= the log's size.
+func (l *LogService) InclusionProof(index, size uint64) ([]LogHash, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if index >= size || size > uint64(len(l.leaves)) {
+		return nil, fmt.Errorf("velocity: no entry %d in a log of %d entries (size %d)", index, size, len(l.leaves))
+	}
+	return merkleInclusion(int(index), l.leaves[:size]), nil
+}
+
+// ConsistencyProof returns the proof that the first size2 entries of the log
+// extend the first size1; see VerifyConsistency. It returns an error unless
+// 0 < size1 <= size2 <= the log's size.
+func (l *LogService) ConsistencyProof(size1, size2 uint64) ([]LogHash, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if size1 == 0 || size1 > size2 || size2 > uint64(len(l.leaves)) {
+		return nil, fmt.Errorf("velocity: no consistency proof from %d to %d entries in a log of %d", size1, size2, len(l.leaves))
+	}
+	return merkleConsistency(int(size1), l.leaves[:size2]), nil
+}
+
+// LogTreeHead is the body of a LogService's /log/root response.
+type LogTreeHead struct {
+	Size uint64  `json:"size"`
+	Root LogHash `json:"root"`
+}
+
+// LogRange is the body of a LogService's /log/range response. Entries are
+// encoded as by nwep.MerkleEntryEncode, and base64 in JSON.
+type LogRange struct {
+	Start   uint64   `json:"start"`
+	Entries [][]byte `json:"entries"`
+}
+
+// LogInclusionProof is the body of a LogService's /log/proof/{index}
+// response.
+type LogInclusionProof struct {
+	Index uint64    `json:"index"`
+	Size  uint64    `json:"size"`
+	Root  LogHash   `json:"root"`
+	Path  []LogHash `json:"path"`
+}
+
+// LogConsistencyProof is the body of a LogService's /log/consistency/{a}/{b}
+// response.
+type LogConsistencyProof struct {
+	First      uint64    `json:"first"`
+	Second     uint64    `json:"second"`
+	FirstRoot  LogHash   `json:"first_root"`
+	SecondRoot LogHash   `json:"second_root"`
+	Proof      []LogHash `json:"proof"`
+}
+
+// Mount registers the service's routes on srv under opts.Prefix and returns
+// their group, to which mw applies:
+//
+//	read  /size                 {"size": n}
+//	read  /root                 LogTreeHead
+//	read  /entry/:index         the encoded entry
+//	write /entry                append the encoded entry in the body; {"index": i}
+//	read  /range?start=&count=  LogRange, at most MaxRange entries
+//	read  /proof/:index?size=   LogInclusionProof; size defaults to the log's
+//	read  /consistency/:a/:b    LogConsistencyProof
+//
+// /size, /entry/:index, and write /entry behave like nwep.LogServer's routes,
+// so clients such as PostKeyBinding and LogRevocations work with either.
+// Do not also attach an nwep.LogServer with WithLogServer: it intercepts
+// /log before the router.
+func (l *LogService) Mount(srv *Server, mw ...MiddlewareFunc) *Group {
+	g := srv.Group(l.opts.Prefix, mw...)
+	g.Read("/size", func(c *Context) error {
+		return c.JSON(map[string]uint64{"size": l.Size()})
+	})
+	g.Read("/root", func(c *Context) error {
+		l.mu.RLock()
+		head := LogTreeHead{Size: l.tree.size, Root: l.tree.root()}
+		l.mu.RUnlock()
+		return c.JSON(head)
+	})
+	g.Read("/entry/:index", func(c *Context) error {
+		index, err := logIndexParam(c.Param("index"), "index")
+		if err != nil {
+			return err
+		}
+		if index >= l.Size() {
+			return ErrNotFoundf("no log entry %d", index)
+		}
+		entry, err := l.Entry(index)
+		if err != nil {
+			return err
+		}
+		return c.OK(entry)
+	})
+	g.Write("/entry", func(c *Context) error {
+		entry, err := nwep.MerkleEntryDecode(c.Body())
+		if err != nil {
+			return ErrBadRequestf("invalid log entry: %v", err)
+		}
+		if err := l.authorize(c.PeerNodeID(), entry); err != nil {
+			return ErrForbidden(err.Error())
+		}
+		index, err := l.Append(entry)
+		if err != nil {
+			return err
+		}
+		return c.JSON(map[string]uint64{"index": index})
+	})
+	g.Read("/range", func(c *Context) error {
+		var start uint64
+		var err error
+		if s := c.QueryParam("start"); s != "" {
+			if start, err = logIndexParam(s, "start"); err != nil {
+				return err
+			}
+		}
+		count := uint64(l.opts.MaxRange)
+		if s := c.QueryParam("count"); s != "" {
+			if count, err = logIndexParam(s, "count"); err != nil {
+				return err
+			}
+			count = min(count, uint64(l.opts.MaxRange))
+		}
+		size := l.Size()
+		if start > size {
+			return ErrNotFoundf("log has %d entries", size)
+		}
+		end := start + min(count, size-start)
+		r := LogRange{Start: start, Entries: make([][]byte, 0, end-start)}
+		for i := start; i < end; i++ {
+			entry, err := l.Entry(i)
+			if err != nil {
+				return err
+			}
+			r.Entries = append(r.Entries, entry)
+		}
+		return c.JSON(r)
+	})
+	g.Read("/proof/:index", func(c *Context) error {
+		index, err := logIndexParam(c.Param("index"), "index")
+		if err != nil {
+			return err
+		}
+		size := l.Size()
+		if s := c.QueryParam("size"); s != "" {
+			if size, err = logIndexParam(s, "size"); err != nil {
+				return err
+			}
+		}
+		path, err := l.InclusionProof(index, size)
+		if err != nil {
+			return ErrNotFound(err.Error())
+		}
+		root, err := l.Root(size)
+		if err != nil {
+			return ErrNotFound(err.Error())
+		}
+		return c.JSON(LogInclusionProof{Index: index, Size: size, Root: root, Path: path})
+	})
+	g.Read("/consistency/:a/:b", func(c *Context) error {
+		a, err := logIndexParam(c.Param("a"), "first size")
+		if err != nil {
+			return err
+		}
+		b, err := logIndexParam(c.Param("b"), "second size")
+		if err != nil {
+			return err
+		}
+		proof, err := l.ConsistencyProof(a, b)
+		if err != nil {
+			return ErrNotFound(err.Error())
+		}
+		p := LogConsistencyProof{First: a, Second: b, Proof: proof}
+		if p.FirstRoot, err = l.Root(a); err != nil {
+			return ErrNotFound(err.Error())
+		}
+		if p.SecondRoot, err = l.Root(b); err != nil {
+			return ErrNotFound(err.Error())
+		}
+		return c.JSON(p)
+	})
+	return g
+}
+
+func (l *LogService) authorize(peer nwep.NodeID, entry *nwep.MerkleEntry) error {
+	if l.opts.Authorize != nil {
+		return l.opts.Authorize(peer, entry)
+	}
+	if peer.IsZero() || entry.NodeID != peer {
+		return errors.New("peer may only append its own entries")
+	}
+	return nil
+}
+
+// logIndexParam parses a non-negative integer request parameter, what naming
+// it in the error.
+func logIndexParam(s, what string) (uint64, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, ErrBadRequestf("invalid %s %q", what, s)
+	}
+	return n, nil
+}
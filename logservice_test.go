@@ -0,0 +1,128 @@
+package velocity
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestLogServiceRoutes(t *testing.T) {
+	storage := &memLogStorage{}
+	l, err := NewLogService(storage, LogServiceOptions{
+		MaxRange:  2,
+		Authorize: func(peer nwep.NodeID, entry *nwep.MerkleEntry) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for range 3 {
+		if _, err := l.Append(makeTestEntry(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var leaves []LogHash
+	for _, e := range storage.entries {
+		leaves = append(leaves, MerkleLeafHash(e))
+	}
+
+	s := &Server{logger: DefaultLogger(), router: NewRouter(), peers: newPeerTracker(), errorHandler: DefaultErrorHandler}
+	l.Mount(s)
+	serve := func(method, path string, body []byte, out any) string {
+		t.Helper()
+		rec := NewRecorder()
+		s.ServeWEB(rec, &nwep.Request{Method: method, Path: path, Body: body})
+		if out != nil && rec.Status == StatusOK {
+			if err := json.Unmarshal(rec.Body, out); err != nil {
+				t.Fatalf("%s %s: %v: %s", method, path, err, rec.Body)
+			}
+		}
+		return rec.Status
+	}
+
+	var size map[string]uint64
+	if st := serve(MethodRead, "/log/size", nil, &size); st != StatusOK || size["size"] != 3 {
+		t.Fatalf("/log/size: %q %v", st, size)
+	}
+	var head LogTreeHead
+	if st := serve(MethodRead, "/log/root", nil, &head); st != StatusOK || head.Size != 3 || head.Root != merkleRoot(leaves) {
+		t.Fatalf("/log/root: %q %+v", st, head)
+	}
+
+	rec := NewRecorder()
+	s.ServeWEB(rec, &nwep.Request{Method: MethodRead, Path: "/log/entry/1"})
+	if rec.Status != StatusOK || string(rec.Body) != string(storage.entries[1]) {
+		t.Fatalf("/log/entry/1: %q %x", rec.Status, rec.Body)
+	}
+	if st := serve(MethodRead, "/log/entry/3", nil, nil); st != StatusNotFound {
+		t.Fatalf("/log/entry past the end: %q", st)
+	}
+	if st := serve(MethodRead, "/log/entry/x", nil, nil); st != StatusBadRequest {
+		t.Fatalf("/log/entry/x: %q", st)
+	}
+
+	var r LogRange
+	if st := serve(MethodRead, "/log/range?start=1&count=5", nil, &r); st != StatusOK || r.Start != 1 || len(r.Entries) != 2 {
+		t.Fatalf("/log/range: %q start %d, %d entries", st, r.Start, len(r.Entries))
+	}
+	if st := serve(MethodRead, "/log/range?start=4", nil, nil); st != StatusNotFound {
+		t.Fatalf("/log/range past the end: %q", st)
+	}
+
+	var incl LogInclusionProof
+	if st := serve(MethodRead, "/log/proof/0?size=2", nil, &incl); st != StatusOK ||
+		!VerifyInclusion(leaves[0], 0, 2, incl.Path, merkleRoot(leaves[:2])) || incl.Root != merkleRoot(leaves[:2]) {
+		t.Fatalf("/log/proof/0: %q %+v", st, incl)
+	}
+	if st := serve(MethodRead, "/log/proof/3", nil, nil); st != StatusNotFound {
+		t.Fatalf("/log/proof past the end: %q", st)
+	}
+
+	var cons LogConsistencyProof
+	if st := serve(MethodRead, "/log/consistency/1/3", nil, &cons); st != StatusOK ||
+		!VerifyConsistency(1, 3, cons.FirstRoot, cons.SecondRoot, cons.Proof) || cons.SecondRoot != head.Root {
+		t.Fatalf("/log/consistency/1/3: %q %+v", st, cons)
+	}
+	if st := serve(MethodRead, "/log/consistency/0/3", nil, nil); st != StatusNotFound {
+		t.Fatalf("/log/consistency from 0: %q", st)
+	}
+
+	encoded, err := nwep.MerkleEntryEncode(makeTestEntry(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index map[string]uint64
+	if st := serve(MethodWrite, "/log/entry", encoded, &index); st != StatusOK || index["index"] != 3 || l.Size() != 4 {
+		t.Fatalf("write /log/entry: %q %v, size %d", st, index, l.Size())
+	}
+	if st := serve(MethodWrite, "/log/entry", []byte("junk"), nil); st != StatusBadRequest {
+		t.Fatalf("write of an invalid entry: %q", st)
+	}
+}
+
+func TestLogServiceAuthorize(t *testing.T) {
+	l := &LogService{}
+	entry := &nwep.MerkleEntry{Type: nwep.LogEntryKeyBinding, NodeID: nwep.NodeID{2}}
+	if err := l.authorize(nwep.NodeID{}, entry); err == nil {
+		t.Fatal("unauthenticated peer may append")
+	}
+	if err := l.authorize(nwep.NodeID{1}, entry); err == nil {
+		t.Fatal("peer may append another node's entry")
+	}
+	if err := l.authorize(entry.NodeID, entry); err != nil {
+		t.Fatalf("peer may not append its own entry: %v", err)
+	}
+	denied := errors.New("denied")
+	l.opts.Authorize = func(nwep.NodeID, *nwep.MerkleEntry) error { return denied }
+	if err := l.authorize(entry.NodeID, entry); err != denied {
+		t.Fatalf("Authorize not consulted: %v", err)
+	}
+	if _, err := NewLogService(nil, LogServiceOptions{}); err == nil {
+		t.Fatal("nil storage accepted")
+	}
+	if _, err := NewLogService(&memLogStorage{}, LogServiceOptions{MaxRange: -1}); err == nil {
+		t.Fatal("negative MaxRange accepted")
+	}
+}
@@ -0,0 +1,245 @@
+package velocity
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// errLogIndex is wrapped by the errors of the log storage backends when an
+// entry is appended out of order or read past the end of the log.
+var errLogIndex = errors.New("velocity: log index out of range")
+
+// FileLogStorage is an nwep.LogStorage that keeps entries in an append-only
+// file, with a second file of 8-byte offsets indexing it, so that any entry is
+// read with one seek. It is durable across restarts: NewLogService or
+// nwep.NewMerkleLog on a reopened FileLogStorage sees every entry appended
+// before.
+//
+// Appends are written through to both files and, if opened with sync, fsynced
+// before Append returns. A crash between the two writes leaves unindexed
+// bytes at the end of the data file, which are discarded on the next open. A
+// FileLogStorage is safe for concurrent use.
+type FileLogStorage struct {
+	mu    sync.Mutex
+	data  *os.File
+	index *os.File
+	size  uint64 // entries
+	end   int64  // offset of the end of the last entry in data
+	sync  bool
+}
+
+// OpenFileLogStorage opens the log stored at path, creating it if needed. The
+// entries are in path and their index in path + ".idx". If sync is true,
+// every append is fsynced. This function returns an error if the files cannot
+// be opened or the index is damaged.
+func OpenFileLogStorage(path string, sync bool) (*FileLogStorage, error) {
+	data, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("velocity: open log storage: %w", err)
+	}
+	index, err := os.OpenFile(path+".idx", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		data.Close()
+		return nil, fmt.Errorf("velocity: open log storage: %w", err)
+	}
+	s := &FileLogStorage{data: data, index: index, sync: sync}
+	if err := s.recover(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover reads the size of the log from the index and truncates any partial
+// write left at the end of either file.
+func (s *FileLogStorage) recover() error {
+	info, err := s.index.Stat()
+	if err != nil {
+		return fmt.Errorf("velocity: open log storage: %w", err)
+	}
+	s.size = uint64(info.Size() / 8)
+	if info.Size()%8 != 0 {
+		if err := s.index.Truncate(int64(s.size) * 8); err != nil {
+			return fmt.Errorf("velocity: open log storage: %w", err)
+		}
+	}
+	if s.size > 0 {
+		var buf [8]byte
+		if _, err := s.index.ReadAt(buf[:], int64(s.size-1)*8); err != nil {
+			return fmt.Errorf("velocity: open log storage: %w", err)
+		}
+		s.end = int64(binary.BigEndian.Uint64(buf[:]))
+	}
+	info, err = s.data.Stat()
+	if err != nil {
+		return fmt.Errorf("velocity: open log storage: %w", err)
+	}
+	if info.Size() < s.end {
+		return fmt.Errorf("velocity: open log storage: data file is %d bytes, index says %d", info.Size(), s.end)
+	}
+	if info.Size() > s.end {
+		if err := s.data.Truncate(s.end); err != nil {
+			return fmt.Errorf("velocity: open log storage: %w", err)
+		}
+	}
+	return nil
+}
+
+// bounds returns the offsets of entry i in the data file. The caller must
+// hold s.mu.
+func (s *FileLogStorage) bounds(i uint64) (start, end int64, err error) {
+	var buf [16]byte
+	if i == 0 {
+		if _, err := s.index.ReadAt(buf[8:], 0); err != nil {
+			return 0, 0, err
+		}
+	} else if _, err := s.index.ReadAt(buf[:], int64(i-1)*8); err != nil {
+		return 0, 0, err
+	}
+	return int64(binary.BigEndian.Uint64(buf[:8])), int64(binary.BigEndian.Uint64(buf[8:])), nil
+}
+
+// Append stores entry at index, which must be the current size of the log.
+func (s *FileLogStorage) Append(index uint64, entry []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if index != s.size {
+		return fmt.Errorf("%w: append at %d to log of size %d", errLogIndex, index, s.size)
+	}
+	if _, err := s.data.WriteAt(entry, s.end); err != nil {
+		return fmt.Errorf("velocity: log storage: %w", err)
+	}
+	end := s.end + int64(len(entry))
+	if s.sync {
+		if err := s.data.Sync(); err != nil {
+			return fmt.Errorf("velocity: log storage: %w", err)
+		}
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(end))
+	if _, err := s.index.WriteAt(buf[:], int64(s.size)*8); err != nil {
+		return fmt.Errorf("velocity: log storage: %w", err)
+	}
+	if s.sync {
+		if err := s.index.Sync(); err != nil {
+			return fmt.Errorf("velocity: log storage: %w", err)
+		}
+	}
+	s.size++
+	s.end = end
+	return nil
+}
+
+// Get copies the entry at index into buf and returns its length. It returns
+// -1 and an error if there is no such entry or it does not fit in buf.
+func (s *FileLogStorage) Get(index uint64, buf []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if index >= s.size {
+		return -1, fmt.Errorf("%w: entry %d of %d", errLogIndex, index, s.size)
+	}
+	start, end, err := s.bounds(index)
+	if err != nil {
+		return -1, fmt.Errorf("velocity: log storage: %w", err)
+	}
+	n := int(end - start)
+	if n > len(buf) {
+		return -1, fmt.Errorf("velocity: log storage: entry %d is %d bytes, buffer %d", index, n, len(buf))
+	}
+	if _, err := s.data.ReadAt(buf[:n], start); err != nil && !errors.Is(err, io.EOF) {
+		return -1, fmt.Errorf("velocity: log storage: %w", err)
+	}
+	return n, nil
+}
+
+// Size returns the number of entries.
+func (s *FileLogStorage) Size() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Close closes the files. The storage must not be used afterwards.
+func (s *FileLogStorage) Close() error {
+	return errors.Join(s.data.Close(), s.index.Close())
+}
+
+// SQLLogStorage is an nwep.LogStorage that keeps entries in a SQL table,
+// (idx INTEGER PRIMARY KEY, entry BLOB), through database/sql. It is meant
+// for SQLite, with whichever driver the application imports, and works with
+// other databases that accept ? placeholders and those column types. The
+// database provides durability; SQLLogStorage is safe for concurrent use.
+type SQLLogStorage struct {
+	db    *sql.DB
+	table string
+
+	mu   sync.Mutex
+	size uint64
+}
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// OpenSQLLogStorage returns storage in table of db, creating the table if it
+// does not exist. This function returns an error if table is not a plain SQL
+// identifier or the table cannot be created or read.
+func OpenSQLLogStorage(db *sql.DB, table string) (*SQLLogStorage, error) {
+	if !sqlIdentifier.MatchString(table) {
+		return nil, fmt.Errorf("velocity: invalid log table name %q", table)
+	}
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+table+" (idx INTEGER PRIMARY KEY, entry BLOB NOT NULL)"); err != nil {
+		return nil, fmt.Errorf("velocity: create log table: %w", err)
+	}
+	s := &SQLLogStorage{db: db, table: table}
+	var n int64
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+		return nil, fmt.Errorf("velocity: read log table: %w", err)
+	}
+	s.size = uint64(n)
+	return s, nil
+}
+
+// Append stores entry at index, which must be the current size of the log.
+func (s *SQLLogStorage) Append(index uint64, entry []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if index != s.size {
+		return fmt.Errorf("%w: append at %d to log of size %d", errLogIndex, index, s.size)
+	}
+	if _, err := s.db.Exec("INSERT INTO "+s.table+" (idx, entry) VALUES (?, ?)", int64(index), entry); err != nil {
+		return fmt.Errorf("velocity: log storage: %w", err)
+	}
+	s.size++
+	return nil
+}
+
+// Get copies the entry at index into buf and returns its length. It returns
+// -1 and an error if there is no such entry or it does not fit in buf.
+func (s *SQLLogStorage) Get(index uint64, buf []byte) (int, error) {
+	var entry []byte
+	err := s.db.QueryRow("SELECT entry FROM "+s.table+" WHERE idx = ?", int64(index)).Scan(&entry)
+	if errors.Is(err, sql.ErrNoRows) {
+		return -1, fmt.Errorf("%w: entry %d", errLogIndex, index)
+	}
+	if err != nil {
+		return -1, fmt.Errorf("velocity: log storage: %w", err)
+	}
+	if len(entry) > len(buf) {
+		return -1, fmt.Errorf("velocity: log storage: entry %d is %d bytes, buffer %d", index, len(entry), len(buf))
+	}
+	return copy(buf, entry), nil
+}
+
+// Size returns the number of entries.
+func (s *SQLLogStorage) Size() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
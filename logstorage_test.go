@@ -0,0 +1,210 @@
+package velocity
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFileLogStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	s, err := OpenFileLogStorage(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []string{"first", "", "third entry"}
+	for i, e := range entries {
+		if err := s.Append(uint64(i), []byte(e)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Append(7, []byte("gap")); err == nil {
+		t.Fatal("out-of-order append accepted")
+	}
+	s.Close()
+
+	// Simulate a crash after writing data but before indexing it.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("torn")
+	f.Close()
+
+	s, err = OpenFileLogStorage(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Size() != uint64(len(entries)) {
+		t.Fatalf("Size() = %d, want %d", s.Size(), len(entries))
+	}
+	buf := make([]byte, 64)
+	for i, want := range entries {
+		n, err := s.Get(uint64(i), buf)
+		if err != nil || string(buf[:n]) != want {
+			t.Fatalf("Get(%d) = %q, %v; want %q", i, buf[:n], err, want)
+		}
+	}
+	if n, err := s.Get(3, buf); err == nil || n != -1 {
+		t.Fatalf("Get past end = %d, %v", n, err)
+	}
+	if err := s.Append(3, []byte("fourth")); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := s.Get(3, buf); string(buf[:n]) != "fourth" {
+		t.Fatalf("Get(3) after recovery = %q", buf[:n])
+	}
+}
+
+func TestSQLLogStorage(t *testing.T) {
+	db, err := sql.Open("velocitylogtest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := OpenSQLLogStorage(db, "log; DROP TABLE users"); err == nil {
+		t.Fatal("table name that is not an identifier accepted")
+	}
+
+	s, err := OpenSQLLogStorage(db, "log_entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []string{"first", "", "third entry"}
+	for i, e := range entries {
+		if err := s.Append(uint64(i), []byte(e)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Append(7, []byte("gap")); !errors.Is(err, errLogIndex) {
+		t.Fatalf("out-of-order append = %v", err)
+	}
+
+	s, err = OpenSQLLogStorage(db, "log_entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Size() != uint64(len(entries)) {
+		t.Fatalf("Size() after reopening = %d, want %d", s.Size(), len(entries))
+	}
+	buf := make([]byte, 64)
+	for i, want := range entries {
+		n, err := s.Get(uint64(i), buf)
+		if err != nil || string(buf[:n]) != want {
+			t.Fatalf("Get(%d) = %q, %v; want %q", i, buf[:n], err, want)
+		}
+	}
+	if n, err := s.Get(3, buf); !errors.Is(err, errLogIndex) || n != -1 {
+		t.Fatalf("Get past end = %d, %v", n, err)
+	}
+	if n, err := s.Get(2, buf[:4]); err == nil || n != -1 {
+		t.Fatalf("Get into a short buffer = %d, %v", n, err)
+	}
+}
+
+// logTestDriver is a database/sql driver that understands just the
+// statements SQLLogStorage issues. Databases are named by their DSN and live
+// as long as the test binary.
+type logTestDriver struct {
+	mu  sync.Mutex
+	dbs map[string]map[string]map[int64][]byte // by DSN, table, and idx
+}
+
+func init() {
+	sql.Register("velocitylogtest", &logTestDriver{dbs: make(map[string]map[string]map[int64][]byte)})
+}
+
+func (d *logTestDriver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dbs[dsn] == nil {
+		d.dbs[dsn] = make(map[string]map[int64][]byte)
+	}
+	return &logTestConn{d: d, tables: d.dbs[dsn]}, nil
+}
+
+type logTestConn struct {
+	d      *logTestDriver
+	tables map[string]map[int64][]byte
+}
+
+func (c *logTestConn) Prepare(query string) (driver.Stmt, error) {
+	return &logTestStmt{c: c, query: query}, nil
+}
+func (c *logTestConn) Close() error { return nil }
+func (c *logTestConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+
+type logTestStmt struct {
+	c     *logTestConn
+	query string
+}
+
+func (s *logTestStmt) Close() error  { return nil }
+func (s *logTestStmt) NumInput() int { return -1 }
+
+func (s *logTestStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.c.d.mu.Lock()
+	defer s.c.d.mu.Unlock()
+	var table string
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE IF NOT EXISTS "):
+		table, _, _ = strings.Cut(strings.TrimPrefix(s.query, "CREATE TABLE IF NOT EXISTS "), " ")
+		if s.c.tables[table] == nil {
+			s.c.tables[table] = make(map[int64][]byte)
+		}
+	case strings.HasPrefix(s.query, "INSERT INTO "):
+		table, _, _ = strings.Cut(strings.TrimPrefix(s.query, "INSERT INTO "), " ")
+		rows := s.c.tables[table]
+		idx := args[0].(int64)
+		if _, dup := rows[idx]; dup {
+			return nil, fmt.Errorf("duplicate idx %d", idx)
+		}
+		rows[idx] = append([]byte(nil), args[1].([]byte)...)
+	default:
+		return nil, fmt.Errorf("unsupported statement %q", s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *logTestStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.c.d.mu.Lock()
+	defer s.c.d.mu.Unlock()
+	if table, ok := strings.CutPrefix(s.query, "SELECT COUNT(*) FROM "); ok {
+		return &logTestRows{col: "count", vals: []driver.Value{int64(len(s.c.tables[table]))}}, nil
+	}
+	if rest, ok := strings.CutPrefix(s.query, "SELECT entry FROM "); ok {
+		table, _, _ := strings.Cut(rest, " ")
+		r := &logTestRows{col: "entry"}
+		if entry, ok := s.c.tables[table][args[0].(int64)]; ok {
+			r.vals = []driver.Value{entry}
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unsupported query %q", s.query)
+}
+
+type logTestRows struct {
+	col  string
+	vals []driver.Value
+}
+
+func (r *logTestRows) Columns() []string { return []string{r.col} }
+func (r *logTestRows) Close() error      { return nil }
+
+func (r *logTestRows) Next(dest []driver.Value) error {
+	if len(r.vals) == 0 {
+		return io.EOF
+	}
+	dest[0], r.vals = r.vals[0], r.vals[1:]
+	return nil
+}
@@ -0,0 +1,212 @@
+package velocity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"slices"
+)
+
+// LogHash is a SHA-256 Merkle tree hash. It is encoded in JSON as 64
+// lowercase hex characters.
+type LogHash [32]byte
+
+// String returns h in hex.
+func (h LogHash) String() string { return hex.EncodeToString(h[:]) }
+
+// MarshalText implements encoding.TextMarshaler.
+func (h LogHash) MarshalText() ([]byte, error) { return []byte(h.String()), nil }
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (h *LogHash) UnmarshalText(text []byte) error {
+	if hex.DecodedLen(len(text)) != len(h) {
+		return fmt.Errorf("velocity: log hash must be %d hex characters, got %d", hex.EncodedLen(len(h)), len(text))
+	}
+	_, err := hex.Decode(h[:], text)
+	return err
+}
+
+// The Merkle tree functions below follow RFC 6962 (Certificate
+// Transparency): leaves are hashed as SHA-256(0x00 || entry) and interior
+// nodes as SHA-256(0x01 || left || right), and a tree of n leaves splits at
+// the largest power of two smaller than n. LogService serves proofs in this
+// form, and VerifyInclusion and VerifyConsistency check them.
+
+// MerkleLeafHash returns the RFC 6962 hash of a log entry, in its encoded
+// form as stored and served at /log/entry/{index}.
+func MerkleLeafHash(entry []byte) LogHash {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(entry)
+	var out LogHash
+	h.Sum(out[:0])
+	return out
+}
+
+func merkleNodeHash(left, right LogHash) LogHash {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left[:])
+	h.Write(right[:])
+	var out LogHash
+	h.Sum(out[:0])
+	return out
+}
+
+// merkleSplit returns the largest power of two smaller than n, for n > 1.
+func merkleSplit(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
+
+// merkleRoot returns the root hash of the tree with the given leaf hashes.
+func merkleRoot(leaves []LogHash) LogHash {
+	switch len(leaves) {
+	case 0:
+		return LogHash(sha256.Sum256(nil))
+	case 1:
+		return leaves[0]
+	}
+	k := merkleSplit(len(leaves))
+	return merkleNodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// merkleRange is the compact range of a tree: the root hashes of its perfect
+// subtrees, largest first. It gives the tree's root, and takes further
+// leaves, without the leaf hashes themselves.
+type merkleRange struct {
+	size  uint64
+	nodes []LogHash
+}
+
+// append adds leaf to the right of the tree.
+func (r *merkleRange) append(leaf LogHash) {
+	r.nodes = append(r.nodes, leaf)
+	for n := r.size; n&1 == 1; n >>= 1 {
+		k := len(r.nodes)
+		r.nodes = append(r.nodes[:k-2], merkleNodeHash(r.nodes[k-2], r.nodes[k-1]))
+	}
+	r.size++
+}
+
+// root returns the root hash of the tree, as merkleRoot does for its leaves.
+func (r *merkleRange) root() LogHash {
+	if len(r.nodes) == 0 {
+		return LogHash(sha256.Sum256(nil))
+	}
+	root := r.nodes[len(r.nodes)-1]
+	for i := len(r.nodes) - 2; i >= 0; i-- {
+		root = merkleNodeHash(r.nodes[i], root)
+	}
+	return root
+}
+
+// clone returns a copy of r that can be extended independently.
+func (r *merkleRange) clone() merkleRange {
+	return merkleRange{size: r.size, nodes: slices.Clone(r.nodes)}
+}
+
+// merkleInclusion returns the audit path for leaf m in the tree with the
+// given leaf hashes, for 0 <= m < len(leaves).
+func merkleInclusion(m int, leaves []LogHash) []LogHash {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := merkleSplit(len(leaves))
+	if m < k {
+		return append(merkleInclusion(m, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+	return append(merkleInclusion(m-k, leaves[k:]), merkleRoot(leaves[:k]))
+}
+
+// merkleConsistency returns the consistency proof between the tree of the
+// first m leaves and the tree of all of them, for 0 < m <= len(leaves).
+func merkleConsistency(m int, leaves []LogHash) []LogHash {
+	return merkleSubproof(m, leaves, true)
+}
+
+func merkleSubproof(m int, leaves []LogHash, complete bool) []LogHash {
+	n := len(leaves)
+	if m == n {
+		if complete {
+			return nil
+		}
+		return []LogHash{merkleRoot(leaves)}
+	}
+	k := merkleSplit(n)
+	if m <= k {
+		return append(merkleSubproof(m, leaves[:k], complete), merkleRoot(leaves[k:]))
+	}
+	return append(merkleSubproof(m-k, leaves[k:], false), merkleRoot(leaves[:k]))
+}
+
+// VerifyInclusion reports whether proof shows that the entry with leaf hash
+// leaf (see MerkleLeafHash) is at index in the log of size entries whose
+// root hash is root, as served by LogService at /log/proof/{index}.
+func VerifyInclusion(leaf LogHash, index, size uint64, proof []LogHash, root LogHash) bool {
+	if index >= size {
+		return false
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && r == root
+}
+
+// VerifyConsistency reports whether proof shows that the log of size2
+// entries with root hash root2 extends the log of size1 entries with root
+// hash root1, as served by LogService at /log/consistency/{size1}/{size2}.
+func VerifyConsistency(size1, size2 uint64, root1, root2 LogHash, proof []LogHash) bool {
+	switch {
+	case size1 > size2:
+		return false
+	case size1 == size2:
+		return len(proof) == 0 && root1 == root2
+	case size1 == 0:
+		return len(proof) == 0
+	case len(proof) == 0:
+		return false
+	}
+	if size1&(size1-1) == 0 {
+		proof = append([]LogHash{root1}, proof...)
+	}
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = merkleNodeHash(c, fr)
+			sr = merkleNodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = merkleNodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && fr == root1 && sr == root2
+}
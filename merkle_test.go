@@ -0,0 +1,60 @@
+package velocity
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMerkleProofs(t *testing.T) {
+	var leaves []LogHash
+	for i := range 20 {
+		leaves = append(leaves, MerkleLeafHash(fmt.Appendf(nil, "entry %d", i)))
+	}
+	for n := 1; n <= len(leaves); n++ {
+		tree := leaves[:n]
+		root := merkleRoot(tree)
+		for m := range n {
+			path := merkleInclusion(m, tree)
+			if !VerifyInclusion(tree[m], uint64(m), uint64(n), path, root) {
+				t.Fatalf("inclusion of %d in %d does not verify", m, n)
+			}
+			if VerifyInclusion(tree[(m+1)%n], uint64(m), uint64(n), path, root) && n > 1 {
+				t.Fatalf("inclusion of %d in %d verifies for the wrong leaf", m, n)
+			}
+		}
+		for m := 1; m <= n; m++ {
+			proof := merkleConsistency(m, tree)
+			if !VerifyConsistency(uint64(m), uint64(n), merkleRoot(tree[:m]), root, proof) {
+				t.Fatalf("consistency from %d to %d does not verify", m, n)
+			}
+			if m < n && VerifyConsistency(uint64(m), uint64(n), merkleRoot(tree[1:m+1]), root, proof) {
+				t.Fatalf("consistency from %d to %d verifies with the wrong root", m, n)
+			}
+		}
+	}
+}
+
+func TestMerkleRange(t *testing.T) {
+	var r merkleRange
+	var leaves []LogHash
+	for i := range 40 {
+		if got, want := r.root(), merkleRoot(leaves); got != want {
+			t.Fatalf("root of %d leaves = %s, want %s", i, got, want)
+		}
+		leaf := MerkleLeafHash(fmt.Appendf(nil, "entry %d", i))
+		leaves = append(leaves, leaf)
+		r.append(leaf)
+	}
+}
+
+func TestLogHashText(t *testing.T) {
+	h := MerkleLeafHash([]byte("x"))
+	text, _ := h.MarshalText()
+	var got LogHash
+	if err := got.UnmarshalText(text); err != nil || got != h {
+		t.Fatalf("round trip = %v, %v", got, err)
+	}
+	if err := got.UnmarshalText([]byte("abc")); err == nil {
+		t.Fatal("short hash accepted")
+	}
+}
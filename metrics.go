@@ -0,0 +1,339 @@
+package velocity
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsBuckets are the upper bounds, in seconds, of the request duration
+// histogram. They match the Prometheus client library defaults.
+var metricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metricsRoute identifies the requests a set of metrics was recorded for.
+// tenant is empty unless the server is multi-tenant.
+type metricsRoute struct {
+	method, route, tenant string
+}
+
+// labels returns r as Prometheus labels, without braces. The tenant label is
+// present only for requests with a tenant.
+func (r metricsRoute) labels() string {
+	l := "method=" + quoteLabel(r.method) + ",route=" + quoteLabel(r.route)
+	if r.tenant != "" {
+		l += ",tenant=" + quoteLabel(r.tenant)
+	}
+	return l
+}
+
+// durationHistogram is a histogram of request durations.
+type durationHistogram struct {
+	counts []uint64 // per bucket, not cumulative; the last entry is +Inf
+	sum    float64
+	count  uint64
+}
+
+// metricsRegistry holds the per-route request metrics recorded by the Metrics
+// middleware.
+type metricsRegistry struct {
+	mu        sync.Mutex
+	requests  map[metricsRoute]map[string]uint64 // by status
+	durations map[metricsRoute]*durationHistogram
+	inflight  map[metricsRoute]int64
+
+	notifications map[string]uint64 // by outcome; see observeNotify
+}
+
+// notified counts a notification attempt with outcome.
+func (m *metricsRegistry) notified(outcome string) {
+	m.mu.Lock()
+	if m.notifications == nil {
+		m.notifications = make(map[string]uint64)
+	}
+	m.notifications[outcome]++
+	m.mu.Unlock()
+}
+
+func (m *metricsRegistry) begin(r metricsRoute) {
+	m.mu.Lock()
+	if m.inflight == nil {
+		m.inflight = make(map[metricsRoute]int64)
+	}
+	m.inflight[r]++
+	m.mu.Unlock()
+}
+
+func (m *metricsRegistry) end(r metricsRoute, status string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inflight[r]--
+	if m.requests == nil {
+		m.requests = make(map[metricsRoute]map[string]uint64)
+		m.durations = make(map[metricsRoute]*durationHistogram)
+	}
+	if m.requests[r] == nil {
+		m.requests[r] = make(map[string]uint64)
+	}
+	m.requests[r][status]++
+	h := m.durations[r]
+	if h == nil {
+		h = &durationHistogram{counts: make([]uint64, len(metricsBuckets)+1)}
+		m.durations[r] = h
+	}
+	secs := d.Seconds()
+	i, _ := slices.BinarySearch(metricsBuckets, secs)
+	h.counts[i]++
+	h.sum += secs
+	h.count++
+}
+
+// Metrics returns middleware that records Prometheus-style request metrics
+// for each route: a request counter by status, a duration histogram, and an
+// in-flight gauge, all labelled with the method and the route pattern (see
+// Context.RoutePattern), and with the tenant for requests that have one (see
+// WithTenantResolver). Only the tenants named with WithMetricsTenants or
+// resolved by TenantFromPeer are labelled by name; the others share the label
+// MetricsOtherTenant. Expose them with MetricsHandler.
+//
+// Install it as global middleware so that every route is covered. The status
+// of a handler that returns an error without responding is taken from the
+// error if it is an *Error, and is "internal_error" otherwise, matching
+// DefaultErrorHandler. Metrics are kept per Server.
+func Metrics() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			m := &c.server.metrics
+			r := metricsRoute{method: c.Method(), route: c.RoutePattern(), tenant: c.metricsTenant()}
+			start := time.Now()
+			m.begin(r)
+			err := next(c)
+			m.end(r, metricsStatus(c, err), time.Since(start))
+			return err
+		}
+	}
+}
+
+// metricsStatus returns the status the request was, or will be, answered
+// with.
+func metricsStatus(c *Context, err error) string {
+	if c.Committed() {
+		if c.status == "" {
+			return StatusOK
+		}
+		return c.status
+	}
+	if err == nil {
+		return StatusOK
+	}
+	if e, ok := asError(err); ok {
+		return e.Status
+	}
+	return StatusInternalError
+}
+
+// RouteStats summarizes the requests recorded by Metrics for one method and
+// route, and tenant if the server is multi-tenant. See Server.RouteStats.
+type RouteStats struct {
+	Method string `json:"method"`
+	Route  string `json:"route"`
+	Tenant string `json:"tenant,omitempty"`
+
+	// Requests is the number of requests handled, and ByStatus breaks it
+	// down by response status.
+	Requests uint64            `json:"requests"`
+	ByStatus map[string]uint64 `json:"by_status"`
+
+	// InFlight is the number of requests being handled.
+	InFlight int64 `json:"in_flight"`
+
+	// Mean is the mean handling time. P50, P90, and P99 are percentiles
+	// estimated from the duration histogram, so they are only as precise as
+	// its buckets, and a percentile that falls beyond the last bucket is
+	// reported as that bucket's bound.
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+}
+
+// RouteStats returns the per-route request statistics recorded by the
+// Metrics middleware, ordered by route, method, and tenant. It returns nil if
+// Metrics is not installed.
+func (s *Server) RouteStats() []RouteStats {
+	m := &s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]RouteStats, 0, len(m.inflight))
+	for _, r := range m.sortedRoutes() {
+		st := RouteStats{
+			Method:   r.method,
+			Route:    r.route,
+			Tenant:   r.tenant,
+			ByStatus: maps.Clone(m.requests[r]),
+			InFlight: m.inflight[r],
+		}
+		if h := m.durations[r]; h != nil && h.count > 0 {
+			st.Requests = h.count
+			st.Mean = secondsDuration(h.sum / float64(h.count))
+			st.P50 = secondsDuration(h.quantile(0.5))
+			st.P90 = secondsDuration(h.quantile(0.9))
+			st.P99 = secondsDuration(h.quantile(0.99))
+		}
+		out = append(out, st)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// quantile estimates the q-quantile of h by linear interpolation within the
+// bucket it falls in, as Prometheus's histogram_quantile does.
+func (h *durationHistogram) quantile(q float64) float64 {
+	rank := q * float64(h.count)
+	var cum uint64
+	for i, n := range h.counts {
+		if float64(cum+n) < rank || n == 0 {
+			cum += n
+			continue
+		}
+		if i == len(metricsBuckets) {
+			return metricsBuckets[i-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = metricsBuckets[i-1]
+		}
+		return lower + (metricsBuckets[i]-lower)*(rank-float64(cum))/float64(n)
+	}
+	return metricsBuckets[len(metricsBuckets)-1]
+}
+
+func secondsDuration(secs float64) time.Duration {
+	return time.Duration(secs * float64(time.Second))
+}
+
+// sortedRoutes returns the routes m has seen, ordered by route, method, and
+// tenant. The caller must hold m.mu.
+func (m *metricsRegistry) sortedRoutes() []metricsRoute {
+	routes := make([]metricsRoute, 0, len(m.inflight))
+	for r := range m.inflight {
+		routes = append(routes, r)
+	}
+	slices.SortFunc(routes, func(a, b metricsRoute) int {
+		return strings.Compare(a.route+" "+a.method+" "+a.tenant, b.route+" "+b.method+" "+b.tenant)
+	})
+	return routes
+}
+
+// MetricsHandler returns a handler that serves the server's metrics in the
+// Prometheus text exposition format, for scraping over WEB/1:
+//
+//	srv.Use(velocity.Metrics())
+//	srv.Handle("/metrics", velocity.MetricsHandler())
+//
+// Besides the per-route metrics recorded by Metrics, it reports the number of
+// open connections, the number of handlers running (see PoolStats), the
+// notification counters from NotifyStats, notification send attempts by
+// outcome (see WithNotifyObserver), and the lag of each LogMirror. All metric
+// names start with "velocity_".
+func MetricsHandler() HandlerFunc {
+	return func(c *Context) error {
+		c.SetHeader("content-type", "text/plain; version=0.0.4")
+		return c.OK(c.server.metrics.appendText(nil, c.server))
+	}
+}
+
+// appendText appends the metrics of m and s to buf in the Prometheus text
+// exposition format.
+func (m *metricsRegistry) appendText(buf []byte, s *Server) []byte {
+	b := bytes.NewBuffer(buf)
+
+	m.mu.Lock()
+	routes := m.sortedRoutes()
+
+	b.WriteString("# HELP velocity_requests_total Requests handled, by method, route, and status.\n")
+	b.WriteString("# TYPE velocity_requests_total counter\n")
+	for _, r := range routes {
+		statuses := make([]string, 0, len(m.requests[r]))
+		for st := range m.requests[r] {
+			statuses = append(statuses, st)
+		}
+		slices.Sort(statuses)
+		for _, st := range statuses {
+			fmt.Fprintf(b, "velocity_requests_total{%s,status=%s} %d\n", r.labels(), quoteLabel(st), m.requests[r][st])
+		}
+	}
+
+	b.WriteString("# HELP velocity_request_duration_seconds Request handling time, by method and route.\n")
+	b.WriteString("# TYPE velocity_request_duration_seconds histogram\n")
+	for _, r := range routes {
+		h := m.durations[r]
+		if h == nil {
+			continue
+		}
+		labels := r.labels()
+		var cum uint64
+		for i, le := range metricsBuckets {
+			cum += h.counts[i]
+			fmt.Fprintf(b, "velocity_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels, strconv.FormatFloat(le, 'g', -1, 64), cum)
+		}
+		fmt.Fprintf(b, "velocity_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(b, "velocity_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(b, "velocity_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	b.WriteString("# HELP velocity_requests_in_flight Requests being handled, by method and route.\n")
+	b.WriteString("# TYPE velocity_requests_in_flight gauge\n")
+	for _, r := range routes {
+		fmt.Fprintf(b, "velocity_requests_in_flight{%s} %d\n", r.labels(), m.inflight[r])
+	}
+
+	if len(m.notifications) > 0 {
+		b.WriteString("# HELP velocity_notifications_total Notification send attempts, by outcome.\n")
+		b.WriteString("# TYPE velocity_notifications_total counter\n")
+		for _, o := range slices.Sorted(maps.Keys(m.notifications)) {
+			fmt.Fprintf(b, "velocity_notifications_total{outcome=%s} %d\n", quoteLabel(o), m.notifications[o])
+		}
+	}
+	m.mu.Unlock()
+
+	if s != nil {
+		writeScalar(b, "velocity_connections", "Open peer connections.", "gauge", int64(s.ConnectionCount()))
+		writeScalar(b, "velocity_handlers_active", "Handlers currently running.", "gauge", s.PoolStats().Active)
+		ns := s.NotifyStats()
+		writeScalar(b, "velocity_notifications_throttled_total", "Notifications delayed by the notification rate limit.", "counter", int64(ns.Throttled))
+		writeScalar(b, "velocity_notifications_dropped_total", "Notifications dropped by the notification rate limit.", "counter", int64(ns.Dropped))
+		if mirrors := s.logMirrors.stats(); len(mirrors) > 0 {
+			b.WriteString("# HELP velocity_log_mirror_lag_entries Entries the upstream log has that its mirror lacks, by upstream.\n")
+			b.WriteString("# TYPE velocity_log_mirror_lag_entries gauge\n")
+			for _, st := range mirrors {
+				fmt.Fprintf(b, "velocity_log_mirror_lag_entries{upstream=%s} %d\n", quoteLabel(st.Upstream), st.Lag)
+			}
+			b.WriteString("# HELP velocity_log_mirror_lag_seconds Time since the mirror was last caught up with its upstream, by upstream.\n")
+			b.WriteString("# TYPE velocity_log_mirror_lag_seconds gauge\n")
+			for _, st := range mirrors {
+				fmt.Fprintf(b, "velocity_log_mirror_lag_seconds{upstream=%s} %s\n", quoteLabel(st.Upstream), strconv.FormatFloat(st.LagTime.Seconds(), 'g', -1, 64))
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+func writeScalar(b *bytes.Buffer, name, help, typ string, v int64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, typ, name, v)
+}
+
+// quoteLabel quotes a label value as required by the Prometheus text format.
+func quoteLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
@@ -0,0 +1,61 @@
+package velocity
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsText(t *testing.T) {
+	var m metricsRegistry
+	r := metricsRoute{method: MethodRead, route: "/users/:id"}
+	m.begin(r)
+	m.end(r, StatusOK, 20*time.Millisecond)
+	m.begin(r)
+	m.end(r, StatusNotFound, 2*time.Second)
+	m.begin(r)
+
+	out := string(m.appendText(nil, nil))
+	for _, want := range []string{
+		`velocity_requests_total{method="read",route="/users/:id",status="not_found"} 1`,
+		`velocity_requests_total{method="read",route="/users/:id",status="ok"} 1`,
+		`velocity_request_duration_seconds_bucket{method="read",route="/users/:id",le="0.025"} 1`,
+		`velocity_request_duration_seconds_bucket{method="read",route="/users/:id",le="2.5"} 2`,
+		`velocity_request_duration_seconds_bucket{method="read",route="/users/:id",le="+Inf"} 2`,
+		`velocity_request_duration_seconds_count{method="read",route="/users/:id"} 2`,
+		`velocity_requests_in_flight{method="read",route="/users/:id"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if got := quoteLabel("a\"b\\c\n"); got != `"a\"b\\c\n"` {
+		t.Errorf("quoteLabel = %s", got)
+	}
+}
+
+func TestRouteStats(t *testing.T) {
+	s := &Server{}
+	if st := s.RouteStats(); st != nil {
+		t.Fatalf("RouteStats without requests = %v", st)
+	}
+	r := metricsRoute{method: MethodRead, route: "/items"}
+	for i := range 100 {
+		d := 20 * time.Millisecond // 0.01-0.025 bucket
+		if i >= 90 {
+			d = 200 * time.Millisecond // 0.1-0.25 bucket
+		}
+		s.metrics.begin(r)
+		s.metrics.end(r, StatusOK, d)
+	}
+	st := s.RouteStats()
+	if len(st) != 1 || st[0].Requests != 100 || st[0].ByStatus[StatusOK] != 100 {
+		t.Fatalf("RouteStats = %+v", st)
+	}
+	if p := st[0].P50; p <= 10*time.Millisecond || p > 25*time.Millisecond {
+		t.Errorf("P50 = %s, want within (10ms, 25ms]", p)
+	}
+	if p := st[0].P99; p <= 100*time.Millisecond || p > 250*time.Millisecond {
+		t.Errorf("P99 = %s, want within (100ms, 250ms]", p)
+	}
+}
@@ -2,6 +2,8 @@ package velocity
 
 import (
 	"fmt"
+	"runtime"
+	"strings"
 	"time"
 
 	nwep "github.com/usenwep/nwep-go"
@@ -38,21 +40,160 @@ func applyMiddleware(h HandlerFunc, mw []MiddlewareFunc) HandlerFunc {
 	return h
 }
 
+// Skipper reports whether a middleware should let a request through
+// untouched, straight to the next handler. Built-in middleware with an
+// options struct take one in a Skipper field; Unless adds one to any
+// middleware.
+type Skipper func(c *Context) bool
+
+// Unless returns middleware that runs mw except for requests skip returns
+// true for, which go straight to the next handler. It lets selected routes,
+// such as health checks and metrics, bypass logging or limits applied to the
+// whole server, without moving them into a separate group:
+//
+//	srv.Use(velocity.Unless(velocity.RateLimit(10, 20), velocity.SkipRoutes("/health", "/metrics")))
+//
+// skip runs before mw. ValidateMiddleware names the result "velocity.Unless"
+// rather than after mw, so ordering rules about mw do not apply to it. Unless
+// panics if mw or skip is nil.
+func Unless(mw MiddlewareFunc, skip Skipper) MiddlewareFunc {
+	if mw == nil || skip == nil {
+		panic("velocity: Unless requires a middleware and a skipper")
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		h := mw(next)
+		return func(c *Context) error {
+			if skip(c) {
+				return next(c)
+			}
+			return h(c)
+		}
+	}
+}
+
+// SkipRoutes returns a Skipper for the requests matched by the given route
+// patterns, compared with Context.RoutePattern, so that "/users/:id" skips
+// every user and a query string does not matter.
+func SkipRoutes(patterns ...string) Skipper {
+	skip := make(map[string]bool, len(patterns))
+	for _, p := range patterns {
+		skip[p] = true
+	}
+	return func(c *Context) bool { return skip[c.RoutePattern()] }
+}
+
+// DefaultRecoverStackSize is how many bytes of the panicking goroutine's
+// stack trace Recover captures unless RecoverConfig says otherwise.
+const DefaultRecoverStackSize = 4 << 10
+
 // Recover returns middleware that catches panics in downstream handlers and
 // converts them to an "internal_error" response. The panic value and the
 // request path are logged at error level through the server's Logger.
 //
 // Recover should be the first middleware in the chain (registered first with
 // Server.Use) so that it catches panics from all subsequent middleware and
-// handlers.
+// handlers. Use RecoverWithResponse to customize the response, and
+// RecoverWith to log stack traces or report panics elsewhere.
 func Recover() MiddlewareFunc {
+	return RecoverWith(RecoverConfig{})
+}
+
+// RecoverWithResponse is like Recover, but the response sent after a panic is
+// built by fn, which receives the request Context and returns the status and
+// body. This allows an opaque message, a structured JSON body, or a body that
+// includes the request ID for the client to quote to support:
+//
+//	velocity.RecoverWithResponse(func(c *velocity.Context) (string, []byte) {
+//	    rid := c.RequestID()
+//	    return velocity.StatusInternalError, []byte("internal error, ref " + hex.EncodeToString(rid[:]))
+//	})
+//
+// fn may also set response headers with c.SetHeader. If the handler had
+// already started a response before panicking, no second response is sent.
+func RecoverWithResponse(fn func(c *Context) (status string, body []byte)) MiddlewareFunc {
+	return RecoverWith(RecoverConfig{Response: fn})
+}
+
+// RecoverConfig configures RecoverWith.
+type RecoverConfig struct {
+	// StackSize caps the stack trace captured for LogStack and OnPanic, in
+	// bytes. Zero means DefaultRecoverStackSize.
+	StackSize int
+
+	// LogStack adds the stack trace to the logged error, as a "stack"
+	// field.
+	LogStack bool
+
+	// OnPanic, if set, is called with the recovered value and the stack
+	// trace after the panic is logged, for example to report it to an
+	// error tracker. It may respond itself, such as with c.JSONStatus, in
+	// which case Response is not used. A panic in OnPanic is not
+	// recovered.
+	OnPanic func(c *Context, recovered any, stack []byte)
+
+	// Response builds the response sent after a panic, as in
+	// RecoverWithResponse. Nil means an "internal_error" response with
+	// the body "internal error".
+	Response func(c *Context) (status string, body []byte)
+
+	// Skipper, if set, selects requests whose panics are not recovered
+	// here but left to propagate, such as to an outer Recover.
+	Skipper Skipper
+}
+
+// RecoverWith is like Recover, configured by cfg:
+//
+//	srv.Use(velocity.RecoverWith(velocity.RecoverConfig{
+//	    LogStack: true,
+//	    OnPanic: func(c *velocity.Context, v any, stack []byte) {
+//	        tracker.Report(v, stack)
+//	        c.JSONStatus(velocity.StatusInternalError, map[string]string{"error": "internal"})
+//	    },
+//	}))
+//
+// No response is sent if the handler or OnPanic had already started one.
+// RecoverWith panics if cfg.StackSize is negative.
+func RecoverWith(cfg RecoverConfig) MiddlewareFunc {
+	if cfg.StackSize < 0 {
+		panic(fmt.Sprintf("velocity: Recover stack size must not be negative, got %d", cfg.StackSize))
+	}
+	if cfg.StackSize == 0 {
+		cfg.StackSize = DefaultRecoverStackSize
+	}
+	if cfg.Response == nil {
+		cfg.Response = func(c *Context) (string, []byte) {
+			return nwep.StatusInternalError, []byte("internal error")
+		}
+	}
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c *Context) (err error) {
+			if cfg.Skipper != nil && cfg.Skipper(c) {
+				return next(c)
+			}
 			defer func() {
-				if r := recover(); r != nil {
-					c.Logger().Error("panic recovered", "panic", fmt.Sprint(r), "path", c.Path())
-					err = c.InternalError("internal error")
+				r := recover()
+				if r == nil {
+					return
+				}
+				var stack []byte
+				if cfg.LogStack || cfg.OnPanic != nil {
+					stack = make([]byte, cfg.StackSize)
+					stack = stack[:runtime.Stack(stack, false)]
+				}
+				args := []any{"panic", fmt.Sprint(r), "path", c.Path()}
+				if cfg.LogStack {
+					args = append(args, "stack", string(stack))
 				}
+				c.Logger().Error("panic recovered", args...)
+				if cfg.OnPanic != nil {
+					cfg.OnPanic(c, r, stack)
+				}
+				if c.Committed() {
+					err = nil
+					return
+				}
+				status, body := cfg.Response(c)
+				err = c.Respond(status, body)
 			}()
 			return next(c)
 		}
@@ -60,22 +201,53 @@ func Recover() MiddlewareFunc {
 }
 
 // RequestLogger returns middleware that logs every completed request. Each log
-// entry includes the method, path, peer node ID, and wall-clock duration. The
+// entry includes the method, path, matched route pattern (see
+// Context.RoutePattern), peer node ID, and wall-clock duration. The
 // entry is emitted at info level after the downstream handler returns,
-// regardless of whether the handler returned an error.
+// regardless of whether the handler returned an error. Use
+// RequestLoggerWith to log additional fields.
 func RequestLogger() MiddlewareFunc {
+	return RequestLoggerWith(RequestLoggerOptions{})
+}
+
+// RequestLoggerOptions configures RequestLoggerWith.
+type RequestLoggerOptions struct {
+	// Compression adds a "compression" field holding the compression
+	// algorithm negotiated for the connection (see
+	// Context.ConnSettings). The field is omitted when the algorithm is
+	// not known.
+	Compression bool
+
+	// Skipper, if set, selects requests that are not logged.
+	Skipper Skipper
+}
+
+// RequestLoggerWith is like RequestLogger, but logs the optional fields
+// selected by opts in addition to the standard ones, and skips the requests
+// opts.Skipper selects.
+func RequestLoggerWith(opts RequestLoggerOptions) MiddlewareFunc {
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c *Context) error {
+			if opts.Skipper != nil && opts.Skipper(c) {
+				return next(c)
+			}
 			start := time.Now()
 			err := next(c)
 			dur := time.Since(start)
 			peer := c.PeerNodeID()
-			c.Logger().Info("request",
+			args := []any{
 				"method", c.Method(),
 				"path", c.Path(),
-				"peer", peer.String(),
+				"route", c.RoutePattern(),
+				"peer", FormatNodeID(peer),
 				"duration", dur.String(),
-			)
+			}
+			if opts.Compression {
+				if cs, ok := c.ConnSettings(); ok && cs.Compression != "" {
+					args = append(args, "compression", cs.Compression)
+				}
+			}
+			c.Logger().Info("request", args...)
 			return err
 		}
 	}
@@ -105,7 +277,8 @@ func RequirePeer() MiddlewareFunc {
 // at middleware creation time and is safe for concurrent use.
 //
 // AllowPeers implicitly requires that the peer is authenticated - a
-// zero-valued node ID will never match the allowed set.
+// zero-valued node ID will never match the allowed set. To build the set from
+// configuration, parse each ID with NodeIDFromString.
 func AllowPeers(allowed ...nwep.NodeID) MiddlewareFunc {
 	set := make(map[nwep.NodeID]struct{}, len(allowed))
 	for _, id := range allowed {
@@ -144,3 +317,82 @@ func MethodFilter(methods ...string) MiddlewareFunc {
 		}
 	}
 }
+
+// BodyLimit returns middleware that rejects requests whose body is longer than
+// maxBytes with a "bad_request" response and the message "request body too
+// large", before the handler runs. Use it to give routes a tighter limit than
+// the transport's MaxMessageSize or the server-wide WithMaxBodySize. A route
+// cannot raise the server-wide limit, which is checked first. Under
+// WithStreamingUploads, BodyLimit reads the body into memory to measure it.
+func BodyLimit(maxBytes int) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if len(c.Body()) > maxBytes {
+				return c.BadRequest("request body too large")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireHeaders returns middleware that rejects requests missing any of the
+// named headers. Rejected requests receive a "bad_request" response whose
+// message lists every missing header, e.g. "missing required headers:
+// idempotency-key, x-tenant". Header names are matched case-sensitively, as in
+// Context.Header. A header that is present with an empty value counts as
+// present.
+//
+// RequireHeaders is typically attached per route:
+//
+//	srv.Router().Write("/orders", createOrder,
+//	    velocity.RequireHeaders("idempotency-key"))
+func RequireHeaders(names ...string) MiddlewareFunc {
+	required := append([]string(nil), names...)
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			var missing []string
+			for _, name := range required {
+				if _, ok := c.Header(name); !ok {
+					missing = append(missing, name)
+				}
+			}
+			if len(missing) > 0 {
+				return c.BadRequest("missing required headers: " + strings.Join(missing, ", "))
+			}
+			return next(c)
+		}
+	}
+}
+
+// ContentType returns middleware that sets the "content-type" response header
+// to ct before the handler runs. The handler can still override it by calling
+// SetHeader itself, and helpers that set their own content type (such as
+// Context.JSON) take precedence. Use it for routes or groups that uniformly
+// return one content type; see also Group.SetContentType.
+func ContentType(ct string) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			c.SetHeader("content-type", ct)
+			return next(c)
+		}
+	}
+}
+
+// Timeout returns middleware that gives the request a deadline of d from the
+// time the middleware runs, replacing the server default set by WithTimeout.
+// A d of zero or less removes the deadline for the route. Like WithTimeout,
+// the timeout is cooperative: Context.Ctx is cancelled at the deadline, and if
+// the handler returns after it without responding, the server responds with
+// status "unavailable".
+//
+// Timeout is intended as route or group middleware; pass it to Handle,
+// Method, or Group to give slow routes a longer limit or fast ones a shorter
+// one.
+func Timeout(d time.Duration) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			c.setTimeout(d)
+			return next(c)
+		}
+	}
+}
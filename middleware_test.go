@@ -0,0 +1,93 @@
+package velocity
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecoverWith(t *testing.T) {
+	var got any
+	var stack []byte
+	mw := RecoverWith(RecoverConfig{
+		StackSize: 256,
+		OnPanic: func(c *Context, v any, s []byte) {
+			got, stack = v, s
+			c.JSONStatus(StatusInternalError, map[string]string{"error": "boom"})
+		},
+	})
+	c, rec := NewTestContext(MethodRead, "/", nil)
+	if err := mw(func(*Context) error { panic("boom") })(c); err != nil {
+		t.Fatal(err)
+	}
+	if got != "boom" || len(stack) == 0 || len(stack) > 256 || !bytes.HasPrefix(stack, []byte("goroutine ")) {
+		t.Fatalf("OnPanic got %v with %d-byte stack", got, len(stack))
+	}
+	if rec.Status != StatusInternalError || string(rec.Body) != `{"error":"boom"}` {
+		t.Fatalf("response %s %q", rec.Status, rec.Body)
+	}
+
+	c, rec = NewTestContext(MethodRead, "/", nil)
+	if err := RecoverWith(RecoverConfig{LogStack: true})(func(*Context) error { panic(1) })(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != StatusInternalError || string(rec.Body) != "internal error" {
+		t.Fatalf("default response %s %q", rec.Status, rec.Body)
+	}
+}
+
+func TestUnless(t *testing.T) {
+	limit := RateLimit(1, 1, RateLimitKey(KeyGlobal))
+	mw := Unless(limit, SkipRoutes("/health"))
+	h := mw(func(c *Context) error { return c.NoContent() })
+	serve := func(route string) string {
+		c, rec := NewTestContext(MethodRead, route, nil)
+		c.route = route
+		h(c)
+		return rec.Status
+	}
+	if st := serve("/orders"); st != StatusNoContent {
+		t.Fatalf("first request: %q", st)
+	}
+	if st := serve("/orders"); st != StatusRateLimited {
+		t.Fatalf("second request: %q", st)
+	}
+	if st := serve("/health"); st != StatusNoContent {
+		t.Fatalf("skipped route: %q", st)
+	}
+
+	h = RateLimit(1, 1, RateLimitKey(KeyGlobal), RateLimitSkipper(SkipRoutes("/health")))(
+		func(c *Context) error { return c.NoContent() })
+	for range 3 {
+		if st := serve("/health"); st != StatusNoContent {
+			t.Fatalf("RateLimitSkipper: %q", st)
+		}
+	}
+}
+
+func TestWhen(t *testing.T) {
+	var ran int
+	count := func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error { ran++; return next(c) }
+	}
+	h := When(MatchAll(MatchMethods(MethodWrite), MatchPath("/admin/**", "/users/:id")), count)(
+		func(c *Context) error { return nil })
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{MethodWrite, "/admin", 1},
+		{MethodWrite, "/admin/users/7?x=1", 1},
+		{MethodWrite, "/users/7", 1},
+		{MethodWrite, "/users", 0},
+		{MethodRead, "/admin/users", 0},
+		{MethodWrite, "/administrator", 0},
+	}
+	for _, tt := range tests {
+		ran = 0
+		c, _ := NewTestContext(tt.method, tt.path, nil)
+		h(c)
+		if ran != tt.want {
+			t.Errorf("%s %s: middleware ran %d times, want %d", tt.method, tt.path, ran, tt.want)
+		}
+	}
+}
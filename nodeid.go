@@ -0,0 +1,123 @@
+package velocity
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// FormatNodeID returns the canonical text form of a node ID: 64 lowercase hex
+// characters. velocity uses this form wherever it writes a node ID, including
+// every "peer" field it logs, so an ID copied from a log can be parsed back
+// with NodeIDFromString and used with AllowPeers.
+func FormatNodeID(id nwep.NodeID) string {
+	return hex.EncodeToString(id[:])
+}
+
+// NodeIDFromString parses a node ID in the form produced by FormatNodeID.
+// Surrounding whitespace is ignored and upper-case hex digits are accepted.
+// This function returns an error if s is not 64 hex characters.
+func NodeIDFromString(s string) (nwep.NodeID, error) {
+	var id nwep.NodeID
+	s = strings.TrimSpace(s)
+	if len(s) != hex.EncodedLen(len(id)) {
+		return id, fmt.Errorf("velocity: parse node ID: want %d hex characters, got %d", hex.EncodedLen(len(id)), len(s))
+	}
+	if _, err := hex.Decode(id[:], []byte(s)); err != nil {
+		return nwep.NodeID{}, fmt.Errorf("velocity: parse node ID: %w", err)
+	}
+	return id, nil
+}
+
+// PeerNodeIDString returns the peer's node ID formatted with FormatNodeID.
+// For an unauthenticated peer it returns the all-zero ID.
+func (c *Context) PeerNodeIDString() string {
+	return FormatNodeID(c.PeerNodeID())
+}
+
+// base58Alphabet is the Bitcoin base58 alphabet used in WEB/1 URLs.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// NodeIDFromURL returns the node ID of the server that a WEB/1 URL, such as
+// one returned by Server.URL, points to. Such URLs have the form
+// web://[Base58(IP||NodeID)]:port/path, so the node ID is the last 32 bytes
+// of the decoded host. This function returns an error if url is not of that
+// form.
+func NodeIDFromURL(url string) (nwep.NodeID, error) {
+	rest, ok := strings.CutPrefix(url, "web://")
+	if !ok {
+		return nwep.NodeID{}, fmt.Errorf("velocity: parse node ID: %q is not a web:// URL", url)
+	}
+	host, _, _ := strings.Cut(rest, "/")
+	host = strings.TrimPrefix(host, "[")
+	if i := strings.LastIndex(host, "]"); i >= 0 {
+		host = host[:i]
+	} else if i := strings.LastIndexByte(host, ':'); i >= 0 {
+		host = host[:i]
+	}
+	raw, err := decodeBase58(host)
+	if err != nil {
+		return nwep.NodeID{}, fmt.Errorf("velocity: parse node ID from %q: %w", url, err)
+	}
+	var id nwep.NodeID
+	if n := len(raw) - len(id); n != 4 && n != 16 {
+		return id, fmt.Errorf("velocity: parse node ID from %q: host decodes to %d bytes, want an IPv4 or IPv6 address and a node ID", url, len(raw))
+	}
+	copy(id[:], raw[len(raw)-len(id):])
+	return id, nil
+}
+
+// decodeBase58 decodes s in the Bitcoin base58 alphabet.
+func decodeBase58(s string) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty base58 string")
+	}
+	var out []byte // big-endian, without leading zeros
+	for _, r := range s {
+		d := strings.IndexRune(base58Alphabet, r)
+		if d < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", r)
+		}
+		carry := d
+		for i := len(out) - 1; i >= 0; i-- {
+			carry += int(out[i]) * 58
+			out[i] = byte(carry)
+			carry >>= 8
+		}
+		for ; carry > 0; carry >>= 8 {
+			out = append([]byte{byte(carry)}, out...)
+		}
+	}
+	zeros := len(s) - len(strings.TrimLeft(s, "1"))
+	return append(make([]byte, zeros), out...), nil
+}
+
+// encodeBase58 encodes b in the Bitcoin base58 alphabet. It is the inverse
+// of decodeBase58.
+func encodeBase58(b []byte) string {
+	var digits []byte // little-endian base58
+	for _, c := range b {
+		carry := int(c)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for ; carry > 0; carry /= 58 {
+			digits = append(digits, byte(carry%58))
+		}
+	}
+	out := make([]byte, 0, len(b)+len(digits))
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, '1')
+	}
+	for i := len(digits) - 1; i >= 0; i-- {
+		out = append(out, base58Alphabet[digits[i]])
+	}
+	return string(out)
+}
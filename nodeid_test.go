@@ -0,0 +1,44 @@
+package velocity
+
+import (
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestNodeIDRoundTrip(t *testing.T) {
+	var id [32]byte
+	for i := range id {
+		id[i] = byte(i * 7)
+	}
+	s := FormatNodeID(id)
+	got, err := NodeIDFromString(" " + s + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != id {
+		t.Fatalf("round trip: got %x, want %x", got, id)
+	}
+
+	for _, bad := range []string{"", "abc", s[:62] + "zz", s + "00"} {
+		if _, err := NodeIDFromString(bad); err == nil {
+			t.Errorf("NodeIDFromString(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestNodeIDFromURL(t *testing.T) {
+	if got, err := decodeBase58("StV1DL6CwTryKyV"); err != nil || string(got) != "hello world" {
+		t.Fatalf("decodeBase58 = %q, %v", got, err)
+	}
+	id := nwep.NodeID{0, 1, 2, 3, 250}
+	got, err := NodeIDFromURL(testPoolURL(id) + "path")
+	if err != nil || got != id {
+		t.Fatalf("NodeIDFromURL = %x, %v; want %x", got, err, id)
+	}
+	for _, bad := range []string{"http://x/", "web://0OIl:1/", "web://" + encodeBase58([]byte{1, 2}) + ":1/"} {
+		if _, err := NodeIDFromURL(bad); err == nil {
+			t.Errorf("NodeIDFromURL(%q) succeeded", bad)
+		}
+	}
+}
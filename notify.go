@@ -1,28 +1,67 @@
 package velocity
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"slices"
+	"sync"
+	"time"
 
 	nwep "github.com/usenwep/nwep-go"
 )
 
+// NotifyTransformFunc transforms the body of an outgoing notification. It
+// receives the event name, path, and body exactly as passed to the Notify
+// family of methods and returns the body to send. Returning nil drops the
+// notification.
+type NotifyTransformFunc func(event, path string, body []byte) []byte
+
+// SetNotifyTransform installs fn as the server-wide notification transform.
+// fn is applied to every notification sent through Notify, NotifyWithOptions,
+// NotifyAll, and their JSON variants, immediately before the notification is
+// handed to the underlying nwep server. The JSON variants marshal first, so fn
+// always sees the encoded bytes.
+//
+// If fn returns nil the notification is dropped: Notify and NotifyWithOptions
+// return nil and NotifyAll sends nothing. Because a nil body is otherwise a
+// valid payload, a transform that wants to forward body-less notifications
+// must return a non-nil empty slice for them. Passing a nil fn removes the
+// transform. SetNotifyTransform must be called before Run or Start.
+func (s *Server) SetNotifyTransform(fn NotifyTransformFunc) {
+	s.notifyTransform = fn
+}
+
+// transformNotify applies the notification transform, if any. The second
+// return value is false if the transform dropped the notification.
+func (s *Server) transformNotify(event, path string, body []byte) ([]byte, bool) {
+	if s.notifyTransform == nil {
+		return body, true
+	}
+	body = s.notifyTransform(event, path, body)
+	return body, body != nil
+}
+
 // Notify sends a server-initiated notification to a specific peer. The
 // notification is delivered as a WEB/1 NOTIFY message with the given event
 // name, path, and body.
 //
-// peer is the 32-byte node ID of the target peer. The peer must be currently
-// connected - if it is not, the notification is silently dropped by the
-// underlying nwep server. event is an application-defined event name (e.g.
-// "update", "delete"). path identifies the resource the event relates to.
-// body may be nil for events that carry no payload.
+// peer is the 32-byte node ID of the target peer. event is an
+// application-defined event name (e.g. "update", "delete"), path identifies
+// the resource the event relates to, and body may be nil for events that
+// carry no payload.
+//
+// The peer must be currently connected. If it is not, the nwep server
+// silently drops the notification, unless WithNotifyQueue is configured, in
+// which case it is queued until the peer reconnects.
+//
+// If WithNotifyRateLimit is configured, Notify may block until the peer is
+// under its rate, or return ErrNotifyRateLimited; see NotifyLimitMode.
 //
 // This function returns ErrServerNotRunning if the server has not been started,
 // or a non-nil error if the underlying nwep notification fails.
 func (s *Server) Notify(peer nwep.NodeID, event, path string, body []byte) error {
-	if s.nwep == nil {
-		return ErrServerNotRunning
-	}
-	return s.nwep.Notify(peer, event, path, body)
+	_, err := s.notify(peer, event, path, body, nil, false)
+	return err
 }
 
 // NotifyWithOptions sends a notification to a specific peer with additional
@@ -31,22 +70,279 @@ func (s *Server) Notify(peer nwep.NodeID, event, path string, body []byte) error
 // opts must not be nil. See nwep.NotifyOptions for the available fields. This
 // function returns ErrServerNotRunning if the server has not been started.
 func (s *Server) NotifyWithOptions(peer nwep.NodeID, event, path string, body []byte, opts *nwep.NotifyOptions) error {
-	if s.nwep == nil {
-		return ErrServerNotRunning
-	}
-	return s.nwep.NotifyWithOptions(peer, event, path, body, opts)
+	_, err := s.notify(peer, event, path, body, opts, false)
+	return err
 }
 
 // NotifyAll broadcasts a notification to every currently connected peer. The
 // notification is delivered as a WEB/1 NOTIFY message with the given event
 // name, path, and body. body may be nil.
 //
+// If WithNotifyRateLimit is configured, the broadcast is fanned out peer by
+// peer so that each peer's rate is enforced individually. Over-limit peers are
+// either skipped or sent to later in the background, depending on the
+// NotifyLimitMode; NotifyAll itself never blocks.
+//
 // If the server has not been started, NotifyAll is a no-op.
 func (s *Server) NotifyAll(event, path string, body []byte) {
+	s.broadcast(event, path, body, nil)
+}
+
+// notify is the common path for single-peer notifications. It applies the
+// notification transform and the per-peer rate limit, then sends through
+// nwep, using NotifyWithOptions if opts is non-nil, or adds the notification
+// to the peer's batch under WithNotifyBatching. held is true if the caller
+// already counts the notification in the peer's queue depth, as TryNotify
+// does. dropped reports whether the transform discarded the notification.
+func (s *Server) notify(peer nwep.NodeID, event, path string, body []byte, opts *nwep.NotifyOptions, held bool) (dropped bool, err error) {
+	if s.nwep == nil {
+		s.observeNotify(NotifyEvent{Peer: peer, Event: event, Path: path, Size: len(body), Outcome: NotifyFailed, Err: ErrServerNotRunning})
+		return false, ErrServerNotRunning
+	}
+	size := len(body)
+	body, ok := s.transformNotify(event, path, body)
+	if !ok {
+		s.observeNotify(NotifyEvent{Peer: peer, Event: event, Path: path, Size: size, Outcome: NotifyDropped})
+		return true, nil
+	}
+	if opts == nil && s.notifyBatch != nil && s.batchNotify(peer, Notification{Event: event, Path: path, Body: body}) {
+		return false, nil
+	}
+	_, err = s.deliver(peer, event, path, body, opts, held)
+	return false, err
+}
+
+// deliver sends an already transformed notification to peer, applying the
+// per-peer rate limit, or queues it if peer is offline and the offline queue
+// is enabled, and reports the attempt to the notification observers. Unless
+// held is true, because the caller already counts it, the notification is
+// counted in the peer's queue depth while it is sent. It returns the outcome
+// reported to the observers.
+func (s *Server) deliver(peer nwep.NodeID, event, path string, body []byte, opts *nwep.NotifyOptions, held bool) (string, error) {
+	start := time.Now()
+	outcome, err := s.send(peer, event, path, body, opts, held)
+	s.observeNotify(NotifyEvent{
+		Peer:    peer,
+		Event:   event,
+		Path:    path,
+		Size:    len(body),
+		Outcome: outcome,
+		Err:     err,
+		Latency: time.Since(start),
+	})
+	return outcome, err
+}
+
+// send implements deliver, returning the outcome of the attempt.
+func (s *Server) send(peer nwep.NodeID, event, path string, body []byte, opts *nwep.NotifyOptions, held bool) (string, error) {
+	if queued, err := s.queueNotify(peer, event, path, body, opts); err != nil {
+		return NotifyFailed, err
+	} else if queued {
+		return NotifyQueued, nil
+	}
+	if !held {
+		s.notifyDepth.add(peer, 1)
+		defer s.notifyDepth.add(peer, -1)
+	}
+	if err := s.limitNotify(peer); err != nil {
+		return NotifyRateLimited, err
+	}
+	srv := s.listenerFor(peer)
+	var err error
+	if opts == nil {
+		err = srv.Notify(peer, event, path, body)
+	} else {
+		err = srv.NotifyWithOptions(peer, event, path, body, opts)
+	}
+	if err != nil {
+		return NotifyFailed, err
+	}
+	return NotifySent, nil
+}
+
+// NotifyPeers sends a notification to each of peers concurrently and waits
+// for all sends to finish. It returns the peers whose notification failed,
+// mapped to the error, or nil if every send succeeded. A peer listed more
+// than once is notified once.
+//
+// Each send behaves like Notify: the per-peer rate limit applies, so in
+// NotifyThrottle mode NotifyPeers waits for throttled peers. The notification
+// transform runs once, and if it drops the notification nothing is sent and
+// NotifyPeers returns nil. If the server has not been started, every peer is
+// mapped to ErrServerNotRunning.
+func (s *Server) NotifyPeers(peers []nwep.NodeID, event, path string, body []byte) map[nwep.NodeID]error {
 	if s.nwep == nil {
-		return
+		errs := make(map[nwep.NodeID]error, len(peers))
+		for _, peer := range peers {
+			errs[peer] = ErrServerNotRunning
+		}
+		return errs
+	}
+	size := len(body)
+	body, ok := s.transformNotify(event, path, body)
+	if !ok {
+		s.observeNotify(NotifyEvent{Event: event, Path: path, Size: size, Outcome: NotifyDropped})
+		return nil
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs map[nwep.NodeID]error
+	)
+	seen := make(map[nwep.NodeID]bool, len(peers))
+	for _, peer := range peers {
+		if seen[peer] {
+			continue
+		}
+		seen[peer] = true
+		wg.Go(func() {
+			if _, err := s.deliver(peer, event, path, body, nil, false); err != nil {
+				mu.Lock()
+				if errs == nil {
+					errs = make(map[nwep.NodeID]error)
+				}
+				errs[peer] = err
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	return errs
+}
+
+// broadcast is the common path for notifications to every connected peer. If
+// neither a rate limit nor options are in play it hands the broadcast to nwep
+// in one call; otherwise it fans out peer by peer. dropped reports whether the
+// transform discarded the notification.
+func (s *Server) broadcast(event, path string, body []byte, opts *nwep.NotifyOptions) (dropped bool) {
+	if s.nwep == nil {
+		return false
+	}
+	size := len(body)
+	body, ok := s.transformNotify(event, path, body)
+	if !ok {
+		s.observeNotify(NotifyEvent{Broadcast: true, Event: event, Path: path, Size: size, Outcome: NotifyDropped})
+		return true
+	}
+	l := s.notifyLimiter
+	if l == nil && opts == nil {
+		start := time.Now()
+		for _, srv := range s.allListeners() {
+			srv.NotifyAll(event, path, body)
+		}
+		s.observeNotify(NotifyEvent{
+			Broadcast: true,
+			Event:     event,
+			Path:      path,
+			Size:      len(body),
+			Outcome:   NotifySent,
+			Latency:   time.Since(start),
+		})
+		return false
+	}
+	s.fanout(s.ConnectedPeers(), event, path, body, opts)
+	return false
+}
+
+// fanout delivers an already transformed notification to each of peers,
+// applying the per-peer rate limit if one is configured.
+func (s *Server) fanout(peers []nwep.NodeID, event, path string, body []byte, opts *nwep.NotifyOptions) {
+	l := s.notifyLimiter
+	start := time.Now()
+	for _, peer := range peers {
+		if l == nil {
+			s.notifyDepth.add(peer, 1)
+			s.sendBroadcast(peer, event, path, body, opts, start)
+			continue
+		}
+		wait, ok := l.take(peer)
+		if !ok {
+			s.observeNotify(NotifyEvent{
+				Peer:      peer,
+				Broadcast: true,
+				Event:     event,
+				Path:      path,
+				Size:      len(body),
+				Outcome:   NotifyRateLimited,
+				Err:       ErrNotifyRateLimited,
+			})
+			continue
+		}
+		s.notifyDepth.add(peer, 1)
+		if wait == 0 {
+			s.sendBroadcast(peer, event, path, body, opts, start)
+			continue
+		}
+		l.after(wait, func() { s.sendBroadcast(peer, event, path, body, opts, start) })
+	}
+}
+
+// sendBroadcast delivers one peer's share of a fanned-out broadcast that
+// started at start, for which the caller has counted the peer's queue depth
+// up. Errors are logged rather than returned because NotifyAll has no error
+// result.
+func (s *Server) sendBroadcast(peer nwep.NodeID, event, path string, body []byte, opts *nwep.NotifyOptions, start time.Time) {
+	defer s.notifyDepth.add(peer, -1)
+	var err error
+	if srv := s.listenerFor(peer); opts == nil {
+		err = srv.Notify(peer, event, path, body)
+	} else {
+		err = srv.NotifyWithOptions(peer, event, path, body, opts)
+	}
+	e := NotifyEvent{
+		Peer:      peer,
+		Broadcast: true,
+		Event:     event,
+		Path:      path,
+		Size:      len(body),
+		Outcome:   NotifySent,
+		Err:       err,
+		Latency:   time.Since(start),
+	}
+	if err != nil {
+		e.Outcome = NotifyFailed
+	}
+	s.observeNotify(e)
+	if err != nil {
+		s.logger.Warn("notify failed",
+			"peer", FormatNodeID(peer),
+			"event", event,
+			"path", path,
+			"error", err.Error(),
+		)
+	}
+}
+
+// limitNotify applies the per-peer notification rate limit, if configured. In
+// NotifyThrottle mode it sleeps until the peer is under its rate; in NotifyDrop
+// mode, or if the peer already owes a full burst, it returns
+// ErrNotifyRateLimited instead.
+func (s *Server) limitNotify(peer nwep.NodeID) error {
+	if s.notifyLimiter == nil {
+		return nil
+	}
+	wait, ok := s.notifyLimiter.take(peer)
+	if !ok {
+		return ErrNotifyRateLimited
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return nil
+}
+
+// NotifyStats returns a snapshot of the server's notification counters. The
+// counters are zero unless WithNotifyRateLimit is configured and the server
+// has been started.
+func (s *Server) NotifyStats() NotifyStats {
+	if s.notifyLimiter == nil {
+		return NotifyStats{}
+	}
+	return NotifyStats{
+		Throttled: s.notifyLimiter.throttled.Load(),
+		Dropped:   s.notifyLimiter.dropped.Load(),
 	}
-	s.nwep.NotifyAll(event, path, body)
 }
 
 // NotifyJSON marshals v to JSON and sends the result as a notification to the
@@ -76,21 +372,118 @@ func (s *Server) NotifyAllJSON(event, path string, v any) error {
 	return nil
 }
 
-// ConnectionCount returns the number of active peer connections. If the server
-// has not been started, it returns 0.
+// ConnectionCount returns the number of active peer connections, across all
+// listeners. If the server has not been started, it returns 0.
 func (s *Server) ConnectionCount() int {
-	if s.nwep == nil {
-		return 0
+	n := 0
+	for _, srv := range s.allListeners() {
+		n += srv.ConnectionCount()
 	}
-	return s.nwep.ConnectionCount()
+	return n
 }
 
-// ConnectedPeers returns the node IDs of all currently connected peers. The
-// returned slice is a snapshot - it may become stale as peers connect and
-// disconnect. If the server has not been started, it returns nil.
+// ConnectedPeers returns the node IDs of all currently connected peers, across
+// all listeners. The returned slice is a snapshot - it may become stale as
+// peers connect and disconnect. If the server has not been started, it
+// returns nil.
 func (s *Server) ConnectedPeers() []nwep.NodeID {
-	if s.nwep == nil {
+	listeners := s.allListeners()
+	if len(listeners) <= 1 {
+		if s.nwep == nil {
+			return nil
+		}
+		return s.nwep.ConnectedPeers()
+	}
+	var peers []nwep.NodeID
+	for _, srv := range listeners {
+		peers = append(peers, srv.ConnectedPeers()...)
+	}
+	// A peer connected to more than one listener is listed once.
+	return slices.Compact(sortedNodeIDs(peers))
+}
+
+// HeaderCorrelationID is the notification header that carries the ID of the
+// request that caused the notification. It is set by the Context notification
+// methods when the server is configured with WithNotifyCorrelation. The value
+// is the request's 16-byte RequestID in lowercase hex.
+const HeaderCorrelationID = "x-correlation-id"
+
+// CorrelationID returns the value of the HeaderCorrelationID header from the
+// headers of a received notification. It is the client-side counterpart of
+// WithNotifyCorrelation. The second return value is false if the notification
+// was not stamped.
+func CorrelationID(headers []nwep.Header) (string, bool) {
+	for _, h := range headers {
+		if h.Name == HeaderCorrelationID {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// Notify sends a notification to peer on behalf of the current request. It
+// behaves like Server.Notify, with two additions: if the server was configured
+// with WithNotifyCorrelation, the notification carries a HeaderCorrelationID
+// header set to this request's ID, and if the notification is dropped by the
+// notification transform or fails to send, the outcome is logged together with
+// the request ID.
+func (c *Context) Notify(peer nwep.NodeID, event, path string, body []byte) error {
+	dropped, err := c.server.notify(peer, event, path, body, c.notifyOptions(), false)
+	c.logNotify(FormatNodeID(peer), event, path, dropped, err)
+	return err
+}
+
+// NotifyJSON marshals v to JSON and sends it to peer with Context.Notify.
+func (c *Context) NotifyJSON(peer nwep.NodeID, event, path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Notify(peer, event, path, data)
+}
+
+// NotifyAll broadcasts a notification to every connected peer on behalf of the
+// current request. It behaves like Server.NotifyAll, with the same correlation
+// stamping and logging as Context.Notify.
+func (c *Context) NotifyAll(event, path string, body []byte) {
+	dropped := c.server.broadcast(event, path, body, c.notifyOptions())
+	c.logNotify("*", event, path, dropped, nil)
+}
+
+// notifyOptions returns the options that stamp a notification with this
+// request's correlation ID, or nil if correlation is disabled.
+func (c *Context) notifyOptions() *nwep.NotifyOptions {
+	if !c.server.notifyCorrelation {
 		return nil
 	}
-	return s.nwep.ConnectedPeers()
+	return &nwep.NotifyOptions{
+		Headers: []nwep.Header{{Name: HeaderCorrelationID, Value: c.requestIDHex()}},
+	}
+}
+
+// logNotify records a dropped or failed notification together with the ID of
+// the request that sent it. Successful sends are not logged.
+func (c *Context) logNotify(peer, event, path string, dropped bool, err error) {
+	switch {
+	case dropped:
+		c.Logger().Debug("notify dropped by transform",
+			"request_id", c.requestIDHex(),
+			"peer", peer,
+			"event", event,
+			"path", path,
+		)
+	case err != nil:
+		c.Logger().Warn("notify failed",
+			"request_id", c.requestIDHex(),
+			"peer", peer,
+			"event", event,
+			"path", path,
+			"error", err.Error(),
+		)
+	}
+}
+
+func (c *Context) requestIDHex() string {
+	rid := c.Request.RequestID
+	return hex.EncodeToString(rid[:])
 }
@@ -0,0 +1,44 @@
+package velocity
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestNotifyCorrelation(t *testing.T) {
+	var logs bytes.Buffer
+	s := &Server{logger: SlogLogger(slog.New(slog.NewTextHandler(&logs, nil))), peers: newPeerTracker()}
+	req := &nwep.Request{RequestID: [16]byte{0xab, 0x01}}
+	c := &Context{server: s, Request: req}
+
+	if opts := c.notifyOptions(); opts != nil {
+		t.Fatalf("notifyOptions without correlation = %+v, want nil", opts)
+	}
+	if err := WithNotifyCorrelation()(s); err != nil {
+		t.Fatal(err)
+	}
+	opts := c.notifyOptions()
+	if opts == nil {
+		t.Fatal("notifyOptions with correlation = nil")
+	}
+	want := "ab010000000000000000000000000000"
+	if id, ok := CorrelationID(opts.Headers); !ok || id != want {
+		t.Fatalf("CorrelationID = %q, %v, want %q", id, ok, want)
+	}
+	if _, ok := CorrelationID([]nwep.Header{{Name: "x-other", Value: want}}); ok {
+		t.Fatal("CorrelationID found an ID in unstamped headers")
+	}
+
+	// The server is not running, so the send fails and is logged with the
+	// ID of the request that made it.
+	if err := c.Notify(nwep.NodeID{1}, "update", "/orders/7", nil); err != ErrServerNotRunning {
+		t.Fatalf("Notify = %v, want ErrServerNotRunning", err)
+	}
+	if out := logs.String(); !strings.Contains(out, "notify failed") || !strings.Contains(out, "request_id="+want) {
+		t.Fatalf("log = %q, want a failure with the request ID", out)
+	}
+}
@@ -0,0 +1,279 @@
+package velocity
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// NotifyBatchEvent is the event name of a notification that carries a batch
+// of notifications, as sent by NotifyBatch and WithNotifyBatching. NotifyMux,
+// and so Client.OnNotify, unpacks batches and dispatches each notification in
+// them; other receivers can use DecodeNotifyBatch.
+const NotifyBatchEvent = "velocity.batch"
+
+// NotifyBatch sends ns to peer as one notification, so that a burst of small
+// notifications costs one protocol message rather than one each. The peer
+// receives them in order; a velocity Client or NotifyMux dispatches them one
+// by one as if they had been sent separately.
+//
+// The notification transform is applied to each notification, and one that
+// it drops is left out. The batch then goes through the offline queue and
+// the rate limit as one notification, and the observers see it as one
+// attempt with event NotifyBatchEvent. A batch of a single notification is
+// sent as that notification. Peers that are not velocity clients must decode
+// batches with DecodeNotifyBatch.
+//
+// This function returns ErrServerNotRunning if the server has not been
+// started, or the error of the send.
+func (s *Server) NotifyBatch(peer nwep.NodeID, ns []Notification) error {
+	if s.nwep == nil {
+		return ErrServerNotRunning
+	}
+	kept := make([]Notification, 0, len(ns))
+	for _, n := range ns {
+		size := len(n.Body)
+		body, ok := s.transformNotify(n.Event, n.Path, n.Body)
+		if !ok {
+			s.observeNotify(NotifyEvent{Peer: peer, Event: n.Event, Path: n.Path, Size: size, Outcome: NotifyDropped})
+			continue
+		}
+		kept = append(kept, Notification{Event: n.Event, Path: n.Path, Body: body})
+	}
+	return s.sendBatch(peer, kept)
+}
+
+// sendBatch sends already transformed notifications to peer as one batch.
+func (s *Server) sendBatch(peer nwep.NodeID, ns []Notification) error {
+	switch len(ns) {
+	case 0:
+		return nil
+	case 1:
+		_, err := s.deliver(peer, ns[0].Event, ns[0].Path, ns[0].Body, nil, false)
+		return err
+	}
+	_, err := s.deliver(peer, NotifyBatchEvent, "/", EncodeNotifyBatch(ns), nil, false)
+	return err
+}
+
+// EncodeNotifyBatch encodes ns as the body of a NotifyBatchEvent
+// notification: for each notification in order, its event, path, and body,
+// each preceded by its length as an unsigned varint.
+func EncodeNotifyBatch(ns []Notification) []byte {
+	size := 0
+	for _, n := range ns {
+		size += 3*binary.MaxVarintLen32 + len(n.Event) + len(n.Path) + len(n.Body)
+	}
+	b := make([]byte, 0, size)
+	for _, n := range ns {
+		b = binary.AppendUvarint(b, uint64(len(n.Event)))
+		b = append(b, n.Event...)
+		b = binary.AppendUvarint(b, uint64(len(n.Path)))
+		b = append(b, n.Path...)
+		b = binary.AppendUvarint(b, uint64(len(n.Body)))
+		b = append(b, n.Body...)
+	}
+	return b
+}
+
+// DecodeNotifyBatch decodes the body of a NotifyBatchEvent notification into
+// the notifications it carries. The bodies share body's memory. It returns an
+// error if body is not a well-formed batch.
+func DecodeNotifyBatch(body []byte) ([]Notification, error) {
+	var ns []Notification
+	field := func() ([]byte, error) {
+		n, k := binary.Uvarint(body)
+		if k <= 0 || n > uint64(len(body)-k) {
+			return nil, errors.New("velocity: malformed notification batch")
+		}
+		f := body[k : k+int(n)]
+		body = body[k+int(n):]
+		return f, nil
+	}
+	for len(body) > 0 {
+		var fs [3][]byte
+		for i := range fs {
+			f, err := field()
+			if err != nil {
+				return nil, err
+			}
+			fs[i] = f
+		}
+		ns = append(ns, Notification{Event: string(fs[0]), Path: string(fs[1]), Body: fs[2]})
+	}
+	return ns, nil
+}
+
+// notifyBatcher collects the notifications sent to each peer with Notify
+// during a window and sends them as one batch. See WithNotifyBatching.
+type notifyBatcher struct {
+	window time.Duration
+	max    int
+
+	mu      sync.Mutex
+	pending map[nwep.NodeID]*pendingBatch
+	closed  bool
+
+	// sends holds, per peer, the batches taken out of pending but not yet
+	// sent, in the order they were taken. A peer has an entry while a
+	// goroutine is sending its batches; only that goroutine sends them, so
+	// a peer's batches leave in order and never concurrently. draining
+	// counts those goroutines.
+	sends    map[nwep.NodeID][][]Notification
+	draining sync.WaitGroup
+}
+
+// pendingBatch is a batch waiting for its window to end.
+type pendingBatch struct {
+	ns    []Notification
+	timer *time.Timer
+}
+
+// WithNotifyBatching batches the notifications sent to each peer with
+// Notify, NotifyJSON, and Context.Notify: the first one starts a window of
+// window, and the notifications sent to the same peer until it ends are sent
+// together, as by NotifyBatch, or as soon as max of them are waiting:
+//
+//	velocity.WithNotifyBatching(5*time.Millisecond, 64)
+//
+// This trades up to window of latency for fewer protocol messages under
+// rapid-fire notification. A batched Notify returns nil once the
+// notification is collected; an error sending the batch is logged and
+// reported to the notification observers instead. Notifications with options
+// (NotifyWithOptions, or Context.Notify under WithNotifyCorrelation),
+// broadcasts, and NotifyWithAck are not batched. A peer's batches are sent
+// in the background, one at a time, in the order they filled or their
+// windows ended. Batches waiting when the server shuts down are sent first.
+// Receivers must understand batches; see NotifyBatchEvent. This function
+// returns an error if window is not positive or max is less than 2.
+func WithNotifyBatching(window time.Duration, max int) Option {
+	return func(s *Server) error {
+		if window <= 0 {
+			return fmt.Errorf("velocity: notify batching window must be positive, got %s", window)
+		}
+		if max < 2 {
+			return fmt.Errorf("velocity: notify batch size must be at least 2, got %d", max)
+		}
+		s.notifyBatch = &notifyBatcher{window: window, max: max, pending: make(map[nwep.NodeID]*pendingBatch)}
+		return nil
+	}
+}
+
+// batchNotify adds an already transformed notification to peer's pending
+// batch. It reports false if batching has stopped, in which case the caller
+// sends the notification itself.
+func (s *Server) batchNotify(peer nwep.NodeID, n Notification) bool {
+	b := s.notifyBatch
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return false
+	}
+	p := b.pending[peer]
+	if p == nil {
+		p = &pendingBatch{}
+		p.timer = time.AfterFunc(b.window, func() { s.flushBatch(peer, p) })
+		b.pending[peer] = p
+	}
+	p.ns = append(p.ns, n)
+	drain := false
+	if len(p.ns) >= b.max {
+		p.timer.Stop()
+		delete(b.pending, peer)
+		drain = b.queueSend(peer, p.ns)
+	}
+	b.mu.Unlock()
+	if drain {
+		go s.drainSends(peer)
+	}
+	return true
+}
+
+// flushBatch sends p, peer's pending batch, when its window ends, unless it
+// has already been sent.
+func (s *Server) flushBatch(peer nwep.NodeID, p *pendingBatch) {
+	b := s.notifyBatch
+	b.mu.Lock()
+	if b.pending[peer] != p {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, peer)
+	drain := b.queueSend(peer, p.ns)
+	b.mu.Unlock()
+	if drain {
+		s.drainSends(peer)
+	}
+}
+
+// queueSend appends ns to peer's batches waiting to be sent. It reports true
+// if no goroutine is sending peer's batches, in which case the caller must
+// call drainSends. The caller must hold b.mu.
+func (b *notifyBatcher) queueSend(peer nwep.NodeID, ns []Notification) bool {
+	if b.sends == nil {
+		b.sends = make(map[nwep.NodeID][][]Notification)
+	}
+	q, busy := b.sends[peer]
+	b.sends[peer] = append(q, ns)
+	if !busy {
+		b.draining.Add(1)
+	}
+	return !busy
+}
+
+// drainSends sends peer's waiting batches, in order, until none are left.
+func (s *Server) drainSends(peer nwep.NodeID) {
+	b := s.notifyBatch
+	defer b.draining.Done()
+	for {
+		b.mu.Lock()
+		q := b.sends[peer]
+		if len(q) == 0 {
+			delete(b.sends, peer)
+			b.mu.Unlock()
+			return
+		}
+		b.sends[peer] = q[1:]
+		b.mu.Unlock()
+		s.sendPending(peer, q[0])
+	}
+}
+
+// sendPending sends a collected batch, logging a failure.
+func (s *Server) sendPending(peer nwep.NodeID, ns []Notification) {
+	if err := s.sendBatch(peer, ns); err != nil {
+		s.logger.Warn("notify batch failed",
+			"peer", FormatNodeID(peer),
+			"notifications", len(ns),
+			"error", err.Error(),
+		)
+	}
+}
+
+// closeNotifyBatching stops batching, sends the batches still waiting, and
+// waits for the sends in progress to finish.
+func (s *Server) closeNotifyBatching() {
+	b := s.notifyBatch
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.closed = true
+	var drain []nwep.NodeID
+	for peer, p := range b.pending {
+		p.timer.Stop()
+		if b.queueSend(peer, p.ns) {
+			drain = append(drain, peer)
+		}
+	}
+	b.pending = nil
+	b.mu.Unlock()
+	for _, peer := range drain {
+		s.drainSends(peer)
+	}
+	b.draining.Wait()
+}
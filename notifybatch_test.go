@@ -0,0 +1,96 @@
+package velocity
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestNotifyBatch(t *testing.T) {
+	ns := []Notification{
+		{Event: "update", Path: "/orders/7", Body: []byte("a")},
+		{Event: "delete", Path: "/orders/8"},
+	}
+	body := EncodeNotifyBatch(ns)
+	got, err := DecodeNotifyBatch(body)
+	if err != nil || len(got) != 2 || got[0].Path != "/orders/7" || string(got[0].Body) != "a" ||
+		got[1].Event != "delete" || len(got[1].Body) != 0 {
+		t.Fatalf("DecodeNotifyBatch = %+v, %v", got, err)
+	}
+	if _, err := DecodeNotifyBatch(body[:len(body)-3]); err == nil {
+		t.Fatal("truncated batch decoded")
+	}
+
+	var mux NotifyMux
+	var paths []string
+	mux.On("", "/orders/:id", func(n *Notification) { paths = append(paths, n.Event+" "+n.Param("id")) })
+	mux.Dispatch(&nwep.Notification{Event: NotifyBatchEvent, Path: "/", Body: body})
+	if want := []string{"update 7", "delete 8"}; !slices.Equal(paths, want) {
+		t.Fatalf("dispatched %q, want %q", paths, want)
+	}
+}
+
+func TestNotifyBatching(t *testing.T) {
+	s := &Server{peers: newPeerTracker(), notifyQueue: &QueueConfig{Storage: &MemoryQueue{}}}
+	var events []NotifyEvent
+	for _, opt := range []Option{
+		WithNotifyBatching(time.Hour, 3),
+		WithNotifyObserver(func(e NotifyEvent) { events = append(events, e) }),
+	} {
+		if err := opt(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	peer := nwep.NodeID{7}
+	for i := range 4 {
+		if !s.batchNotify(peer, Notification{Event: "tick", Path: "/", Body: []byte{byte(i)}}) {
+			t.Fatal("batching stopped")
+		}
+	}
+	// The first three fill a batch and are sent in the background, here to
+	// the offline queue.
+	s.notifyBatch.draining.Wait()
+	if len(events) != 1 || events[0].Event != NotifyBatchEvent || events[0].Outcome != NotifyQueued {
+		t.Fatalf("events after a full batch = %+v", events)
+	}
+	s.closeNotifyBatching()
+	if len(events) != 2 || events[1].Event != "tick" {
+		t.Fatalf("events after close = %+v", events)
+	}
+	if s.batchNotify(peer, Notification{Event: "tick", Path: "/"}) {
+		t.Fatal("batched after close")
+	}
+}
+
+func TestNotifyBatchSendOrder(t *testing.T) {
+	s := &Server{peers: newPeerTracker(), notifyQueue: &QueueConfig{Storage: &MemoryQueue{}}}
+	var paths []string
+	for _, opt := range []Option{
+		WithNotifyBatching(time.Hour, 2),
+		WithNotifyObserver(func(e NotifyEvent) { paths = append(paths, e.Path) }),
+	} {
+		if err := opt(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, peer := s.notifyBatch, nwep.NodeID{7}
+	b.mu.Lock()
+	first := b.queueSend(peer, []Notification{{Event: "tick", Path: "/a"}})
+	second := b.queueSend(peer, []Notification{{Event: "tick", Path: "/b"}})
+	b.mu.Unlock()
+	if !first || second {
+		t.Fatalf("queueSend = %v, %v, want only the first to drain", first, second)
+	}
+	if got := s.NotifyQueueDepth(peer); got != 2 {
+		t.Fatalf("NotifyQueueDepth with two batches waiting = %d, want 2", got)
+	}
+	s.drainSends(peer)
+	if want := []string{"/a", "/b"}; !slices.Equal(paths, want) {
+		t.Fatalf("sent %q, want %q", paths, want)
+	}
+	if len(b.sends) != 0 {
+		t.Fatalf("sends left after draining: %v", b.sends)
+	}
+}
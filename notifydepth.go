@@ -0,0 +1,142 @@
+package velocity
+
+import (
+	"fmt"
+	"sync"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// DefaultNotifyHighWater is the queue depth at which TryNotify considers a
+// peer busy, unless WithNotifyHighWater sets another.
+const DefaultNotifyHighWater = 64
+
+// notifyDepths counts, per peer, the notifications the server holds that
+// nwep has not yet taken. The zero value is ready to use.
+type notifyDepths struct {
+	mu sync.Mutex
+	n  map[nwep.NodeID]int
+}
+
+// add adjusts peer's count by delta, forgetting peers whose count drops to
+// zero.
+func (d *notifyDepths) add(peer nwep.NodeID, delta int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.n == nil {
+		d.n = make(map[nwep.NodeID]int)
+	}
+	if n := d.n[peer] + delta; n > 0 {
+		d.n[peer] = n
+	} else {
+		delete(d.n, peer)
+	}
+}
+
+// reserve adds one to peer's count and reports true, unless the count plus
+// held has reached limit, in which case it reports false. The check and the
+// increment are one step, so concurrent callers cannot pass the limit.
+func (d *notifyDepths) reserve(peer nwep.NodeID, held, limit int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.n[peer]+held >= limit {
+		return false
+	}
+	if d.n == nil {
+		d.n = make(map[nwep.NodeID]int)
+	}
+	d.n[peer]++
+	return true
+}
+
+// get returns peer's count.
+func (d *notifyDepths) get(peer nwep.NodeID) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.n[peer]
+}
+
+// reset forgets every peer's count.
+func (d *notifyDepths) reset() {
+	d.mu.Lock()
+	d.n = nil
+	d.mu.Unlock()
+}
+
+// WithNotifyHighWater sets the queue depth (see NotifyQueueDepth) at which
+// TryNotify refuses to add to a peer's notifications. The default is
+// DefaultNotifyHighWater. This function returns an error if n is less than 1.
+func WithNotifyHighWater(n int) Option {
+	return func(s *Server) error {
+		if n < 1 {
+			return fmt.Errorf("velocity: notify high-water mark must be at least 1, got %d", n)
+		}
+		s.notifyHighWater = n
+		return nil
+	}
+}
+
+// NotifyQueueDepth returns how many notifications to peer the server is
+// holding: those waiting on the notification rate limit, those collected in
+// a batch (see WithNotifyBatching), and those in an nwep send that has not
+// returned, including the send of each TryNotify call in progress. A peer
+// that keeps up has a depth near zero; one whose depth grows is consuming
+// notifications more slowly than they are sent.
+//
+// nwep does not report the length of its own send buffers, so notifications
+// nwep has accepted are not counted. Without a rate limit or batching, the
+// depth therefore rises only while nwep sends to the peer block, and it
+// bounds the sends in flight rather than the notifications buffered for the
+// peer. Notifications in the offline queue are not counted either;
+// WithNotifyQueue bounds them separately.
+func (s *Server) NotifyQueueDepth(peer nwep.NodeID) int {
+	return s.notifyDepth.get(peer) + s.batchedFor(peer)
+}
+
+// batchedFor returns how many notifications to peer are collected in a batch,
+// including batches waiting for an earlier one to be sent.
+func (s *Server) batchedFor(peer nwep.NodeID) int {
+	b := s.notifyBatch
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	if p := b.pending[peer]; p != nil {
+		n = len(p.ns)
+	}
+	for _, ns := range b.sends[peer] {
+		n += len(ns)
+	}
+	return n
+}
+
+// TryNotify sends a notification to peer like Notify, unless the peer's
+// queue depth (see NotifyQueueDepth) has reached the high-water mark set by
+// WithNotifyHighWater, in which case it sends nothing and returns
+// ErrPeerBusy. Use it for notifications that can be skipped or coalesced,
+// such as progress updates, so that a slow consumer is not buried under
+// notifications it cannot take:
+//
+//	if err := srv.TryNotify(peer, "progress", "/jobs/7", body); errors.Is(err, velocity.ErrPeerBusy) {
+//	    // The peer will catch up with the next update.
+//	}
+//
+// TryNotify counts its own notification in the depth from the check until
+// its send returns, so concurrent TryNotify calls never take the depth past
+// the mark. Notify does not check the mark, and its sends can. Otherwise
+// TryNotify behaves, and returns the same errors, as Notify.
+func (s *Server) TryNotify(peer nwep.NodeID, event, path string, body []byte) error {
+	hw := s.notifyHighWater
+	if hw == 0 {
+		hw = DefaultNotifyHighWater
+	}
+	if !s.notifyDepth.reserve(peer, s.batchedFor(peer), hw) {
+		s.observeNotify(NotifyEvent{Peer: peer, Event: event, Path: path, Size: len(body), Outcome: NotifyBusy, Err: ErrPeerBusy})
+		return ErrPeerBusy
+	}
+	defer s.notifyDepth.add(peer, -1)
+	_, err := s.notify(peer, event, path, body, nil, true)
+	return err
+}
@@ -0,0 +1,83 @@
+package velocity
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestTryNotify(t *testing.T) {
+	s := &Server{peers: newPeerTracker()}
+	var outcomes []string
+	for _, opt := range []Option{
+		WithNotifyHighWater(2),
+		WithNotifyObserver(func(e NotifyEvent) { outcomes = append(outcomes, e.Outcome) }),
+	} {
+		if err := opt(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	peer := nwep.NodeID{3}
+	if err := s.TryNotify(peer, "tick", "/", nil); !errors.Is(err, ErrServerNotRunning) {
+		t.Fatalf("TryNotify to an idle peer = %v, want ErrServerNotRunning", err)
+	}
+	if d := s.NotifyQueueDepth(peer); d != 0 {
+		t.Fatalf("NotifyQueueDepth after TryNotify returned = %d, want 0", d)
+	}
+	s.notifyDepth.add(peer, 2)
+	if d := s.NotifyQueueDepth(peer); d != 2 {
+		t.Fatalf("NotifyQueueDepth = %d, want 2", d)
+	}
+	if err := s.TryNotify(peer, "tick", "/", nil); !errors.Is(err, ErrPeerBusy) {
+		t.Fatalf("TryNotify to a busy peer = %v, want ErrPeerBusy", err)
+	}
+	if want := []string{NotifyFailed, NotifyBusy}; !slices.Equal(outcomes, want) {
+		t.Fatalf("outcomes = %q, want %q", outcomes, want)
+	}
+	s.notifyDepth.add(peer, -2)
+	if d := s.NotifyQueueDepth(peer); d != 0 || len(s.notifyDepth.n) != 0 {
+		t.Fatalf("NotifyQueueDepth after draining = %d", d)
+	}
+	if !s.notifyDepth.reserve(peer, 1, 2) || s.notifyDepth.reserve(peer, 1, 2) {
+		t.Fatal("reserve did not stop at the mark")
+	}
+	s.notifyDepth.add(peer, -1)
+	if err := WithNotifyHighWater(0)(s); err == nil {
+		t.Fatal("WithNotifyHighWater(0) accepted")
+	}
+}
+
+func TestTryNotifyCountsOnce(t *testing.T) {
+	s, err := New(":0", WithNotifyHighWater(2), WithNotifyRateLimit(10, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	// The first notification takes the peer's only token, so the second
+	// waits for the rate limit inside its send.
+	peer := nwep.NodeID{3}
+	s.TryNotify(peer, "tick", "/", nil)
+	done := make(chan error, 1)
+	go func() { done <- s.TryNotify(peer, "tick", "/", nil) }()
+	deadline := time.Now().Add(time.Second)
+	for s.NotifyStats().Throttled == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if d := s.NotifyQueueDepth(peer); d != 1 {
+		t.Fatalf("NotifyQueueDepth during a TryNotify send = %d, want 1", d)
+	}
+	if err := s.TryNotify(peer, "tick", "/", nil); errors.Is(err, ErrPeerBusy) {
+		t.Fatal("TryNotify below the mark returned ErrPeerBusy")
+	}
+	<-done
+	if d := s.NotifyQueueDepth(peer); d != 0 {
+		t.Fatalf("NotifyQueueDepth after the sends = %d, want 0", d)
+	}
+}
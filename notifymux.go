@@ -0,0 +1,105 @@
+package velocity
+
+import (
+	"sync"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// Notification is a notification received by a client, as passed to the
+// handlers of a NotifyMux, or one of a batch sent with Server.NotifyBatch.
+type Notification struct {
+	Event string
+	Path  string
+	Body  []byte
+
+	params []pathParam
+}
+
+// Param returns the value of a path parameter captured by the pattern the
+// notification matched, as Context.Param does for routes.
+func (n *Notification) Param(name string) string {
+	for i := len(n.params) - 1; i >= 0; i-- {
+		if n.params[i].name == name {
+			return n.params[i].value
+		}
+	}
+	return ""
+}
+
+// NotifyMux routes the notifications a client receives to handlers by event
+// and path pattern, in place of a single callback that switches on strings.
+// Install it on an nwep client with its Dispatch method:
+//
+//	var mux velocity.NotifyMux
+//	mux.On("update", "/orders/*", func(n *velocity.Notification) { ... })
+//	client, err := nwep.NewClient(kp, nwep.WithOnNotify(mux.Dispatch))
+//
+// Client has a NotifyMux built in; see Client.OnNotify. The zero value is an
+// empty mux ready to use, and handlers may be registered at any time.
+type NotifyMux struct {
+	mu       sync.RWMutex
+	handlers []notifyHandler
+	notFound func(*Notification)
+}
+
+type notifyHandler struct {
+	event string
+	pr    paramRoute
+	fn    func(*Notification)
+}
+
+// On registers fn for notifications with the given event ("" for any event)
+// whose path matches pattern. Patterns are written as for routes, with
+// ":name", "*", and "**" segments whose values are available from
+// Notification.Param. Every matching handler is called, in registration
+// order. On panics if pattern has a "**" segment anywhere but at the end.
+func (m *NotifyMux) On(event, pattern string, fn func(*Notification)) {
+	h := notifyHandler{event: event, pr: newParamRoute("", &route{pattern: pattern}), fn: fn}
+	m.mu.Lock()
+	m.handlers = append(m.handlers, h)
+	m.mu.Unlock()
+}
+
+// SetNotFound sets the handler for notifications that match no registered
+// handler. Without one, they are dropped.
+func (m *NotifyMux) SetNotFound(fn func(*Notification)) {
+	m.mu.Lock()
+	m.notFound = fn
+	m.mu.Unlock()
+}
+
+// Dispatch calls the handlers that match n. Its signature suits
+// nwep.WithOnNotify. Handlers run on the calling goroutine. A batch (see
+// NotifyBatchEvent) is unpacked and each of its notifications dispatched in
+// turn; a batch that does not decode is dispatched as it is.
+func (m *NotifyMux) Dispatch(n *nwep.Notification) {
+	if n.Event == NotifyBatchEvent {
+		if ns, err := DecodeNotifyBatch(n.Body); err == nil {
+			for _, bn := range ns {
+				m.dispatch(bn.Event, bn.Path, bn.Body)
+			}
+			return
+		}
+	}
+	m.dispatch(n.Event, n.Path, n.Body)
+}
+
+// dispatch calls the handlers that match one notification.
+func (m *NotifyMux) dispatch(event, path string, body []byte) {
+	segs := splitPath(path)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	matched := false
+	for i := range m.handlers {
+		h := &m.handlers[i]
+		if (h.event != "" && h.event != event) || !h.pr.matches(segs) {
+			continue
+		}
+		matched = true
+		h.fn(&Notification{Event: event, Path: path, Body: body, params: h.pr.capture(segs, nil)})
+	}
+	if !matched && m.notFound != nil {
+		m.notFound(&Notification{Event: event, Path: path, Body: body})
+	}
+}
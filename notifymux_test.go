@@ -0,0 +1,26 @@
+package velocity
+
+import (
+	"slices"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestNotifyMux(t *testing.T) {
+	var mux NotifyMux
+	var got []string
+	mux.On("update", "/orders/:id", func(n *Notification) { got = append(got, "order "+n.Param("id")) })
+	mux.On("", "/orders/**", func(n *Notification) { got = append(got, "any "+n.Param("*")) })
+	mux.On("delete", "/orders/:id", func(n *Notification) { got = append(got, "delete") })
+	mux.SetNotFound(func(n *Notification) { got = append(got, "unmatched "+n.Path) })
+
+	mux.Dispatch(&nwep.Notification{Event: "update", Path: "/orders/7"})
+	mux.Dispatch(&nwep.Notification{Event: "created", Path: "/orders/7/items/2"})
+	mux.Dispatch(&nwep.Notification{Event: "update", Path: "/users/7"})
+
+	want := []string{"order 7", "any 7", "any 7/items/2", "unmatched /users/7"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package velocity
+
+import (
+	"errors"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// Notification outcomes, reported in NotifyEvent.Outcome.
+const (
+	// NotifySent means the notification was handed to nwep. WEB/1 does
+	// not acknowledge notifications, so it does not mean the peer received
+	// it; see NotifyWithAck for that.
+	NotifySent = "sent"
+
+	// NotifyFailed means nwep, or the offline queue, returned an error.
+	NotifyFailed = "failed"
+
+	// NotifyQueued means the peer was offline and the notification was
+	// queued for it (see WithNotifyQueue).
+	NotifyQueued = "queued"
+
+	// NotifyRateLimited means the notification rate limit discarded the
+	// notification (see WithNotifyRateLimit).
+	NotifyRateLimited = "rate_limited"
+
+	// NotifyDropped means the notification transform discarded the
+	// notification (see SetNotifyTransform).
+	NotifyDropped = "dropped"
+
+	// NotifyBusy means TryNotify did not send the notification because
+	// the peer's queue depth was at the high-water mark.
+	NotifyBusy = "busy"
+)
+
+// NotifyEvent describes one attempt to send a notification. See
+// WithNotifyObserver.
+type NotifyEvent struct {
+	// Peer is the peer notified. It is zero for a broadcast handed to
+	// nwep in one call, and for a notification to several peers that the
+	// transform dropped before it was addressed to any.
+	Peer nwep.NodeID
+
+	// Broadcast is true for notifications sent by NotifyAll, its JSON and
+	// Context variants, and Publish.
+	Broadcast bool
+
+	Event string
+	Path  string
+
+	// Size is the length of the body sent, after the transform, or for
+	// NotifyDropped of the body the transform was given.
+	Size int
+
+	// Outcome is one of NotifySent, NotifyFailed, NotifyQueued,
+	// NotifyRateLimited, and NotifyDropped.
+	Outcome string
+
+	// Err is the error the attempt failed with, if any.
+	Err error
+
+	// Latency is how long the attempt took, including any wait for the
+	// notification rate limit. For a broadcast fanned out peer by peer,
+	// it is measured from the start of the broadcast.
+	Latency time.Duration
+}
+
+// WithNotifyObserver registers fn to be called after every attempt to send a
+// notification, with the peer, event, size, outcome, and latency, so that
+// delivery rates and failures can be instrumented:
+//
+//	velocity.WithNotifyObserver(func(e velocity.NotifyEvent) {
+//	    notifyLatency.WithLabelValues(e.Event, e.Outcome).Observe(e.Latency.Seconds())
+//	})
+//
+// Every attempt is reported, including each retry of NotifyWithAck and each
+// delivery of a queued notification once its peer reconnects. fn runs on the
+// sending goroutine, after the send, so it must be fast and must not send
+// notifications itself. The option may be given more than once; observers run
+// in order. MetricsHandler reports the same attempts as the counter
+// velocity_notifications_total, by outcome, whether or not an observer is
+// registered. This function returns an error if fn is nil.
+func WithNotifyObserver(fn func(e NotifyEvent)) Option {
+	return func(s *Server) error {
+		if fn == nil {
+			return errors.New("velocity: notify observer must not be nil")
+		}
+		s.notifyObservers = append(s.notifyObservers, fn)
+		return nil
+	}
+}
+
+// observeNotify counts e and reports it to the notification observers.
+func (s *Server) observeNotify(e NotifyEvent) {
+	s.metrics.notified(e.Outcome)
+	for _, fn := range s.notifyObservers {
+		fn(e)
+	}
+}
@@ -0,0 +1,46 @@
+package velocity
+
+import (
+	"strings"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestNotifyObserver(t *testing.T) {
+	s := &Server{
+		peers:       newPeerTracker(),
+		notifyQueue: &QueueConfig{Storage: &MemoryQueue{}},
+	}
+	var events []NotifyEvent
+	if err := WithNotifyObserver(func(e NotifyEvent) { events = append(events, e) })(s); err != nil {
+		t.Fatal(err)
+	}
+	peer := nwep.NodeID{7}
+
+	if err := s.Notify(peer, "update", "/a", []byte("x")); err != ErrServerNotRunning {
+		t.Fatalf("Notify before Start: %v", err)
+	}
+	if _, err := s.deliver(peer, "update", "/a", []byte("xyz"), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("events = %+v", events)
+	}
+	if e := events[0]; e.Outcome != NotifyFailed || e.Err != ErrServerNotRunning || e.Peer != peer {
+		t.Errorf("not running: %+v", e)
+	}
+	if e := events[1]; e.Outcome != NotifyQueued || e.Size != 3 || e.Event != "update" || e.Broadcast {
+		t.Errorf("offline peer: %+v", e)
+	}
+
+	out := string(s.metrics.appendText(nil, nil))
+	for _, want := range []string{
+		`velocity_notifications_total{outcome="failed"} 1`,
+		`velocity_notifications_total{outcome="queued"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
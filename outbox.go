@@ -0,0 +1,155 @@
+package velocity
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// QueueConfig configures the offline notification queue enabled by
+// WithNotifyQueue.
+type QueueConfig struct {
+	// MaxPerPeer is the most notifications kept for one disconnected peer.
+	// When the queue is full, the oldest notification is discarded to make
+	// room. It must be at least 1.
+	MaxPerPeer int
+
+	// TTL is how long a queued notification stays deliverable. Older
+	// notifications are discarded instead of being sent on reconnect. Zero
+	// means notifications do not expire.
+	TTL time.Duration
+
+	// Storage holds the queued notifications. If nil, they are kept in
+	// memory and lost when the process exits.
+	Storage QueueStorage
+}
+
+// QueuedNotification is a notification waiting in the offline queue for its
+// peer to reconnect.
+type QueuedNotification struct {
+	Event   string
+	Path    string
+	Body    []byte
+	Options *nwep.NotifyOptions
+	Queued  time.Time
+}
+
+// QueueStorage stores the offline notification queue. Implement it to keep
+// queued notifications somewhere other than process memory, such as a
+// database, so that they survive restarts. Implementations must be safe for
+// concurrent use.
+type QueueStorage interface {
+	// Enqueue appends n to peer's queue, discarding the oldest entries so
+	// that at most max remain.
+	Enqueue(peer nwep.NodeID, n QueuedNotification, max int) error
+
+	// Dequeue removes and returns all of peer's queued notifications,
+	// oldest first.
+	Dequeue(peer nwep.NodeID) ([]QueuedNotification, error)
+}
+
+// MemoryQueue is the in-memory QueueStorage used when QueueConfig.Storage is
+// nil. The zero value is an empty queue ready to use.
+type MemoryQueue struct {
+	mu    sync.Mutex
+	peers map[nwep.NodeID][]QueuedNotification
+}
+
+// Enqueue implements QueueStorage.
+func (q *MemoryQueue) Enqueue(peer nwep.NodeID, n QueuedNotification, max int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.peers == nil {
+		q.peers = make(map[nwep.NodeID][]QueuedNotification)
+	}
+	list := append(q.peers[peer], n)
+	if over := len(list) - max; over > 0 {
+		list = append(list[:0], list[over:]...)
+	}
+	q.peers[peer] = list
+	return nil
+}
+
+// Dequeue implements QueueStorage.
+func (q *MemoryQueue) Dequeue(peer nwep.NodeID) ([]QueuedNotification, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	list := q.peers[peer]
+	delete(q.peers, peer)
+	return list, nil
+}
+
+// WithNotifyQueue enables the offline notification queue. With it, a
+// notification sent with Notify, NotifyWithOptions, NotifyPeers, or their
+// Context and JSON variants to a peer that is not connected is queued instead
+// of being dropped, and the queue is flushed in order when the peer next
+// connects. Broadcasts and Publish only address connected peers and are not
+// queued. Anonymous peers, with a zero node ID, are never queued for.
+//
+// Queued notifications go through the notification transform when they are
+// first sent and through the rate limit when they are flushed. Flush errors
+// are logged. This function returns an error if cfg.MaxPerPeer is less than 1
+// or cfg.TTL is negative.
+func WithNotifyQueue(cfg QueueConfig) Option {
+	return func(s *Server) error {
+		if cfg.MaxPerPeer < 1 {
+			return fmt.Errorf("velocity: notify queue MaxPerPeer must be at least 1, got %d", cfg.MaxPerPeer)
+		}
+		if cfg.TTL < 0 {
+			return fmt.Errorf("velocity: notify queue TTL must not be negative, got %s", cfg.TTL)
+		}
+		if cfg.Storage == nil {
+			cfg.Storage = &MemoryQueue{}
+		}
+		s.notifyQueue = &cfg
+		return nil
+	}
+}
+
+// queueNotify queues a notification for peer if the offline queue is enabled
+// and peer is not connected. It reports whether the notification was queued.
+func (s *Server) queueNotify(peer nwep.NodeID, event, path string, body []byte, opts *nwep.NotifyOptions) (bool, error) {
+	q := s.notifyQueue
+	if q == nil || peer.IsZero() || s.peers.connected(peer) {
+		return false, nil
+	}
+	n := QueuedNotification{Event: event, Path: path, Body: body, Options: opts, Queued: time.Now()}
+	if err := q.Storage.Enqueue(peer, n, q.MaxPerPeer); err != nil {
+		return false, err
+	}
+	// The peer may have connected, and its queue been flushed, between the
+	// check above and the enqueue. Flush again so the entry is not stranded.
+	if s.peers.connected(peer) {
+		go s.flushQueue(peer)
+	}
+	return true, nil
+}
+
+// flushQueue delivers peer's queued notifications, oldest first, skipping any
+// older than the configured TTL.
+func (s *Server) flushQueue(peer nwep.NodeID) {
+	q := s.notifyQueue
+	if q == nil || peer.IsZero() {
+		return
+	}
+	list, err := q.Storage.Dequeue(peer)
+	if err != nil {
+		s.logger.Warn("notify queue read failed", "peer", FormatNodeID(peer), "error", err.Error())
+		return
+	}
+	for _, n := range list {
+		if q.TTL > 0 && time.Since(n.Queued) > q.TTL {
+			continue
+		}
+		if _, err := s.deliver(peer, n.Event, n.Path, n.Body, n.Options, false); err != nil {
+			s.logger.Warn("queued notify failed",
+				"peer", FormatNodeID(peer),
+				"event", n.Event,
+				"path", n.Path,
+				"error", err.Error(),
+			)
+		}
+	}
+}
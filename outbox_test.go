@@ -0,0 +1,38 @@
+package velocity
+
+import (
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestQueueNotify(t *testing.T) {
+	store := &MemoryQueue{}
+	s := &Server{
+		peers:       newPeerTracker(),
+		notifyQueue: &QueueConfig{MaxPerPeer: 2, Storage: store},
+	}
+	peer := nwep.NodeID{7}
+
+	for _, event := range []string{"a", "b", "c"} {
+		if queued, err := s.queueNotify(peer, event, "/", nil, nil); !queued || err != nil {
+			t.Fatalf("queueNotify(%s) = %v, %v, want queued", event, queued, err)
+		}
+	}
+	if queued, _ := s.queueNotify(nwep.NodeID{}, "x", "/", nil, nil); queued {
+		t.Fatal("queued a notification for the zero peer")
+	}
+
+	list, _ := store.Dequeue(peer)
+	if len(list) != 2 || list[0].Event != "b" || list[1].Event != "c" {
+		t.Fatalf("queue = %+v, want events b, c", list)
+	}
+	if list, _ := store.Dequeue(peer); len(list) != 0 {
+		t.Fatalf("queue not emptied by Dequeue: %+v", list)
+	}
+
+	s.peers.connect(peer, &nwep.Conn{})
+	if queued, _ := s.queueNotify(peer, "d", "/", nil, nil); queued {
+		t.Fatal("queued a notification for a connected peer")
+	}
+}
@@ -0,0 +1,179 @@
+package velocity
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pathParam is one value captured by a parameterized route.
+type pathParam struct {
+	name  string
+	value string
+}
+
+// paramRoute is a route whose pattern contains ":name", "*", or "**"
+// segments.
+type paramRoute struct {
+	method   string // "" matches any method
+	segments []string
+	literals int
+	catchAll bool // the last segment is "**"
+	route    *route
+}
+
+// wildcardParam is the name under which "*" and "**" segments are captured.
+const wildcardParam = "*"
+
+// isVariableSegment reports whether seg is a ":name", "*", or "**" pattern
+// segment.
+func isVariableSegment(seg string) bool {
+	return strings.HasPrefix(seg, ":") || seg == "*" || seg == "**"
+}
+
+// isParamPattern reports whether path contains a variable segment.
+func isParamPattern(path string) bool {
+	for _, seg := range splitPath(path) {
+		if isVariableSegment(seg) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+// addParamRoute registers r as a parameterized route for method ("" for any
+// method), replacing an existing route with the same method and pattern. It
+// panics if the pattern has a "**" segment anywhere but at the end.
+func (rt *Router) addParamRoute(method string, r *route) {
+	pr := newParamRoute(method, r)
+	for i := range rt.params {
+		if rt.params[i].method == method && rt.params[i].route.pattern == r.pattern {
+			rt.params[i] = pr
+			return
+		}
+	}
+	rt.params = append(rt.params, pr)
+}
+
+// newParamRoute parses the pattern of r. It panics if the pattern has a "**"
+// segment anywhere but at the end.
+func newParamRoute(method string, r *route) paramRoute {
+	pr := paramRoute{method: method, segments: splitPath(r.pattern), route: r}
+	for i, seg := range pr.segments {
+		switch {
+		case seg == "**" && i != len(pr.segments)-1:
+			panic(fmt.Sprintf("velocity: route %q: ** must be the last segment", r.pattern))
+		case seg == "**":
+			pr.catchAll = true
+		case !isVariableSegment(seg):
+			pr.literals++
+		}
+	}
+	return pr
+}
+
+// score ranks a matching route: more literal segments win, then fixed-length
+// patterns beat "**" catch-alls, then method-specific routes beat path-only
+// ones.
+func (pr *paramRoute) score() int {
+	score := pr.literals * 4
+	if !pr.catchAll {
+		score += 2
+	}
+	if pr.method != "" {
+		score++
+	}
+	return score
+}
+
+// matchParams returns the best parameterized route for path and method, or
+// nil. Routes are ranked by score; on a tie the earliest registered route
+// wins. Captured values are appended to params if it is non-nil.
+func (rt *Router) matchParams(path, method string, params *[]pathParam) *route {
+	if len(rt.params) == 0 {
+		return nil
+	}
+	segs := splitPath(path)
+	var best *paramRoute
+	bestScore := -1
+	for i := range rt.params {
+		pr := &rt.params[i]
+		if pr.method != "" && pr.method != method {
+			continue
+		}
+		if !pr.matches(segs) {
+			continue
+		}
+		if score := pr.score(); score > bestScore {
+			best, bestScore = pr, score
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	if params != nil {
+		*params = best.capture(segs, *params)
+	}
+	return best.route
+}
+
+// capture appends the values pr captures from segs, which it must match, to
+// params and returns the result.
+func (pr *paramRoute) capture(segs []string, params []pathParam) []pathParam {
+	for i, seg := range pr.segments {
+		switch {
+		case seg == "**":
+			params = append(params, pathParam{name: wildcardParam, value: strings.Join(segs[i:], "/")})
+		case seg == "*":
+			params = append(params, pathParam{name: wildcardParam, value: segs[i]})
+		case strings.HasPrefix(seg, ":"):
+			params = append(params, pathParam{name: seg[1:], value: segs[i]})
+		}
+	}
+	return params
+}
+
+func (pr *paramRoute) matches(segs []string) bool {
+	fixed := pr.segments
+	if pr.catchAll {
+		// "**" matches any remainder, including none at all, so "/files/**"
+		// matches "/files" and "/files/" as well as "/files/a/b".
+		fixed = fixed[:len(fixed)-1]
+		if len(segs) < len(fixed) {
+			return false
+		}
+	} else if len(segs) != len(fixed) {
+		return false
+	}
+	for i, seg := range fixed {
+		if isVariableSegment(seg) {
+			if segs[i] == "" {
+				return false
+			}
+			continue
+		}
+		if seg != segs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Param returns the value of the path parameter with the given name, as
+// captured by a parameterized route such as "/users/:id". The value matched
+// by a "*" or "**" segment is available as Param("*"); for "**" it is the
+// whole remainder of the path, without a leading slash. Param returns "" if
+// the matched route has no such parameter. Values are not URL-decoded.
+func (c *Context) Param(name string) string {
+	// Search from the end so that, in a pattern with both "*" and "**",
+	// Param("*") returns the catch-all remainder.
+	for i := len(c.params) - 1; i >= 0; i-- {
+		if c.params[i].name == name {
+			return c.params[i].value
+		}
+	}
+	return ""
+}
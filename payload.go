@@ -0,0 +1,73 @@
+package velocity
+
+import (
+	"encoding/json"
+	"errors"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// ErrorPayload is the canonical JSON shape of an error sent by velocity, used
+// both for response bodies (see WithJSONErrors and Context.ErrorJSON) and for
+// error notifications (see Server.NotifyError), so that clients parse a single
+// schema on both channels:
+//
+//	{"status": "not_found", "message": "no such user", "details": {...}}
+//
+// Status is a WEB/1 status such as StatusNotFound. Details is optional
+// application-defined data and is omitted when nil.
+type ErrorPayload struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Error returns the message, prefixed with the status, so that a decoded
+// payload can be returned as an error by client code.
+func (p *ErrorPayload) Error() string {
+	if p.Message == "" {
+		return p.Status
+	}
+	return p.Status + ": " + p.Message
+}
+
+// ParseErrorPayload decodes a response body or notification body produced by
+// ErrorJSON, WithJSONErrors, or NotifyError. Details, if present, is decoded
+// into the generic encoding/json representation; decode the body yourself
+// into a struct with a typed Details field for anything more specific. This
+// function returns an error if data is not valid JSON or has no status.
+func ParseErrorPayload(data []byte) (*ErrorPayload, error) {
+	var p ErrorPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	if p.Status == "" {
+		return nil, errors.New("velocity: error payload has no status")
+	}
+	return &p, nil
+}
+
+// ErrorJSON sends an error response with the given status whose body is an
+// ErrorPayload holding status, msg, and details. details may be nil.
+func (c *Context) ErrorJSON(status, msg string, details any) error {
+	return c.JSONStatus(status, ErrorPayload{Status: status, Message: msg, Details: details})
+}
+
+// WithJSONErrors makes the error helpers - Context.Error, NotFound,
+// BadRequest, Unauthorized, Forbidden, and InternalError - send an
+// ErrorPayload JSON body instead of a plain-text message. Built-in middleware
+// and the default not-found response use these helpers, so they follow suit.
+func WithJSONErrors() Option {
+	return func(s *Server) error {
+		s.jsonErrors = true
+		return nil
+	}
+}
+
+// NotifyError sends peer a notification whose body is an ErrorPayload holding
+// status and message, for reporting error conditions over the push channel in
+// the same shape as error responses. It is a convenience wrapper around
+// NotifyJSON and returns the same errors.
+func (s *Server) NotifyError(peer nwep.NodeID, event, path, status, message string) error {
+	return s.NotifyJSON(peer, event, path, ErrorPayload{Status: status, Message: message})
+}
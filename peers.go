@@ -0,0 +1,241 @@
+package velocity
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// peerState is the server's bookkeeping for one connected peer.
+type peerState struct {
+	conn        *nwep.Conn
+	connectedAt time.Time
+	inflight    int
+
+	// closing counts the DisconnectPeer calls in progress for the peer;
+	// while it is positive, begin rejects the peer's new requests.
+	closing int
+
+	// tags are the connection's tags, set with Server.TagConn and dropped
+	// on disconnect.
+	tags map[string]string
+
+	// idle is non-nil while DisconnectPeer calls are waiting for the
+	// peer's in-flight requests. It is shared by every waiting call and
+	// closed when inflight drops to zero.
+	idle chan struct{}
+}
+
+// peerTracker tracks connections and in-flight requests per peer node ID.
+// Requests from peers with a zero node ID (not authenticated) are not tracked.
+type peerTracker struct {
+	// maxPerPeer, if positive, bounds each peer's in-flight requests. See
+	// WithMaxConcurrentPerPeer.
+	maxPerPeer int
+
+	mu    sync.Mutex
+	peers map[nwep.NodeID]*peerState
+}
+
+// Reasons peerTracker.begin refuses a request.
+var (
+	errPeerClosing = errors.New("peer disconnecting")
+	errPeerBusy    = errors.New("too many concurrent requests")
+)
+
+func newPeerTracker() *peerTracker {
+	return &peerTracker{peers: make(map[nwep.NodeID]*peerState)}
+}
+
+// state returns the state for peer, creating it if necessary. The caller must
+// hold t.mu.
+func (t *peerTracker) state(peer nwep.NodeID) *peerState {
+	ps, ok := t.peers[peer]
+	if !ok {
+		ps = &peerState{}
+		t.peers[peer] = ps
+	}
+	return ps
+}
+
+// gc removes the state for peer if nothing refers to it anymore. The caller
+// must hold t.mu.
+func (t *peerTracker) gc(peer nwep.NodeID, ps *peerState) {
+	if ps.conn == nil && ps.inflight == 0 && ps.closing == 0 {
+		delete(t.peers, peer)
+	}
+}
+
+func (t *peerTracker) connect(peer nwep.NodeID, conn *nwep.Conn) {
+	if peer.IsZero() {
+		return
+	}
+	t.mu.Lock()
+	ps := t.state(peer)
+	ps.conn = conn
+	ps.connectedAt = time.Now()
+	t.mu.Unlock()
+}
+
+// connectedAt returns the time peer's current connection completed its
+// handshake, or the zero time if it is not known.
+func (t *peerTracker) connectedAt(peer nwep.NodeID) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ps, ok := t.peers[peer]; ok {
+		return ps.connectedAt
+	}
+	return time.Time{}
+}
+
+// connected reports whether peer currently has a connection.
+func (t *peerTracker) connected(peer nwep.NodeID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ps, ok := t.peers[peer]
+	return ok && ps.conn != nil
+}
+
+func (t *peerTracker) disconnect(peer nwep.NodeID) {
+	if peer.IsZero() {
+		return
+	}
+	t.mu.Lock()
+	if ps, ok := t.peers[peer]; ok {
+		ps.conn = nil
+		ps.connectedAt = time.Time{}
+		ps.tags = nil
+		t.gc(peer, ps)
+	}
+	t.mu.Unlock()
+}
+
+// begin records the start of a request from peer. Without recording
+// anything, it returns errPeerClosing if the peer is being disconnected and
+// must not start new requests, and errPeerBusy if the peer already has
+// maxPerPeer requests in flight.
+func (t *peerTracker) begin(peer nwep.NodeID, conn *nwep.Conn) error {
+	if peer.IsZero() {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ps := t.state(peer)
+	if ps.closing > 0 {
+		return errPeerClosing
+	}
+	if t.maxPerPeer > 0 && ps.inflight >= t.maxPerPeer {
+		return errPeerBusy
+	}
+	if conn != nil {
+		ps.conn = conn
+	}
+	ps.inflight++
+	return nil
+}
+
+// WithMaxConcurrentPerPeer bounds how many requests each peer may have in
+// flight at once to n, across all of its connections and streams, so that a
+// single peer cannot monopolize the server. Requests beyond the limit are
+// rejected with status "rate_limited" and the message "too many concurrent
+// requests", without running any middleware. Requests from unauthenticated
+// peers, which all share the zero node ID, are not limited; use
+// WithMaxInFlight to bound them. This function returns an error if n is less
+// than 1.
+func WithMaxConcurrentPerPeer(n int) Option {
+	return func(s *Server) error {
+		if n < 1 {
+			return fmt.Errorf("velocity: max concurrent requests per peer must be at least 1, got %d", n)
+		}
+		s.peers.maxPerPeer = n
+		return nil
+	}
+}
+
+// end records the completion of a request started with begin.
+func (t *peerTracker) end(peer nwep.NodeID) {
+	if peer.IsZero() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ps, ok := t.peers[peer]
+	if !ok {
+		return
+	}
+	ps.inflight--
+	if ps.inflight == 0 && ps.idle != nil {
+		close(ps.idle)
+		ps.idle = nil
+	}
+	t.gc(peer, ps)
+}
+
+// close marks peer as closing so that begin rejects its new requests, and
+// returns a channel that is closed once its in-flight requests have finished
+// (already closed if there are none) along with its last known connection.
+// Each call must be paired with a call to reopen.
+func (t *peerTracker) close(peer nwep.NodeID) (<-chan struct{}, *nwep.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ps := t.state(peer)
+	ps.closing++
+	if ps.inflight == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle, ps.conn
+	}
+	if ps.idle == nil {
+		ps.idle = make(chan struct{})
+	}
+	return ps.idle, ps.conn
+}
+
+// reopen undoes one call to close. Once every close is undone, a
+// reconnecting peer is accepted again.
+func (t *peerTracker) reopen(peer nwep.NodeID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ps, ok := t.peers[peer]; ok && ps.closing > 0 {
+		ps.closing--
+		if ps.closing == 0 {
+			ps.idle = nil
+			t.gc(peer, ps)
+		}
+	}
+}
+
+// DisconnectPeer gracefully disconnects peer. New requests from the peer are
+// rejected immediately with status "unavailable" while its in-flight requests
+// are given up to drain to complete. The peer's connection is then closed,
+// whether or not the in-flight requests have finished.
+//
+// DisconnectPeer blocks until the connection is closed. It does not ban the
+// peer: once DisconnectPeer returns, the peer may reconnect and its requests
+// are served normally.
+//
+// This function returns ErrServerNotRunning if the server has not been started,
+// ErrPeerNotConnected if velocity has no connection for the peer, and the
+// error from closing the connection otherwise.
+func (s *Server) DisconnectPeer(peer nwep.NodeID, drain time.Duration) error {
+	if s.nwep == nil {
+		return ErrServerNotRunning
+	}
+	idle, conn := s.peers.close(peer)
+	defer s.peers.reopen(peer)
+	if conn == nil {
+		return ErrPeerNotConnected
+	}
+
+	timer := time.NewTimer(drain)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+		s.logger.Warn("disconnecting peer with requests in flight", "peer", FormatNodeID(peer))
+	}
+	return conn.Close(0)
+}
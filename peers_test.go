@@ -0,0 +1,191 @@
+package velocity
+
+import (
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestPeerTrackerClose(t *testing.T) {
+	tr := newPeerTracker()
+	var peer nwep.NodeID
+	peer[0] = 1
+
+	if tr.begin(peer, nil) != nil {
+		t.Fatal("begin should succeed for an open peer")
+	}
+	idle, _ := tr.close(peer)
+	if tr.begin(peer, nil) != errPeerClosing {
+		t.Fatal("begin should fail while the peer is closing")
+	}
+	select {
+	case <-idle:
+		t.Fatal("idle closed with a request in flight")
+	default:
+	}
+
+	tr.end(peer)
+	select {
+	case <-idle:
+	default:
+		t.Fatal("idle not closed after the last request ended")
+	}
+
+	tr.reopen(peer)
+	if tr.begin(peer, nil) != nil {
+		t.Fatal("begin should succeed after reopen")
+	}
+	tr.end(peer)
+	if len(tr.peers) != 0 {
+		t.Fatalf("tracker holds %d peers, want 0", len(tr.peers))
+	}
+}
+
+func TestPeerTrackerOverlappingClose(t *testing.T) {
+	tr := newPeerTracker()
+	peer := nwep.NodeID{1}
+	tr.begin(peer, nil)
+	first, _ := tr.close(peer)
+	second, _ := tr.close(peer)
+
+	tr.end(peer)
+	for i, idle := range []<-chan struct{}{first, second} {
+		select {
+		case <-idle:
+		default:
+			t.Fatalf("close %d: idle not closed after the last request ended", i+1)
+		}
+	}
+
+	tr.reopen(peer)
+	if tr.begin(peer, nil) != errPeerClosing {
+		t.Fatal("begin should fail while another close is in progress")
+	}
+	tr.reopen(peer)
+	if tr.begin(peer, nil) != nil {
+		t.Fatal("begin should succeed once every close is undone")
+	}
+}
+
+func TestPeerTrackerIgnoresZeroPeer(t *testing.T) {
+	tr := newPeerTracker()
+	if tr.begin(nwep.NodeID{}, nil) != nil {
+		t.Fatal("begin should always succeed for the zero peer")
+	}
+	if len(tr.peers) != 0 {
+		t.Fatal("zero peer should not be tracked")
+	}
+}
+
+func TestMaxConcurrentPerPeer(t *testing.T) {
+	s := &Server{peers: newPeerTracker()}
+	if err := WithMaxConcurrentPerPeer(2)(s); err != nil {
+		t.Fatal(err)
+	}
+	tr := s.peers
+	a, b := nwep.NodeID{1}, nwep.NodeID{2}
+	for range 2 {
+		if err := tr.begin(a, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tr.begin(a, nil); err != errPeerBusy {
+		t.Fatalf("third request: %v, want errPeerBusy", err)
+	}
+	if err := tr.begin(b, nil); err != nil {
+		t.Fatalf("other peer limited: %v", err)
+	}
+	for range 3 {
+		if err := tr.begin(nwep.NodeID{}, nil); err != nil {
+			t.Fatalf("unauthenticated peer limited: %v", err)
+		}
+	}
+	tr.end(a)
+	if err := tr.begin(a, nil); err != nil {
+		t.Fatalf("after a request ended: %v", err)
+	}
+	if WithMaxConcurrentPerPeer(0)(s) == nil {
+		t.Error("limit of 0 accepted")
+	}
+}
+
+func TestConnTags(t *testing.T) {
+	s := &Server{peers: newPeerTracker()}
+	a, b := nwep.NodeID{1}, nwep.NodeID{2}
+	if err := s.TagConn(a, "tenant", "acme"); err != ErrPeerNotConnected {
+		t.Fatalf("tagging a peer that is not connected: %v", err)
+	}
+	s.peers.connect(a, &nwep.Conn{})
+	s.peers.connect(b, &nwep.Conn{})
+	for _, id := range []nwep.NodeID{b, a} {
+		if err := s.TagConn(id, "tenant", "acme"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.TagConn(b, "device", "sensor")
+	if got := s.PeersWithTag("tenant", "acme"); len(got) != 2 || got[0] != a {
+		t.Fatalf("PeersWithTag = %v", got)
+	}
+	s.UntagConn(a, "tenant")
+	s.peers.disconnect(b)
+	if got := s.PeersWithTag("tenant", "acme"); len(got) != 0 {
+		t.Fatalf("PeersWithTag after untag and disconnect = %v", got)
+	}
+	s.peers.connect(b, &nwep.Conn{})
+	if tags := s.ConnTags(b); tags != nil {
+		t.Fatalf("tags survived a reconnect: %v", tags)
+	}
+}
+
+func TestOncePerConnection(t *testing.T) {
+	s := &Server{}
+	conn := &nwep.Conn{}
+	runs := 0
+	mw := OncePerConnection(func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			runs++
+			return next(c)
+		}
+	})
+	h := mw(nopHandler)
+
+	c := &Context{server: s, Request: &nwep.Request{Conn: conn}}
+	for range 3 {
+		if err := h(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs != 1 {
+		t.Fatalf("middleware ran %d times on one connection, want 1", runs)
+	}
+
+	s.conns.forget(conn)
+	if err := h(c); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 {
+		t.Fatalf("middleware ran %d times after reconnect, want 2", runs)
+	}
+}
+
+func TestConnInfo(t *testing.T) {
+	s := &Server{peers: newPeerTracker()}
+	peer := nwep.NodeID{9}
+	if _, ok := s.ConnInfo(peer); ok {
+		t.Fatal("ConnInfo for a peer that never connected")
+	}
+	conn := &nwep.Conn{}
+	s.peers.connect(peer, conn)
+	s.peers.begin(peer, conn)
+	s.newPushStream(NewRecorder(), peer, "/feed")
+
+	info, ok := s.ConnInfo(peer)
+	if !ok || info.Peer != peer || info.InFlight != 1 || info.PushStreams != 1 || info.ConnectedAt.IsZero() {
+		t.Fatalf("ConnInfo = %+v, %v", info, ok)
+	}
+
+	s.peers.disconnect(peer)
+	if _, ok := s.ConnInfo(peer); ok {
+		t.Fatal("ConnInfo after disconnect")
+	}
+}
@@ -0,0 +1,47 @@
+package velocity
+
+import "sync/atomic"
+
+// PoolStats is a snapshot of the server's handler execution counters, for
+// metrics endpoints and health checks. A queue that stays deep signals that
+// the pool is undersized or that handlers are waiting on a slow downstream.
+type PoolStats struct {
+	// Workers is the configured number of handler workers (see
+	// WithHandlerPool), or zero if handlers run inline on the nwep
+	// callback, in which case concurrency is bounded only by the
+	// transport and WithMaxInFlight.
+	Workers int
+
+	// Active is the number of requests whose handlers are running.
+	Active int64
+
+	// Queued is the number of requests waiting for a free worker (see
+	// WithHandlerPool) or an in-flight slot (see WithMaxInFlight and
+	// WithQueue).
+	Queued int64
+
+	// Rejected is the total number of requests turned away because a
+	// queue was full, or, under load shedding, because their wait timed
+	// out or was cut short by a higher-priority request.
+	Rejected uint64
+}
+
+// poolCounters holds the live values behind PoolStats. The counters are
+// updated on every request, so they are atomic rather than guarded by a lock.
+type poolCounters struct {
+	workers  int
+	active   atomic.Int64
+	queued   atomic.Int64
+	rejected atomic.Uint64
+}
+
+// PoolStats returns a snapshot of the server's handler execution counters.
+// The snapshot is a copy and does not change as requests come and go.
+func (s *Server) PoolStats() PoolStats {
+	return PoolStats{
+		Workers:  s.pool.workers,
+		Active:   s.pool.active.Load(),
+		Queued:   s.pool.queued.Load(),
+		Rejected: s.pool.rejected.Load(),
+	}
+}
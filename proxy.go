@@ -0,0 +1,136 @@
+package velocity
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// HeaderPeerNodeID is the header ReverseProxy sets on requests to the HTTP
+// backend. It holds the requesting peer's node ID, formatted with
+// FormatNodeID, or is empty for an unauthenticated peer.
+const HeaderPeerNodeID = "X-Velocity-Peer"
+
+// hopHeaders are connection-specific headers that a proxy must not forward.
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length", "Host",
+}
+
+// proxyClient sends ReverseProxy requests. It does not follow redirects, so
+// that the backend's response is what the peer sees.
+var proxyClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// ReverseProxy returns a handler that forwards WEB/1 requests to the HTTP
+// backend at target, so that an existing REST service can sit behind a
+// WEB/1 edge that authenticates peers by node ID.
+//
+// The request path, including any query string, is appended to target's
+// path, which makes ReverseProxy suitable for prefix routes. A path with a
+// ".." segment, which could reach outside target's path, is rejected with
+// status "bad_request":
+//
+//	backend, _ := url.Parse("http://127.0.0.1:8080/api")
+//	srv.Router().HandlePrefix("/api/", velocity.ReverseProxy(backend))
+//
+// Methods are mapped with HTTPMethod and statuses with StatusFromHTTP.
+// Request and response headers are copied, except hop-by-hop headers,
+// including those named in a Connection header. The
+// peer's node ID is sent in HeaderPeerNodeID, replacing any value the peer
+// supplied, so the backend can trust it. The request is cancelled with
+// Context.Ctx. If the backend cannot be reached, the peer receives status
+// "unavailable" and the error is logged.
+func ReverseProxy(target *url.URL) HandlerFunc {
+	return func(c *Context) error {
+		method, ok := HTTPMethod(c.Method())
+		if !ok {
+			return c.BadRequest("method not supported by proxy")
+		}
+		u := *target
+		reqPath, query, _ := strings.Cut(c.Path(), "?")
+		if u.Path, ok = proxyPath(target.Path, reqPath); !ok {
+			return c.BadRequest("invalid path")
+		}
+		u.RawPath = ""
+		u.RawQuery = query
+
+		req, err := http.NewRequestWithContext(c.Ctx(), method, u.String(), bytes.NewReader(c.Body()))
+		if err != nil {
+			return err
+		}
+		c.RangeHeaders(func(name, value string) bool {
+			if !strings.HasPrefix(name, ":") {
+				req.Header.Add(name, value)
+			}
+			return true
+		})
+		removeHopHeaders(req.Header)
+		req.Header.Set(HeaderPeerNodeID, "")
+		if peer := c.PeerNodeID(); !peer.IsZero() {
+			req.Header.Set(HeaderPeerNodeID, FormatNodeID(peer))
+		}
+
+		resp, err := proxyClient.Do(req)
+		if err != nil {
+			c.Logger().Warn("proxy request failed", "target", u.Redacted(), "error", err.Error())
+			return c.Error(StatusUnavailable, "upstream unavailable")
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			c.Logger().Warn("proxy response failed", "target", u.Redacted(), "error", err.Error())
+			return c.Error(StatusUnavailable, "upstream unavailable")
+		}
+		removeHopHeaders(resp.Header)
+		for name, values := range resp.Header {
+			if len(values) > 0 {
+				c.SetHeader(strings.ToLower(name), strings.Join(values, ", "))
+			}
+		}
+		return c.Respond(StatusFromHTTP(resp.StatusCode), body)
+	}
+}
+
+// proxyPath joins the request path p to the backend path base. It reports
+// false if p has a ".." segment, escaped or not, or if the joined path would
+// fall outside base.
+func proxyPath(base, p string) (string, bool) {
+	unescaped, err := url.PathUnescape(p)
+	if err != nil {
+		return "", false
+	}
+	for seg := range strings.SplitSeq(unescaped, "/") {
+		if seg == ".." {
+			return "", false
+		}
+	}
+	base = strings.TrimSuffix(base, "/")
+	joined := path.Clean(base + "/" + p)
+	if strings.HasSuffix(p, "/") && joined != "/" {
+		joined += "/"
+	}
+	if joined != base && !strings.HasPrefix(joined, base+"/") {
+		return "", false
+	}
+	return joined, true
+}
+
+// removeHopHeaders deletes the hop-by-hop headers from h: those in
+// hopHeaders and those named in its Connection header.
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for name := range strings.SplitSeq(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
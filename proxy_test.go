@@ -0,0 +1,96 @@
+package velocity
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestReverseProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Seen", r.Method+" "+r.URL.RequestURI()+" "+string(body))
+		w.Header().Set("X-Peer", r.Header.Get(HeaderPeerNodeID))
+		w.Header().Set("Connection", "X-Backend-Hop")
+		w.Header().Set("X-Backend-Hop", "1")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte("exists"))
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL + "/api/")
+
+	c, rec := NewTestContext(MethodWrite, "/items?x=1", []byte("new"))
+	if err := ReverseProxy(target)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != StatusConflict || string(rec.Body) != "exists" {
+		t.Fatalf("response = %s %q", rec.Status, rec.Body)
+	}
+	if v, _ := rec.Header("x-seen"); v != "POST /api/items?x=1 new" {
+		t.Fatalf("backend saw %q", v)
+	}
+	if v, ok := rec.Header("x-peer"); !ok || v != "" {
+		t.Fatalf("peer header = %q, %v, want empty", v, ok)
+	}
+	if _, ok := rec.Header("x-backend-hop"); ok {
+		t.Fatal("header named in the backend's Connection header was forwarded")
+	}
+
+	for _, p := range []string{"/../x", "/a/../../x", "/%2e%2e/x"} {
+		c, rec := NewTestContext(MethodRead, p, nil)
+		if err := ReverseProxy(target)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Status != StatusBadRequest {
+			t.Errorf("%s: status = %s, want %s", p, rec.Status, StatusBadRequest)
+		}
+	}
+}
+
+func TestProxyPath(t *testing.T) {
+	tests := []struct {
+		base, path, want string
+		ok               bool
+	}{
+		{"/api/", "/items", "/api/items", true},
+		{"/api", "/items/", "/api/items/", true},
+		{"/api", "//items/./7", "/api/items/7", true},
+		{"", "/items", "/items", true},
+		{"/api/", "/../x", "", false},
+		{"/api", "/a/../x", "", false},
+		{"/api", "/%2E%2E/x", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := proxyPath(tt.base, tt.path)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("proxyPath(%q, %q) = %q, %v, want %q, %v", tt.base, tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRemoveHopHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "X-Hop, keep-alive")
+	h.Set("X-Hop", "1")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("X-Kept", "1")
+	removeHopHeaders(h)
+	if len(h) != 1 || h.Get("X-Kept") != "1" {
+		t.Fatalf("headers after removal = %v", h)
+	}
+}
+
+func TestStatusMapping(t *testing.T) {
+	for _, st := range []string{StatusOK, StatusCreated, StatusAccepted, StatusNoContent,
+		StatusUnauthorized, StatusForbidden, StatusNotFound, StatusConflict,
+		StatusRateLimited, StatusUnavailable} {
+		if got := StatusFromHTTP(HTTPStatus(st)); got != st {
+			t.Errorf("round trip of %s = %s", st, got)
+		}
+	}
+	if got := StatusFromHTTP(http.StatusTeapot); got != StatusBadRequest {
+		t.Errorf("418 = %s", got)
+	}
+}
@@ -0,0 +1,180 @@
+package velocity
+
+import (
+	"sync"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// PushStream is a long-lived, server-initiated stream to a peer, opened with
+// Server.OpenStream or Context.OpenStream. Where a notification delivers one
+// message, a push stream stays open and carries data for as long as the
+// application keeps writing, which suits tail -f style feeds.
+//
+// PushStream implements io.WriteCloser, so it can be wrapped in a bufio.Writer
+// or a json.Encoder. Each Write is sent immediately with StreamWrite. Writes
+// are serialized, so a PushStream may be shared between goroutines.
+type PushStream struct {
+	w    ResponseWriter
+	peer nwep.NodeID
+	path string
+	set  *pushStreams
+
+	mu     sync.Mutex
+	err    error // set once the stream is closed
+	done   chan struct{}
+	closed bool
+}
+
+// Write sends p on the stream. It returns ErrStreamClosed after Close or
+// Abort, and ErrPeerDisconnected once the peer's connection has closed.
+func (ps *PushStream) Write(p []byte) (int, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.closed {
+		return 0, ps.err
+	}
+	return ps.w.StreamWrite(p)
+}
+
+// Close closes the stream gracefully. Closing a closed stream is a no-op.
+func (ps *PushStream) Close() error {
+	ps.Abort(0)
+	return nil
+}
+
+// Abort closes the stream with the given error code, telling the peer the
+// stream did not end normally. An error code of 0 is the same as Close.
+func (ps *PushStream) Abort(errCode int) {
+	if ps.finish(ErrStreamClosed) {
+		ps.w.StreamClose(errCode)
+	}
+}
+
+// Done returns a channel that is closed when the stream is closed, either by
+// the application or because the peer disconnected or the server shut down.
+// Producers should select on it to stop work nobody will receive.
+func (ps *PushStream) Done() <-chan struct{} { return ps.done }
+
+// Peer returns the node ID of the peer the stream was opened to.
+func (ps *PushStream) Peer() nwep.NodeID { return ps.peer }
+
+// Path returns the path the stream was opened with.
+func (ps *PushStream) Path() string { return ps.path }
+
+// finish marks the stream closed with err and reports whether this call
+// closed it.
+func (ps *PushStream) finish(err error) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.closed {
+		return false
+	}
+	ps.closed = true
+	ps.err = err
+	close(ps.done)
+	if ps.set != nil {
+		ps.set.remove(ps)
+	}
+	return true
+}
+
+// pushStreams tracks the open push streams, so that they can be closed when
+// their peer disconnects or the server shuts down.
+type pushStreams struct {
+	mu      sync.Mutex
+	streams map[*PushStream]struct{}
+}
+
+func (ps *pushStreams) add(s *PushStream) {
+	ps.mu.Lock()
+	if ps.streams == nil {
+		ps.streams = make(map[*PushStream]struct{})
+	}
+	ps.streams[s] = struct{}{}
+	ps.mu.Unlock()
+}
+
+func (ps *pushStreams) remove(s *PushStream) {
+	ps.mu.Lock()
+	delete(ps.streams, s)
+	ps.mu.Unlock()
+}
+
+// list returns the open streams, to peer only unless peer is nil.
+func (ps *pushStreams) list(peer *nwep.NodeID) []*PushStream {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	var out []*PushStream
+	for s := range ps.streams {
+		if peer == nil || s.peer == *peer {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// closePeer marks peer's streams closed with ErrPeerDisconnected. The streams
+// are not closed on the wire, since the connection is already gone.
+func (ps *pushStreams) closePeer(peer nwep.NodeID) {
+	for _, s := range ps.list(&peer) {
+		s.finish(ErrPeerDisconnected)
+	}
+}
+
+// closeAll closes every open stream gracefully.
+func (ps *pushStreams) closeAll() {
+	for _, s := range ps.list(nil) {
+		_ = s.Close()
+	}
+}
+
+// OpenStream opens a server-initiated stream to peer at path, sending headers
+// with it, and returns the stream for the application to push data on over
+// time:
+//
+//	st, err := srv.OpenStream(peer, "/logs", nil)
+//	if err != nil {
+//	    return err
+//	}
+//	defer st.Close()
+//	for line := range lines {
+//	    if _, err := st.Write(line); err != nil {
+//	        return err // closed, or the peer went away
+//	    }
+//	}
+//
+// The stream stays open until it is closed with Close or Abort, the peer
+// disconnects, or the server shuts down; Done reports when that happens.
+// Streams still open at Shutdown are closed gracefully.
+//
+// This function returns ErrServerNotRunning if the server has not been
+// started and ErrPeerNotConnected if the peer has no connection. Use Notify
+// for one-shot messages.
+func (s *Server) OpenStream(peer nwep.NodeID, path string, headers []nwep.Header) (*PushStream, error) {
+	if s.nwep == nil {
+		return nil, ErrServerNotRunning
+	}
+	if !s.peers.connected(peer) {
+		return nil, ErrPeerNotConnected
+	}
+	w, err := s.listenerFor(peer).OpenStream(peer, path, headers)
+	if err != nil {
+		return nil, err
+	}
+	return s.newPushStream(w, peer, path), nil
+}
+
+func (s *Server) newPushStream(w ResponseWriter, peer nwep.NodeID, path string) *PushStream {
+	ps := &PushStream{w: w, peer: peer, path: path, set: &s.streams, done: make(chan struct{})}
+	s.streams.add(ps)
+	return ps
+}
+
+// OpenStream opens a server-initiated stream to the peer that sent the
+// request. The stream outlives the request: the handler can respond, return,
+// and hand the stream to a goroutine that keeps pushing data. See
+// Server.OpenStream.
+func (c *Context) OpenStream(path string, headers []nwep.Header) (*PushStream, error) {
+	return c.server.OpenStream(c.PeerNodeID(), path, headers)
+}
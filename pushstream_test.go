@@ -0,0 +1,53 @@
+package velocity
+
+import (
+	"errors"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestPushStream(t *testing.T) {
+	s := &Server{}
+	peer := nwep.NodeID{1}
+
+	rec := NewRecorder()
+	st := s.newPushStream(rec, peer, "/logs")
+	if _, err := st.Write([]byte("a\n")); err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+	if _, err := st.Write([]byte("b\n")); !errors.Is(err, ErrStreamClosed) {
+		t.Fatalf("write after close: %v", err)
+	}
+	if string(rec.Body) != "a\n" || !rec.Closed || rec.CloseCode != 0 {
+		t.Fatalf("body %q, closed %v (%d)", rec.Body, rec.Closed, rec.CloseCode)
+	}
+
+	rec = NewRecorder()
+	st = s.newPushStream(rec, peer, "/logs")
+	other := s.newPushStream(NewRecorder(), nwep.NodeID{2}, "/logs")
+	s.streams.closePeer(peer)
+	select {
+	case <-st.Done():
+	default:
+		t.Fatal("stream not done after peer disconnect")
+	}
+	if _, err := st.Write(nil); !errors.Is(err, ErrPeerDisconnected) {
+		t.Fatalf("write after disconnect: %v", err)
+	}
+	if rec.Closed {
+		t.Fatal("disconnected stream was closed on the wire")
+	}
+	if _, err := other.Write([]byte("x")); err != nil {
+		t.Fatalf("other peer's stream: %v", err)
+	}
+
+	s.streams.closeAll()
+	if len(s.streams.list(nil)) != 0 {
+		t.Fatal("streams left open after closeAll")
+	}
+	if _, err := s.OpenStream(peer, "/logs", nil); !errors.Is(err, ErrServerNotRunning) {
+		t.Fatalf("OpenStream before start: %v", err)
+	}
+}
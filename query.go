@@ -0,0 +1,26 @@
+package velocity
+
+import (
+	"net/url"
+	"strings"
+)
+
+// QueryParams returns the query parameters of the request path, parsed from
+// the part after the first "?". Keys and values are URL-decoded. Malformed
+// pairs are skipped rather than reported. The result is parsed once per
+// request and shared between calls, so it must not be modified. A path
+// without a query string yields an empty, non-nil map.
+func (c *Context) QueryParams() url.Values {
+	if c.query == nil {
+		_, raw, _ := strings.Cut(c.Request.Path, "?")
+		c.query, _ = url.ParseQuery(raw)
+	}
+	return c.query
+}
+
+// QueryParam returns the first value of the query parameter name, or "" if
+// the request path has no such parameter. Use QueryParams to tell a missing
+// parameter from an empty one, or to read repeated parameters.
+func (c *Context) QueryParam(name string) string {
+	return c.QueryParams().Get(name)
+}
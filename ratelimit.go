@@ -0,0 +1,413 @@
+package velocity
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// tokenBucket is a classic token bucket. It holds up to burst tokens and
+// refills at rate tokens per second. The zero value is an empty bucket; use
+// newTokenBucket to start full. tokenBucket is not safe for concurrent use -
+// the owner must serialize access.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(now time.Time, burst int) *tokenBucket {
+	return &tokenBucket{tokens: float64(burst), last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time, rate float64, burst int) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(float64(burst), b.tokens+elapsed*rate)
+	}
+	b.last = now
+}
+
+// allow takes one token if available and reports whether it did.
+func (b *tokenBucket) allow(now time.Time, rate float64, burst int) bool {
+	b.refill(now, rate, burst)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve takes one token, letting the balance go negative, and returns how
+// long the caller must wait before the token is really available. A zero
+// duration means the token was available immediately. The debt is capped at
+// burst tokens: once the bucket owes that many, reserve takes nothing and
+// returns false.
+func (b *tokenBucket) reserve(now time.Time, rate float64, burst int) (time.Duration, bool) {
+	b.refill(now, rate, burst)
+	if b.tokens-1 < -float64(burst) {
+		return 0, false
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0, true
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second)), true
+}
+
+// full reports whether the bucket would be at capacity at now, meaning it is
+// indistinguishable from a fresh bucket and can be discarded.
+func (b *tokenBucket) full(now time.Time, rate float64, burst int) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst)
+}
+
+// NotifyLimitMode selects what happens to a notification that exceeds the
+// per-peer rate configured with WithNotifyRateLimit.
+type NotifyLimitMode int
+
+const (
+	// NotifyThrottle delays over-limit notifications until the peer's
+	// bucket has refilled. Notify and NotifyWithOptions block the
+	// caller for the delay; NotifyAll schedules the delayed sends in
+	// the background and returns immediately. A peer is delayed by at
+	// most one burst's worth of notifications: beyond that, they are
+	// dropped as in NotifyDrop. This is the default.
+	NotifyThrottle NotifyLimitMode = iota
+
+	// NotifyDrop discards over-limit notifications. Notify and
+	// NotifyWithOptions return ErrNotifyRateLimited for a dropped
+	// notification; NotifyAll skips the affected peers.
+	NotifyDrop
+)
+
+// NotifyStats is a snapshot of the server's notification counters.
+type NotifyStats struct {
+	// Throttled is the number of notifications that were delayed by
+	// the per-peer rate limit.
+	Throttled uint64
+
+	// Dropped is the number of notifications that were discarded by
+	// the per-peer rate limit.
+	Dropped uint64
+}
+
+// notifyLimiter enforces a per-peer outbound rate on notifications. Buckets are
+// created on demand and swept once they have refilled to capacity, so the map
+// only holds peers that have been notified recently.
+type notifyLimiter struct {
+	rate  float64
+	burst int
+	mode  NotifyLimitMode
+
+	mu        sync.Mutex
+	peers     map[nwep.NodeID]*tokenBucket
+	lastSweep time.Time
+
+	// delayed holds the sends scheduled by after, earliest first, and
+	// timer fires when the earliest is due. One timer serves every
+	// peer. Both are guarded by mu; stopped is set by close.
+	delayed delayHeap
+	timer   *time.Timer
+	stopped bool
+
+	// closeMu guards closed. Delayed sends hold it for reading while they
+	// run so that close can wait for in-flight sends before the
+	// underlying nwep server is torn down.
+	closeMu sync.RWMutex
+	closed  bool
+
+	throttled atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+func newNotifyLimiter(rate float64, burst int) *notifyLimiter {
+	return &notifyLimiter{
+		rate:  rate,
+		burst: burst,
+		peers: make(map[nwep.NodeID]*tokenBucket),
+	}
+}
+
+// take consumes a token for peer. In NotifyDrop mode it returns ok=false if no
+// token is available. In NotifyThrottle mode it returns ok=true along with the
+// delay the caller must observe before sending, unless the peer's bucket
+// already owes a full burst, in which case it returns ok=false.
+func (l *notifyLimiter) take(peer nwep.NodeID) (wait time.Duration, ok bool) {
+	now := time.Now()
+	l.mu.Lock()
+	l.sweep(now)
+	b, exists := l.peers[peer]
+	if !exists {
+		b = newTokenBucket(now, l.burst)
+		l.peers[peer] = b
+	}
+	if l.mode == NotifyDrop {
+		ok = b.allow(now, l.rate, l.burst)
+	} else {
+		wait, ok = b.reserve(now, l.rate, l.burst)
+	}
+	l.mu.Unlock()
+
+	switch {
+	case !ok:
+		l.dropped.Add(1)
+	case wait > 0:
+		l.throttled.Add(1)
+	}
+	return wait, ok
+}
+
+// sweep discards buckets that have refilled to capacity. It runs at most once
+// per refill window. The caller must hold l.mu.
+func (l *notifyLimiter) sweep(now time.Time) {
+	window := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < window {
+		return
+	}
+	l.lastSweep = now
+	for id, b := range l.peers {
+		if b.full(now, l.rate, l.burst) {
+			delete(l.peers, id)
+		}
+	}
+}
+
+// after runs fn on its own goroutine once d has elapsed, unless the limiter
+// has been closed by then.
+func (l *notifyLimiter) after(d time.Duration, fn func()) {
+	at := time.Now().Add(d)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return
+	}
+	heap.Push(&l.delayed, delayedSend{at: at, fn: fn})
+	switch {
+	case l.timer == nil:
+		l.timer = time.AfterFunc(d, l.runDue)
+	case l.delayed[0].at.Equal(at):
+		// fn is now the earliest send.
+		l.timer.Reset(d)
+	}
+}
+
+// runDue starts the delayed sends that are due and rearms the timer for the
+// next one.
+func (l *notifyLimiter) runDue() {
+	now := time.Now()
+	l.mu.Lock()
+	var due []func()
+	for len(l.delayed) > 0 && !l.delayed[0].at.After(now) {
+		due = append(due, heap.Pop(&l.delayed).(delayedSend).fn)
+	}
+	if len(l.delayed) > 0 && !l.stopped {
+		l.timer.Reset(l.delayed[0].at.Sub(now))
+	}
+	l.mu.Unlock()
+	for _, fn := range due {
+		go func() {
+			l.closeMu.RLock()
+			defer l.closeMu.RUnlock()
+			if !l.closed {
+				fn()
+			}
+		}()
+	}
+}
+
+// close prevents any pending delayed sends from running and waits for those
+// already running to finish.
+func (l *notifyLimiter) close() {
+	l.mu.Lock()
+	l.stopped = true
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.delayed = nil
+	l.mu.Unlock()
+
+	l.closeMu.Lock()
+	l.closed = true
+	l.closeMu.Unlock()
+}
+
+// delayedSend is a send scheduled by notifyLimiter.after.
+type delayedSend struct {
+	at time.Time
+	fn func()
+}
+
+// delayHeap is a min-heap of delayed sends ordered by due time. It implements
+// heap.Interface.
+type delayHeap []delayedSend
+
+func (h delayHeap) Len() int           { return len(h) }
+func (h delayHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h delayHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *delayHeap) Push(x any)        { *h = append(*h, x.(delayedSend)) }
+
+func (h *delayHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	old[len(old)-1] = delayedSend{}
+	*h = old[:len(old)-1]
+	return x
+}
+
+// RateLimitKeyFunc returns the key a request is rate limited under by
+// RateLimit. Requests with the same key share a token bucket.
+type RateLimitKeyFunc func(c *Context) string
+
+// KeyByPeer limits each peer separately, keyed by node ID. Unauthenticated
+// peers, which all have the zero node ID, share one bucket. This is the
+// default key for RateLimit.
+func KeyByPeer(c *Context) string {
+	peer := c.PeerNodeID()
+	return string(peer[:])
+}
+
+// KeyByPath limits each route separately, across all peers. The key is the
+// route pattern (see Context.RoutePattern), so "/users/1" and "/users/2"
+// share the bucket of "/users/:id".
+func KeyByPath(c *Context) string { return c.RoutePattern() }
+
+// KeyGlobal puts every request in one bucket, limiting the total rate.
+func KeyGlobal(c *Context) string { return "" }
+
+// RateLimitOption configures RateLimit.
+type RateLimitOption func(*rateLimiter)
+
+// RateLimitKey sets the function that picks the bucket for a request. The
+// default is KeyByPeer.
+func RateLimitKey(fn RateLimitKeyFunc) RateLimitOption {
+	return func(l *rateLimiter) { l.key = fn }
+}
+
+// RateLimitMaxKeys caps the number of buckets kept at n. When a new key would
+// exceed the cap, the bucket used least recently is discarded, which resets
+// the limit for that key. The default is no cap.
+func RateLimitMaxKeys(n int) RateLimitOption {
+	return func(l *rateLimiter) { l.maxKeys = n }
+}
+
+// RateLimitSkipper exempts the requests skip returns true for from the limit;
+// they neither need nor take a token. The default is to limit every request.
+func RateLimitSkipper(skip Skipper) RateLimitOption {
+	return func(l *rateLimiter) { l.skip = skip }
+}
+
+// rateLimiter is the state of one RateLimit middleware instance. Like
+// notifyLimiter, it sweeps buckets that have refilled to capacity, since they
+// are indistinguishable from fresh ones, so idle keys do not accumulate.
+type rateLimiter struct {
+	rate    float64
+	burst   int
+	key     RateLimitKeyFunc
+	maxKeys int
+	skip    Skipper
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// allow takes a token from key's bucket. If none is available it returns
+// false and how long until one will be.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		if l.maxKeys > 0 && len(l.buckets) >= l.maxKeys {
+			l.evictOldest()
+		}
+		b = newTokenBucket(now, l.burst)
+		l.buckets[key] = b
+	}
+	if b.allow(now, l.rate, l.burst) {
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep discards buckets that have refilled to capacity. It runs at most once
+// per refill window. The caller must hold l.mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	window := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < window {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if b.full(now, l.rate, l.burst) {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// evictOldest discards the bucket that was used least recently. The caller
+// must hold l.mu.
+func (l *rateLimiter) evictOldest() {
+	var oldest string
+	var at time.Time
+	for k, b := range l.buckets {
+		if at.IsZero() || b.last.Before(at) {
+			oldest, at = k, b.last
+		}
+	}
+	delete(l.buckets, oldest)
+}
+
+// HeaderRetryAfter is the response header RateLimit sets on rejected
+// requests. Its value is the number of whole seconds, rounded up, after which
+// the request may succeed.
+const HeaderRetryAfter = "retry-after"
+
+// RateLimit returns middleware that limits requests with a token bucket per
+// key: each bucket holds up to burst tokens and refills at rate tokens per
+// second, and each request takes one. Requests that find their bucket empty
+// receive status "rate_limited" with a HeaderRetryAfter header and are not
+// passed on.
+//
+// Buckets are keyed by peer node ID unless RateLimitKey selects another key,
+// such as KeyByPath or KeyGlobal. Buckets that have refilled to capacity are
+// discarded, so keys that go idle cost nothing; RateLimitMaxKeys additionally
+// bounds the number of active keys. Each call to RateLimit creates an
+// independent limiter. RateLimit panics if rate is not positive or burst is
+// less than 1.
+func RateLimit(rate float64, burst int, opts ...RateLimitOption) MiddlewareFunc {
+	if rate <= 0 {
+		panic(fmt.Sprintf("velocity: rate limit must be positive, got %v", rate))
+	}
+	if burst < 1 {
+		panic(fmt.Sprintf("velocity: rate limit burst must be at least 1, got %d", burst))
+	}
+	l := &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		key:     KeyByPeer,
+		buckets: make(map[string]*tokenBucket),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if l.skip != nil && l.skip(c) {
+				return next(c)
+			}
+			ok, wait := l.allow(l.key(c), time.Now())
+			if !ok {
+				secs := int64(math.Ceil(wait.Seconds()))
+				c.SetHeader(HeaderRetryAfter, strconv.FormatInt(max(secs, 1), 10))
+				return c.Error(StatusRateLimited, "rate limit exceeded")
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package velocity
+
+import (
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestTokenBucketAllow(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(now, 2)
+
+	if !b.allow(now, 1, 2) || !b.allow(now, 1, 2) {
+		t.Fatal("burst of 2 should be allowed")
+	}
+	if b.allow(now, 1, 2) {
+		t.Fatal("third token should be denied")
+	}
+	if !b.allow(now.Add(time.Second), 1, 2) {
+		t.Fatal("token should refill after 1s at rate 1")
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(now, 2)
+
+	for i, want := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if wait, ok := b.reserve(now, 10, 2); !ok || wait != want {
+			t.Fatalf("reserve %d = %v, %v, want %v", i+1, wait, ok, want)
+		}
+	}
+	if _, ok := b.reserve(now, 10, 2); ok {
+		t.Fatal("reserve past a burst of debt should fail")
+	}
+	if wait, ok := b.reserve(now.Add(100*time.Millisecond), 10, 2); !ok || wait != 200*time.Millisecond {
+		t.Fatalf("reserve after a refill = %v, %v, want 200ms", wait, ok)
+	}
+}
+
+func TestNotifyLimiterAfter(t *testing.T) {
+	l := newNotifyLimiter(1, 1)
+	ran := make(chan int, 3)
+	l.after(30*time.Millisecond, func() { ran <- 3 })
+	l.after(20*time.Millisecond, func() { ran <- 2 })
+	l.after(time.Millisecond, func() { ran <- 1 })
+	for want := 1; want <= 3; want++ {
+		select {
+		case got := <-ran:
+			if got != want {
+				t.Fatalf("send %d ran, want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("send %d did not run", want)
+		}
+	}
+
+	l.after(10*time.Millisecond, func() { ran <- 4 })
+	l.close()
+	l.after(0, func() { ran <- 5 })
+	select {
+	case got := <-ran:
+		t.Fatalf("send %d ran after close", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNotifyLimiterDrop(t *testing.T) {
+	l := newNotifyLimiter(1, 1)
+	l.mode = NotifyDrop
+
+	var peer nwep.NodeID
+	peer[0] = 1
+
+	if _, ok := l.take(peer); !ok {
+		t.Fatal("first notification should pass")
+	}
+	if _, ok := l.take(peer); ok {
+		t.Fatal("second notification should be dropped")
+	}
+
+	var other nwep.NodeID
+	other[0] = 2
+	if _, ok := l.take(other); !ok {
+		t.Fatal("limits should be per peer")
+	}
+	if got := l.dropped.Load(); got != 1 {
+		t.Fatalf("dropped = %d, want 1", got)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := &rateLimiter{rate: 1, burst: 2, maxKeys: 2, buckets: make(map[string]*tokenBucket)}
+	now := time.Now()
+
+	for i := range 2 {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d denied within burst", i)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok || wait <= 0 || wait > time.Second {
+		t.Fatalf("third request: ok = %v, wait = %v, want denied within a second", ok, wait)
+	}
+	if ok, _ := l.allow("a", now.Add(time.Second)); !ok {
+		t.Fatal("request denied after refill")
+	}
+
+	l.allow("b", now.Add(2*time.Second))
+	l.allow("c", now.Add(2*time.Second))
+	if _, ok := l.buckets["a"]; ok || len(l.buckets) != 2 {
+		t.Fatalf("buckets = %v, want a evicted", l.buckets)
+	}
+}
@@ -0,0 +1,127 @@
+package velocity
+
+import (
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// ResponseRecorder is a ResponseWriter that records the response in memory so
+// that tests can inspect it after the handler returns. See NewTestContext.
+type ResponseRecorder struct {
+	// Status is the response status, or "" if none was set.
+	Status string
+
+	// Headers holds the response headers in the order they were first set.
+	// Setting a header again replaces its value.
+	Headers []nwep.Header
+
+	// Body is the response body, including everything written with
+	// StreamWrite.
+	Body []byte
+
+	// Responded is true once Respond or Write has been called.
+	Responded bool
+
+	// Closed is true once StreamClose has been called, and CloseCode holds
+	// the code it was called with.
+	Closed    bool
+	CloseCode int
+
+	// SendClosed and RecvClosed are true once CloseWrite and CloseRead,
+	// respectively, have been called.
+	SendClosed bool
+	RecvClosed bool
+}
+
+// NewRecorder returns an empty ResponseRecorder.
+func NewRecorder() *ResponseRecorder { return &ResponseRecorder{} }
+
+// Header returns the value of the response header name and whether it was
+// set.
+func (r *ResponseRecorder) Header(name string) (string, bool) {
+	for _, h := range r.Headers {
+		if h.Name == name {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetStatus implements ResponseWriter.
+func (r *ResponseRecorder) SetStatus(status string) { r.Status = status }
+
+// SetHeader implements ResponseWriter.
+func (r *ResponseRecorder) SetHeader(name, value string) {
+	for i := range r.Headers {
+		if r.Headers[i].Name == name {
+			r.Headers[i].Value = value
+			return
+		}
+	}
+	r.Headers = append(r.Headers, nwep.Header{Name: name, Value: value})
+}
+
+// Respond implements ResponseWriter.
+func (r *ResponseRecorder) Respond(status string, body []byte) error {
+	r.Status = status
+	return r.Write(body)
+}
+
+// Write implements ResponseWriter. A response written without a
+// status is recorded with status "ok", as nwep sends it.
+func (r *ResponseRecorder) Write(body []byte) error {
+	if r.Status == "" {
+		r.Status = StatusOK
+	}
+	r.Body = append(r.Body, body...)
+	r.Responded = true
+	return nil
+}
+
+// StreamWrite implements ResponseWriter.
+func (r *ResponseRecorder) StreamWrite(data []byte) (int, error) {
+	r.Body = append(r.Body, data...)
+	return len(data), nil
+}
+
+// StreamClose implements ResponseWriter.
+func (r *ResponseRecorder) StreamClose(errCode int) {
+	r.Closed = true
+	r.CloseCode = errCode
+}
+
+// StreamID implements ResponseWriter. It always returns 0.
+func (r *ResponseRecorder) StreamID() int64 { return 0 }
+
+// IsServerInitiated implements ResponseWriter. It always returns
+// false.
+func (r *ResponseRecorder) IsServerInitiated() bool { return false }
+
+// CloseWrite implements ResponseWriter.
+func (r *ResponseRecorder) CloseWrite() error {
+	r.SendClosed = true
+	return nil
+}
+
+// CloseRead implements ResponseWriter.
+func (r *ResponseRecorder) CloseRead() error {
+	r.RecvClosed = true
+	return nil
+}
+
+// NewTestContext returns a Context for a request with the given method, path,
+// and body, backed by an in-memory ResponseRecorder instead of a network
+// stream, so that handlers and middleware can be unit tested without the nwep
+// C library. After the handler returns, the recorder holds the status,
+// headers, and body it sent:
+//
+//	c, rec := velocity.NewTestContext(velocity.MethodRead, "/hello", nil)
+//	err := hello(c)
+//	// inspect err, rec.Status, rec.Header("content-type"), rec.Body
+//
+// The request has no headers and comes from an unauthenticated peer. See
+// NewContext for what a Context outside a running server supports.
+func NewTestContext(method, path string, body []byte) (*Context, *ResponseRecorder) {
+	rec := NewRecorder()
+	req := &nwep.Request{Method: method, Path: path, Body: body}
+	return NewContext(rec, req, nil), rec
+}
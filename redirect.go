@@ -0,0 +1,81 @@
+package velocity
+
+import (
+	"fmt"
+	"strings"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// HeaderLocation is the response header that carries the target URL of a
+// redirect sent by Context.Redirect.
+const HeaderLocation = "location"
+
+// Redirect tells the client to reissue the request to the server at url,
+// which must be a web:// URL such as one returned by Server.URL. It is
+// intended for handing clients over during a blue-green deploy or for simple
+// routing between servers.
+//
+// WEB/1 has no redirect status, so velocity defines a convention on top of an
+// existing one. A redirect is a response with:
+//
+//   - status StatusUnavailable, so that a client unaware of the convention
+//     treats it as a transient failure rather than as success;
+//   - a HeaderLocation header holding the target URL; and
+//   - a body consisting of exactly that URL, for clients that cannot read
+//     response headers.
+//
+// On the client side, RedirectTarget recognizes such a response and
+// FollowRedirects follows it. This function returns an error, without
+// responding, if url is not a web:// URL.
+func (c *Context) Redirect(url string) error {
+	if !isWebURL(url) {
+		return fmt.Errorf("velocity: redirect target must be a web:// URL, got %q", url)
+	}
+	c.SetHeader(HeaderLocation, url)
+	return c.Respond(StatusUnavailable, []byte(url))
+}
+
+// RedirectTarget reports whether resp is a redirect sent by Context.Redirect
+// and, if so, returns the target URL.
+func RedirectTarget(resp *nwep.Response) (string, bool) {
+	if resp == nil || resp.Status != StatusUnavailable {
+		return "", false
+	}
+	url := string(resp.Body)
+	if !isWebURL(url) {
+		return "", false
+	}
+	return url, true
+}
+
+// FollowRedirects issues a request by calling do with url and, while the
+// response is a redirect, calls do again with the target URL. do is
+// responsible for connecting to the server named by its argument and sending
+// the request; FollowRedirects only decides where to go next.
+//
+// To prevent loops, FollowRedirects follows at most maxHops redirects and
+// never revisits a URL. It returns the first response that is not a
+// redirect, or ErrRedirectLoop if either limit is hit.
+func FollowRedirects(url string, maxHops int, do func(url string) (*nwep.Response, error)) (*nwep.Response, error) {
+	visited := map[string]bool{url: true}
+	for hops := 0; ; hops++ {
+		resp, err := do(url)
+		if err != nil {
+			return nil, err
+		}
+		next, ok := RedirectTarget(resp)
+		if !ok {
+			return resp, nil
+		}
+		if hops >= maxHops || visited[next] {
+			return resp, fmt.Errorf("%w: %s", ErrRedirectLoop, next)
+		}
+		visited[next] = true
+		url = next
+	}
+}
+
+func isWebURL(s string) bool {
+	return strings.HasPrefix(s, "web://") && !strings.ContainsAny(s, " \t\r\n")
+}
@@ -0,0 +1,36 @@
+package velocity
+
+import (
+	"errors"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestFollowRedirects(t *testing.T) {
+	redirect := func(url string) *nwep.Response {
+		return &nwep.Response{Status: StatusUnavailable, Body: []byte(url)}
+	}
+	routes := map[string]*nwep.Response{
+		"web://a/x": redirect("web://b/x"),
+		"web://b/x": {Status: StatusOK, Body: []byte("hi")},
+		"web://c/x": redirect("web://d/x"),
+		"web://d/x": redirect("web://c/x"),
+	}
+	do := func(url string) (*nwep.Response, error) { return routes[url], nil }
+
+	resp, err := FollowRedirects("web://a/x", 3, do)
+	if err != nil || string(resp.Body) != "hi" {
+		t.Fatalf("resp = %v, err = %v, want hi", resp, err)
+	}
+	if _, err := FollowRedirects("web://c/x", 3, do); !errors.Is(err, ErrRedirectLoop) {
+		t.Fatalf("err = %v, want ErrRedirectLoop", err)
+	}
+	if _, err := FollowRedirects("web://a/x", 0, do); !errors.Is(err, ErrRedirectLoop) {
+		t.Fatalf("err = %v, want ErrRedirectLoop with no hops allowed", err)
+	}
+
+	if _, ok := RedirectTarget(&nwep.Response{Status: StatusUnavailable, Body: []byte("busy")}); ok {
+		t.Fatal("plain unavailable response reported as a redirect")
+	}
+}
@@ -0,0 +1,319 @@
+package velocity
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// DefaultRevocationInterval is how often a server polls a RevocationSource
+// unless WithRevocationSource says otherwise.
+const DefaultRevocationInterval = time.Minute
+
+// revocationDrain is how long a revoked peer's in-flight requests are given
+// before its connection is closed.
+const revocationDrain = time.Second
+
+// revocationList is the set of revoked node IDs: those revoked with
+// Server.Revoke and those reported by each RevocationSource.
+type revocationList struct {
+	mu      sync.RWMutex
+	manual  map[nwep.NodeID]struct{}
+	sources []map[nwep.NodeID]struct{}
+}
+
+func (r *revocationList) contains(peer nwep.NodeID) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.manual[peer]; ok {
+		return true
+	}
+	for _, set := range r.sources {
+		if _, ok := set[peer]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Revoke revokes peer's identity: its requests are rejected by RejectRevoked
+// from now on, and its connection, if it has one, is closed after its
+// in-flight requests are given a second to finish. The revocation lasts until
+// Unrevoke and does not survive a restart; use a RevocationSource for a list
+// that does. Revoking an unauthenticated peer's zero node ID is a no-op.
+//
+// The peer stays revoked whatever this function returns. It returns the
+// error from closing the peer's connection, if that fails.
+func (s *Server) Revoke(peer nwep.NodeID) error {
+	if peer.IsZero() {
+		return nil
+	}
+	s.revoked.mu.Lock()
+	if s.revoked.manual == nil {
+		s.revoked.manual = make(map[nwep.NodeID]struct{})
+	}
+	s.revoked.manual[peer] = struct{}{}
+	s.revoked.mu.Unlock()
+	s.logger.Info("peer revoked", "peer", FormatNodeID(peer))
+	return s.disconnectRevoked(peer)
+}
+
+// Unrevoke lifts a revocation made with Revoke. It does not lift one reported
+// by a RevocationSource, which lasts until the source stops reporting it.
+func (s *Server) Unrevoke(peer nwep.NodeID) {
+	s.revoked.mu.Lock()
+	delete(s.revoked.manual, peer)
+	s.revoked.mu.Unlock()
+}
+
+// IsRevoked reports whether peer is revoked, by Revoke or by a
+// RevocationSource.
+func (s *Server) IsRevoked(peer nwep.NodeID) bool {
+	return s.revoked.contains(peer)
+}
+
+// Revoked returns the revoked node IDs, sorted.
+func (s *Server) Revoked() []nwep.NodeID {
+	s.revoked.mu.RLock()
+	defer s.revoked.mu.RUnlock()
+	seen := make(map[nwep.NodeID]struct{}, len(s.revoked.manual))
+	var ids []nwep.NodeID
+	add := func(set map[nwep.NodeID]struct{}) {
+		for id := range set {
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				ids = append(ids, id)
+			}
+		}
+	}
+	add(s.revoked.manual)
+	for _, set := range s.revoked.sources {
+		add(set)
+	}
+	return sortedNodeIDs(ids)
+}
+
+// disconnectRevoked closes peer's connection, if it has one.
+func (s *Server) disconnectRevoked(peer nwep.NodeID) error {
+	if s.nwep == nil || !s.peers.connected(peer) {
+		return nil
+	}
+	err := s.DisconnectPeer(peer, revocationDrain)
+	if errors.Is(err, ErrPeerNotConnected) || errors.Is(err, ErrServerNotRunning) {
+		return nil
+	}
+	return err
+}
+
+// RejectRevoked returns middleware that rejects requests from revoked peers
+// (see Server.Revoke and WithRevocationSource) with status "forbidden" and
+// the message "identity revoked", and closes the peer's connection once the
+// response is sent. Revocation is checked on every request, so a peer revoked
+// while connected is cut off at its next request even if its connection could
+// not be closed.
+func RejectRevoked() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			peer := c.PeerNodeID()
+			if c.server != nil && !peer.IsZero() && c.server.IsRevoked(peer) {
+				go func() {
+					if err := c.server.disconnectRevoked(peer); err != nil {
+						c.server.logger.Warn("cannot close revoked peer's connection", "peer", FormatNodeID(peer), "error", err.Error())
+					}
+				}()
+				return c.Forbidden("identity revoked")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RevocationSource supplies a list of revoked node IDs, such as one kept in a
+// file or published to a Merkle log. See WithRevocationSource.
+type RevocationSource interface {
+	// Revoked returns every node ID currently revoked. ctx bounds any
+	// remote calls the source makes.
+	Revoked(ctx context.Context) ([]nwep.NodeID, error)
+}
+
+// RevocationSourceFunc adapts a function to the RevocationSource interface.
+type RevocationSourceFunc func(ctx context.Context) ([]nwep.NodeID, error)
+
+// Revoked calls f(ctx).
+func (f RevocationSourceFunc) Revoked(ctx context.Context) ([]nwep.NodeID, error) { return f(ctx) }
+
+// WithRevocationSource revokes the node IDs that src reports. src is polled
+// when the server starts and then every interval until Shutdown; a zero
+// interval means DefaultRevocationInterval. Each poll replaces the set src
+// reported before, so an ID the source drops is no longer revoked, and a
+// newly revoked peer that is connected is disconnected as by Server.Revoke. A
+// failed poll is logged and keeps the previous set. Several sources may be
+// used; a peer is revoked if any of them reports it.
+//
+// This option returns an error if src is nil or interval is negative.
+func WithRevocationSource(src RevocationSource, interval time.Duration) Option {
+	return func(s *Server) error {
+		if src == nil {
+			return errors.New("velocity: nil revocation source")
+		}
+		if interval < 0 {
+			return fmt.Errorf("velocity: revocation interval must not be negative, got %s", interval)
+		}
+		if interval == 0 {
+			interval = DefaultRevocationInterval
+		}
+		s.revoked.mu.Lock()
+		idx := len(s.revoked.sources)
+		s.revoked.sources = append(s.revoked.sources, nil)
+		s.revoked.mu.Unlock()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		s.onStart = append(s.onStart, func(s *Server) {
+			wg.Go(func() {
+				t := time.NewTicker(interval)
+				defer t.Stop()
+				for {
+					s.pollRevocations(ctx, src, idx, interval)
+					select {
+					case <-t.C:
+					case <-ctx.Done():
+						return
+					}
+				}
+			})
+		})
+		s.onShutdown = append(s.onShutdown, func(*Server) {
+			cancel()
+			wg.Wait()
+		})
+		return nil
+	}
+}
+
+// pollRevocations replaces the set of source idx with what src reports now,
+// and disconnects peers it newly revokes.
+func (s *Server) pollRevocations(ctx context.Context, src RevocationSource, idx int, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ids, err := src.Revoked(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.Warn("revocation source failed", "error", err.Error())
+		}
+		return
+	}
+	set := make(map[nwep.NodeID]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	s.revoked.mu.Lock()
+	prev := s.revoked.sources[idx]
+	s.revoked.sources[idx] = set
+	s.revoked.mu.Unlock()
+	for id := range set {
+		if _, ok := prev[id]; ok {
+			continue
+		}
+		s.logger.Info("peer revoked", "peer", FormatNodeID(id))
+		if err := s.disconnectRevoked(id); err != nil {
+			s.logger.Warn("cannot close revoked peer's connection", "peer", FormatNodeID(id), "error", err.Error())
+		}
+	}
+}
+
+// RevocationFile returns a RevocationSource that reads the file at path on
+// every poll. The file lists one node ID per line in the form produced by
+// FormatNodeID; blank lines and lines starting with # are ignored. A missing
+// file revokes nothing. Reading fails if a line is not a node ID.
+func RevocationFile(path string) RevocationSource {
+	return RevocationSourceFunc(func(context.Context) ([]nwep.NodeID, error) {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("velocity: revocation file: %w", err)
+		}
+		defer f.Close()
+		var ids []nwep.NodeID
+		sc := bufio.NewScanner(f)
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			id, err := NodeIDFromString(line)
+			if err != nil {
+				return nil, fmt.Errorf("velocity: revocation file %s:%d: %w", path, n, err)
+			}
+			ids = append(ids, id)
+		}
+		if err := sc.Err(); err != nil {
+			return nil, fmt.Errorf("velocity: revocation file: %w", err)
+		}
+		return ids, nil
+	})
+}
+
+// logEntryRevocation is the Merkle log entry type of a revocation,
+// NWEP_LOG_ENTRY_REVOCATION in nwep.
+const logEntryRevocation = 3
+
+// LogRevocations returns a RevocationSource that reads revocation entries
+// from the log server c is connected to, through its /log/size and
+// /log/entry/{index} routes. The first poll reads the whole log; later polls
+// read only the entries appended since. The source is not safe for use by
+// more than one server.
+func LogRevocations(c *Client) RevocationSource {
+	ls := &logRevocations{c: c, set: make(map[nwep.NodeID]struct{})}
+	return RevocationSourceFunc(ls.revoked)
+}
+
+type logRevocations struct {
+	c *Client
+
+	mu   sync.Mutex
+	next uint64 // index of the first entry not yet read
+	set  map[nwep.NodeID]struct{}
+}
+
+func (l *logRevocations) revoked(ctx context.Context) ([]nwep.NodeID, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var size struct {
+		Size uint64 `json:"size"`
+	}
+	if err := l.c.ReadJSON(ctx, "/log/size", &size); err != nil {
+		return nil, fmt.Errorf("velocity: read log size: %w", err)
+	}
+	for ; l.next < size.Size; l.next++ {
+		resp, err := l.c.Read(ctx, "/log/entry/"+strconv.FormatUint(l.next, 10))
+		if err == nil {
+			err = ResponseError(resp)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("velocity: read log entry %d: %w", l.next, err)
+		}
+		entry, err := nwep.MerkleEntryDecode(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("velocity: decode log entry %d: %w", l.next, err)
+		}
+		if entry.Type == logEntryRevocation {
+			l.set[entry.NodeID] = struct{}{}
+		}
+	}
+	ids := make([]nwep.NodeID, 0, len(l.set))
+	for id := range l.set {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,60 @@
+package velocity
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestRevoke(t *testing.T) {
+	s, err := New(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := nwep.NodeID{1}, nwep.NodeID{2}
+	if err := s.Revoke(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke(a); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsRevoked(a) || !s.IsRevoked(b) {
+		t.Fatal("revoked peers not reported revoked")
+	}
+	if got := s.Revoked(); !slices.Equal(got, []nwep.NodeID{a, b}) {
+		t.Fatalf("Revoked() = %v", got)
+	}
+	s.Unrevoke(a)
+	if s.IsRevoked(a) {
+		t.Fatal("peer still revoked after Unrevoke")
+	}
+}
+
+func TestRevocationFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked")
+	ids, err := RevocationFile(path).Revoked(context.Background())
+	if err != nil || len(ids) != 0 {
+		t.Fatalf("missing file: ids = %v, err = %v", ids, err)
+	}
+
+	id := nwep.NodeID{7}
+	data := "# compromised 2026-10-01\n\n" + FormatNodeID(id) + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ids, err = RevocationFile(path).Revoked(context.Background())
+	if err != nil || !slices.Equal(ids, []nwep.NodeID{id}) {
+		t.Fatalf("ids = %v, err = %v", ids, err)
+	}
+
+	if err := os.WriteFile(path, []byte("not-a-node-id\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RevocationFile(path).Revoked(context.Background()); err == nil {
+		t.Fatal("bad line accepted")
+	}
+}
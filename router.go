@@ -1,6 +1,11 @@
 package velocity
 
-import "strings"
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
 
 // combineMW returns a new slice containing the elements of a followed by b.
 // It always allocates a fresh backing array so that appending to the result
@@ -13,12 +18,14 @@ func combineMW(a, b []MiddlewareFunc) []MiddlewareFunc {
 }
 
 type route struct {
+	pattern    string
 	handler    HandlerFunc
 	middleware []MiddlewareFunc
+	schema     *routeSchema // from WithSchema, for APISpec
 }
 
 // Router maps request paths (and optionally methods) to handlers. It supports
-// three kinds of routes, checked in the following order:
+// five kinds of routes, checked in the following order:
 //
 //  1. Method-specific exact match - registered with Router.Method or the
 //     convenience methods Read, Write, Update, Delete. The route matches only
@@ -27,9 +34,27 @@ type route struct {
 //  2. Path-only exact match - registered with Router.Handle. The route matches
 //     any method for the given path.
 //
-//  3. Prefix match - registered with Router.HandlePrefix. When multiple prefix
+//  3. Parameterized match - registered with Handle, Method, or the convenience
+//     methods using a path with variable segments. A ":name" segment, as in
+//     "/users/:id", matches exactly one non-empty path segment, available
+//     from Context.Param(name). A "*" segment matches one segment in the same
+//     way, and a final "**" segment, as in "/files/**", matches the rest of
+//     the path; both are available as Context.Param("*"). When several
+//     parameterized routes match, the one with the most literal segments
+//     wins, then fixed-length patterns beat "**", then method-specific
+//     routes beat path-only ones.
+//
+//  4. Mounted router - attached with Router.Mount. The request is handed to
+//     the router mounted under the longest prefix containing the path, which
+//     matches the rest of the path in this same order.
+//
+//  5. Prefix match - registered with Router.HandlePrefix. When multiple prefix
 //     routes match, the longest prefix wins.
 //
+// Routes are matched against the path portion of the request path only: a
+// query string such as "?page=2" is ignored for routing and available from
+// Context.QueryParam instead.
+//
 // If no route matches, the not-found handler set by SetNotFound is called. If
 // no not-found handler has been set, the server returns a "not_found" response
 // with the body "not found".
@@ -39,10 +64,19 @@ type route struct {
 // (Find) is safe for concurrent use.
 type Router struct {
 	exact    map[string]*route
+	params   []paramRoute
+	mounts   []mountPoint
 	prefixes []prefixRoute
 	notFound HandlerFunc
 }
 
+// mountPoint is a router attached with Mount.
+type mountPoint struct {
+	prefix string
+	sub    *Router
+	mw     []MiddlewareFunc
+}
+
 type prefixRoute struct {
 	prefix string
 	route  *route
@@ -61,15 +95,24 @@ func NewRouter() *Router {
 // Optional middleware mw is applied to this route only, after global
 // middleware. If a handler is already registered for path, it is replaced.
 func (rt *Router) Handle(path string, h HandlerFunc, mw ...MiddlewareFunc) {
-	rt.exact[path] = &route{handler: h, middleware: mw}
+	r := &route{pattern: path, handler: h, middleware: mw, schema: schemaFrom(mw)}
+	if isParamPattern(path) {
+		rt.addParamRoute("", r)
+		return
+	}
+	rt.exact[path] = r
 }
 
 // Method registers h for a specific method and path combination. Optional
 // middleware mw is applied to this route only. Method-specific routes take
 // precedence over path-only routes registered with Handle.
 func (rt *Router) Method(method, path string, h HandlerFunc, mw ...MiddlewareFunc) {
-	key := method + " " + path
-	rt.exact[key] = &route{handler: h, middleware: mw}
+	r := &route{pattern: path, handler: h, middleware: mw, schema: schemaFrom(mw)}
+	if isParamPattern(path) {
+		rt.addParamRoute(method, r)
+		return
+	}
+	rt.exact[method+" "+path] = r
 }
 
 // Read registers h for MethodRead ("read") on the given path. It is a
@@ -96,6 +139,19 @@ func (rt *Router) Delete(path string, h HandlerFunc, mw ...MiddlewareFunc) {
 	rt.Method(MethodDelete, path, h, mw...)
 }
 
+// HandleChain registers an ordered list of handlers for path, matching all
+// request methods. The handlers are tried in order: a handler that returns
+// ErrDecline (see Context.Decline) passes the request to the next one, and
+// when every handler has declined the request is answered by the not-found
+// handler. Any other result, including a nil error, ends the chain.
+//
+// A handler must decline before it starts a response. If it declines after
+// responding, the chain stops there and the decline is ignored, so the
+// request never receives a second response.
+func (rt *Router) HandleChain(path string, handlers ...HandlerFunc) {
+	rt.Handle(path, chainHandlers(handlers))
+}
+
 // HandlePrefix registers h for all paths that begin with prefix. When multiple
 // prefix routes match a request, the route with the longest matching prefix is
 // selected. Optional middleware mw is applied to this route only.
@@ -105,7 +161,7 @@ func (rt *Router) Delete(path string, h HandlerFunc, mw ...MiddlewareFunc) {
 func (rt *Router) HandlePrefix(prefix string, h HandlerFunc, mw ...MiddlewareFunc) {
 	rt.prefixes = append(rt.prefixes, prefixRoute{
 		prefix: prefix,
-		route:  &route{handler: h, middleware: mw},
+		route:  &route{pattern: prefix, handler: h, middleware: mw, schema: schemaFrom(mw)},
 	})
 }
 
@@ -132,22 +188,241 @@ func (rt *Router) Group(prefix string, mw ...MiddlewareFunc) *Group {
 	}
 }
 
+// Mount attaches sub under prefix, so that a router built on its own, such as
+// by another package, can be served as part of this one without registering
+// its routes again:
+//
+//	// package billing
+//	func Routes() *velocity.Router {
+//	    rt := velocity.NewRouter()
+//	    rt.Read("/invoices/:id", getInvoice)
+//	    rt.SetNotFound(func(c *velocity.Context) error { return c.NotFound("no such billing resource") })
+//	    return rt
+//	}
+//
+//	// package main
+//	srv.Router().Mount("/billing", billing.Routes(), velocity.RequireRole("billing"))
+//
+// Requests for prefix and the paths below it are matched by sub against the
+// path with prefix removed, so "/billing/invoices/7" is served by
+// "/invoices/:id" and Context.RoutePattern reports "/billing/invoices/:id";
+// Context.Path still returns the full path. The prefix only matches whole
+// segments: "/billing" does not contain "/billingx". A request in sub's
+// subtree that sub has no route for is answered by sub's not-found handler, or
+// by this router's if sub has none.
+//
+// The middleware mw runs after global middleware and before the route
+// middleware sub's routes were registered with, including around sub's
+// not-found handler. sub is consulted on every request, so routes added to it
+// after Mount are served too. Mount panics if prefix does not start with "/",
+// or if sub is nil or the router itself.
+func (rt *Router) Mount(prefix string, sub *Router, mw ...MiddlewareFunc) {
+	if !strings.HasPrefix(prefix, "/") {
+		panic(fmt.Sprintf("velocity: mount prefix must start with /, got %q", prefix))
+	}
+	if sub == nil || sub == rt {
+		panic("velocity: Mount requires another router")
+	}
+	rt.mounts = append(rt.mounts, mountPoint{prefix: strings.TrimSuffix(prefix, "/"), sub: sub, mw: mw})
+}
+
+// mountFor returns the mount point with the longest prefix containing path,
+// and path relative to it, or nil if path is not under any mount point.
+func (rt *Router) mountFor(path string) (*mountPoint, string) {
+	path, _, _ = strings.Cut(path, "?")
+	var best *mountPoint
+	var bestRest string
+	for i := range rt.mounts {
+		m := &rt.mounts[i]
+		rest, ok := strings.CutPrefix(path, m.prefix)
+		if !ok || (rest != "" && rest[0] != '/') {
+			continue
+		}
+		if best == nil || len(m.prefix) > len(best.prefix) {
+			best, bestRest = m, rest
+		}
+	}
+	if best != nil && bestRest == "" {
+		bestRest = "/"
+	}
+	return best, bestRest
+}
+
 // Find looks up a handler for the given path and method, composing globalMW
 // and any route-level middleware around the matched handler. Find returns nil
 // if no route matches and no not-found handler is set.
 //
 // The lookup order is: method-specific exact match, then path-only exact
-// match, then longest prefix match, then the not-found handler.
+// match, then parameterized match, then mounted routers, then longest prefix
+// match, then the not-found handler.
 func (rt *Router) Find(path, method string, globalMW []MiddlewareFunc) HandlerFunc {
+	h, _ := rt.find(path, method, globalMW, nil)
+	return h
+}
+
+// HasRoute reports whether a route is registered for exactly path, with any
+// method: by Handle, or by Method or one of its convenience wrappers. A
+// parameterized route is reported under its pattern (e.g. "/users/:id"), not
+// under the paths it matches, and prefix routes are not considered. Use Find
+// to check whether a request path would be served.
+func (rt *Router) HasRoute(path string) bool {
+	for _, r := range rt.exact {
+		if r.pattern == path {
+			return true
+		}
+	}
+	for _, pr := range rt.params {
+		if pr.route.pattern == path {
+			return true
+		}
+	}
+	return false
+}
+
+// HasMethodRoute reports whether a request with method for exactly path is
+// handled by a registered route: one registered by Method (or a convenience
+// wrapper) for method and path, or by Handle for path, which serves every
+// method. Patterns are compared as in HasRoute.
+func (rt *Router) HasMethodRoute(method, path string) bool {
+	if _, ok := rt.exact[method+" "+path]; ok {
+		return true
+	}
+	if _, ok := rt.exact[path]; ok {
+		return true
+	}
+	for _, pr := range rt.params {
+		if pr.route.pattern == path && (pr.method == "" || pr.method == method) {
+			return true
+		}
+	}
+	return false
+}
+
+// find implements Find and additionally returns the pattern of the matched
+// route, or "" if the not-found handler was selected or nothing matched. If
+// params is non-nil, the values captured by a parameterized route are
+// appended to it.
+func (rt *Router) find(path, method string, globalMW []MiddlewareFunc, params *[]pathParam) (HandlerFunc, string) {
+	if r := rt.matchExact(path, method, params); r != nil {
+		return applyMiddleware(r.handler, combineMW(globalMW, r.middleware)), r.pattern
+	}
+	if m, rest := rt.mountFor(path); m != nil {
+		h, pattern := m.sub.find(rest, method, combineMW(globalMW, m.mw), params)
+		if h != nil {
+			if pattern != "" {
+				pattern = m.prefix + pattern
+			}
+			return h, pattern
+		}
+	} else if r := rt.matchPrefix(path); r != nil {
+		return applyMiddleware(r.handler, combineMW(globalMW, r.middleware)), r.pattern
+	}
+	// Not found handler.
+	if rt.notFound != nil {
+		return applyMiddleware(rt.notFound, globalMW), ""
+	}
+	return nil, ""
+}
+
+// routes returns every registered route: exact and method-specific routes
+// ordered by key, followed by parameterized and then prefix routes in
+// registration order, and then the routes of mounted routers, with their
+// patterns prefixed and the mount middleware added.
+func (rt *Router) routes() []*route {
+	keys := make([]string, 0, len(rt.exact))
+	for k := range rt.exact {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	out := make([]*route, 0, len(keys)+len(rt.prefixes))
+	for _, k := range keys {
+		out = append(out, rt.exact[k])
+	}
+	for _, pr := range rt.params {
+		out = append(out, pr.route)
+	}
+	for _, pr := range rt.prefixes {
+		out = append(out, pr.route)
+	}
+	for _, m := range rt.mounts {
+		for _, r := range m.sub.routes() {
+			r := *r
+			r.pattern = m.prefix + r.pattern
+			r.middleware = combineMW(m.mw, r.middleware)
+			out = append(out, &r)
+		}
+	}
+	return out
+}
+
+// RouteInfo describes a registered route. See Router.Routes.
+type RouteInfo struct {
+	// Method is the method the route is restricted to, or "" if it
+	// matches any method.
+	Method string
+
+	// Pattern is the path, parameterized pattern, or prefix the route
+	// was registered with.
+	Pattern string
+
+	// Prefix is true for routes registered with HandlePrefix.
+	Prefix bool
+}
+
+// Routes returns every registered route: exact routes sorted by method and
+// path, then parameterized and then prefix routes in registration order, and
+// then the routes of mounted routers (see Mount), with their patterns
+// prefixed.
+func (rt *Router) Routes() []RouteInfo {
+	keys := make([]string, 0, len(rt.exact))
+	for k := range rt.exact {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	out := make([]RouteInfo, 0, len(keys)+len(rt.params)+len(rt.prefixes))
+	for _, k := range keys {
+		method, path, ok := strings.Cut(k, " ")
+		if !ok {
+			method, path = "", k
+		}
+		out = append(out, RouteInfo{Method: method, Pattern: path})
+	}
+	for _, pr := range rt.params {
+		out = append(out, RouteInfo{Method: pr.method, Pattern: pr.route.pattern})
+	}
+	for _, pr := range rt.prefixes {
+		out = append(out, RouteInfo{Pattern: pr.prefix, Prefix: true})
+	}
+	for _, m := range rt.mounts {
+		for _, ri := range m.sub.Routes() {
+			ri.Pattern = m.prefix + ri.Pattern
+			out = append(out, ri)
+		}
+	}
+	return out
+}
+
+// matchExact returns the exact or parameterized route registered for path and
+// method, or nil. Parameter values captured along the way are appended to
+// params if it is non-nil.
+func (rt *Router) matchExact(path, method string, params *[]pathParam) *route {
+	path, _, _ = strings.Cut(path, "?")
 	// Try method-specific exact match first.
 	if r, ok := rt.exact[method+" "+path]; ok {
-		return applyMiddleware(r.handler, combineMW(globalMW, r.middleware))
+		return r
 	}
 	// Try path-only exact match.
 	if r, ok := rt.exact[path]; ok {
-		return applyMiddleware(r.handler, combineMW(globalMW, r.middleware))
+		return r
 	}
-	// Try prefix match (longest prefix wins).
+	// Try parameterized match.
+	return rt.matchParams(path, method, params)
+}
+
+// matchPrefix returns the prefix route with the longest prefix of path, or
+// nil.
+func (rt *Router) matchPrefix(path string) *route {
+	path, _, _ = strings.Cut(path, "?")
 	var best *route
 	bestLen := 0
 	for _, pr := range rt.prefixes {
@@ -156,14 +431,7 @@ func (rt *Router) Find(path, method string, globalMW []MiddlewareFunc) HandlerFu
 			bestLen = len(pr.prefix)
 		}
 	}
-	if best != nil {
-		return applyMiddleware(best.handler, combineMW(globalMW, best.middleware))
-	}
-	// Not found handler.
-	if rt.notFound != nil {
-		return applyMiddleware(rt.notFound, globalMW)
-	}
-	return nil
+	return best
 }
 
 // Group is a collection of routes that share a common path prefix and
@@ -225,6 +493,21 @@ func (g *Group) HandlePrefix(prefix string, h HandlerFunc, mw ...MiddlewareFunc)
 	g.router.HandlePrefix(g.prefix+prefix, h, combineMW(g.middleware, mw)...)
 }
 
+// HandleChain registers a chain of handlers for path (prefixed by the group
+// prefix), as Router.HandleChain does. The group's middleware wraps the chain
+// as a whole.
+func (g *Group) HandleChain(path string, handlers ...HandlerFunc) {
+	g.router.Handle(g.prefix+path, chainHandlers(handlers), g.middleware...)
+}
+
+// SetContentType sets a default "content-type" response header for routes in
+// the group, by adding ContentType(ct) to the group's middleware. Like other
+// group middleware, it applies to routes and sub-groups registered after the
+// call. Handlers can still override the header with Context.SetHeader.
+func (g *Group) SetContentType(ct string) {
+	g.middleware = combineMW(g.middleware, []MiddlewareFunc{ContentType(ct)})
+}
+
 // Group creates a sub-group that inherits this group's prefix and middleware.
 // The sub-group's prefix is appended to the parent prefix, and the sub-group's
 // middleware runs after the parent group's middleware.
@@ -235,3 +518,43 @@ func (g *Group) Group(prefix string, mw ...MiddlewareFunc) *Group {
 		middleware: combineMW(g.middleware, mw),
 	}
 }
+
+// chainHandlers returns a handler that tries each of handlers in order until
+// one does not decline. It returns ErrDecline if all of them decline.
+func chainHandlers(handlers []HandlerFunc) HandlerFunc {
+	handlers = slices.Clone(handlers)
+	return func(c *Context) error {
+		for _, h := range handlers {
+			err := h(c)
+			if !errors.Is(err, ErrDecline) {
+				return err
+			}
+			if c.Committed() {
+				return nil
+			}
+		}
+		return ErrDecline
+	}
+}
+
+// declined answers a request whose handler declined it, with the not-found
+// handler if one is set. Middleware has already run around the declining
+// handler, so it is not applied again.
+func (rt *Router) declined(c *Context) {
+	if h := rt.notFoundFor(c.Path()); h != nil {
+		_ = h(c)
+		return
+	}
+	_ = c.NotFound("not found")
+}
+
+// notFoundFor returns the not-found handler for path: that of the innermost
+// mounted router containing path that has one, or rt's own.
+func (rt *Router) notFoundFor(path string) HandlerFunc {
+	if m, rest := rt.mountFor(path); m != nil {
+		if h := m.sub.notFoundFor(rest); h != nil {
+			return h
+		}
+	}
+	return rt.notFound
+}
@@ -0,0 +1,247 @@
+package velocity
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func nopHandler(c *Context) error { return nil }
+
+func TestRouterFindPattern(t *testing.T) {
+	rt := NewRouter()
+	rt.Handle("/users", nopHandler)
+	rt.Read("/users/me", nopHandler)
+	rt.HandlePrefix("/files/", nopHandler)
+	rt.HandlePrefix("/files/img/", nopHandler)
+
+	tests := []struct {
+		method, path string
+		want         string
+		found        bool
+	}{
+		{MethodWrite, "/users", "/users", true},
+		{MethodRead, "/users/me", "/users/me", true},
+		{MethodWrite, "/users/me", "", false},
+		{MethodRead, "/files/a.txt", "/files/", true},
+		{MethodRead, "/files/img/a.png", "/files/img/", true},
+		{MethodRead, "/nope", "", false},
+		{MethodWrite, "/users?page=2", "/users", true},
+		{MethodRead, "/users/me?x=1&y", "/users/me", true},
+		{MethodRead, "/files/a.txt?v=3", "/files/", true},
+	}
+	for _, tt := range tests {
+		h, pattern := rt.find(tt.path, tt.method, nil, nil)
+		if (h != nil) != tt.found {
+			t.Errorf("%s %s: found = %v, want %v", tt.method, tt.path, h != nil, tt.found)
+		}
+		if pattern != tt.want {
+			t.Errorf("%s %s: pattern = %q, want %q", tt.method, tt.path, pattern, tt.want)
+		}
+	}
+}
+
+func TestRouterFindNotFoundHandler(t *testing.T) {
+	rt := NewRouter()
+	rt.SetNotFound(nopHandler)
+
+	h, pattern := rt.find("/missing", MethodRead, nil, nil)
+	if h == nil {
+		t.Fatal("expected not-found handler")
+	}
+	if pattern != "" {
+		t.Fatalf("pattern = %q, want empty", pattern)
+	}
+}
+
+func TestRouterFindParams(t *testing.T) {
+	rt := NewRouter()
+	rt.Handle("/users/me", nopHandler)
+	rt.Handle("/users/:id", nopHandler)
+	rt.Read("/users/:id/posts/:post", nopHandler)
+	rt.Handle("/:org/posts/latest", nopHandler)
+
+	tests := []struct {
+		method, path string
+		want         string
+		params       []pathParam
+	}{
+		{MethodRead, "/users/me", "/users/me", nil},
+		{MethodRead, "/users/42", "/users/:id", []pathParam{{"id", "42"}}},
+		{MethodRead, "/users/42/posts/7", "/users/:id/posts/:post", []pathParam{{"id", "42"}, {"post", "7"}}},
+		{MethodWrite, "/users/42/posts/7", "", nil},
+		{MethodRead, "/users/", "", nil},
+		{MethodRead, "/users/posts/latest", "/:org/posts/latest", []pathParam{{"org", "users"}}},
+	}
+	for _, tt := range tests {
+		var params []pathParam
+		_, pattern := rt.find(tt.path, tt.method, nil, &params)
+		if pattern != tt.want {
+			t.Errorf("%s %s: pattern = %q, want %q", tt.method, tt.path, pattern, tt.want)
+		}
+		if !slices.Equal(params, tt.params) {
+			t.Errorf("%s %s: params = %v, want %v", tt.method, tt.path, params, tt.params)
+		}
+	}
+}
+
+func TestRouterHasRoute(t *testing.T) {
+	rt := NewRouter()
+	rt.Handle("/health", nopHandler)
+	rt.Read("/users", nopHandler)
+	rt.Write("/users/:id", nopHandler)
+	rt.HandlePrefix("/files/", nopHandler)
+
+	tests := []struct {
+		method, path      string
+		hasAny, hasMethod bool
+	}{
+		{MethodWrite, "/health", true, true},
+		{MethodRead, "/users", true, true},
+		{MethodWrite, "/users", true, false},
+		{MethodWrite, "/users/:id", true, true},
+		{MethodRead, "/users/:id", true, false},
+		{MethodWrite, "/users/42", false, false},
+		{MethodRead, "/files/", false, false},
+	}
+	for _, tt := range tests {
+		if got := rt.HasRoute(tt.path); got != tt.hasAny {
+			t.Errorf("HasRoute(%q) = %v, want %v", tt.path, got, tt.hasAny)
+		}
+		if got := rt.HasMethodRoute(tt.method, tt.path); got != tt.hasMethod {
+			t.Errorf("HasMethodRoute(%q, %q) = %v, want %v", tt.method, tt.path, got, tt.hasMethod)
+		}
+	}
+}
+
+func TestChainHandlers(t *testing.T) {
+	var ran []int
+	step := func(i int, err error, respond bool) HandlerFunc {
+		return func(c *Context) error {
+			ran = append(ran, i)
+			if respond {
+				c.committed = true
+			}
+			return err
+		}
+	}
+
+	h := chainHandlers([]HandlerFunc{
+		step(1, ErrDecline, false),
+		step(2, nil, false),
+		step(3, nil, false),
+	})
+	if err := h(&Context{}); err != nil || !slices.Equal(ran, []int{1, 2}) {
+		t.Fatalf("err = %v, ran %v, want nil after [1 2]", err, ran)
+	}
+
+	ran = nil
+	h = chainHandlers([]HandlerFunc{step(1, ErrDecline, false), step(2, ErrDecline, false)})
+	if err := h(&Context{}); !errors.Is(err, ErrDecline) || len(ran) != 2 {
+		t.Fatalf("err = %v, ran %v, want ErrDecline after both", err, ran)
+	}
+
+	ran = nil
+	h = chainHandlers([]HandlerFunc{step(1, ErrDecline, true), step(2, nil, false)})
+	if err := h(&Context{}); err != nil || !slices.Equal(ran, []int{1}) {
+		t.Fatalf("err = %v, ran %v, want chain to stop after a committed decline", err, ran)
+	}
+}
+
+func TestRouterFindWildcards(t *testing.T) {
+	rt := NewRouter()
+	rt.Handle("/files/*", nopHandler)
+	rt.Handle("/files/**", nopHandler)
+	rt.Handle("/files/public/**", nopHandler)
+	rt.Handle("/:a/:b", nopHandler)
+
+	tests := []struct {
+		path, want, star string
+	}{
+		{"/files/a.txt", "/files/*", "a.txt"},
+		{"/files/img/a.png", "/files/**", "img/a.png"},
+		{"/files/", "/files/**", ""},
+		{"/files", "/files/**", ""},
+		{"/files/public/x/y", "/files/public/**", "x/y"},
+		{"/other/thing", "/:a/:b", ""},
+	}
+	for _, tt := range tests {
+		c := &Context{}
+		_, pattern := rt.find(tt.path, MethodRead, nil, &c.params)
+		if pattern != tt.want {
+			t.Errorf("%s: pattern = %q, want %q", tt.path, pattern, tt.want)
+		}
+		if got := c.Param("*"); got != tt.star {
+			t.Errorf("%s: Param(\"*\") = %q, want %q", tt.path, got, tt.star)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for ** before the last segment")
+		}
+	}()
+	rt.Handle("/bad/**/x", nopHandler)
+}
+
+func TestRouterRoutes(t *testing.T) {
+	rt := NewRouter()
+	rt.HandlePrefix("/static/", nopHandler)
+	rt.Read("/users/:id", nopHandler)
+	rt.Write("/users", nopHandler)
+	rt.Handle("/health", nopHandler)
+
+	want := []RouteInfo{
+		{Pattern: "/health"},
+		{Method: MethodWrite, Pattern: "/users"},
+		{Method: MethodRead, Pattern: "/users/:id"},
+		{Pattern: "/static/", Prefix: true},
+	}
+	if got := rt.Routes(); !slices.Equal(got, want) {
+		t.Fatalf("Routes() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRouterMount(t *testing.T) {
+	sub := NewRouter()
+	sub.Read("/invoices/:id", nopHandler)
+	sub.Handle("/", nopHandler)
+	rt := NewRouter()
+	rt.HandlePrefix("/", nopHandler)
+	rt.Read("/billing/health", nopHandler)
+	rt.Mount("/billing/", sub)
+
+	tests := []struct {
+		path, want string
+		found      bool
+	}{
+		{"/billing/invoices/7?x=1", "/billing/invoices/:id", true},
+		{"/billing", "/billing/", true},
+		{"/billing/health", "/billing/health", true},
+		{"/billing/missing", "", false},
+		{"/billingx", "/", true},
+	}
+	for _, tt := range tests {
+		var params []pathParam
+		h, pattern := rt.find(tt.path, MethodRead, nil, &params)
+		if (h != nil) != tt.found || pattern != tt.want {
+			t.Errorf("%s: found = %v, pattern = %q; want %v, %q", tt.path, h != nil, pattern, tt.found, tt.want)
+		}
+	}
+
+	var notFound string
+	sub.SetNotFound(func(c *Context) error { notFound = "sub"; return nil })
+	if h, _ := rt.find("/billing/missing", MethodRead, nil, nil); h == nil || h(nil) != nil || notFound != "sub" {
+		t.Fatalf("sub-router not-found handler not used")
+	}
+
+	want := []RouteInfo{
+		{Method: MethodRead, Pattern: "/billing/health"},
+		{Pattern: "/", Prefix: true},
+		{Pattern: "/billing/"},
+		{Method: MethodRead, Pattern: "/billing/invoices/:id"},
+	}
+	if got := rt.Routes(); !slices.Equal(got, want) {
+		t.Fatalf("Routes() = %+v, want %+v", got, want)
+	}
+}
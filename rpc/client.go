@@ -0,0 +1,72 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/usenwep/velocity"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// Client calls the methods of one RPC service over a velocity.Client.
+type Client struct {
+	c           *velocity.Client
+	service     string
+	contentType string
+	codec       velocity.Codec
+}
+
+// NewClient returns a Client for service, sending calls with c and encoding
+// them as JSON.
+func NewClient(c *velocity.Client, service string) *Client {
+	codec, _ := velocity.LookupCodec(velocity.MIMEJSON)
+	return &Client{c: c, service: service, contentType: velocity.MIMEJSON, codec: codec}
+}
+
+// WithContentType returns a copy of the Client that encodes requests, and
+// asks for responses, in contentType, which must have a codec registered
+// with velocity.RegisterCodec on both sides. Calls then carry content-type
+// and accept headers. This function returns an error if no codec is
+// registered for contentType.
+func (r *Client) WithContentType(contentType string) (*Client, error) {
+	codec, ok := velocity.LookupCodec(contentType)
+	if !ok {
+		return nil, fmt.Errorf("rpc: no codec registered for %q", contentType)
+	}
+	cp := *r
+	cp.contentType, cp.codec = contentType, codec
+	return &cp, nil
+}
+
+// Call invokes method with req and decodes the result into resp, which must
+// be a pointer, or nil for methods that return only an error. An error
+// status from the server is returned as a *velocity.Error (see
+// velocity.ResponseError).
+func (r *Client) Call(ctx context.Context, method string, req, resp any) error {
+	body, err := r.codec.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("rpc: encode %s.%s request: %w", r.service, method, err)
+	}
+	var headers []nwep.Header
+	if r.contentType != velocity.MIMEJSON {
+		headers = []nwep.Header{
+			{Name: "content-type", Value: r.contentType},
+			{Name: "accept", Value: r.contentType},
+		}
+	}
+	res, err := r.c.Write(ctx, Path(r.service, method), body, headers...)
+	if err != nil {
+		return err
+	}
+	if err := velocity.ResponseError(res); err != nil {
+		return err
+	}
+	if resp == nil || len(res.Body) == 0 {
+		return nil
+	}
+	if err := r.codec.Unmarshal(res.Body, resp); err != nil {
+		return fmt.Errorf("rpc: decode %s.%s response: %w", r.service, method, err)
+	}
+	return nil
+}
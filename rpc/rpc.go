@@ -0,0 +1,151 @@
+// Package rpc is a service-to-service RPC layer over velocity. A service is a
+// Go type, usually described by an interface, whose methods have the form
+//
+//	func (s *Calc) Add(ctx context.Context, req AddRequest) (AddResponse, error)
+//
+// or return only an error. Register serves each such method on a velocity
+// server as a write route at PathPrefix+service+"/"+method, decoding the
+// request body with the codec named by its content-type and encoding the
+// result as the caller asks, JSON by default (see velocity.RegisterCodec):
+//
+//	rpc.Register[Calculator](srv, "calc", &calc{})
+//
+// On the calling side, Client.Call invokes a method by name over a
+// velocity.Client:
+//
+//	calc := rpc.NewClient(client, "calc")
+//	var sum AddResponse
+//	err := calc.Call(ctx, "Add", AddRequest{A: 1, B: 2}, &sum)
+//
+// The velocity-rpcgen command generates a typed stub with one method per
+// interface method, so that callers need not spell method names:
+//
+//	//go:generate velocity-rpcgen -type Calculator -service calc
+//
+// A method's error reaches the caller as a *velocity.Error: errors that are
+// (or wrap) a *velocity.Error keep their status and message, and any other
+// error becomes "internal_error", as for ordinary handlers.
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/usenwep/velocity"
+)
+
+// PathPrefix is the path prefix of RPC routes. Method M of service S is
+// served at PathPrefix + S + "/" + M.
+const PathPrefix = "/rpc/"
+
+// Path returns the route path of method on service.
+func Path(service, method string) string {
+	return PathPrefix + service + "/" + method
+}
+
+var (
+	contextType = reflect.TypeFor[context.Context]()
+	errorType   = reflect.TypeFor[error]()
+)
+
+// Register serves the methods of impl as the RPC service named service on
+// srv. If I is an interface type, only the methods of I are served;
+// otherwise every exported method of impl with an RPC signature is. Methods
+// of I without an RPC signature make Register fail, while other methods of a
+// concrete type are skipped. Routes are registered with mw as route
+// middleware.
+//
+// Call it with an explicit interface type to serve exactly that interface:
+//
+//	rpc.Register[Calculator](srv, "calc", impl)
+//
+// This function returns an error if service is empty or contains "/", or no
+// method can be served.
+func Register[I any](srv *velocity.Server, service string, impl I, mw ...velocity.MiddlewareFunc) error {
+	if service == "" || strings.Contains(service, "/") {
+		return fmt.Errorf("rpc: invalid service name %q", service)
+	}
+	iface := reflect.TypeFor[I]()
+	rv := reflect.ValueOf(impl)
+	if !rv.IsValid() {
+		return fmt.Errorf("rpc: service %s: nil implementation", service)
+	}
+
+	var names []string
+	if iface.Kind() == reflect.Interface {
+		for i := range iface.NumMethod() {
+			m := iface.Method(i)
+			if !isRPCMethod(m.Type, false) {
+				return fmt.Errorf("rpc: service %s: method %s does not have an RPC signature", service, m.Name)
+			}
+			names = append(names, m.Name)
+		}
+	} else {
+		for i := range rv.Type().NumMethod() {
+			m := rv.Type().Method(i)
+			if isRPCMethod(m.Type, true) {
+				names = append(names, m.Name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("rpc: service %s: no methods with an RPC signature", service)
+	}
+	for _, name := range names {
+		srv.Router().Write(Path(service, name), methodHandler(rv.MethodByName(name)), mw...)
+	}
+	return nil
+}
+
+// isRPCMethod reports whether t, a method type, has the form
+// func(context.Context, T) (R, error) or func(context.Context, T) error.
+// hasRecv says whether t includes the receiver as its first parameter.
+func isRPCMethod(t reflect.Type, hasRecv bool) bool {
+	in := 0
+	if hasRecv {
+		in = 1
+	}
+	if t.NumIn() != in+2 || t.In(in) != contextType {
+		return false
+	}
+	switch t.NumOut() {
+	case 1:
+		return t.Out(0) == errorType
+	case 2:
+		return t.Out(1) == errorType
+	}
+	return false
+}
+
+// methodHandler returns the handler that serves the bound method m.
+func methodHandler(m reflect.Value) velocity.HandlerFunc {
+	mt := m.Type()
+	reqType := mt.In(1)
+	return func(c *velocity.Context) error {
+		req := reflect.New(reqType)
+		if err := c.Bind(req.Interface()); err != nil {
+			return velocity.ErrBadRequestf("rpc: decode request: %v", err)
+		}
+		ctx := context.WithValue(c.Ctx(), contextKey{}, c)
+		out := m.Call([]reflect.Value{reflect.ValueOf(ctx), req.Elem()})
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return err
+		}
+		if len(out) == 1 {
+			return c.NoContent()
+		}
+		return c.Render(velocity.StatusOK, out[0].Interface())
+	}
+}
+
+type contextKey struct{}
+
+// RequestContext returns the velocity Context of the request an RPC method
+// is serving, for access to the calling peer and request headers. ctx must be
+// the context passed to the method, or derived from it.
+func RequestContext(ctx context.Context) (*velocity.Context, bool) {
+	c, ok := ctx.Value(contextKey{}).(*velocity.Context)
+	return c, ok
+}
@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/usenwep/velocity"
+)
+
+type addRequest struct{ A, B int }
+
+type calculator interface {
+	Add(ctx context.Context, req addRequest) (int, error)
+	Reset(ctx context.Context, req struct{}) error
+}
+
+type calc struct{}
+
+func (calc) Add(ctx context.Context, req addRequest) (int, error) {
+	if _, ok := RequestContext(ctx); !ok {
+		return 0, errors.New("no request context")
+	}
+	if req.A < 0 {
+		return 0, velocity.ErrBadRequest("negative")
+	}
+	return req.A + req.B, nil
+}
+
+func (calc) Reset(context.Context, struct{}) error { return nil }
+
+func (calc) Helper() {}
+
+func TestRegister(t *testing.T) {
+	srv, err := velocity.New(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Register[calculator](srv, "calc", calc{}); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Router().HasRoute(Path("calc", "Helper")) {
+		t.Error("non-RPC method registered")
+	}
+
+	call := func(method string, req any) *velocity.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		h := srv.Router().Find(Path("calc", method), velocity.MethodWrite, nil)
+		if h == nil {
+			t.Fatalf("no route for %s", method)
+		}
+		c, rec := velocity.NewTestContext(velocity.MethodWrite, Path("calc", method), body)
+		if err := h(c); err != nil {
+			velocity.DefaultErrorHandler(c, err)
+		}
+		return rec
+	}
+	if rec := call("Add", addRequest{A: 2, B: 3}); rec.Status != velocity.StatusOK || string(rec.Body) != "5" {
+		t.Errorf("Add = %s %q", rec.Status, rec.Body)
+	}
+	if rec := call("Add", addRequest{A: -1}); rec.Status != velocity.StatusBadRequest {
+		t.Errorf("Add error = %s %q", rec.Status, rec.Body)
+	}
+	if rec := call("Reset", struct{}{}); rec.Status != velocity.StatusNoContent {
+		t.Errorf("Reset = %s", rec.Status)
+	}
+
+	if err := Register(srv, "bad/name", calc{}); err == nil {
+		t.Error("Register accepted a service name with a slash")
+	}
+	if err := Register(srv, "none", struct{}{}); err == nil {
+		t.Error("Register accepted a type without RPC methods")
+	}
+}
@@ -0,0 +1,131 @@
+package velocity
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// peerQueue is the per-peer state of a peerSerializer. active is true while
+// one of the peer's requests holds the slot; waiters are the channels of the
+// requests queued behind it, in arrival order.
+type peerQueue struct {
+	active  bool
+	waiters []chan struct{}
+}
+
+// peerSerializer admits one request per peer at a time, handing the slot to
+// waiting requests in the order they arrived. A peer's entry exists only while
+// it has a request running or queued, so state for a disconnected peer is gone
+// as soon as its last request finishes.
+type peerSerializer struct {
+	maxQueue int
+
+	mu     sync.Mutex
+	queues map[nwep.NodeID]*peerQueue
+}
+
+func newPeerSerializer(maxQueue int) *peerSerializer {
+	return &peerSerializer{
+		maxQueue: maxQueue,
+		queues:   make(map[nwep.NodeID]*peerQueue),
+	}
+}
+
+// acquire waits for peer's slot. It returns false without waiting if
+// maxQueue requests are already queued for the peer, and false if ctx is
+// done before the slot is handed over. A true result must be paired with a
+// call to release.
+func (ps *peerSerializer) acquire(ctx context.Context, peer nwep.NodeID) bool {
+	ps.mu.Lock()
+	q, ok := ps.queues[peer]
+	if !ok {
+		q = &peerQueue{}
+		ps.queues[peer] = q
+	}
+	if !q.active {
+		q.active = true
+		ps.mu.Unlock()
+		return true
+	}
+	if len(q.waiters) >= ps.maxQueue {
+		ps.mu.Unlock()
+		return false
+	}
+	ready := make(chan struct{})
+	q.waiters = append(q.waiters, ready)
+	ps.mu.Unlock()
+
+	select {
+	case <-ready:
+		return true
+	case <-ctx.Done():
+	}
+
+	ps.mu.Lock()
+	if i := slices.Index(q.waiters, ready); i >= 0 {
+		q.waiters = slices.Delete(q.waiters, i, i+1)
+		ps.mu.Unlock()
+		return false
+	}
+	ps.mu.Unlock()
+	// The slot was handed over while ctx was being cancelled; pass it on.
+	ps.release(peer)
+	return false
+}
+
+// release hands peer's slot to the next queued request, or frees it.
+func (ps *peerSerializer) release(peer nwep.NodeID) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	q := ps.queues[peer]
+	if len(q.waiters) > 0 {
+		next := q.waiters[0]
+		q.waiters = slices.Delete(q.waiters, 0, 1)
+		close(next)
+		return
+	}
+	delete(ps.queues, peer)
+}
+
+// SerializePerPeer returns middleware that runs a peer's requests one at a
+// time, in the order they arrive, even when the peer sends them on concurrent
+// streams. It is meant for stateful resources, such as state machines driven
+// over velocity, where a client's requests must not interleave. Requests from
+// different peers still run concurrently.
+//
+// At most maxQueue requests per peer wait behind the running one; further
+// requests are rejected immediately with status "rate_limited". A request
+// whose Context.Ctx is cancelled while waiting, for example by a timeout,
+// leaves the queue and is answered with status "unavailable". A maxQueue of
+// zero or less rejects any request that would have to wait.
+//
+// Requests from unauthenticated peers, which all share the zero node ID, are
+// not serialized. Per-peer state is held only while the peer has requests
+// running or queued, so nothing is retained once a disconnected peer's last
+// request finishes.
+//
+// Place SerializePerPeer on the routes that need it rather than globally: it
+// serializes every request that passes through it, so a global instance
+// limits each peer to one request at a time server-wide.
+func SerializePerPeer(maxQueue int) MiddlewareFunc {
+	ps := newPeerSerializer(max(maxQueue, 0))
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			peer := c.PeerNodeID()
+			if peer.IsZero() {
+				return next(c)
+			}
+			if !ps.acquire(c.Ctx(), peer) {
+				if c.Ctx().Err() != nil {
+					return c.Error(StatusUnavailable, "request timed out")
+				}
+				return c.Error(StatusRateLimited, "too many queued requests")
+			}
+			defer ps.release(peer)
+			return next(c)
+		}
+	}
+}
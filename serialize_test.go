@@ -0,0 +1,76 @@
+package velocity
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestPeerSerializerOrder(t *testing.T) {
+	ps := newPeerSerializer(2)
+	var peer nwep.NodeID
+	peer[0] = 1
+	ctx := context.Background()
+
+	if !ps.acquire(ctx, peer) {
+		t.Fatal("first acquire should succeed")
+	}
+	order := make(chan int, 2)
+	for i := 1; i <= 2; i++ {
+		go func() {
+			if ps.acquire(ctx, peer) {
+				order <- i
+				ps.release(peer)
+			}
+		}()
+		waitQueued(t, ps, peer, i)
+	}
+	if ps.acquire(ctx, peer) {
+		t.Fatal("acquire should fail with a full queue")
+	}
+
+	ps.release(peer)
+	for want := 1; want <= 2; want++ {
+		if got := <-order; got != want {
+			t.Fatalf("ran request %d, want %d", got, want)
+		}
+	}
+	waitQueued(t, ps, peer, -1)
+}
+
+func TestPeerSerializerCancel(t *testing.T) {
+	ps := newPeerSerializer(1)
+	var peer nwep.NodeID
+	peer[0] = 1
+
+	ps.acquire(context.Background(), peer)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if ps.acquire(ctx, peer) {
+		t.Fatal("acquire should fail once ctx is done")
+	}
+	ps.release(peer)
+	waitQueued(t, ps, peer, -1)
+}
+
+// waitQueued waits until peer has n queued requests, or has no state at all
+// if n is -1.
+func waitQueued(t *testing.T, ps *peerSerializer, peer nwep.NodeID, n int) {
+	t.Helper()
+	for range 1000 {
+		ps.mu.Lock()
+		q, ok := ps.queues[peer]
+		got := -1
+		if ok {
+			got = len(q.waiters)
+		}
+		ps.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("peer never reached %d queued requests", n)
+}
@@ -0,0 +1,239 @@
+package velocity
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// Session is state kept for one peer across requests, unlike the per-request
+// store of Context.Set. Get one with Context.Session. All methods are safe for
+// concurrent use, since a peer's requests may run at the same time.
+type Session struct {
+	peer nwep.NodeID
+
+	mu     sync.RWMutex
+	values map[string]any
+}
+
+func newSession(peer nwep.NodeID, values map[string]any) *Session {
+	if values == nil {
+		values = make(map[string]any)
+	}
+	return &Session{peer: peer, values: values}
+}
+
+// Peer returns the node ID of the session's peer, which is zero for the
+// connection-scoped session of an unauthenticated peer.
+func (s *Session) Peer() nwep.NodeID { return s.peer }
+
+// Get returns the value stored under key and whether it was present.
+func (s *Session) Get(key string) (any, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// Set stores val under key, replacing any previous value.
+func (s *Session) Set(key string, val any) {
+	s.mu.Lock()
+	s.values[key] = val
+	s.mu.Unlock()
+}
+
+// Delete removes key. It is a no-op if key is not present.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	delete(s.values, key)
+	s.mu.Unlock()
+}
+
+// Clear removes every key.
+func (s *Session) Clear() {
+	s.mu.Lock()
+	clear(s.values)
+	s.mu.Unlock()
+}
+
+// Keys returns the session's keys, sorted.
+func (s *Session) Keys() []string {
+	s.mu.RLock()
+	keys := slices.Collect(maps.Keys(s.values))
+	s.mu.RUnlock()
+	slices.Sort(keys)
+	return keys
+}
+
+// Values returns a copy of the session's contents.
+func (s *Session) Values() map[string]any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.values)
+}
+
+// SessionStore persists sessions while their peer is disconnected, so that a
+// reconnecting peer gets its session back. Implementations must be safe for
+// concurrent use, and must be able to store the values the application puts
+// in sessions; a store that serializes them may restrict their types.
+type SessionStore interface {
+	// Load returns the values saved for peer, or nil if there are none.
+	Load(peer nwep.NodeID) (map[string]any, error)
+
+	// Save stores values for peer, replacing what was saved before.
+	Save(peer nwep.NodeID, values map[string]any) error
+}
+
+// MemorySessionStore is a SessionStore that keeps sessions in memory, so that
+// they survive reconnects but not restarts. The zero value is an empty store
+// ready to use. Saved sessions are never discarded.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[nwep.NodeID]map[string]any
+}
+
+// Load implements SessionStore.
+func (m *MemorySessionStore) Load(peer nwep.NodeID) (map[string]any, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Clone(m.sessions[peer]), nil
+}
+
+// Save implements SessionStore.
+func (m *MemorySessionStore) Save(peer nwep.NodeID, values map[string]any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions == nil {
+		m.sessions = make(map[nwep.NodeID]map[string]any)
+	}
+	m.sessions[peer] = values
+	return nil
+}
+
+// WithSessionStore persists peer sessions in store: a session is saved when
+// its peer disconnects and when the server shuts down, and loaded again the
+// first time Context.Session is called after the peer reconnects. Without a
+// store, a session lasts only while its peer is connected. Load and save
+// errors are logged, and a session that fails to load starts empty. This
+// function returns an error if store is nil.
+func WithSessionStore(store SessionStore) Option {
+	return func(s *Server) error {
+		if store == nil {
+			return fmt.Errorf("velocity: session store must not be nil")
+		}
+		s.sessionStore = store
+		return nil
+	}
+}
+
+// Session returns the session of the peer that sent the request. The session
+// is shared by all of the peer's requests, including concurrent ones, and
+// lasts until the peer disconnects; with WithSessionStore it is saved then and
+// restored when the peer reconnects:
+//
+//	sess := c.Session()
+//	if v, ok := sess.Get("cart"); ok {
+//	    cart = v.(*Cart)
+//	}
+//
+// Sessions are keyed by node ID. Unauthenticated peers, which all have the
+// zero node ID, get a session scoped to their connection that is never
+// persisted. A Context that is not associated with a connection, such as one
+// from NewTestContext, gets a fresh session for the request.
+func (c *Context) Session() *Session {
+	peer := c.PeerNodeID()
+	if !peer.IsZero() && c.server != nil {
+		return c.server.sessions.get(c.server, peer)
+	}
+	conn := c.Conn()
+	if conn == nil || c.server == nil {
+		return newSession(nwep.NodeID{}, nil)
+	}
+	sess, _ := c.server.conns.loadOrStore(conn, sessionConnKey{}, newSession(nwep.NodeID{}, nil))
+	return sess.(*Session)
+}
+
+// Session returns the session of peer, if the peer has one: that is, if a
+// request from it has called Context.Session since it connected.
+func (s *Server) Session(peer nwep.NodeID) (*Session, bool) {
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	sess, ok := s.sessions.m[peer]
+	return sess, ok
+}
+
+// sessionConnKey is the connStore key of an unauthenticated peer's session.
+type sessionConnKey struct{}
+
+// sessionSet holds the sessions of connected peers.
+type sessionSet struct {
+	mu sync.Mutex
+	m  map[nwep.NodeID]*Session
+}
+
+// get returns peer's session, creating it, and loading it from the server's
+// session store, if there is none yet.
+func (ss *sessionSet) get(s *Server, peer nwep.NodeID) *Session {
+	ss.mu.Lock()
+	sess, ok := ss.m[peer]
+	ss.mu.Unlock()
+	if ok {
+		return sess
+	}
+
+	var values map[string]any
+	if s.sessionStore != nil {
+		var err error
+		if values, err = s.sessionStore.Load(peer); err != nil {
+			s.logger.Warn("session load failed", "peer", FormatNodeID(peer), "error", err.Error())
+			values = nil
+		}
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if sess, ok := ss.m[peer]; ok {
+		return sess // another request created it while this one loaded
+	}
+	if ss.m == nil {
+		ss.m = make(map[nwep.NodeID]*Session)
+	}
+	sess = newSession(peer, values)
+	ss.m[peer] = sess
+	return sess
+}
+
+// release removes peer's session, saving it to the server's session store if
+// there is one.
+func (ss *sessionSet) release(s *Server, peer nwep.NodeID) {
+	ss.mu.Lock()
+	sess, ok := ss.m[peer]
+	delete(ss.m, peer)
+	ss.mu.Unlock()
+	if ok {
+		s.saveSession(sess)
+	}
+}
+
+// releaseAll removes and saves every session.
+func (ss *sessionSet) releaseAll(s *Server) {
+	ss.mu.Lock()
+	all := ss.m
+	ss.m = nil
+	ss.mu.Unlock()
+	for _, sess := range all {
+		s.saveSession(sess)
+	}
+}
+
+func (s *Server) saveSession(sess *Session) {
+	if s.sessionStore == nil {
+		return
+	}
+	if err := s.sessionStore.Save(sess.peer, sess.Values()); err != nil {
+		s.logger.Warn("session save failed", "peer", FormatNodeID(sess.peer), "error", err.Error())
+	}
+}
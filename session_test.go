@@ -0,0 +1,49 @@
+package velocity
+
+import (
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestSessions(t *testing.T) {
+	peer := nwep.NodeID{5}
+
+	s := &Server{}
+	sess := s.sessions.get(s, peer)
+	sess.Set("user", "ann")
+	if again := s.sessions.get(s, peer); again != sess {
+		t.Fatal("second get returned a different session")
+	}
+	s.sessions.release(s, peer)
+	if _, ok := s.sessions.get(s, peer).Get("user"); ok {
+		t.Fatal("session survived disconnect without a store")
+	}
+
+	store := &MemorySessionStore{}
+	s = &Server{sessionStore: store}
+	s.sessions.get(s, peer).Set("user", "ann")
+	s.sessions.release(s, peer)
+	if _, ok := s.Session(peer); ok {
+		t.Fatal("released session still registered")
+	}
+	restored := s.sessions.get(s, peer)
+	if v, _ := restored.Get("user"); v != "ann" {
+		t.Fatalf("restored session: user = %v", v)
+	}
+	restored.Delete("user")
+	restored.Set("b", 1)
+	restored.Set("a", 2)
+	if keys := restored.Keys(); len(keys) != 2 || keys[0] != "a" {
+		t.Fatalf("keys = %v", keys)
+	}
+	s.sessions.releaseAll(s)
+	if vals, _ := store.Load(peer); len(vals) != 2 {
+		t.Fatalf("saved at shutdown: %v", vals)
+	}
+
+	c, _ := NewTestContext(MethodRead, "/", nil)
+	if c.Session() == nil {
+		t.Fatal("nil session for a test context")
+	}
+}
@@ -0,0 +1,216 @@
+package velocity
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Priority is the class of a request for load shedding. When requests have
+// to wait for an in-flight slot, higher priorities are admitted first, and a
+// full queue makes room for a request by shedding a lower-priority one. See
+// WithPriority.
+type Priority int
+
+// Priority classes. Any int is a valid Priority; these are the ones
+// PriorityFromHeader understands.
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// admission limits how many requests are handled at once. Requests beyond the
+// limit wait in a queue ordered by priority, first come first served within a
+// priority, for at most timeout.
+type admission struct {
+	max      int
+	depth    int
+	timeout  time.Duration
+	priority func(*Context) Priority
+
+	mu      sync.Mutex
+	running int
+	waiters []*admitWaiter // by descending priority, then arrival
+}
+
+// admitWaiter is a queued request. ready receives true when the request is
+// handed a slot and false when it is shed to make room for a more important
+// one.
+type admitWaiter struct {
+	prio  Priority
+	ready chan bool
+}
+
+// admission returns the server's admission state, creating it for the
+// options that configure it.
+func (s *Server) admission() *admission {
+	if s.admit == nil {
+		s.admit = &admission{}
+	}
+	return s.admit
+}
+
+// WithMaxInFlight bounds how many requests are handled at once to n. Requests
+// beyond it are queued as configured with WithQueue, or, without a queue,
+// rejected at once with status "rate_limited", so that a burst is shed at the
+// door instead of slowing down every request being served. PoolStats reports
+// the queue and the number of requests turned away. This function returns an
+// error if n is less than 1.
+func WithMaxInFlight(n int) Option {
+	return func(s *Server) error {
+		if n < 1 {
+			return fmt.Errorf("velocity: max in-flight requests must be at least 1, got %d", n)
+		}
+		s.admission().max = n
+		return nil
+	}
+}
+
+// WithQueue lets up to depth requests wait for an in-flight slot when
+// WithMaxInFlight's limit is reached, each for at most timeout. A request
+// arriving at a full queue is rejected with status "rate_limited", and one
+// whose wait times out with status "unavailable". It requires
+// WithMaxInFlight; Start returns an error otherwise. This function returns an
+// error if depth is negative or timeout is not positive.
+func WithQueue(depth int, timeout time.Duration) Option {
+	return func(s *Server) error {
+		if depth < 0 {
+			return fmt.Errorf("velocity: queue depth must not be negative, got %d", depth)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("velocity: queue timeout must be positive, got %s", timeout)
+		}
+		a := s.admission()
+		a.depth, a.timeout = depth, timeout
+		return nil
+	}
+}
+
+// WithPriority classifies requests for load shedding with fn, which is
+// called before the handler runs, with the matched route available from
+// Context.RoutePattern. Queued requests are admitted in priority order, and a
+// request arriving at a full queue sheds the most recently queued request of
+// the lowest priority, if that is lower than its own, which is then answered
+// with status "unavailable":
+//
+//	velocity.WithPriority(func(c *velocity.Context) velocity.Priority {
+//	    if strings.HasPrefix(c.RoutePattern(), "/admin/") {
+//	        return velocity.PriorityHigh
+//	    }
+//	    return velocity.PriorityFromHeader("priority")(c)
+//	})
+//
+// Without WithPriority every request is PriorityNormal. It requires
+// WithMaxInFlight; Start returns an error otherwise. This function returns an
+// error if fn is nil.
+func WithPriority(fn func(c *Context) Priority) Option {
+	return func(s *Server) error {
+		if fn == nil {
+			return errors.New("velocity: priority function must not be nil")
+		}
+		s.admission().priority = fn
+		return nil
+	}
+}
+
+// PriorityFromHeader returns a priority function for WithPriority that reads
+// the request header name: "low", "normal", or "high". A missing or
+// unrecognized value is PriorityNormal. Since clients choose their headers,
+// combine it with authorization where a high priority must be earned.
+func PriorityFromHeader(name string) func(c *Context) Priority {
+	return func(c *Context) Priority {
+		v, _ := c.Header(name)
+		switch v {
+		case "low":
+			return PriorityLow
+		case "high":
+			return PriorityHigh
+		}
+		return PriorityNormal
+	}
+}
+
+// checkAdmission returns an error if load shedding options were given
+// without WithMaxInFlight.
+func (s *Server) checkAdmission() error {
+	if s.admit != nil && s.admit.max == 0 {
+		return errors.New("velocity: WithQueue and WithPriority require WithMaxInFlight")
+	}
+	return nil
+}
+
+// admitRequest waits for an in-flight slot for c's request. It returns ""
+// once the request holds a slot, which must be given back with release, and
+// otherwise the status to reject it with.
+func (s *Server) admitRequest(c *Context) string {
+	a := s.admit
+	prio := PriorityNormal
+	if a.priority != nil {
+		prio = a.priority(c)
+	}
+
+	a.mu.Lock()
+	if a.running < a.max {
+		a.running++
+		a.mu.Unlock()
+		return ""
+	}
+	if len(a.waiters) >= a.depth {
+		last := len(a.waiters) - 1
+		if last < 0 || a.waiters[last].prio >= prio {
+			a.mu.Unlock()
+			s.pool.rejected.Add(1)
+			return StatusRateLimited
+		}
+		a.waiters[last].ready <- false
+		a.waiters = a.waiters[:last]
+	}
+	w := &admitWaiter{prio: prio, ready: make(chan bool, 1)}
+	i := slices.IndexFunc(a.waiters, func(o *admitWaiter) bool { return o.prio < prio })
+	if i < 0 {
+		i = len(a.waiters)
+	}
+	a.waiters = slices.Insert(a.waiters, i, w)
+	a.mu.Unlock()
+
+	s.pool.queued.Add(1)
+	defer s.pool.queued.Add(-1)
+	t := time.NewTimer(a.timeout)
+	defer t.Stop()
+	select {
+	case ok := <-w.ready:
+		if ok {
+			return ""
+		}
+	case <-t.C:
+		a.mu.Lock()
+		if i := slices.Index(a.waiters, w); i >= 0 {
+			a.waiters = slices.Delete(a.waiters, i, i+1)
+			a.mu.Unlock()
+		} else {
+			a.mu.Unlock()
+			// The request was handed a slot or shed as the timer
+			// fired; a slot is passed on.
+			if <-w.ready {
+				a.release()
+			}
+		}
+	}
+	s.pool.rejected.Add(1)
+	return StatusUnavailable
+}
+
+// release hands the caller's slot to the first queued request, or frees it.
+func (a *admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.waiters) > 0 {
+		a.waiters[0].ready <- true
+		a.waiters = slices.Delete(a.waiters, 0, 1)
+		return
+	}
+	a.running--
+}
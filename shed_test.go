@@ -0,0 +1,87 @@
+package velocity
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestLoadShedding(t *testing.T) {
+	s := &Server{logger: DefaultLogger(), router: NewRouter(), peers: newPeerTracker()}
+	for _, opt := range []Option{
+		WithMaxInFlight(1),
+		WithQueue(1, 5*time.Second),
+		WithPriority(func(c *Context) Priority {
+			if c.RoutePattern() == "/high" {
+				return PriorityHigh
+			}
+			return PriorityNormal
+		}),
+	} {
+		if err := opt(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	started, unblock := make(chan struct{}), make(chan struct{})
+	s.Handle("/slow", func(c *Context) error {
+		close(started)
+		<-unblock
+		return c.NoContent()
+	})
+	s.Handle("/low", func(c *Context) error { return c.NoContent() })
+	s.Handle("/high", func(c *Context) error { return c.NoContent() })
+	serve := func(path string) *ResponseRecorder {
+		rec := NewRecorder()
+		s.ServeWEB(rec, &nwep.Request{Method: MethodRead, Path: path})
+		return rec
+	}
+	waitQueued := func(n int64) {
+		for deadline := time.Now().Add(5 * time.Second); s.PoolStats().Queued != n; {
+			if time.Now().After(deadline) {
+				t.Fatalf("queued = %d, want %d", s.PoolStats().Queued, n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var slow, high *ResponseRecorder
+	shed := make(chan *ResponseRecorder, 1)
+	wg.Go(func() { slow = serve("/slow") })
+	<-started
+	wg.Go(func() { shed <- serve("/low") })
+	waitQueued(1)
+	if rec := serve("/low"); rec.Status != StatusRateLimited {
+		t.Fatalf("request beyond a full queue: %q", rec.Status)
+	}
+	wg.Go(func() { high = serve("/high") })
+	waitQueued(1)
+	if rec := <-shed; rec.Status != StatusUnavailable {
+		t.Fatalf("shed request: %q", rec.Status)
+	}
+	close(unblock)
+	wg.Wait()
+	if slow.Status != StatusNoContent || high.Status != StatusNoContent {
+		t.Fatalf("statuses: slow %q, high %q", slow.Status, high.Status)
+	}
+	if st := s.PoolStats(); st.Rejected != 2 || st.Queued != 0 || s.admit.running != 0 {
+		t.Fatalf("stats = %+v, running = %d", st, s.admit.running)
+	}
+}
+
+func TestLoadSheddingOptions(t *testing.T) {
+	s := &Server{}
+	if err := WithQueue(10, time.Second)(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.checkAdmission() == nil {
+		t.Error("queue without WithMaxInFlight accepted")
+	}
+	for _, opt := range []Option{WithMaxInFlight(0), WithQueue(-1, time.Second), WithQueue(1, 0), WithPriority(nil)} {
+		if opt(&Server{}) == nil {
+			t.Error("invalid option accepted")
+		}
+	}
+}
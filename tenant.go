@@ -0,0 +1,164 @@
+package velocity
+
+import (
+	"errors"
+	"fmt"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// TenantTag is the connection tag (see Server.TagConn) under which the tenant
+// of an authenticated peer's latest request is recorded, for NotifyTenant.
+const TenantTag = "tenant"
+
+// TenantResolver returns the tenant a request belongs to, or "" if it belongs
+// to none. An error rejects the request: an *Error, such as one from
+// ErrUnauthorizedf, is answered with its status, like any handler error.
+type TenantResolver func(c *Context) (string, error)
+
+// WithTenantResolver makes the server multi-tenant: fn resolves the tenant of
+// every request before any middleware runs, so that handlers and middleware
+// read it with Context.Tenant, rate limits can be kept per tenant with
+// KeyByTenant, Metrics labels its series with it, and the peer's connection is
+// tagged with it for NotifyTenant.
+//
+//	srv, _ := velocity.New(":6937",
+//	    velocity.WithTenantResolver(velocity.TenantFromHeader("x-tenant")),
+//	)
+//
+// The resolver runs after routing, so path parameters are available, and not
+// for requests that match no route. This function returns an error if fn is
+// nil.
+func WithTenantResolver(fn TenantResolver) Option {
+	return func(s *Server) error {
+		if fn == nil {
+			return errors.New("velocity: tenant resolver must not be nil")
+		}
+		s.tenants = fn
+		return nil
+	}
+}
+
+// resolveTenant wraps h, the handler chain of a matched route, so that the
+// request's tenant is resolved before it runs.
+func (s *Server) resolveTenant(h HandlerFunc) HandlerFunc {
+	return func(c *Context) error {
+		tenant, err := s.tenants(c)
+		if err != nil {
+			return err
+		}
+		c.tenant = tenant
+		if tenant != "" {
+			if peer := c.PeerNodeID(); !peer.IsZero() {
+				s.peers.tag(peer, TenantTag, tenant)
+			}
+		}
+		return h(c)
+	}
+}
+
+// Tenant returns the tenant the request belongs to, as resolved by the
+// server's TenantResolver, or "" if it has none or the server is not
+// multi-tenant (see WithTenantResolver).
+func (c *Context) Tenant() string { return c.tenant }
+
+// TenantFromHeader returns a TenantResolver that takes the tenant from the
+// request header name. Requests without the header have no tenant. Since
+// clients choose their headers, use it only where peers are trusted to name
+// their tenant, or check the result against the peer's identity.
+func TenantFromHeader(name string) TenantResolver {
+	return func(c *Context) (string, error) {
+		v, _ := c.Header(name)
+		return v, nil
+	}
+}
+
+// TenantFromPeer returns a TenantResolver that looks the tenant up by the
+// peer's verified node ID, with lookup reporting false for peers that belong
+// to no tenant. Unauthenticated peers have no tenant.
+func TenantFromPeer(lookup func(peer nwep.NodeID) (string, bool)) TenantResolver {
+	return func(c *Context) (string, error) {
+		peer := c.PeerNodeID()
+		if peer.IsZero() {
+			return "", nil
+		}
+		tenant, ok := lookup(peer)
+		c.tenantVerified = ok && tenant != ""
+		return tenant, nil
+	}
+}
+
+// TenantFromParam returns a TenantResolver that takes the tenant from the
+// path parameter name, for servers whose routes are prefixed with it:
+//
+//	velocity.WithTenantResolver(velocity.TenantFromParam("tenant"))
+//	srv.Router().Read("/:tenant/orders", listOrders)
+func TenantFromParam(name string) TenantResolver {
+	return func(c *Context) (string, error) {
+		return c.Param(name), nil
+	}
+}
+
+// RequireTenant returns a TenantResolver that rejects requests for which
+// resolve finds no tenant with status "unauthorized".
+func RequireTenant(resolve TenantResolver) TenantResolver {
+	return func(c *Context) (string, error) {
+		tenant, err := resolve(c)
+		if err == nil && tenant == "" {
+			err = ErrUnauthorizedf("tenant required")
+		}
+		return tenant, err
+	}
+}
+
+// MetricsOtherTenant is the tenant label Metrics gives requests whose tenant
+// it does not label by name (see WithMetricsTenants).
+const MetricsOtherTenant = "other"
+
+// WithMetricsTenants names the tenants Metrics labels its series with. Every
+// series is kept until the server stops, so labelling with whatever tenant a
+// request names would let clients create series without bound; Metrics
+// therefore labels a request with its tenant only if the tenant is one of
+// tenants, or was resolved from the peer's verified identity by
+// TenantFromPeer, whose lookup only knows the application's own tenants.
+// Requests of any other tenant are labelled MetricsOtherTenant. Calling it
+// again adds to the tenants. This function returns an error if a tenant is ""
+// or MetricsOtherTenant.
+func WithMetricsTenants(tenants ...string) Option {
+	return func(s *Server) error {
+		for _, t := range tenants {
+			if t == "" || t == MetricsOtherTenant {
+				return fmt.Errorf("velocity: metrics tenant must not be %q", t)
+			}
+		}
+		if s.metricsTenants == nil {
+			s.metricsTenants = make(map[string]bool, len(tenants))
+		}
+		for _, t := range tenants {
+			s.metricsTenants[t] = true
+		}
+		return nil
+	}
+}
+
+// metricsTenant returns the tenant label of c's metrics: its tenant, if
+// Metrics may label series with it, MetricsOtherTenant if not, or "" if c has
+// no tenant.
+func (c *Context) metricsTenant() string {
+	if c.tenant == "" || c.tenantVerified || c.server.metricsTenants[c.tenant] {
+		return c.tenant
+	}
+	return MetricsOtherTenant
+}
+
+// KeyByTenant limits each tenant separately (see RateLimitKey), so that one
+// customer's traffic cannot use up another's allowance. Requests without a
+// tenant share one bucket.
+func KeyByTenant(c *Context) string { return c.tenant }
+
+// NotifyTenant sends a notification to every connected peer whose latest
+// request was resolved to tenant, as NotifyPeers does, and reports the sends
+// that failed.
+func (s *Server) NotifyTenant(tenant, event, path string, body []byte) map[nwep.NodeID]error {
+	return s.NotifyPeers(s.PeersWithTag(TenantTag, tenant), event, path, body)
+}
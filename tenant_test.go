@@ -0,0 +1,60 @@
+package velocity
+
+import (
+	"strings"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestTenantResolver(t *testing.T) {
+	s := &Server{logger: DefaultLogger(), router: NewRouter(), peers: newPeerTracker(), errorHandler: DefaultErrorHandler}
+	for _, opt := range []Option{
+		WithTenantResolver(RequireTenant(TenantFromParam("tenant"))),
+		WithMetricsTenants("acme"),
+	} {
+		if err := opt(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Use(Metrics())
+	var got, key string
+	s.Handle("/:tenant/orders", func(c *Context) error {
+		got, key = c.Tenant(), KeyByTenant(c)
+		return c.NoContent()
+	})
+	s.Handle("/health", func(c *Context) error { return c.NoContent() })
+
+	rec := NewRecorder()
+	s.ServeWEB(rec, &nwep.Request{Method: MethodRead, Path: "/acme/orders"})
+	if rec.Status != StatusNoContent || got != "acme" || key != "acme" {
+		t.Fatalf("status %q, tenant %q, key %q", rec.Status, got, key)
+	}
+	for _, tenant := range []string{"globex", "initech"} {
+		s.ServeWEB(NewRecorder(), &nwep.Request{Method: MethodRead, Path: "/" + tenant + "/orders"})
+	}
+	rec = NewRecorder()
+	s.ServeWEB(rec, &nwep.Request{Method: MethodRead, Path: "/health"})
+	if rec.Status != StatusUnauthorized {
+		t.Fatalf("request without a tenant: %q", rec.Status)
+	}
+
+	out := string(s.metrics.appendText(nil, nil))
+	for _, want := range []string{
+		`velocity_requests_total{method="read",route="/:tenant/orders",tenant="acme",status="no_content"} 1`,
+		`velocity_requests_total{method="read",route="/:tenant/orders",tenant="other",status="no_content"} 2`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "globex") {
+		t.Errorf("tenant not named with WithMetricsTenants labelled by name:\n%s", out)
+	}
+	if st := s.RouteStats(); len(st) != 2 || st[0].Tenant != "acme" || st[1].Tenant != MetricsOtherTenant {
+		t.Errorf("RouteStats = %+v", st)
+	}
+	if err := WithMetricsTenants(MetricsOtherTenant)(s); err == nil {
+		t.Error("WithMetricsTenants accepted the other label")
+	}
+}
@@ -0,0 +1,179 @@
+package velocity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// Topics tracks which peers are subscribed to which named notification
+// topics, so that Server.Publish can notify only the peers that asked for an
+// event instead of every connected peer.
+//
+// Peers subscribe either through the handler returned by Handler, mounted at
+// a path of the application's choosing, or through Subscribe on the server
+// side. A peer's subscriptions are removed automatically when its connection
+// closes, so a reconnecting peer must subscribe again.
+//
+// The zero value is an empty set ready to use, and all methods are safe for
+// concurrent use. Each Server has its own set, available from Server.Topics.
+// Only authenticated peers can subscribe; the zero node ID is ignored.
+type Topics struct {
+	mu     sync.RWMutex
+	topics map[string]map[nwep.NodeID]struct{}
+	peers  map[nwep.NodeID]map[string]struct{}
+}
+
+// Subscribe adds peer to topic. Subscribing twice has no further effect.
+func (t *Topics) Subscribe(peer nwep.NodeID, topic string) {
+	if peer.IsZero() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.topics == nil {
+		t.topics = make(map[string]map[nwep.NodeID]struct{})
+		t.peers = make(map[nwep.NodeID]map[string]struct{})
+	}
+	if t.topics[topic] == nil {
+		t.topics[topic] = make(map[nwep.NodeID]struct{})
+	}
+	t.topics[topic][peer] = struct{}{}
+	if t.peers[peer] == nil {
+		t.peers[peer] = make(map[string]struct{})
+	}
+	t.peers[peer][topic] = struct{}{}
+}
+
+// Unsubscribe removes peer from topic. It is a no-op if peer is not
+// subscribed.
+func (t *Topics) Unsubscribe(peer nwep.NodeID, topic string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.topics[topic], peer)
+	if len(t.topics[topic]) == 0 {
+		delete(t.topics, topic)
+	}
+	delete(t.peers[peer], topic)
+	if len(t.peers[peer]) == 0 {
+		delete(t.peers, peer)
+	}
+}
+
+// UnsubscribeAll removes every subscription held by peer. The server calls it
+// when the peer's connection closes.
+func (t *Topics) UnsubscribeAll(peer nwep.NodeID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for topic := range t.peers[peer] {
+		delete(t.topics[topic], peer)
+		if len(t.topics[topic]) == 0 {
+			delete(t.topics, topic)
+		}
+	}
+	delete(t.peers, peer)
+}
+
+// Subscribers returns the peers subscribed to topic, in no particular order.
+func (t *Topics) Subscribers(topic string) []nwep.NodeID {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	out := make([]nwep.NodeID, 0, len(t.topics[topic]))
+	for peer := range t.topics[topic] {
+		out = append(out, peer)
+	}
+	return out
+}
+
+// PeerTopics returns the topics peer is subscribed to, sorted.
+func (t *Topics) PeerTopics(peer nwep.NodeID) []string {
+	t.mu.RLock()
+	out := make([]string, 0, len(t.peers[peer]))
+	for topic := range t.peers[peer] {
+		out = append(out, topic)
+	}
+	t.mu.RUnlock()
+	slices.Sort(out)
+	return out
+}
+
+// Handler returns a handler that lets the requesting peer manage its own
+// subscriptions. Mount it at a path of your choosing:
+//
+//	srv.Handle("/subscribe", srv.Topics().Handler())
+//
+// The request body lists topic names, one per line; blank lines and
+// surrounding whitespace are ignored. A write or update request subscribes
+// the peer to the listed topics and a delete request unsubscribes it. Each of
+// these responds with the peer's resulting topics as a JSON array, as does a
+// read request, which changes nothing. Requests from unauthenticated peers
+// get an "unauthorized" response and other methods a "bad_request" one.
+func (t *Topics) Handler() HandlerFunc {
+	return func(c *Context) error {
+		peer := c.PeerNodeID()
+		if peer.IsZero() {
+			return c.Unauthorized("peer identity required")
+		}
+		var apply func(nwep.NodeID, string)
+		switch c.Method() {
+		case MethodRead:
+		case MethodWrite, MethodUpdate:
+			apply = t.Subscribe
+		case MethodDelete:
+			apply = t.Unsubscribe
+		default:
+			return c.BadRequest("unsupported method")
+		}
+		if apply != nil {
+			sc := bufio.NewScanner(bytes.NewReader(c.Body()))
+			for sc.Scan() {
+				if topic := strings.TrimSpace(sc.Text()); topic != "" {
+					apply(peer, topic)
+				}
+			}
+		}
+		return c.JSON(t.PeerTopics(peer))
+	}
+}
+
+// Topics returns the server's topic subscriptions. See Topics and Publish.
+func (s *Server) Topics() *Topics { return &s.topics }
+
+// Publish sends a notification to every connected peer subscribed to topic
+// and returns the number of subscribers it was addressed to. It otherwise
+// behaves like NotifyAll: the notification transform and the per-peer rate
+// limit apply, delivery errors are logged rather than returned, and Publish
+// never blocks. If the server has not been started, Publish sends nothing and
+// returns 0.
+func (s *Server) Publish(topic, event, path string, body []byte) int {
+	if s.nwep == nil {
+		return 0
+	}
+	peers := s.topics.Subscribers(topic)
+	if len(peers) == 0 {
+		return 0
+	}
+	size := len(body)
+	body, ok := s.transformNotify(event, path, body)
+	if !ok {
+		s.observeNotify(NotifyEvent{Broadcast: true, Event: event, Path: path, Size: size, Outcome: NotifyDropped})
+		return 0
+	}
+	s.fanout(peers, event, path, body, nil)
+	return len(peers)
+}
+
+// PublishJSON marshals v to JSON and publishes the result to topic. It
+// returns a non-nil error if JSON marshaling fails.
+func (s *Server) PublishJSON(topic, event, path string, v any) (int, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	return s.Publish(topic, event, path, data), nil
+}
@@ -0,0 +1,35 @@
+package velocity
+
+import (
+	"slices"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestTopics(t *testing.T) {
+	var tp Topics
+	a, b := nwep.NodeID{1}, nwep.NodeID{2}
+
+	tp.Subscribe(a, "prices")
+	tp.Subscribe(a, "news")
+	tp.Subscribe(b, "prices")
+	tp.Subscribe(nwep.NodeID{}, "prices")
+
+	if got := tp.Subscribers("prices"); len(got) != 2 {
+		t.Fatalf("prices subscribers = %d, want 2", len(got))
+	}
+	if got := tp.PeerTopics(a); !slices.Equal(got, []string{"news", "prices"}) {
+		t.Fatalf("PeerTopics(a) = %q", got)
+	}
+
+	tp.Unsubscribe(b, "prices")
+	if got := tp.Subscribers("prices"); !slices.Equal(got, []nwep.NodeID{a}) {
+		t.Fatalf("prices subscribers after Unsubscribe = %v", got)
+	}
+
+	tp.UnsubscribeAll(a)
+	if len(tp.topics) != 0 || len(tp.peers) != 0 {
+		t.Fatalf("state left after UnsubscribeAll: %v, %v", tp.topics, tp.peers)
+	}
+}
@@ -0,0 +1,92 @@
+package velocity
+
+import (
+	"slices"
+	"time"
+)
+
+// TrustPolicy describes the verified identities a route accepts. The zero
+// TrustPolicy accepts any verified identity. See RequireTrust.
+type TrustPolicy struct {
+	// MaxAge, if positive, rejects identities verified longer ago than
+	// MaxAge, judged by the identity's VerifiedAt field.
+	MaxAge time.Duration
+
+	// RequiredLogIndex, if positive, rejects identities whose key binding
+	// sits before this index in the log, judged by the identity's LogIndex
+	// field - for example to exclude every binding published before a
+	// known key compromise.
+	RequiredLogIndex uint64
+
+	// AllowedRoles, if not empty, rejects peers whose role advertised in
+	// the WEB/1 handshake is not listed. The check fails closed: a peer
+	// whose handshake settings the server does not have, because the
+	// request did not arrive over a connection the server tracks, is
+	// rejected like one with the wrong role.
+	AllowedRoles []string
+}
+
+// RequireVerified returns middleware that rejects requests from peers without
+// a verified identity with status "unauthorized" and the message "identity
+// not verified". It must run after TrustVerify, which looks the identity up;
+// ValidateMiddleware checks this. It is RequireTrust(TrustPolicy{}).
+func RequireVerified() MiddlewareFunc {
+	return RequireTrust(TrustPolicy{})
+}
+
+// RequireTrust returns middleware that enforces policy on the peer's verified
+// identity, as stored by TrustVerify, which must run first. Requests without
+// a verified identity are rejected with status "unauthorized"; those whose
+// identity or role the policy rejects get status "forbidden" and a message
+// naming the failed check. Apply it to a group to give the group's routes a
+// policy:
+//
+//	admin := srv.Group("/admin", velocity.RequireTrust(velocity.TrustPolicy{
+//	    MaxAge:       time.Hour,
+//	    AllowedRoles: []string{"anchor"},
+//	}))
+//
+// RequireTrust panics if MaxAge is negative.
+func RequireTrust(policy TrustPolicy) MiddlewareFunc {
+	if policy.MaxAge < 0 {
+		panic("velocity: RequireTrust: MaxAge must not be negative")
+	}
+	roles := slices.Clone(policy.AllowedRoles)
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			vi := VerifiedIdentity(c)
+			if vi == nil {
+				return c.Unauthorized("identity not verified")
+			}
+			if policy.MaxAge > 0 {
+				if time.Since(time.Unix(0, int64(vi.VerifiedAt))) > policy.MaxAge {
+					return c.Forbidden("identity verification too old")
+				}
+			}
+			if policy.RequiredLogIndex > 0 {
+				if vi.LogIndex < policy.RequiredLogIndex {
+					return c.Forbidden("identity key binding predates required log index")
+				}
+			}
+			if len(roles) > 0 {
+				if role, ok := peerRole(c); !ok || !slices.Contains(roles, role) {
+					return c.Forbidden("peer role not allowed")
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// peerRole returns the role the peer advertised in the handshake, and false
+// if the peer is not connected.
+func peerRole(c *Context) (string, bool) {
+	if c.server == nil {
+		return "", false
+	}
+	info, ok := c.server.ConnInfo(c.PeerNodeID())
+	if !ok {
+		return "", false
+	}
+	return info.Role, true
+}
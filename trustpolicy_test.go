@@ -0,0 +1,63 @@
+package velocity
+
+import (
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestRequireTrust(t *testing.T) {
+	ok := func(c *Context) error { return c.OK(nil) }
+
+	c, rec := NewTestContext(MethodRead, "/secure", nil)
+	_ = RequireVerified()(ok)(c)
+	if rec.Status != StatusUnauthorized {
+		t.Errorf("unverified peer: status %s", rec.Status)
+	}
+
+	c, rec = NewTestContext(MethodRead, "/secure", nil)
+	c.Set(contextKeyVerifiedIdentity, &nwep.VerifiedIdentity{})
+	_ = RequireVerified()(ok)(c)
+	if rec.Status != StatusOK {
+		t.Errorf("verified peer: status %s", rec.Status)
+	}
+
+	c, rec = NewTestContext(MethodRead, "/secure", nil)
+	c.Set(contextKeyVerifiedIdentity, &nwep.VerifiedIdentity{})
+	_ = RequireTrust(TrustPolicy{AllowedRoles: []string{"anchor"}})(ok)(c)
+	if rec.Status != StatusForbidden {
+		t.Errorf("peer without handshake settings: status %s", rec.Status)
+	}
+
+	now := time.Now()
+	for _, tc := range []struct {
+		vi     nwep.VerifiedIdentity
+		status string
+	}{
+		{nwep.VerifiedIdentity{VerifiedAt: nwep.Tstamp(now.Add(-time.Minute).UnixNano()), LogIndex: 9}, StatusOK},
+		{nwep.VerifiedIdentity{VerifiedAt: nwep.Tstamp(now.Add(-2 * time.Hour).UnixNano()), LogIndex: 9}, StatusForbidden},
+		{nwep.VerifiedIdentity{VerifiedAt: nwep.Tstamp(now.UnixNano()), LogIndex: 4}, StatusForbidden},
+	} {
+		c, rec = NewTestContext(MethodRead, "/secure", nil)
+		c.Set(contextKeyVerifiedIdentity, &tc.vi)
+		_ = RequireTrust(TrustPolicy{MaxAge: time.Hour, RequiredLogIndex: 5})(ok)(c)
+		if rec.Status != tc.status {
+			t.Errorf("identity %+v: status %s, want %s", tc.vi, rec.Status, tc.status)
+		}
+	}
+
+	if name := MiddlewareName(RequireVerified()); name != "velocity.RequireTrust" {
+		t.Errorf("MiddlewareName(RequireVerified()) = %q", name)
+	}
+	if err := MiddlewareRequires("velocity.RequireTrust", "velocity.TrustVerify")([]string{"velocity.RequireTrust"}); err == nil {
+		t.Error("RequireTrust without TrustVerify passed the ordering rule")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("RequireTrust accepted a negative MaxAge")
+		}
+	}()
+	RequireTrust(TrustPolicy{MaxAge: -1})
+}
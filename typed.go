@@ -0,0 +1,34 @@
+package velocity
+
+// TypedHandler is an alternative handler shape that returns its result instead
+// of writing it through the Context. Handlers of this form are easy to test in
+// isolation: call them and inspect the returned value and error.
+//
+// A TypedHandler is not registered directly. Wrap it with Typed to obtain a
+// HandlerFunc:
+//
+//	srv.Router().Read("/users", velocity.Typed(func(c *velocity.Context) ([]User, error) {
+//	    return db.ListUsers()
+//	}))
+type TypedHandler[T any] func(c *Context) (T, error)
+
+// Typed adapts h to a HandlerFunc. If h returns a nil error, the value is sent
+// with Context.JSON. If h returns a non-nil error, no response is sent and the
+// error is returned up the middleware chain, where it is handled like any
+// other handler error - typically by the error handler installed with
+// WithErrorHandler.
+//
+// If h has already sent a response itself (for example with c.NotFound), the
+// returned value is ignored.
+func Typed[T any](h TypedHandler[T]) HandlerFunc {
+	return func(c *Context) error {
+		v, err := h(c)
+		if err != nil {
+			return err
+		}
+		if c.Committed() {
+			return nil
+		}
+		return c.JSON(v)
+	}
+}
@@ -0,0 +1,67 @@
+package velocity
+
+import (
+	"errors"
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestTyped(t *testing.T) {
+	type item struct {
+		ID int `json:"id"`
+	}
+	decode := func(c *Context) (any, error) {
+		var in item
+		if err := c.Bind(&in); err != nil {
+			return nil, err
+		}
+		return in, nil
+	}
+	tests := []struct {
+		name   string
+		h      TypedHandler[any]
+		body   string
+		status string
+		want   string
+	}{
+		{"value", decode, `{"id":7}`, StatusOK, `{"id":7}`},
+		{"nil value", func(c *Context) (any, error) { return nil, nil }, "", StatusOK, "null"},
+		{"malformed body", decode, `{"id":`, StatusInternalError, ""},
+		{"wrong field type", decode, `{"id":"7"}`, StatusInternalError, ""},
+		{"empty body", decode, "", StatusInternalError, ""},
+		{"mapped decode error", func(c *Context) (any, error) {
+			v, err := decode(c)
+			if err != nil {
+				return nil, ErrBadRequest("invalid item")
+			}
+			return v, nil
+		}, `{"id":`, StatusBadRequest, ""},
+		{"unencodable value", func(c *Context) (any, error) { return make(chan int), nil }, "", StatusInternalError, ""},
+		{"handler responded", func(c *Context) (any, error) {
+			return item{ID: 1}, c.NotFound("no such item")
+		}, "", StatusNotFound, ""},
+		{"handler error", func(c *Context) (any, error) {
+			return item{ID: 1}, errors.New("boom")
+		}, "", StatusInternalError, ""},
+	}
+	for _, tt := range tests {
+		s := &Server{logger: DefaultLogger(), router: NewRouter(), peers: newPeerTracker(), errorHandler: DefaultErrorHandler}
+		s.Handle("/items", Typed(tt.h))
+		rec := NewRecorder()
+		s.ServeWEB(rec, &nwep.Request{Method: MethodWrite, Path: "/items", Body: []byte(tt.body)})
+		if rec.Status != tt.status {
+			t.Errorf("%s: status = %q, want %q", tt.name, rec.Status, tt.status)
+			continue
+		}
+		if tt.status != StatusOK {
+			continue
+		}
+		if ct, _ := rec.Header("content-type"); ct != MIMEJSON {
+			t.Errorf("%s: content-type = %q, want %q", tt.name, ct, MIMEJSON)
+		}
+		if string(rec.Body) != tt.want {
+			t.Errorf("%s: body = %q, want %q", tt.name, rec.Body, tt.want)
+		}
+	}
+}
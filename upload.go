@@ -0,0 +1,102 @@
+package velocity
+
+import (
+	"bytes"
+	"io"
+)
+
+// WithStreamingUploads asks nwep to hand request bodies to handlers as they
+// arrive rather than buffering each whole message first, so that large
+// uploads do not hold their full size in memory per in-flight request.
+// Handlers read a streamed body with Context.BodyReader.
+//
+// Body, Bind, and NDJSON keep working: they read the rest of the stream into
+// memory on first use. WithMaxBodySize is enforced while the stream is read
+// instead of before routing.
+func WithStreamingUploads() Option {
+	return func(s *Server) error {
+		s.streamingUploads = true
+		return nil
+	}
+}
+
+// enableStreamingUploads switches the nwep server to streamed request bodies
+// if WithStreamingUploads was given.
+func (s *Server) enableStreamingUploads() {
+	if !s.streamingUploads {
+		return
+	}
+	for _, srv := range s.allListeners() {
+		srv.SetStreamingBodies(true)
+	}
+}
+
+// BodyReader returns a reader over the request body. Under
+// WithStreamingUploads it reads the body incrementally as frames arrive, so a
+// handler can copy a large upload to disk or object storage without holding
+// it in memory:
+//
+//	f, err := os.Create(dst)
+//	if err != nil {
+//	    return err
+//	}
+//	defer f.Close()
+//	if _, err := io.Copy(f, c.BodyReader()); err != nil {
+//	    return err
+//	}
+//
+// A streamed body can be read only once: after BodyReader, Body returns only
+// what was buffered, which is usually nothing. If the body exceeds the limit
+// set with WithMaxBodySize, the reader returns an *Error with status
+// "bad_request". Without streaming, BodyReader reads from the buffered body.
+func (c *Context) BodyReader() io.Reader {
+	if r := c.openBodyStream(); r != nil {
+		return r
+	}
+	return bytes.NewReader(c.Request.Body)
+}
+
+// openBodyStream returns the streamed request body the first time it is
+// called for a request with one, and nil otherwise.
+func (c *Context) openBodyStream() io.Reader {
+	if c.bodyOpened || c.server == nil || !c.server.streamingUploads {
+		return nil
+	}
+	c.bodyOpened = true
+	r := c.Request.BodyReader()
+	if c.server.maxBody > 0 {
+		r = &limitedBody{r: r, remaining: int64(c.server.maxBody)}
+	}
+	return r
+}
+
+// readBody reads a streamed request body into c.Request.Body, so that Body
+// and the helpers built on it see the whole body. A read error is kept in
+// bodyErr.
+func (c *Context) readBody() {
+	if r := c.openBodyStream(); r != nil {
+		c.Request.Body, c.bodyErr = io.ReadAll(r)
+	}
+}
+
+// limitedBody is a streamed request body limited to a maximum size.
+type limitedBody struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	// Read one byte past the limit so that a body of exactly the limit is
+	// not mistaken for a longer one.
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = 0
+		return n, ErrBadRequest("request body too large")
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,43 @@
+package velocity
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLimitedBody(t *testing.T) {
+	for _, tt := range []struct {
+		body    string
+		tooLong bool
+	}{
+		{"", false},
+		{"12345", false},
+		{"123456", true},
+	} {
+		got, err := io.ReadAll(&limitedBody{r: strings.NewReader(tt.body), remaining: 5})
+		var e *Error
+		if tt.tooLong != errors.As(err, &e) {
+			t.Fatalf("%q: err = %v", tt.body, err)
+		}
+		if tt.tooLong && (e.Status != StatusBadRequest || len(got) != 5) {
+			t.Fatalf("%q: status %q after %d bytes", tt.body, e.Status, len(got))
+		}
+		if !tt.tooLong && (err != nil || string(got) != tt.body) {
+			t.Fatalf("%q: got %q, %v", tt.body, got, err)
+		}
+	}
+}
+
+func TestBodyReaderBuffered(t *testing.T) {
+	c, _ := NewTestContext(MethodWrite, "/upload", []byte("payload"))
+	got, err := io.ReadAll(c.BodyReader())
+	if err != nil || !bytes.Equal(got, []byte("payload")) {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if string(c.Body()) != "payload" {
+		t.Fatal("Body changed by BodyReader on a buffered body")
+	}
+}
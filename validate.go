@@ -0,0 +1,244 @@
+package velocity
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FieldError describes one field that failed validation.
+type FieldError struct {
+	// Field is the path of the field, using JSON names: "name", or
+	// "address.city" for a field of a nested struct.
+	Field string `json:"field"`
+
+	// Rule is the validation rule that failed, such as "required" or "min".
+	Rule string `json:"rule"`
+
+	// Param is the rule's parameter, such as "1" for "min=1", if any.
+	Param string `json:"param,omitempty"`
+
+	// Message is a human-readable description of the failure.
+	Message string `json:"message"`
+}
+
+// ValidationError is returned by Validate and Context.BindAndValidate when
+// one or more fields fail validation. It converts to an *Error with status
+// "bad_request", the message "validation failed", and the field errors as
+// details, so that errors.As finds an *Error in it and the error handler
+// sends it as an ErrorPayload JSON body:
+//
+//	{"status":"bad_request","message":"validation failed",
+//	 "details":[{"field":"name","rule":"required","message":"is required"}]}
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Error lists the failed fields.
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	b.WriteString("velocity: validation failed")
+	for i, f := range e.Fields {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString("; ")
+		}
+		b.WriteString(f.Field + " " + f.Message)
+	}
+	return b.String()
+}
+
+// As converts e to an *Error for errors.As.
+func (e *ValidationError) As(target any) bool {
+	t, ok := target.(**Error)
+	if !ok {
+		return false
+	}
+	*t = &Error{Status: StatusBadRequest, Message: "validation failed", Details: e.Fields}
+	return true
+}
+
+// BindAndValidate decodes the JSON request body into v, as Bind does, and then
+// validates it with Validate:
+//
+//	type createUser struct {
+//		Name  string `json:"name" validate:"required,max=64"`
+//		Age   int    `json:"age" validate:"min=13"`
+//		Role  string `json:"role" validate:"omitempty,oneof=admin member"`
+//	}
+//
+//	var req createUser
+//	if err := c.BindAndValidate(&req); err != nil {
+//		return err
+//	}
+//
+// Decoding errors are returned as from Bind. Validation failures are returned
+// as a *ValidationError, which the error handler sends as a "bad_request"
+// response listing the failed fields.
+func (c *Context) BindAndValidate(v any) error {
+	if err := c.Bind(v); err != nil {
+		return err
+	}
+	return Validate(v)
+}
+
+// Validate checks the fields of the struct pointed to by v against the rules
+// in their validate tags and returns a *ValidationError listing every field
+// that fails, or nil. Rules are separated by commas:
+//
+//	required      the field must not be the zero value, or empty
+//	omitempty     skip the remaining rules if the field is the zero value
+//	min=n, max=n  bounds on a number, or on the length of a string, slice, or map
+//	len=n         exact length of a string, slice, or map
+//	oneof=a b c   the field's value must be one of the space-separated values
+//
+// Fields of nested structs, and of non-nil pointers to structs, are validated
+// too. Unexported fields are ignored. Validate returns a plain error, not a
+// *ValidationError, if v is not a non-nil pointer to a struct or a tag names
+// an unknown rule or has a malformed parameter.
+func Validate(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("velocity: validate: expected non-nil pointer to struct, got %T", v)
+	}
+	var ve ValidationError
+	if err := validateStruct(rv.Elem(), "", &ve); err != nil {
+		return err
+	}
+	if len(ve.Fields) > 0 {
+		return &ve
+	}
+	return nil
+}
+
+func validateStruct(rv reflect.Value, prefix string, ve *ValidationError) error {
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		f := rt.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := fieldName(f)
+		if name == "" {
+			continue
+		}
+		path := prefix + name
+		fv := rv.Field(i)
+		if tag, ok := f.Tag.Lookup("validate"); ok && tag != "" && tag != "-" {
+			if err := validateField(fv, path, tag, ve); err != nil {
+				return err
+			}
+		}
+		if fv.Kind() == reflect.Pointer && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct {
+			if err := validateStruct(fv, path+".", ve); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fieldName returns the JSON name of f, or "" if f is not encoded.
+func fieldName(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+	return f.Name
+}
+
+func validateField(fv reflect.Value, path, tag string, ve *ValidationError) error {
+	fail := func(rule, param, msg string) {
+		ve.Fields = append(ve.Fields, FieldError{Field: path, Rule: rule, Param: param, Message: msg})
+	}
+	for rule := range strings.SplitSeq(tag, ",") {
+		rule, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch rule {
+		case "omitempty":
+			if fv.IsZero() {
+				return nil
+			}
+		case "required":
+			if isEmpty(fv) {
+				fail(rule, "", "is required")
+				return nil
+			}
+		case "min", "max", "len":
+			if fv.Kind() == reflect.Pointer && fv.IsNil() {
+				continue // absent; use required to demand a value
+			}
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return fmt.Errorf("velocity: validate %s: bad %s parameter %q", path, rule, param)
+			}
+			got, isLen, ok := measure(fv)
+			if !ok || (rule == "len" && !isLen) {
+				return fmt.Errorf("velocity: validate %s: rule %s does not apply to %s", path, rule, fv.Type())
+			}
+			what := "be"
+			if isLen {
+				what = "have length"
+			}
+			switch {
+			case rule == "min" && got < n:
+				fail(rule, param, fmt.Sprintf("must %s at least %s", what, param))
+			case rule == "max" && got > n:
+				fail(rule, param, fmt.Sprintf("must %s at most %s", what, param))
+			case rule == "len" && got != n:
+				fail(rule, param, fmt.Sprintf("must have length %s", param))
+			}
+		case "oneof":
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if !slices.Contains(strings.Fields(param), fmt.Sprint(fv.Interface())) {
+				fail(rule, param, "must be one of "+strings.Join(strings.Fields(param), ", "))
+			}
+		default:
+			return fmt.Errorf("velocity: validate %s: unknown rule %q", path, rule)
+		}
+	}
+	return nil
+}
+
+// isEmpty reports whether fv fails the required rule.
+func isEmpty(fv reflect.Value) bool {
+	switch fv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return fv.Len() == 0
+	}
+	return fv.IsZero()
+}
+
+// measure returns the value min and max compare: the length of a string,
+// slice, array, or map, or the value of a number. isLen reports which.
+func measure(fv reflect.Value) (v float64, isLen, ok bool) {
+	if fv.Kind() == reflect.Pointer {
+		fv = fv.Elem()
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		return float64(len([]rune(fv.String()))), true, true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(fv.Len()), true, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(fv.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), false, true
+	}
+	return 0, false, false
+}
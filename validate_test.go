@@ -0,0 +1,65 @@
+package velocity
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	type address struct {
+		City string `json:"city" validate:"required"`
+	}
+	type user struct {
+		Name    string   `json:"name" validate:"required,max=5"`
+		Age     int      `json:"age" validate:"min=13"`
+		Role    string   `json:"role" validate:"omitempty,oneof=admin member"`
+		Tags    []string `json:"tags" validate:"max=2"`
+		Address address  `json:"address"`
+	}
+
+	if err := Validate(&user{Name: "ann", Age: 20, Address: address{City: "Oslo"}}); err != nil {
+		t.Fatalf("valid user: %v", err)
+	}
+
+	err := Validate(&user{Name: "annabel", Age: 9, Role: "root", Tags: []string{"a", "b", "c"}})
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("err = %v", err)
+	}
+	var got []string
+	for _, f := range ve.Fields {
+		got = append(got, f.Field+":"+f.Rule)
+	}
+	want := []string{"name:max", "age:min", "role:oneof", "tags:max", "address.city:required"}
+	if len(got) != len(want) {
+		t.Fatalf("fields = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("fields = %v, want %v", got, want)
+		}
+	}
+
+	e, ok := asError(err)
+	if !ok || e.Status != StatusBadRequest || e.Details == nil {
+		t.Fatalf("asError = %+v, %v", e, ok)
+	}
+
+	if err := Validate(&struct {
+		N int `validate:"positive"`
+	}{}); err == nil || errors.As(err, &ve) {
+		t.Fatalf("unknown rule: %v", err)
+	}
+}
+
+func TestBindAndValidate(t *testing.T) {
+	type req struct {
+		Name string `json:"name" validate:"required"`
+	}
+	c, _ := NewTestContext(MethodWrite, "/users", []byte(`{"name":""}`))
+	var r req
+	var ve *ValidationError
+	if err := c.BindAndValidate(&r); !errors.As(err, &ve) || ve.Fields[0].Field != "name" {
+		t.Fatalf("err = %v", err)
+	}
+}
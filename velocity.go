@@ -22,24 +22,60 @@
 package velocity
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	nwep "github.com/usenwep/nwep-go"
 )
 
-var initOnce sync.Once
+// nwep initialization state. Unlike a sync.Once, a failed nwep.Init is not
+// recorded as done, so the next call to New retries it.
+var (
+	initMu   sync.Mutex
+	initDone bool
+	nwepInit = nwep.Init // replaced in tests
+)
+
+// initNWEP initializes the nwep library the first time it is called
+// successfully. Each failed attempt returns its own error and leaves the
+// library uninitialized.
+func initNWEP() error {
+	initMu.Lock()
+	defer initMu.Unlock()
+	if initDone {
+		return nil
+	}
+	if err := nwepInit(); err != nil {
+		return err
+	}
+	initDone = true
+	return nil
+}
 
 // HandlerFunc is the signature for velocity request handlers. The handler
 // receives a Context containing the request and response writer, and returns
 // an error. A non-nil error is logged by the server but does not automatically
-// generate a response - the handler is responsible for sending a response
-// before returning. If the handler panics and the Recover middleware is
+// generate a response unless an error handler is installed with
+// WithErrorHandler - otherwise the handler is responsible for sending a
+// response before returning. If the handler panics and the Recover middleware is
 // installed, the panic is caught and an "internal_error" response is sent.
 type HandlerFunc func(c *Context) error
 
+// ErrorHandlerFunc handles an error returned from the handler chain. It is
+// installed with WithErrorHandler and is called only when the handler returned
+// a non-nil error without sending a response, so it is free to respond. It is
+// the central place to map errors to statuses and bodies.
+type ErrorHandlerFunc func(c *Context, err error)
+
 // Option configures a Server during construction. Options are passed to New
 // and are applied in order. If an Option returns a non-nil error, New fails
 // immediately with that error, allowing eager validation of configuration
@@ -60,27 +96,94 @@ type Option func(*Server) error
 type Server struct {
 	addr     string
 	keypair  *nwep.Keypair
+	keyMu    sync.Mutex // serializes RotateKeypair and guards keypair
 	settings *nwep.Settings
 	logger   Logger
 	router   *Router
+	hosts    map[string]*Router // see Mount
 	mw       []MiddlewareFunc
 
-	nwep *nwep.Server
+	errorHandler     ErrorHandlerFunc
+	jsonErrors       bool
+	configApplied    bool
+	timeout          time.Duration
+	maxBody          int
+	streamingUploads bool
+	draining         atomic.Bool
+	shutdown         atomic.Bool
+	signals          *[]os.Signal
+	drainStatus      string
+	drainMessage     string
+
+	mwRules          []MiddlewareRule
+	mwValidate       bool
+	mwValidateStrict bool
+
+	nwep       *nwep.Server
+	extraAddrs []string
+
+	advertisedIP   netip.Addr
+	advertisedPort int
+	listeners      listenerSet
 
 	logServer    *nwep.LogServer
 	anchorServer *nwep.AnchorServer
 
-	onConnect    func(*nwep.Conn)
-	onDisconnect func(*nwep.Conn, int)
-	onStart      []func(*Server)
-	onShutdown   []func(*Server)
-
+	hooks       connHooks
+	peers       *peerTracker
+	onStart     []func(*Server)
+	onShutdown  []func(*Server)
+	onKeyRotate []KeyRotateFunc
+
+	notifyTransform   NotifyTransformFunc
+	notifyObservers   []func(NotifyEvent)
+	notifyBatch       *notifyBatcher
+	notifyDepth       notifyDepths
+	notifyHighWater   int
+	notifyCorrelation bool
+	notifyRate        float64
+	notifyBurst       int
+	notifyMode        NotifyLimitMode
+	notifyLimiter     *notifyLimiter
+	notifyQueue       *QueueConfig
+	notifyRetry       *RetryPolicy
+	acks              ackSet
+
+	sessionStore SessionStore
+
+	apiTitle, apiVersion string
+
+	trustMu    sync.Mutex // guards trustStore and anchors
 	trustStore *nwep.TrustStore
+	anchors    []nwep.BLSPubkey
+	revoked    revocationList
+
+	policyMu sync.RWMutex
+	policy   PolicyStore
+
+	logMirrors logMirrorSet
+	issuer     checkpointIssuer
+	health     healthChecks
+
+	features FeatureFlags
+	topics   Topics
+	streams  pushStreams
+	sessions sessionSet
+	requests requestSet
+	pool     poolCounters
+	handlers *handlerPool
+	admit    *admission
+	tenants  TenantResolver
+	audit    *auditTrail
+	conns    connStore
+	metrics  metricsRegistry
+
+	metricsTenants map[string]bool // see WithMetricsTenants
 }
 
 // New creates a new velocity Server that will listen on addr (in "host:port"
-// format). The nwep library is initialized automatically via sync.Once on the
-// first call to New.
+// format). The nwep library is initialized automatically on the first call to
+// New; if initialization fails, the next call to New tries again.
 //
 // Options are applied in order. If no keypair option is provided (WithKeypair,
 // WithKeyFile, WithKeyEnv, or WithConfig with a key field), a random Ed25519
@@ -89,16 +192,15 @@ type Server struct {
 // This function returns a non-nil error if nwep initialization fails, if any
 // option returns an error, or if keypair generation fails.
 func New(addr string, opts ...Option) (*Server, error) {
-	var initErr error
-	initOnce.Do(func() { initErr = nwep.Init() })
-	if initErr != nil {
-		return nil, fmt.Errorf("velocity: nwep init: %w", initErr)
+	if err := initNWEP(); err != nil {
+		return nil, fmt.Errorf("velocity: nwep init: %w", err)
 	}
 
 	s := &Server{
 		addr:   addr,
 		logger: DefaultLogger(),
 		router: NewRouter(),
+		peers:  newPeerTracker(),
 	}
 
 	for _, opt := range opts {
@@ -145,56 +247,136 @@ func (s *Server) Group(prefix string, mw ...MiddlewareFunc) *Group {
 }
 
 // Run starts the server and blocks until Shutdown is called or a termination
-// signal (SIGINT, SIGTERM) is received. It is equivalent to calling Start
-// followed by waiting for the underlying nwep event loop to exit.
+// signal (SIGINT and SIGTERM, unless changed with WithSignals) is received,
+// in which case the server is shut down. It is equivalent to
+// RunContext(context.Background()).
 //
 // This function returns a non-nil error if the server fails to start (e.g.
 // address already in use). After a successful start, Run blocks indefinitely
 // and returns nil on clean shutdown.
 func (s *Server) Run() error {
+	return s.RunContext(context.Background())
+}
+
+// RunContext is like Run, but also shuts the server down when ctx is done,
+// for embedding velocity in a larger service that manages its own lifecycle:
+//
+//	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+//	defer stop()
+//	srv, _ := velocity.New(":6937", velocity.WithSignals())
+//	err := srv.RunContext(ctx)
+//
+// It returns nil once the server has shut down, whatever the cause.
+func (s *Server) RunContext(ctx context.Context) error {
 	if err := s.Start(); err != nil {
 		return err
 	}
-	// Block on the underlying nwep server's Run (which blocks until shutdown).
-	return s.nwep.Run()
+	if sigs := s.runSignals(); len(sigs) > 0 {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, sigs...)
+		defer stop()
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("shutting down", "cause", context.Cause(ctx).Error())
+			s.Shutdown()
+		case <-done:
+		}
+	}()
+	// Block on the primary listener's event loop, which returns once the
+	// server is shut down. Additional listeners run in the background. The
+	// loops leave signal handling to velocity.
+	return s.nwep.RunWithoutSignals()
+}
+
+// runSignals returns the signals that stop Run and RunContext.
+func (s *Server) runSignals() []os.Signal {
+	if s.signals == nil {
+		return []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	return *s.signals
+}
+
+// WithSignals sets the signals on which Run and RunContext shut the server
+// down, replacing the default of SIGINT and SIGTERM. Call it with no
+// arguments to disable signal handling entirely, leaving shutdown to
+// RunContext's context or an explicit Shutdown.
+//
+// velocity runs nwep's event loops without their own signal handling, so
+// these signals are the only ones that stop the server.
+func WithSignals(sigs ...os.Signal) Option {
+	return func(s *Server) error {
+		sigs = append([]os.Signal{}, sigs...)
+		s.signals = &sigs
+		return nil
+	}
 }
 
 // Start creates the underlying nwep.Server, binds to the configured address,
 // and fires OnStart callbacks, but does not block. The caller must eventually
 // call Shutdown to release resources, and must call nwep.Server.Run (via
-// NWEPServer().Run()) or Server.Run to actually process packets.
+// NWEPServer().Run()) or Server.Run to actually process packets. Listeners
+// added with WithAdditionalAddr are bound too, and their event loops started
+// in the background.
 //
 // For most use cases, prefer Run which combines Start and the event loop.
 // Start is provided for scenarios that require non-blocking initialization
 // (e.g. obtaining the resolved address before entering the event loop).
 //
-// This function returns a non-nil error if the nwep server cannot be created
-// (e.g. invalid address, socket error, or key error).
+// This function returns a non-nil error if any nwep server cannot be created
+// (e.g. invalid address, socket error, or key error), or if the checkpoint
+// options are incomplete or the checkpoint state file cannot be read.
 func (s *Server) Start() error {
+	if err := s.checkAdmission(); err != nil {
+		return err
+	}
+	if err := s.startCheckpointIssuer(); err != nil {
+		return err
+	}
+	if s.mwValidate {
+		if err := s.ValidateMiddleware(); err != nil {
+			if s.mwValidateStrict {
+				return fmt.Errorf("velocity: validate middleware: %w", err)
+			}
+			s.logger.Warn("middleware order", "error", err.Error())
+		}
+	}
+
 	handler := s.buildHandler()
 
 	var nwepOpts []nwep.ServerOption
 	if s.settings != nil {
 		nwepOpts = append(nwepOpts, nwep.WithSettings(*s.settings))
 	}
-	if s.onConnect != nil {
-		nwepOpts = append(nwepOpts, nwep.WithOnConnect(s.onConnect))
-	}
-	if s.onDisconnect != nil {
-		nwepOpts = append(nwepOpts, nwep.WithOnDisconnect(s.onDisconnect))
-	}
 
-	srv, err := nwep.NewServer(s.addr, s.keypair, handler, nwepOpts...)
+	srv, err := s.listen(s.addr, handler, nwepOpts)
 	if err != nil {
+		s.stopHandlerPool()
 		return fmt.Errorf("velocity: start server: %w", err)
 	}
+	if err := s.startAdditional(handler, nwepOpts); err != nil {
+		srv.Shutdown()
+		s.stopHandlerPool()
+		return err
+	}
 	s.nwep = srv
+	s.enableStreamingUploads()
 
-	if s.logServer != nil {
-		s.nwep.SetLogServer(s.logServer)
+	if s.notifyRate > 0 {
+		s.notifyLimiter = newNotifyLimiter(s.notifyRate, s.notifyBurst)
+		s.notifyLimiter.mode = s.notifyMode
 	}
-	if s.anchorServer != nil {
-		s.nwep.SetAnchorServer(s.anchorServer)
+
+	for _, l := range s.allListeners() {
+		if s.logServer != nil {
+			l.SetLogServer(s.logServer)
+		}
+		if s.anchorServer != nil {
+			l.SetAnchorServer(s.anchorServer)
+		}
 	}
 
 	for _, fn := range s.onStart {
@@ -209,15 +391,27 @@ func (s *Server) Start() error {
 // Shutdown returns, the Server must not be reused.
 //
 // Shutdown is safe to call on a server that has not been started - it is a
-// no-op in that case.
+// no-op in that case - and calls after the first are no-ops too, so it may
+// race with RunContext's own shutdown.
 func (s *Server) Shutdown() {
-	if s.nwep == nil {
+	if s.nwep == nil || !s.shutdown.CompareAndSwap(false, true) {
 		return
 	}
 	for _, fn := range s.onShutdown {
 		fn(s)
 	}
-	s.nwep.Shutdown()
+	s.closeNotifyBatching()
+	if s.notifyLimiter != nil {
+		s.notifyLimiter.close()
+		// Throttled sends the limiter discarded never count themselves down.
+		s.notifyDepth.reset()
+	}
+	s.streams.closeAll()
+	for _, l := range s.allListeners() {
+		l.Shutdown()
+	}
+	s.stopHandlerPool()
+	s.sessions.releaseAll(s)
 	if s.logServer != nil {
 		s.logServer.Free()
 		s.logServer = nil
@@ -226,10 +420,7 @@ func (s *Server) Shutdown() {
 		s.anchorServer.Free()
 		s.anchorServer = nil
 	}
-	if s.trustStore != nil {
-		s.trustStore.Free()
-		s.trustStore = nil
-	}
+	s.setTrustStore(nil, nil)
 }
 
 // NodeID returns the server's 32-byte node ID, derived from its Ed25519
@@ -239,19 +430,45 @@ func (s *Server) NodeID() nwep.NodeID {
 	if s.nwep != nil {
 		return s.nwep.NodeID()
 	}
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
 	nid, _ := s.keypair.NodeID()
 	return nid
 }
 
+// Keypair returns the server's current keypair, for clients that must
+// authenticate to other servers as this one. After RotateKeypair it returns
+// the new keypair.
+func (s *Server) Keypair() *nwep.Keypair {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	return s.keypair
+}
+
+// Role returns the role the server advertises in the WEB/1 handshake, as set
+// by WithRole or WithSettings, or "" for nwep's default.
+func (s *Server) Role() string {
+	if s.settings == nil {
+		return ""
+	}
+	return s.settings.Role
+}
+
+// Logger returns the server's logger, as set by WithLogger.
+func (s *Server) Logger() Logger { return s.logger }
+
 // URL returns the WEB/1 URL for the given path on this server. The URL
 // includes the server's IP address, port, and node ID in the standard WEB/1
 // format: web://[Base58(IP||NodeID)]:port/path.
 //
+// The address is the one the server is bound to unless WithAdvertisedAddr
+// says otherwise.
+//
 // This function returns an empty string if the server has not been started
 // (the listen address is not yet known).
 func (s *Server) URL(path string) string {
 	if s.nwep != nil {
-		return s.nwep.URL(path)
+		return s.advertise(s.nwep.URL(path))
 	}
 	return ""
 }
@@ -272,26 +489,142 @@ func (s *Server) Addr() net.Addr {
 // directly - use Server.Shutdown instead.
 func (s *Server) NWEPServer() *nwep.Server { return s.nwep }
 
+// handleConnect is installed as the nwep connect callback. It records the
+// connection for per-peer tracking and then invokes the registered callbacks.
+func (s *Server) handleConnect(conn *nwep.Conn) {
+	_, peer := conn.PeerIdentity()
+	s.peers.connect(peer, conn)
+	s.logger.Debug("peer connected", "peer", FormatNodeID(peer), "handshake", conn.HandshakeDuration().String())
+	if s.notifyQueue != nil {
+		go s.flushQueue(peer)
+	}
+	s.hooks.runConnect(conn)
+}
+
+// handleDisconnect is installed as the nwep disconnect callback. It forgets
+// the connection and then invokes the registered callbacks.
+func (s *Server) handleDisconnect(conn *nwep.Conn, code int) {
+	_, peer := conn.PeerIdentity()
+	s.peers.disconnect(peer)
+	s.conns.forget(conn)
+	s.topics.UnsubscribeAll(peer)
+	s.streams.closePeer(peer)
+	s.sessions.release(s, peer)
+	s.requests.cancelConn(conn, ErrPeerDisconnected)
+	s.hooks.runDisconnect(conn, code)
+}
+
 // buildHandler converts the velocity router and middleware chain into a single
 // nwep.HandlerFunc suitable for nwep.NewServer. Each inbound request acquires
 // a pooled Context, performs route lookup with middleware composition, invokes
-// the matched handler, and releases the Context.
+// the matched handler, and releases the Context, inline or, with
+// WithHandlerPool, on a worker.
 func (s *Server) buildHandler() nwep.HandlerFunc {
+	if s.pool.workers > 0 {
+		return s.startHandlerPool()
+	}
 	return func(w *nwep.ResponseWriter, r *nwep.Request) {
-		c := acquireContext(w, r, s)
-		defer releaseContext(c)
+		s.ServeWEB(w, r)
+	}
+}
+
+// ServeWEB handles r as if it had arrived over the network: it runs the
+// router, middleware, handler, and error handling exactly as for a request
+// served by the nwep event loop, and sends the response to w. It is used by
+// in-process adapters such as the httpgw package, and by tests that want the
+// full request pipeline without a network.
+//
+// r.Conn may be nil, in which case the request comes from an unauthenticated
+// peer. ServeWEB does not require the server to be started.
+func (s *Server) ServeWEB(w ResponseWriter, r *nwep.Request) {
+	c := acquireContext(w, r, s)
+	defer releaseContext(c)
+	if s.pool.workers > 0 {
+		defer s.isolatePanic(c)
+	}
+
+	peer := c.PeerNodeID()
+	switch err := s.peers.begin(peer, r.Conn); err {
+	case nil:
+	case errPeerBusy:
+		_ = c.Error(StatusRateLimited, err.Error())
+		return
+	default:
+		_ = c.Error(nwep.StatusUnavailable, err.Error())
+		return
+	}
+	defer s.peers.end(peer)
+
+	if s.acks.handle(c) {
+		return
+	}
+	if s.draining.Load() {
+		status, msg := s.drainStatus, s.drainMessage
+		if status == "" {
+			status, msg = StatusUnavailable, "server draining"
+		}
+		_ = c.Error(status, msg)
+		return
+	}
+	if s.maxBody > 0 && len(r.Body) > s.maxBody {
+		_ = c.BadRequest("request body too large")
+		return
+	}
 
-		h := s.router.Find(r.Path, r.Method, s.mw)
-		if h == nil {
-			_ = c.NotFound("not found")
+	rt := s.routerFor(c)
+	h, pattern := rt.find(r.Path, r.Method, s.mw, &c.params)
+	c.route = pattern
+	if h != nil && s.tenants != nil {
+		h = s.resolveTenant(h)
+	}
+	if s.admit != nil && s.admit.max > 0 {
+		if status := s.admitRequest(c); status != "" {
+			_ = c.Error(status, "server overloaded")
 			return
 		}
-		if err := h(c); err != nil {
-			s.logger.Error("handler error",
-				"path", r.Path,
-				"method", r.Method,
-				"error", err.Error(),
-			)
+		defer s.admit.release()
+	}
+
+	s.requests.begin(c)
+	defer s.requests.end(c)
+	s.pool.active.Add(1)
+	defer s.pool.active.Add(-1)
+	c.setTimeout(s.timeout)
+	defer func() { c.cancel() }()
+
+	if h == nil {
+		_ = c.NotFound("not found")
+		return
+	}
+	err := h(c)
+	if errors.Is(err, ErrDecline) {
+		if !c.Committed() {
+			rt.declined(c)
+		}
+		err = nil
+	}
+	if err != nil {
+		// An Error with a client status is an expected outcome, not a
+		// server fault, so it is only logged at debug level.
+		log := s.logger.Error
+		if e, ok := asError(err); ok && e.Status != StatusInternalError {
+			log = s.logger.Debug
+		}
+		log("handler error",
+			"path", r.Path,
+			"method", r.Method,
+			"error", err.Error(),
+		)
+		if s.errorHandler != nil && !c.Committed() {
+			s.errorHandler(c, err)
+		}
+	}
+	if !c.Committed() {
+		switch {
+		case c.timedOut():
+			_ = c.Error(StatusUnavailable, "request timed out")
+		case c.cancelled():
+			_ = c.Error(StatusUnavailable, "request cancelled")
 		}
 	}
 }
@@ -346,8 +679,19 @@ func WithKeyEnv(envVar string) Option {
 // WithSettings sets the nwep transport-level settings for the server. Fields
 // include MaxStreams, MaxMessageSize, TimeoutMs, Compression, and Role. See
 // nwep.Settings for defaults and valid ranges.
+//
+// Compression may list several algorithms in order of preference, as
+// described for Config.Compression. This option returns an error if it names
+// an algorithm not in CompressionAlgorithms.
 func WithSettings(settings nwep.Settings) Option {
 	return func(s *Server) error {
+		if settings.Compression != "" {
+			comp, err := normalizeCompression(settings.Compression)
+			if err != nil {
+				return fmt.Errorf("velocity: settings: %w", err)
+			}
+			settings.Compression = comp
+		}
 		s.settings = &settings
 		return nil
 	}
@@ -363,24 +707,93 @@ func WithLogger(l Logger) Option {
 	}
 }
 
+// WithTimeout sets a default timeout for every request. The deadline is
+// applied to Context.Ctx before any middleware runs; a route that needs a
+// different limit can replace it with the Timeout middleware. The precedence
+// is: per-route Timeout, then the WithTimeout default, then no timeout. d
+// must be positive.
+//
+// Timeouts are cooperative: handlers are not interrupted, but Context.Ctx is
+// cancelled at the deadline so that blocking work passed the context returns
+// early. If the deadline has passed when the handler returns and nothing has
+// been sent, the server responds with status "unavailable". The request's
+// in-flight slot, which DisconnectPeer waits on, is released as soon as the
+// handler returns.
+func WithTimeout(d time.Duration) Option {
+	return func(s *Server) error {
+		if d <= 0 {
+			return fmt.Errorf("velocity: timeout must be positive, got %s", d)
+		}
+		s.timeout = d
+		return nil
+	}
+}
+
+// WithMaxBodySize rejects requests whose body is longer than n bytes with a
+// "bad_request" response and the message "request body too large", before
+// routing and middleware. It applies to every route; use BodyLimit for a
+// tighter limit on particular routes. This function returns an error if n is
+// not positive.
+func WithMaxBodySize(n int) Option {
+	return func(s *Server) error {
+		if n <= 0 {
+			return fmt.Errorf("velocity: max body size must be positive, got %d", n)
+		}
+		s.maxBody = n
+		return nil
+	}
+}
+
+// WithErrorHandler installs fn as the server's central error handler. When the
+// handler chain returns a non-nil error and no response has been sent yet, fn
+// is called with the request Context and the error so that it can send an
+// appropriate response. The error is still logged as before.
+//
+// Without an error handler, a handler that returns an error without
+// responding leaves the request unanswered. DefaultErrorHandler is a
+// reasonable starting point.
+func WithErrorHandler(fn ErrorHandlerFunc) Option {
+	return func(s *Server) error {
+		s.errorHandler = fn
+		return nil
+	}
+}
+
+// DefaultErrorHandler responds to an Error (found with errors.As) with its
+// status, message, and details, and to every other error with status
+// "internal_error" and the body "internal error". The text of other errors is
+// not sent to the peer.
+func DefaultErrorHandler(c *Context, err error) {
+	if e, ok := asError(err); ok {
+		_ = e.respond(c)
+		return
+	}
+	_ = c.InternalError("internal error")
+}
+
 // WithOnConnect registers a callback that is invoked when a new peer
 // connection is established, after the mutual authentication handshake
 // completes. The callback receives the nwep.Conn for the new connection.
-// Only one OnConnect callback can be active - setting a new one replaces the
-// previous.
+// Multiple callbacks can be registered, with this option or Server.OnConnect,
+// and are called in registration order.
 func WithOnConnect(fn func(*nwep.Conn)) Option {
 	return func(s *Server) error {
-		s.onConnect = fn
+		if fn != nil {
+			s.OnConnect(fn)
+		}
 		return nil
 	}
 }
 
 // WithOnDisconnect registers a callback that is invoked when a peer connection
 // is closed. The callback receives the nwep.Conn and the error code (0 for
-// graceful close). Only one OnDisconnect callback can be active.
+// graceful close). Multiple callbacks can be registered, with this option or
+// Server.OnDisconnect, and are called in registration order.
 func WithOnDisconnect(fn func(*nwep.Conn, int)) Option {
 	return func(s *Server) error {
-		s.onDisconnect = fn
+		if fn != nil {
+			s.OnDisconnect(fn)
+		}
 		return nil
 	}
 }
@@ -398,7 +811,7 @@ func WithTrust(tc *TrustConfig) Option {
 		if err != nil {
 			return fmt.Errorf("velocity: build trust store: %w", err)
 		}
-		s.trustStore = ts
+		s.setTrustStore(ts, tc.Anchors)
 		return nil
 	}
 }
@@ -438,6 +851,51 @@ func WithAnchorServer(as *nwep.AnchorServer) Option {
 	}
 }
 
+// WithNotifyRateLimit limits outbound notifications to perPeerRate per second
+// for each peer, allowing bursts of up to burst notifications. The limit
+// applies to every notification method, including each peer's share of a
+// NotifyAll broadcast. By default over-limit notifications are delayed; use
+// WithNotifyLimitMode to drop them instead. Throttled and dropped counts are
+// reported by Server.NotifyStats.
+//
+// This option returns an error if perPeerRate is not positive or burst is less
+// than 1.
+func WithNotifyRateLimit(perPeerRate float64, burst int) Option {
+	return func(s *Server) error {
+		if perPeerRate <= 0 {
+			return fmt.Errorf("velocity: notify rate must be positive, got %v", perPeerRate)
+		}
+		if burst < 1 {
+			return fmt.Errorf("velocity: notify burst must be at least 1, got %d", burst)
+		}
+		s.notifyRate = perPeerRate
+		s.notifyBurst = burst
+		return nil
+	}
+}
+
+// WithNotifyLimitMode selects whether notifications exceeding the rate set by
+// WithNotifyRateLimit are delayed (NotifyThrottle, the default) or discarded
+// (NotifyDrop). It has no effect unless WithNotifyRateLimit is also used.
+func WithNotifyLimitMode(mode NotifyLimitMode) Option {
+	return func(s *Server) error {
+		s.notifyMode = mode
+		return nil
+	}
+}
+
+// WithNotifyCorrelation stamps every notification sent through the Context
+// notification methods (Context.Notify, Context.NotifyJSON, Context.NotifyAll)
+// with a HeaderCorrelationID header carrying the triggering request's ID.
+// Clients read it back with CorrelationID. Notifications sent directly through
+// Server methods are not stamped, since they have no triggering request.
+func WithNotifyCorrelation() Option {
+	return func(s *Server) error {
+		s.notifyCorrelation = true
+		return nil
+	}
+}
+
 // LogServer returns the attached LogServer, or nil if none was configured.
 func (s *Server) LogServer() *nwep.LogServer { return s.logServer }
 
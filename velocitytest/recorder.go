@@ -0,0 +1,20 @@
+package velocitytest
+
+import (
+	"github.com/usenwep/velocity"
+)
+
+// ResponseRecorder is velocity.ResponseRecorder, which records a response in
+// memory. It is kept here so that tests written against this package need
+// only one import.
+type ResponseRecorder = velocity.ResponseRecorder
+
+// NewRecorder returns an empty ResponseRecorder.
+func NewRecorder() *ResponseRecorder { return velocity.NewRecorder() }
+
+// NewContext returns a velocity.Context for a request with the given method,
+// path, and body, whose response is recorded by the returned
+// ResponseRecorder. It is the same as velocity.NewTestContext.
+func NewContext(method, path string, body []byte) (*velocity.Context, *ResponseRecorder) {
+	return velocity.NewTestContext(method, path, body)
+}
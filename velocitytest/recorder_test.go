@@ -0,0 +1,32 @@
+package velocitytest
+
+import (
+	"testing"
+
+	"github.com/usenwep/velocity"
+)
+
+func TestNewContext(t *testing.T) {
+	c, rec := NewContext(velocity.MethodRead, "/items?page=2", nil)
+
+	h := func(c *velocity.Context) error {
+		c.SetHeader("x-page", c.QueryParam("page"))
+		return c.JSON(map[string]string{"path": c.Path()})
+	}
+	if err := h(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Status != velocity.StatusOK || !rec.Responded {
+		t.Fatalf("status = %q, responded = %v", rec.Status, rec.Responded)
+	}
+	if v, _ := rec.Header("x-page"); v != "2" {
+		t.Fatalf("x-page = %q, want 2", v)
+	}
+	if v, _ := rec.Header("content-type"); v != "application/json" {
+		t.Fatalf("content-type = %q", v)
+	}
+	if string(rec.Body) != `{"path":"/items?page=2"}` {
+		t.Fatalf("body = %s", rec.Body)
+	}
+}
@@ -0,0 +1,71 @@
+// Package velocitytest provides utilities for testing velocity servers and
+// handlers.
+//
+// NewTestServer starts a real server on a random port with a client already
+// connected to it, for end-to-end tests. It requires the nwep C library at run
+// time. NewContext and ResponseRecorder run a single handler against an
+// in-memory response instead, which needs neither a network nor the nwep
+// event loop:
+//
+//	func TestHello(t *testing.T) {
+//		c, rec := velocitytest.NewContext(velocity.MethodRead, "/hello", nil)
+//		if err := hello(c); err != nil {
+//			t.Fatal(err)
+//		}
+//		if rec.Status != velocity.StatusOK || string(rec.Body) != "hello" {
+//			t.Fatalf("got %s %q", rec.Status, rec.Body)
+//		}
+//	}
+package velocitytest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/usenwep/velocity"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// NewTestServer creates a velocity server listening on a random port with
+// opts, starts its event loop, and returns it together with a client that is
+// already connected to it. Register routes on the returned server before
+// sending requests; routes added after the client connects are still served.
+//
+// The client and server are closed, in that order, when the test finishes.
+// NewTestServer fails the test immediately if any step fails.
+func NewTestServer(t testing.TB, opts ...velocity.Option) (*velocity.Server, *nwep.Client) {
+	t.Helper()
+
+	srv, err := velocity.New(":0", opts...)
+	if err != nil {
+		t.Fatal("velocity.New:", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	t.Cleanup(srv.Shutdown)
+
+	go srv.NWEPServer().Run()
+
+	// Give the event loop a moment to begin accepting connections.
+	time.Sleep(50 * time.Millisecond)
+
+	kp, err := nwep.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(kp.Clear)
+
+	client, err := nwep.NewClient(kp, nwep.WithClientSettings(nwep.Settings{TimeoutMs: 5000}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(srv.URL("/")); err != nil {
+		client.Close()
+		t.Fatal("connect:", err)
+	}
+	t.Cleanup(client.Close)
+
+	return srv, client
+}
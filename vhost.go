@@ -0,0 +1,58 @@
+package velocity
+
+import "strings"
+
+// HostHeader is the request header that selects a service mounted with
+// Server.Mount.
+const HostHeader = "host"
+
+// Mount serves the routes of rt, instead of the server's own Router, to
+// requests whose host header equals name, compared case-insensitively. It lets
+// several logical services share one server and one UDP port:
+//
+//	api, admin := velocity.NewRouter(), velocity.NewRouter()
+//	api.Read("/orders", listOrders)
+//	admin.Read("/users", listUsers)
+//	srv.Mount("api.example", api)
+//	srv.Mount("admin.example", admin)
+//
+// Requests without the header, or naming no mounted service, are routed by
+// the server's Router. Global middleware added with Use applies to every
+// service, and each router's not-found handler to its own requests. Mounting
+// a router under a name that is already mounted replaces it.
+//
+// WEB/1 has no counterpart to TLS SNI: the handshake completes before a
+// request names the service it wants, so mounted services share the server's
+// keypair and node ID. A client that knows the service by the node ID it dials
+// can send that node ID's string form as the host header, with name mounted
+// under it. Like routes, services must be mounted before the server is
+// started. Mount panics if name is empty or rt is nil.
+func (s *Server) Mount(name string, rt *Router) {
+	if name == "" {
+		panic("velocity: Mount requires a name")
+	}
+	if rt == nil {
+		panic("velocity: Mount requires a router")
+	}
+	if s.hosts == nil {
+		s.hosts = make(map[string]*Router)
+	}
+	s.hosts[strings.ToLower(name)] = rt
+}
+
+// Mounted returns the router mounted under name, or nil if there is none.
+func (s *Server) Mounted(name string) *Router {
+	return s.hosts[strings.ToLower(name)]
+}
+
+// routerFor returns the router that serves c's request.
+func (s *Server) routerFor(c *Context) *Router {
+	if len(s.hosts) > 0 {
+		if host, ok := c.Header(HostHeader); ok {
+			if rt := s.hosts[strings.ToLower(host)]; rt != nil {
+				return rt
+			}
+		}
+	}
+	return s.router
+}
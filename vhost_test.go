@@ -0,0 +1,37 @@
+package velocity
+
+import (
+	"testing"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestMount(t *testing.T) {
+	s := &Server{logger: DefaultLogger(), router: NewRouter(), peers: newPeerTracker()}
+	s.Handle("/", func(c *Context) error { return c.NoContent() })
+	api := NewRouter()
+	api.Read("/orders", func(c *Context) error { return c.NoContent() })
+	s.Mount("API.example", api)
+	if s.Mounted("api.example") != api || s.Mounted("admin.example") != nil {
+		t.Fatal("Mounted does not match Mount")
+	}
+
+	// Without a host header, requests go to the server's own router.
+	rec := NewRecorder()
+	s.ServeWEB(rec, &nwep.Request{Method: MethodRead, Path: "/"})
+	if rec.Status != StatusNoContent {
+		t.Fatalf("default router: %q", rec.Status)
+	}
+	rec = NewRecorder()
+	s.ServeWEB(rec, &nwep.Request{Method: MethodRead, Path: "/orders"})
+	if rec.Status != StatusNotFound {
+		t.Fatalf("mounted route served without a host header: %q", rec.Status)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Mount with a nil router did not panic")
+		}
+	}()
+	s.Mount("admin.example", nil)
+}
@@ -0,0 +1,84 @@
+package velocity
+
+import (
+	"slices"
+	"strings"
+)
+
+// Matcher selects requests for When. Matchers and Skippers have the same
+// shape, so a Matcher can be passed to Unless as Skipper(m).
+type Matcher func(c *Context) bool
+
+// When returns middleware that runs mw, in order, around the requests m
+// selects, and lets every other request through to the next handler
+// untouched. It applies cross-cutting middleware declaratively, without
+// creating a group just to scope it:
+//
+//	srv.Use(
+//	    velocity.When(velocity.MatchMethods(velocity.MethodWrite, velocity.MethodUpdate),
+//	        velocity.BodyLimit(1<<20)),
+//	    velocity.When(velocity.MatchPath("/admin/**"), velocity.RequireRole("admin")),
+//	)
+//
+// m runs before mw, once per request. ValidateMiddleware names the result
+// "velocity.When" rather than after mw, so ordering rules about mw do not
+// apply to it. When panics if m is nil.
+func When(m Matcher, mw ...MiddlewareFunc) MiddlewareFunc {
+	if m == nil {
+		panic("velocity: When requires a matcher")
+	}
+	mw = slices.Clone(mw)
+	return func(next HandlerFunc) HandlerFunc {
+		h := applyMiddleware(next, mw)
+		return func(c *Context) error {
+			if m(c) {
+				return h(c)
+			}
+			return next(c)
+		}
+	}
+}
+
+// MatchMethods returns a Matcher for requests with any of the given methods.
+func MatchMethods(methods ...string) Matcher {
+	methods = slices.Clone(methods)
+	return func(c *Context) bool { return slices.Contains(methods, c.Method()) }
+}
+
+// MatchPath returns a Matcher for requests whose path matches any of the
+// given patterns, which use the syntax of route patterns: a ":name" or "*"
+// segment matches any one segment, and a final "**" segment the rest of the
+// path, including nothing, so "/admin/**" matches "/admin" and everything
+// below it. The query string is ignored. Unlike SkipRoutes, it looks at the
+// request path rather than the route that matched it. MatchPath panics if a
+// pattern has a "**" segment anywhere but at the end.
+func MatchPath(patterns ...string) Matcher {
+	prs := make([]paramRoute, len(patterns))
+	for i, p := range patterns {
+		prs[i] = newParamRoute("", &route{pattern: p})
+	}
+	return func(c *Context) bool {
+		path, _, _ := strings.Cut(c.Path(), "?")
+		segs := splitPath(path)
+		for i := range prs {
+			if prs[i].matches(segs) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchAll returns a Matcher for requests that every one of ms matches, such
+// as write requests under a path.
+func MatchAll(ms ...Matcher) Matcher {
+	ms = slices.Clone(ms)
+	return func(c *Context) bool {
+		for _, m := range ms {
+			if !m(c) {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,401 @@
+package velocity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// DefaultWitnessPrefix is the path prefix Witness.Mount registers its routes
+// under unless WitnessOptions.Prefix says otherwise.
+const DefaultWitnessPrefix = "/witness"
+
+// witnessHistory bounds the checkpoints, by epoch and by log size, and the
+// discrepancies a Witness remembers.
+const witnessHistory = 1024
+
+// Kinds of WitnessDiscrepancy.
+const (
+	// DiscrepancyRollback: an anchor served a checkpoint with a lower epoch
+	// than one it served before.
+	DiscrepancyRollback = "rollback"
+
+	// DiscrepancyShrink: an anchor served a checkpoint covering fewer log
+	// entries than one it served before.
+	DiscrepancyShrink = "shrink"
+
+	// DiscrepancyFork: two checkpoints for the same epoch, or the same log
+	// size, have different roots - the log has been forked.
+	DiscrepancyFork = "fork"
+
+	// DiscrepancyInvalid: a checkpoint failed WitnessOptions.Verify.
+	DiscrepancyInvalid = "invalid"
+)
+
+// WitnessOptions configures a Witness.
+type WitnessOptions struct {
+	// AnchorURLs are the web:// URLs of the anchor servers to watch.
+	// There must be at least one.
+	AnchorURLs []string
+
+	// Interval is the time between polls. Zero means
+	// DefaultCheckpointSyncInterval.
+	Interval time.Duration
+
+	// Client configures the connections to the anchor servers.
+	Client ClientOptions
+
+	// Prefix is the path Mount registers the routes under. Empty means
+	// DefaultWitnessPrefix.
+	Prefix string
+
+	// Verify, if set, checks each new checkpoint, for example its
+	// signatures, before it is compared with the others. A checkpoint it
+	// rejects is recorded as a DiscrepancyInvalid and otherwise ignored.
+	Verify func(*nwep.Checkpoint) error
+
+	// CoSign, if set, is the witness's BLS keypair. Each new checkpoint
+	// that shows no discrepancy is signed with it, and the newest signed
+	// one is available from Witness.CoSigned.
+	CoSign *nwep.BLSKeypair
+
+	// OnDiscrepancy, if set, is called for every discrepancy found.
+	// Discrepancies are also logged at error level.
+	OnDiscrepancy func(WitnessDiscrepancy)
+}
+
+// WitnessDiscrepancy is an inconsistency a Witness found between
+// checkpoints.
+type WitnessDiscrepancy struct {
+	Time   time.Time `json:"time"`
+	Anchor string    `json:"anchor"`
+	Kind   string    `json:"kind"`
+	Detail string    `json:"detail"`
+}
+
+// WitnessAnchorStatus is what a Witness knows about one anchor server.
+type WitnessAnchorStatus struct {
+	URL string `json:"url"`
+
+	// Epoch, LogSize, and Root describe the newest checkpoint seen from
+	// the anchor.
+	Epoch   uint64  `json:"epoch"`
+	LogSize uint64  `json:"log_size"`
+	Root    LogHash `json:"root"`
+
+	// Seen is when the anchor last served a checkpoint, and Error why the
+	// last poll failed, if it did.
+	Seen  time.Time `json:"seen,omitzero"`
+	Error string    `json:"error,omitempty"`
+}
+
+// WitnessStatus is the state of a Witness, served at /witness/status.
+type WitnessStatus struct {
+	// Consistent is false once a rollback, shrink, or fork has been
+	// found. Checkpoints rejected by WitnessOptions.Verify are listed in
+	// Discrepancies but do not make the anchors inconsistent.
+	Consistent bool `json:"consistent"`
+
+	Anchors []WitnessAnchorStatus `json:"anchors"`
+
+	// CoSignedEpoch is the epoch of the newest checkpoint the witness
+	// co-signed, zero if none.
+	CoSignedEpoch uint64 `json:"cosigned_epoch"`
+
+	// Discrepancies lists the most recent discrepancies, oldest first.
+	Discrepancies []WitnessDiscrepancy `json:"discrepancies"`
+}
+
+// Witness watches the checkpoints published by a set of anchor servers and
+// checks that they tell one consistent story, the role witnesses and
+// monitors play in Certificate Transparency and similar logs. Each anchor's
+// epochs and log sizes must only grow, and no two checkpoints, from the same
+// anchor or different ones, may give different roots for the same epoch or
+// the same log size. Inconsistencies are recorded as WitnessDiscrepancy
+// values, logged, and passed to OnDiscrepancy; with CoSign, the witness
+// co-signs checkpoints that pass.
+//
+//	w, err := velocity.NewWitness(srv, velocity.WitnessOptions{
+//	    AnchorURLs: []string{anchorA, anchorB, anchorC},
+//	    OnDiscrepancy: func(d velocity.WitnessDiscrepancy) { page(d) },
+//	})
+//	...
+//	w.Mount(velocity.RequireRole("ops"))
+//	defer w.Close()
+//
+// A Witness is safe for concurrent use.
+type Witness struct {
+	srv  *Server
+	opts WitnessOptions
+	pool *ClientPool
+
+	mu       sync.Mutex
+	peers    map[nwep.NodeID]string // anchor node ID to URL
+	anchors  map[string]*WitnessAnchorStatus
+	byEpoch  map[uint64]witnessHead
+	bySize   map[uint64]witnessHead
+	found    []WitnessDiscrepancy
+	forked   bool
+	cosigned *nwep.Checkpoint
+
+	ctx       context.Context // canceled by Close
+	stop      context.CancelFunc
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// witnessHead identifies a checkpoint's view of the log, and where it was
+// first seen.
+type witnessHead struct {
+	size   uint64
+	root   LogHash
+	anchor string
+}
+
+// NewWitness returns a witness of the anchor servers in opts. It polls them
+// once right away, in the background, and then every opts.Interval until
+// Close. srv is used for logging and by Mount. This function returns an error
+// if opts is invalid or an anchor URL has no node ID.
+func NewWitness(srv *Server, opts WitnessOptions) (*Witness, error) {
+	if len(opts.AnchorURLs) == 0 {
+		return nil, errors.New("velocity: witness needs at least one anchor URL")
+	}
+	if opts.Interval < 0 {
+		return nil, fmt.Errorf("velocity: witness interval must not be negative, got %s", opts.Interval)
+	}
+	if opts.Interval == 0 {
+		opts.Interval = DefaultCheckpointSyncInterval
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultWitnessPrefix
+	}
+	pool, err := NewClientPool(ClientPoolOptions{Client: opts.Client})
+	if err != nil {
+		return nil, err
+	}
+	w := &Witness{
+		srv:     srv,
+		opts:    opts,
+		pool:    pool,
+		peers:   make(map[nwep.NodeID]string),
+		anchors: make(map[string]*WitnessAnchorStatus),
+		byEpoch: make(map[uint64]witnessHead),
+		bySize:  make(map[uint64]witnessHead),
+	}
+	for _, url := range opts.AnchorURLs {
+		peer, err := pool.Add(url)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		w.peers[peer] = url
+		w.anchors[url] = &WitnessAnchorStatus{URL: url}
+	}
+	w.ctx, w.stop = context.WithCancel(context.Background())
+	w.wg.Go(w.loop)
+	return w, nil
+}
+
+// loop polls now and then after every interval until Close.
+func (w *Witness) loop() {
+	t := time.NewTicker(w.opts.Interval)
+	defer t.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(w.ctx, w.opts.Interval)
+		w.Poll(ctx)
+		cancel()
+		select {
+		case <-t.C:
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// Poll fetches the latest checkpoint from every anchor server concurrently
+// and checks each against what the witness has seen, as the witness does
+// every interval.
+func (w *Witness) Poll(ctx context.Context) {
+	peers := w.pool.Peers()
+	results := make([]PoolResult, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Go(func() {
+			resp, err := w.pool.Do(ctx, peer, MethodRead, "/checkpoint/latest", nil)
+			results[i] = PoolResult{Peer: peer, Response: resp, Err: err}
+		})
+	}
+	wg.Wait()
+
+	var found []WitnessDiscrepancy
+	w.mu.Lock()
+	for _, r := range results {
+		url := w.peers[r.Peer]
+		st := w.anchors[url]
+		err := r.Err
+		if err == nil {
+			err = ResponseError(r.Response)
+		}
+		var cp *nwep.Checkpoint
+		if err == nil {
+			cp, err = nwep.CheckpointDecode(r.Response.Body)
+		}
+		if err != nil {
+			st.Error = err.Error()
+			continue
+		}
+		st.Error = ""
+		found = append(found, w.check(st, cp)...)
+		st.Seen = time.Now()
+	}
+	w.found = append(w.found, found...)
+	if n := len(w.found) - witnessHistory; n > 0 {
+		w.found = slices.Delete(w.found, 0, n)
+	}
+	w.mu.Unlock()
+
+	for _, d := range found {
+		w.srv.logger.Error("witness discrepancy", "anchor", d.Anchor, "kind", d.Kind, "detail", d.Detail)
+		if w.opts.OnDiscrepancy != nil {
+			w.opts.OnDiscrepancy(d)
+		}
+	}
+}
+
+// check compares cp, just served by the anchor st describes, with the
+// checkpoints seen before, records it, and co-signs it if it is new and
+// consistent. st.Seen is still the time of the anchor's previous checkpoint.
+// The caller must hold w.mu.
+func (w *Witness) check(st *WitnessAnchorStatus, cp *nwep.Checkpoint) []WitnessDiscrepancy {
+	var found []WitnessDiscrepancy
+	report := func(kind, format string, args ...any) {
+		found = append(found, WitnessDiscrepancy{
+			Time: time.Now(), Anchor: st.URL, Kind: kind, Detail: fmt.Sprintf(format, args...),
+		})
+	}
+	size, root := cp.LogSize, LogHash(cp.MerkleRoot)
+	if !st.Seen.IsZero() && cp.Epoch == st.Epoch && size == st.LogSize && root == st.Root {
+		return nil // nothing new since the last poll
+	}
+	if w.opts.Verify != nil {
+		if err := w.opts.Verify(cp); err != nil {
+			// The anchors have not disagreed; the checkpoint is
+			// just not taken into account.
+			report(DiscrepancyInvalid, "epoch %d: %v", cp.Epoch, err)
+			return found
+		}
+	}
+
+	if cp.Epoch < st.Epoch {
+		report(DiscrepancyRollback, "epoch %d after epoch %d", cp.Epoch, st.Epoch)
+	}
+	if size < st.LogSize {
+		report(DiscrepancyShrink, "epoch %d covers %d entries, previous checkpoint %d", cp.Epoch, size, st.LogSize)
+	}
+	if h, ok := w.byEpoch[cp.Epoch]; ok && (h.size != size || h.root != root) {
+		report(DiscrepancyFork, "epoch %d has root %s for %d entries here and %s for %d entries at %s",
+			cp.Epoch, root, size, h.root, h.size, h.anchor)
+	}
+	if h, ok := w.bySize[size]; ok && h.root != root {
+		report(DiscrepancyFork, "%d entries have root %s here and %s at %s", size, root, h.root, h.anchor)
+	}
+	head := witnessHead{size: size, root: root, anchor: st.URL}
+	if _, ok := w.byEpoch[cp.Epoch]; !ok {
+		w.byEpoch[cp.Epoch] = head
+		pruneWitnessHistory(w.byEpoch)
+	}
+	if _, ok := w.bySize[size]; !ok {
+		w.bySize[size] = head
+		pruneWitnessHistory(w.bySize)
+	}
+
+	if cp.Epoch >= st.Epoch {
+		st.Epoch = cp.Epoch
+		st.LogSize, st.Root = size, root
+	}
+	if len(found) > 0 {
+		w.forked = true
+		return found
+	}
+	if w.opts.CoSign != nil && !w.forked && (w.cosigned == nil || cp.Epoch > w.cosigned.Epoch) {
+		if err := nwep.CheckpointSign(cp, w.opts.CoSign); err != nil {
+			w.srv.logger.Warn("witness cannot co-sign checkpoint", "epoch", cp.Epoch, "error", err.Error())
+		} else {
+			w.cosigned = cp
+		}
+	}
+	return nil
+}
+
+// pruneWitnessHistory drops the lowest keys of m beyond witnessHistory.
+func pruneWitnessHistory(m map[uint64]witnessHead) {
+	if len(m) <= witnessHistory {
+		return
+	}
+	keys := slices.Sorted(maps.Keys(m))
+	for _, k := range keys[:len(keys)-witnessHistory] {
+		delete(m, k)
+	}
+}
+
+// CoSigned returns the newest checkpoint the witness has co-signed, or nil
+// if it has none or WitnessOptions.CoSign is not set. Co-signing stops once a
+// discrepancy is found.
+func (w *Witness) CoSigned() *nwep.Checkpoint {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.cosigned
+}
+
+// Status returns the witness's state.
+func (w *Witness) Status() WitnessStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := WitnessStatus{
+		Consistent:    !w.forked,
+		Anchors:       make([]WitnessAnchorStatus, 0, len(w.opts.AnchorURLs)),
+		Discrepancies: slices.Clone(w.found),
+	}
+	for _, url := range w.opts.AnchorURLs {
+		if a, ok := w.anchors[url]; ok {
+			st.Anchors = append(st.Anchors, *a)
+		}
+	}
+	if w.cosigned != nil {
+		st.CoSignedEpoch = w.cosigned.Epoch
+	}
+	return st
+}
+
+// Mount registers the witness's routes on its server under opts.Prefix and
+// returns their group, to which mw applies. read /status responds with
+// WitnessStatus, with status "ok" while the witness is consistent and
+// "conflict" once it has found a discrepancy, so that a health check on it
+// fails.
+func (w *Witness) Mount(mw ...MiddlewareFunc) *Group {
+	g := w.srv.Group(w.opts.Prefix, mw...)
+	g.Read("/status", func(c *Context) error {
+		st := w.Status()
+		if !st.Consistent {
+			return c.JSONStatus(StatusConflict, st)
+		}
+		return c.JSON(st)
+	})
+	return g
+}
+
+// Close stops polling, cancelling a poll in progress, and closes the
+// connections to the anchor servers.
+func (w *Witness) Close() {
+	w.closeOnce.Do(func() {
+		w.stop()
+		w.wg.Wait()
+		w.pool.Close()
+	})
+}
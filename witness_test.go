@@ -0,0 +1,108 @@
+package velocity
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	nwep "github.com/usenwep/nwep-go"
+)
+
+func TestNewWitnessErrors(t *testing.T) {
+	s, err := New(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWitness(s, WitnessOptions{}); err == nil {
+		t.Fatal("no anchors accepted")
+	}
+	if _, err := NewWitness(s, WitnessOptions{AnchorURLs: []string{"web://x"}, Interval: -time.Second}); err == nil {
+		t.Fatal("negative interval accepted")
+	}
+}
+
+func TestWitnessCheck(t *testing.T) {
+	s, err := New(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Witness{
+		srv: s,
+		opts: WitnessOptions{Verify: func(cp *nwep.Checkpoint) error {
+			if cp.Epoch == 99 {
+				return errors.New("bad signature")
+			}
+			return nil
+		}},
+		byEpoch: make(map[uint64]witnessHead),
+		bySize:  make(map[uint64]witnessHead),
+	}
+	st := &WitnessAnchorStatus{URL: "web://a"}
+	poll := func(epoch uint64) []WitnessDiscrepancy {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		found := w.check(st, &nwep.Checkpoint{Epoch: epoch})
+		st.Seen = time.Now()
+		return found
+	}
+
+	if found := poll(5); len(found) != 0 {
+		t.Fatalf("first checkpoint: %v", found)
+	}
+	if found := poll(5); len(found) != 0 {
+		t.Fatalf("repeated checkpoint: %v", found)
+	}
+	if found := poll(6); len(found) != 0 || st.Epoch != 6 {
+		t.Fatalf("newer checkpoint: %v, epoch %d", found, st.Epoch)
+	}
+	if found := poll(99); len(found) != 1 || found[0].Kind != DiscrepancyInvalid || st.Epoch != 6 || w.forked {
+		t.Fatalf("invalid checkpoint: %v, epoch %d, forked %v", found, st.Epoch, w.forked)
+	}
+	if found := poll(4); len(found) != 1 || found[0].Kind != DiscrepancyRollback {
+		t.Fatalf("rollback: %v", found)
+	}
+	if st.Epoch != 6 || !w.forked {
+		t.Fatalf("after rollback: epoch %d, forked %v", st.Epoch, w.forked)
+	}
+}
+
+func TestWitnessFork(t *testing.T) {
+	s, err := New(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Witness{srv: s, byEpoch: make(map[uint64]witnessHead), bySize: make(map[uint64]witnessHead)}
+	a, b := &WitnessAnchorStatus{URL: "web://a"}, &WitnessAnchorStatus{URL: "web://b"}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if found := w.check(a, &nwep.Checkpoint{Epoch: 3, LogSize: 10, MerkleRoot: nwep.MerkleHash{1}}); len(found) != 0 {
+		t.Fatalf("first checkpoint: %v", found)
+	}
+	if a.LogSize != 10 || a.Root != (LogHash{1}) {
+		t.Fatalf("anchor status = %+v", a)
+	}
+	found := w.check(b, &nwep.Checkpoint{Epoch: 4, LogSize: 10, MerkleRoot: nwep.MerkleHash{2}})
+	if len(found) != 1 || found[0].Kind != DiscrepancyFork || !w.forked {
+		t.Fatalf("second root for the same size: %v, forked %v", found, w.forked)
+	}
+	if found := w.check(a, &nwep.Checkpoint{Epoch: 5, LogSize: 9, MerkleRoot: nwep.MerkleHash{3}}); len(found) != 1 || found[0].Kind != DiscrepancyShrink {
+		t.Fatalf("shrinking log: %v", found)
+	}
+}
+
+func TestPruneWitnessHistory(t *testing.T) {
+	m := make(map[uint64]witnessHead)
+	for i := range uint64(witnessHistory + 10) {
+		m[i] = witnessHead{}
+	}
+	pruneWitnessHistory(m)
+	if len(m) != witnessHistory {
+		t.Fatalf("len = %d", len(m))
+	}
+	if _, ok := m[9]; ok {
+		t.Fatal("oldest entries kept")
+	}
+	if _, ok := m[witnessHistory+9]; !ok {
+		t.Fatal("newest entry dropped")
+	}
+}
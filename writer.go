@@ -0,0 +1,50 @@
+package velocity
+
+import (
+	nwep "github.com/usenwep/nwep-go"
+)
+
+// ResponseWriter is the interface through which a Context sends its
+// response. Requests served by a Server use *nwep.ResponseWriter. Other
+// implementations, such as ResponseRecorder, let a handler run against a
+// Context created with NewContext, without a network or the nwep library's
+// event loop.
+type ResponseWriter interface {
+	SetStatus(status string)
+	SetHeader(name, value string)
+	Respond(status string, body []byte) error
+	Write(body []byte) error
+	StreamWrite(data []byte) (int, error)
+	StreamClose(errCode int)
+	StreamID() int64
+	IsServerInitiated() bool
+	CloseWrite() error
+	CloseRead() error
+}
+
+var _ ResponseWriter = (*nwep.ResponseWriter)(nil)
+
+// NewContext returns a Context for the request r whose response is sent to w.
+// It is intended for testing handlers and middleware in isolation; the server
+// creates the Contexts for the requests it serves itself.
+//
+// If s is nil, the Context belongs to a bare server with the default logger
+// and no options, which is enough for the accessors, response helpers, and
+// key-value store. Features that need a running server, such as
+// notifications, return ErrServerNotRunning. The Context's Response field is
+// set only if w is a *nwep.ResponseWriter. The Context is not pooled and may
+// be kept after the handler returns.
+func NewContext(w ResponseWriter, r *nwep.Request, s *Server) *Context {
+	if s == nil {
+		s = &Server{
+			logger: DefaultLogger(),
+			router: NewRouter(),
+			peers:  newPeerTracker(),
+		}
+	}
+	c := &Context{Request: r, w: w, server: s}
+	if nw, ok := w.(*nwep.ResponseWriter); ok {
+		c.Response = nw
+	}
+	return c
+}